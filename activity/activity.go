@@ -20,7 +20,7 @@ type ActivityWithKey struct {
 
 func QueryAll(ctx context.Context) ([]ActivityWithKey, error) {
 	var activities []Activity
-	q := datastore.NewQuery("Activity").Order("Keyword")
+	q := dsclient.NewQuery("Activity").Order("Keyword")
 	activityKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &activities)
 	if err != nil {
 		return nil, err
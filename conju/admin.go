@@ -10,6 +10,7 @@ import (
 // TODO(cshabsin): Replace error pages with templates.
 func AdminGetter(ctx context.Context, wr *WrappedRequest) error {
 	if wr.IsAdminUser() {
+		useSelectedEvent(ctx, wr)
 		return nil
 	}
 	u := wr.User
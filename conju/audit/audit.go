@@ -0,0 +1,128 @@
+// Package audit compares two versions of an entity field by field, so that
+// the changes can be recorded.
+package audit
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Change is one field that differs. Map fields are compared entry by
+// entry, and each differing entry is its own Change with a Field like
+// "RsvpMap[Dana Scott]".
+type Change struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// Formatter renders a value for display. It returns false to fall back to
+// the default formatting.
+type Formatter func(v interface{}) (string, bool)
+
+// Diff returns the exported fields of the structs old and new (which must
+// have the same type, or be pointers to it) whose values differ, in field
+// order. Fields named in ignore are skipped. Values are compared by their
+// formatted form, so pointers to equal keys compare equal.
+func Diff(old, new interface{}, format Formatter, ignore ...string) []Change {
+	ov, nv := reflect.Indirect(reflect.ValueOf(old)), reflect.Indirect(reflect.ValueOf(new))
+	if ov.Type() != nv.Type() || ov.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("audit.Diff: can't compare %v and %v", ov.Type(), nv.Type()))
+	}
+	skip := make(map[string]bool)
+	for _, name := range ignore {
+		skip[name] = true
+	}
+	f := formatter{format}
+
+	var changes []Change
+	t := ov.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || skip[field.Name] {
+			continue
+		}
+		of, nf := ov.Field(i), nv.Field(i)
+		if field.Type.Kind() == reflect.Map {
+			changes = append(changes, f.diffMap(field.Name, of, nf)...)
+			continue
+		}
+		if o, n := f.format(of), f.format(nf); o != n {
+			changes = append(changes, Change{Field: field.Name, Old: o, New: n})
+		}
+	}
+	return changes
+}
+
+type formatter struct {
+	custom Formatter
+}
+
+func (f formatter) diffMap(name string, old, new reflect.Value) []Change {
+	entries := func(m reflect.Value) map[string]string {
+		out := make(map[string]string)
+		for _, k := range m.MapKeys() {
+			out[f.format(k)] = f.format(m.MapIndex(k))
+		}
+		return out
+	}
+	oe, ne := entries(old), entries(new)
+	var keys []string
+	for k := range oe {
+		keys = append(keys, k)
+	}
+	for k := range ne {
+		if _, ok := oe[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var changes []Change
+	for _, k := range keys {
+		if oe[k] != ne[k] {
+			changes = append(changes, Change{Field: name + "[" + k + "]", Old: oe[k], New: ne[k]})
+		}
+	}
+	return changes
+}
+
+func (f formatter) format(v reflect.Value) string {
+	if !v.IsValid() || ((v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil()) {
+		return ""
+	}
+	if f.custom != nil && v.CanInterface() {
+		if s, ok := f.custom(v.Interface()); ok {
+			return s
+		}
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if s, ok := v.Interface().(fmt.Stringer); ok {
+			return s.String()
+		}
+		return f.format(v.Elem())
+	case reflect.Slice, reflect.Array:
+		parts := make([]string, v.Len())
+		for i := range parts {
+			parts[i] = f.format(v.Index(i))
+		}
+		return strings.Join(parts, ", ")
+	case reflect.Map:
+		var parts []string
+		for _, k := range v.MapKeys() {
+			parts = append(parts, f.format(k)+": "+f.format(v.MapIndex(k)))
+		}
+		sort.Strings(parts)
+		return strings.Join(parts, ", ")
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format("2006-01-02 15:04")
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,87 @@
+package audit
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type key struct{ ID int }
+
+type status int
+
+type entity struct {
+	Name     string
+	Count    int
+	Tags     []string
+	Owner    *key
+	Statuses map[*key]status
+	Updated  time.Time
+	private  string
+}
+
+func TestDiff(t *testing.T) {
+	format := func(v interface{}) (string, bool) {
+		switch v := v.(type) {
+		case *key:
+			return []string{"", "Alice", "Bob"}[v.ID], true
+		case status:
+			return strings.Repeat("*", int(v)), true
+		}
+		return "", false
+	}
+	base := func() entity {
+		return entity{
+			Name:     "a",
+			Count:    1,
+			Tags:     []string{"x"},
+			Owner:    &key{1},
+			Statuses: map[*key]status{{1}: 1, {2}: 2},
+			Updated:  time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+			private:  "p",
+		}
+	}
+
+	type TestCase struct {
+		Name   string
+		Change func(e *entity)
+		Ignore []string
+		Want   []Change
+	}
+	testcases := []TestCase{
+		{
+			Name:   "Equal values in new pointers",
+			Change: func(e *entity) { e.Owner = &key{1}; e.Statuses = map[*key]status{{2}: 2, {1}: 1} },
+		},
+		{
+			Name:   "Fields",
+			Change: func(e *entity) { e.Name = "b"; e.Tags = append(e.Tags, "y"); e.private = "q" },
+			Want:   []Change{{Field: "Name", Old: "a", New: "b"}, {Field: "Tags", Old: "x", New: "x, y"}},
+		},
+		{
+			Name:   "Map entries",
+			Change: func(e *entity) { e.Statuses = map[*key]status{{1}: 3} },
+			Want:   []Change{{Field: "Statuses[Alice]", Old: "*", New: "***"}, {Field: "Statuses[Bob]", Old: "**", New: ""}},
+		},
+		{
+			Name:   "Ignored",
+			Change: func(e *entity) { e.Updated = time.Now(); e.Count = 2 },
+			Ignore: []string{"Updated"},
+			Want:   []Change{{Field: "Count", Old: "1", New: "2"}},
+		},
+		{
+			Name:   "Nil pointer",
+			Change: func(e *entity) { e.Owner = nil },
+			Want:   []Change{{Field: "Owner", Old: "Alice", New: ""}},
+		},
+	}
+	for _, tc := range testcases {
+		old, new := base(), base()
+		tc.Change(&new)
+		got := Diff(&old, new, format, tc.Ignore...)
+		if !reflect.DeepEqual(got, tc.Want) {
+			t.Errorf("%s: Diff was %+v, want %+v", tc.Name, got, tc.Want)
+		}
+	}
+}
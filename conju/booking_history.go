@@ -0,0 +1,247 @@
+package conju
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/model/housing"
+	"github.com/cshabsin/conju/model/person"
+)
+
+// BookingChange records a change to who is booked in a room, or to
+// whether it's reserved. It's stored under the event rather than the
+// booking, so that it outlives a booking that is removed.
+type BookingChange struct {
+	Room           *datastore.Key
+	Changed        time.Time
+	ChangedBy      string
+	Before         []*datastore.Key `datastore:",noindex"`
+	After          []*datastore.Key `datastore:",noindex"`
+	ReservedBefore bool             `datastore:",noindex"`
+	ReservedAfter  bool             `datastore:",noindex"`
+}
+
+func sameRoommates(a, b []*datastore.Key) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	ids := make(map[int64]int)
+	for _, k := range a {
+		ids[k.ID]++
+	}
+	for _, k := range b {
+		if ids[k.ID] == 0 {
+			return false
+		}
+		ids[k.ID]--
+	}
+	return true
+}
+
+// saveBookings makes the event's bookings match wanted, which holds one
+// booking per room (with no roommates for an empty room). Only rooms whose
+// roommates or lock changed are written, so a booking keeps its Reserved
+// flag and its roommate order if its roommates are the same.
+func saveBookings(ctx context.Context, wr WrappedRequest, wanted []Booking) error {
+	changedBy := ""
+	if wr.User != nil {
+		changedBy = wr.User.Email
+	}
+	return dsclient.FromContext(ctx).RunInTransaction(ctx, func(tx dsclient.Client) error {
+		var existing []*Booking
+		q := dsclient.NewQuery("Booking").Ancestor(wr.EventKey)
+		existingKeys, err := tx.GetAll(ctx, q, &existing)
+		if err != nil {
+			return fmt.Errorf("fetching bookings: %w", err)
+		}
+		byRoom := make(map[int64]int)
+		var deleteKeys []*datastore.Key
+		for i, booking := range existing {
+			if _, ok := byRoom[booking.Room.ID]; ok {
+				deleteKeys = append(deleteKeys, existingKeys[i])
+				continue
+			}
+			byRoom[booking.Room.ID] = i
+		}
+
+		var putKeys []*datastore.Key
+		var puts []*Booking
+		var changes []*BookingChange
+		now := time.Now()
+		for i := range wanted {
+			want := &wanted[i]
+			index, ok := byRoom[want.Room.ID]
+			delete(byRoom, want.Room.ID)
+			if !ok {
+				if len(want.Roommates) == 0 {
+					continue
+				}
+				putKeys = append(putKeys, datastore.IncompleteKey("Booking", wr.EventKey))
+				puts = append(puts, want)
+				changes = append(changes, &BookingChange{Room: want.Room, After: want.Roommates})
+				continue
+			}
+
+			old := existing[index]
+			if len(want.Roommates) == 0 {
+				deleteKeys = append(deleteKeys, existingKeys[index])
+				changes = append(changes, &BookingChange{
+					Room:           old.Room,
+					Before:         old.Roommates,
+					ReservedBefore: old.Reserved,
+				})
+				continue
+			}
+			same := sameRoommates(old.Roommates, want.Roommates)
+			if same && old.Locked == want.Locked {
+				continue
+			}
+			updated := *old
+			updated.Locked = want.Locked
+			if !same {
+				updated.Roommates = want.Roommates
+				changes = append(changes, &BookingChange{
+					Room:           old.Room,
+					Before:         old.Roommates,
+					After:          want.Roommates,
+					ReservedBefore: old.Reserved,
+					ReservedAfter:  old.Reserved,
+				})
+			}
+			putKeys = append(putKeys, existingKeys[index])
+			puts = append(puts, &updated)
+		}
+		// Whatever's left is booked in a room that's no longer part of the
+		// event.
+		for _, index := range byRoom {
+			old := existing[index]
+			deleteKeys = append(deleteKeys, existingKeys[index])
+			changes = append(changes, &BookingChange{
+				Room:           old.Room,
+				Before:         old.Roommates,
+				ReservedBefore: old.Reserved,
+			})
+		}
+
+		if len(deleteKeys) > 0 {
+			if err := tx.DeleteMulti(ctx, deleteKeys); err != nil {
+				return fmt.Errorf("deleting bookings: %w", err)
+			}
+		}
+		if len(puts) > 0 {
+			if _, err := tx.PutMulti(ctx, putKeys, puts); err != nil {
+				return fmt.Errorf("saving bookings: %w", err)
+			}
+		}
+		return putBookingChanges(ctx, tx, wr.EventKey, changes, now, changedBy)
+	})
+}
+
+// putBookingChanges stamps and saves changes.
+func putBookingChanges(ctx context.Context, client dsclient.Client, eventKey *datastore.Key, changes []*BookingChange, changed time.Time, changedBy string) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	keys := make([]*datastore.Key, len(changes))
+	for i, change := range changes {
+		change.Changed = changed
+		change.ChangedBy = changedBy
+		keys[i] = datastore.IncompleteKey("BookingChange", eventKey)
+	}
+	if _, err := client.PutMulti(ctx, keys, changes); err != nil {
+		return fmt.Errorf("saving booking history: %w", err)
+	}
+	return nil
+}
+
+// roomName is how a room is shown in reports, e.g. "Upper King Pine 3B".
+func roomName(room *housing.Room, building *housing.Building) string {
+	name := fmt.Sprintf("%d%s", room.RoomNumber, room.Partition)
+	if building != nil {
+		name = building.Name + " " + name
+	}
+	return name
+}
+
+// handleBookingHistory lists changes to the current event's bookings,
+// newest first.
+func handleBookingHistory(ctx context.Context, wr WrappedRequest) {
+	client := dsclient.FromContext(ctx)
+	var changes []*BookingChange
+	q := dsclient.NewQuery("BookingChange").Ancestor(wr.EventKey)
+	if _, err := client.GetAll(ctx, q, &changes); err != nil {
+		log.Printf("fetching booking history: %v", err)
+		http.Error(wr.ResponseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sort.SliceStable(changes, func(a, b int) bool { return changes[a].Changed.After(changes[b].Changed) })
+
+	buildingsMap := getBuildingMapForVenue(ctx, wr.Event.VenueKey())
+	roomNames := make(map[int64]string)
+	names := make(map[int64]string)
+	for _, change := range changes {
+		if _, ok := roomNames[change.Room.ID]; !ok {
+			var room housing.Room
+			if err := client.Get(ctx, change.Room, &room); err != nil {
+				log.Printf("fetching room %v: %v", change.Room, err)
+			}
+			roomNames[change.Room.ID] = roomName(&room, buildingsMap[change.Room.Parent.ID])
+		}
+		for _, key := range append(append([]*datastore.Key(nil), change.Before...), change.After...) {
+			if _, ok := names[key.ID]; ok {
+				continue
+			}
+			var p person.Person
+			if err := client.Get(ctx, key, &p); err != nil {
+				log.Printf("fetching person %v: %v", key, err)
+			}
+			names[key.ID] = p.FullName()
+		}
+	}
+
+	type HistoryRow struct {
+		Changed         time.Time
+		ChangedBy       string
+		Room            string
+		Before          string
+		After           string
+		ReservedChanged bool
+		Reserved        bool
+	}
+	joinNames := func(keys []*datastore.Key) string {
+		var n []string
+		for _, key := range keys {
+			n = append(n, names[key.ID])
+		}
+		return strings.Join(n, ", ")
+	}
+	var rows []HistoryRow
+	for _, change := range changes {
+		rows = append(rows, HistoryRow{
+			Changed:         change.Changed,
+			ChangedBy:       change.ChangedBy,
+			Room:            roomNames[change.Room.ID],
+			Before:          joinNames(change.Before),
+			After:           joinNames(change.After),
+			ReservedChanged: change.ReservedBefore != change.ReservedAfter && sameRoommates(change.Before, change.After),
+			Reserved:        change.ReservedAfter,
+		})
+	}
+
+	tpl := template.Must(template.New("").ParseFiles("templates/main.html", "templates/bookingHistory.html"))
+	data := wr.MakeTemplateData(map[string]interface{}{
+		"Rows": rows,
+	})
+	if err := tpl.ExecuteTemplate(wr.ResponseWriter, "bookingHistory.html", data); err != nil {
+		log.Printf("%v", err)
+	}
+}
@@ -0,0 +1,222 @@
+package conju
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/conju/ical"
+	"github.com/cshabsin/conju/conju/mailer"
+	"github.com/cshabsin/conju/invitation"
+	"github.com/cshabsin/conju/model/event"
+	"github.com/cshabsin/conju/model/person"
+)
+
+// Calendars show when people are at an event: from the first night their
+// RSVP covers to the morning after the last, or the whole event for
+// statuses without lodging, along with the activities they're leading.
+// The invitation's calendar is attached to the RSVP confirmation, and each
+// person has a feed of all their events at /calendar.ics, authenticated by
+// their login code, which calendar apps can subscribe to.
+
+// nightDate returns the date of the event's night starting on the weekday.
+// An event's nights are taken to be in the week around its start date,
+// from three days before it to three days after.
+func nightDate(ev *event.Event, night time.Weekday) time.Time {
+	first := ev.StartDate.AddDate(0, 0, -3)
+	return first.AddDate(0, 0, (int(night)-int(first.Weekday())+7)%7)
+}
+
+// stayDates returns the first day of a stay with the RSVP status and the
+// day after the last, the form an all-day calendar event takes.
+func stayDates(ev *event.Event, info invitation.RsvpStatusInfo) (time.Time, time.Time) {
+	if len(info.Nights) == 0 {
+		return ev.StartDate, ev.EndDate.AddDate(0, 0, 1)
+	}
+	var first, last time.Time
+	for _, night := range info.Nights {
+		date := nightDate(ev, night)
+		if first.IsZero() || date.Before(first) {
+			first = date
+		}
+		if date.After(last) {
+			last = date
+		}
+	}
+	// The stay includes the morning after the last night.
+	return first, last.AddDate(0, 0, 2)
+}
+
+// stayEvent returns the calendar event for the named invitees' stay at the
+// event, or false if the status isn't one that comes to the event.
+func stayEvent(ev *event.Event, uid string, info invitation.RsvpStatusInfo, names, leading []string) (ical.Event, bool) {
+	if !info.Attending && !info.Undecided {
+		return ical.Event{}, false
+	}
+	start, end := stayDates(ev, info)
+	e := ical.Event{
+		UID:     uid,
+		Summary: ev.Name,
+		Start:   start,
+		End:     end,
+		AllDay:  true,
+		Status:  ical.Confirmed,
+		URL:     ev.AbsoluteURL("/rsvp"),
+		Stamp:   time.Now(),
+	}
+	if info.Undecided {
+		e.Summary += " (maybe)"
+		e.Status = ical.Tentative
+	}
+	if ev.Venue != nil {
+		e.Location = ev.Venue.Name
+	}
+	description := []string{fmt.Sprintf("%s: %s", strings.Join(names, ", "), info.LongDescription)}
+	if len(leading) > 0 {
+		description = append(description, "Leading: "+strings.Join(leading, ", "))
+	}
+	e.Description = strings.Join(description, "\n")
+	return e, true
+}
+
+// leadingActivities returns the descriptions of the activities the invitee
+// has offered to lead.
+func leadingActivities(realizedInvitation RealizedInvitation, personKey string) []string {
+	var leading []string
+	for _, a := range realizedInvitation.Activities {
+		if realizedInvitation.ActivitiesLeadersMap[personKey][a.EncodedKey] {
+			leading = append(leading, a.Activity.Description)
+		}
+	}
+	return leading
+}
+
+// invitationCalendar returns the calendar of the invitation's stays, one
+// event for the invitees with each RSVP status, or nil if nobody is (or
+// might be) coming.
+func invitationCalendar(ctx context.Context, ev *event.Event, realizedInvitation RealizedInvitation) *ical.Calendar {
+	if _, err := ev.LoadVenue(ctx); err != nil {
+		log.Printf("Loading venue for calendar: %v", err)
+	}
+	type stay struct {
+		info           invitation.RsvpStatusInfo
+		names, leading []string
+	}
+	var stays []*stay
+	byStatus := make(map[invitation.RsvpStatus]*stay)
+	for _, invitee := range realizedInvitation.Invitees {
+		info, ok := realizedInvitation.RsvpMap[invitee.Key]
+		if !ok {
+			continue
+		}
+		s := byStatus[info.Status]
+		if s == nil {
+			s = &stay{info: info}
+			byStatus[info.Status] = s
+			stays = append(stays, s)
+		}
+		s.names = append(s.names, invitee.Person.FullName())
+		s.leading = append(s.leading, leadingActivities(realizedInvitation, invitee.Key)...)
+	}
+	cal := &ical.Calendar{Name: ev.Name, Method: "PUBLISH"}
+	for _, s := range stays {
+		uid := fmt.Sprintf("%s-%d@conju", realizedInvitation.EncodedKey, s.info.Status)
+		if e, ok := stayEvent(ev, uid, s.info, s.names, s.leading); ok {
+			cal.Events = append(cal.Events, e)
+		}
+	}
+	if len(cal.Events) == 0 {
+		return nil
+	}
+	return cal
+}
+
+// invitationCalendarAttachment returns the invitation's calendar as a mail
+// attachment, or nil if there's nothing on it.
+func invitationCalendarAttachment(ctx context.Context, ev *event.Event, realizedInvitation RealizedInvitation) []mailer.Attachment {
+	cal := invitationCalendar(ctx, ev, realizedInvitation)
+	if cal == nil {
+		return nil
+	}
+	return []mailer.Attachment{{
+		Filename:    ev.ShortName + ".ics",
+		ContentType: ical.ContentType + "; method=PUBLISH",
+		Data:        cal.Bytes(),
+	}}
+}
+
+// personCalendar returns the calendar of the person's stays at all the
+// events they've been invited to.
+func personCalendar(ctx context.Context, personKey *datastore.Key, p *person.Person) (*ical.Calendar, error) {
+	var invitations []*Invitation
+	q := dsclient.NewQuery("Invitation").FilterField("Invitees", "=", personKey)
+	invitationKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
+	if err != nil {
+		return nil, fmt.Errorf("fetching invitations: %w", err)
+	}
+	cal := &ical.Calendar{Name: p.FullName() + "'s events"}
+	for i, inv := range invitations {
+		ev, err := event.GetEvent(ctx, inv.Event)
+		if err != nil {
+			log.Printf("Getting event %v for calendar: %v", inv.Event, err)
+			continue
+		}
+		if _, err := ev.LoadVenue(ctx); err != nil {
+			log.Printf("Loading venue for calendar: %v", err)
+		}
+		realizedInvitation := makeRealizedInvitation(ctx, invitationKeys[i], inv)
+		info, ok := realizedInvitation.RsvpMap[personKey.Encode()]
+		if !ok {
+			continue
+		}
+		uid := fmt.Sprintf("%s-%s@conju", inv.Event.Encode(), personKey.Encode())
+		if e, ok := stayEvent(ev, uid, info, []string{p.FullName()}, leadingActivities(realizedInvitation, personKey.Encode())); ok {
+			cal.Events = append(cal.Events, e)
+		}
+	}
+	sort.Slice(cal.Events, func(a, b int) bool { return cal.Events[a].Start.Before(cal.Events[b].Start) })
+	return cal, nil
+}
+
+// calendarFeedLink returns the address of the person's calendar feed, on
+// the event's site.
+func calendarFeedLink(ev *event.Event, p *person.Person) string {
+	return ev.AbsoluteURL("/calendar.ics?loginCode=" + p.LoginCode)
+}
+
+// handleCalendarFeed serves the calendar of the person whose login code
+// is given.
+func handleCalendarFeed(ctx context.Context, wr WrappedRequest) {
+	code := wr.Request.URL.Query().Get("loginCode")
+	if code == "" {
+		http.Error(wr.ResponseWriter, "No login code.", http.StatusForbidden)
+		return
+	}
+	var people []*person.Person
+	q := dsclient.NewQuery("Person").FilterField("LoginCode", "=", code)
+	keys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &people)
+	if err != nil {
+		log.Printf("Looking up login code for calendar: %v", err)
+		http.Error(wr.ResponseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(keys) != 1 {
+		http.Error(wr.ResponseWriter, "Login not recognized.", http.StatusNotFound)
+		return
+	}
+	cal, err := personCalendar(ctx, keys[0], people[0])
+	if err != nil {
+		log.Printf("Calendar for %v: %v", keys[0], err)
+		http.Error(wr.ResponseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	wr.ResponseWriter.Header().Set("Content-Type", ical.ContentType)
+	wr.ResponseWriter.Write(cal.Bytes())
+}
@@ -0,0 +1,181 @@
+package conju
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/cshabsin/conju/activity"
+	"github.com/cshabsin/conju/conju/audit"
+	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/invitation"
+	"github.com/cshabsin/conju/model/event"
+	"github.com/cshabsin/conju/model/person"
+)
+
+// ChangeRecord is an append-only record of an edit to an Invitation or a
+// Person, listing the fields that changed.
+type ChangeRecord struct {
+	Entity     *datastore.Key
+	Changed    time.Time
+	ChangedBy  *datastore.Key // the person logged in, if any
+	ByAdmin    bool
+	AdminEmail string         // the admin's Google account, if ByAdmin
+	Changes    []audit.Change `datastore:",noindex"`
+}
+
+// Fields that change on every save, so aren't worth recording.
+var (
+	invitationAuditIgnore = []string{"LastUpdatedPerson", "LastUpdatedTimestamp", "Version"}
+	personAuditIgnore     = []string{"DatastoreKey"}
+)
+
+// auditFormatter shows keys as the names of what they refer to, and enums
+// as their descriptions. ev, which may be nil, supplies the RSVP catalog.
+func auditFormatter(ctx context.Context, ev *event.Event) audit.Formatter {
+	keyNames := make(map[string]string)
+	return func(v interface{}) (string, bool) {
+		switch v := v.(type) {
+		case *datastore.Key:
+			if name, ok := keyNames[v.Encode()]; ok {
+				return name, true
+			}
+			name := v.String()
+			switch v.Kind {
+			case "Person":
+				var p person.Person
+				if err := dsclient.FromContext(ctx).Get(ctx, v, &p); err == nil {
+					name = p.FullName()
+				}
+			case "Activity":
+				var a activity.Activity
+				if err := dsclient.FromContext(ctx).Get(ctx, v, &a); err == nil {
+					name = a.Keyword
+				}
+			}
+			keyNames[v.Encode()] = name
+			return name, true
+		case invitation.RsvpStatus:
+			if ev != nil {
+				return ev.RsvpStatusInfo(v).ShortDescription, true
+			}
+		case HousingPreference:
+			if all := GetAllHousingPreferences(); int(v) >= 0 && int(v) < len(all) {
+				return all[v].ReportDescription, true
+			}
+		case DrivingPreference:
+			if all := GetAllDrivingPreferences(); int(v) >= 0 && int(v) < len(all) {
+				return all[v].ReportDescription, true
+			}
+		}
+		return "", false
+	}
+}
+
+// recordChange saves a ChangeRecord for the differences between old and
+// new, which are an Invitation or a Person before and after an edit. It
+// does nothing if nothing changed.
+func recordChange(ctx context.Context, wr WrappedRequest, key *datastore.Key, old, new interface{}, ev *event.Event, ignore []string) error {
+	changes := audit.Diff(old, new, auditFormatter(ctx, ev), ignore...)
+	if len(changes) == 0 {
+		return nil
+	}
+	record := ChangeRecord{
+		Entity:  key,
+		Changed: time.Now(),
+		ByAdmin: wr.IsAdminUser(),
+		Changes: changes,
+	}
+	if wr.LoginInfo != nil {
+		record.ChangedBy = wr.LoginInfo.PersonKey
+	}
+	if record.ByAdmin && wr.User != nil {
+		record.AdminEmail = wr.User.Email
+	}
+	if _, err := dsclient.FromContext(ctx).Put(ctx, datastore.IncompleteKey("ChangeRecord", nil), &record); err != nil {
+		return fmt.Errorf("recording change to %v: %w", key, err)
+	}
+	return nil
+}
+
+// handleInvitationHistory lists the recorded changes to an invitation and
+// to the people on it, newest first.
+func handleInvitationHistory(ctx context.Context, wr WrappedRequest) {
+	client := dsclient.FromContext(ctx)
+	invitationKey, err := datastore.DecodeKey(wr.Request.FormValue("invitation"))
+	if err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Invalid invitation: %v", err), http.StatusBadRequest)
+		return
+	}
+	var inv Invitation
+	if err := client.Get(ctx, invitationKey, &inv); err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Getting invitation: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var records []*ChangeRecord
+	for _, key := range append([]*datastore.Key{invitationKey}, inv.Invitees...) {
+		var forKey []*ChangeRecord
+		q := dsclient.NewQuery("ChangeRecord").FilterField("Entity", "=", key)
+		if _, err := client.GetAll(ctx, q, &forKey); err != nil {
+			log.Printf("fetching changes to %v: %v", key, err)
+		}
+		records = append(records, forKey...)
+	}
+	sort.SliceStable(records, func(a, b int) bool { return records[a].Changed.After(records[b].Changed) })
+
+	format := auditFormatter(ctx, nil)
+	name := func(key *datastore.Key) string {
+		if key == nil {
+			return ""
+		}
+		s, _ := format(key)
+		return s
+	}
+	type HistoryRow struct {
+		Changed time.Time
+		Who     string
+		ByAdmin bool
+		What    string
+		Changes []audit.Change
+	}
+	var rows []HistoryRow
+	for _, record := range records {
+		row := HistoryRow{
+			Changed: record.Changed,
+			Who:     name(record.ChangedBy),
+			ByAdmin: record.ByAdmin,
+			What:    "Invitation",
+			Changes: record.Changes,
+		}
+		if record.AdminEmail != "" {
+			row.Who = record.AdminEmail
+		}
+		if record.Entity.Kind == "Person" {
+			row.What = name(record.Entity)
+		}
+		rows = append(rows, row)
+	}
+
+	var invitees []string
+	for _, key := range inv.Invitees {
+		invitees = append(invitees, name(key))
+	}
+
+	tpl := template.Must(template.New("").ParseFiles("templates/main.html", "templates/invitationHistory.html"))
+	data := wr.MakeTemplateData(map[string]interface{}{
+		"InvitationKey": invitationKey.Encode(),
+		"Invitees":      strings.Join(invitees, ", "),
+		"Rows":          rows,
+	})
+	if err := tpl.ExecuteTemplate(wr.ResponseWriter, "invitationHistory.html", data); err != nil {
+		log.Printf("%v", err)
+	}
+}
@@ -5,12 +5,15 @@ import (
 	"html/template"
 	"log"
 
-	"cloud.google.com/go/datastore"
+	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/conju/mailer"
+	"github.com/cshabsin/conju/model/event"
 )
 
-func Register(client *datastore.Client) {
+func Register(client dsclient.Client, transport mailer.Transport) {
 	s := Sessionizer{
-		Client: client,
+		Client:        client,
+		MailTransport: transport,
 	}
 	s.AddSessionHandler("/reloadData", AskReloadData).Needs(AdminGetter)
 	s.AddSessionHandler("/doReloadData", ReloadData).Needs(AdminGetter)
@@ -36,6 +39,8 @@ func Register(client *datastore.Client) {
 	s.AddSessionHandler("/invitations", handleInvitations).Needs(PersonGetter).Needs(AdminGetter)
 	s.AddSessionHandler("/receivePay", handleReceivePay).Needs(PersonGetter).Needs(AdminGetter)
 	s.AddSessionHandler("/doReceivePay", handleDoReceivePay).Needs(PersonGetter).Needs(AdminGetter)
+	s.AddSessionHandler("/importPayments", handleImportPayments).Needs(PersonGetter).Needs(AdminGetter)
+	s.AddSessionHandler("/doImportPayments", handleDoImportPayments).Needs(PersonGetter).Needs(AdminGetter)
 	s.AddSessionHandler("/copyInvitations", handleCopyInvitations).Needs(PersonGetter).Needs(AdminGetter)
 	s.AddSessionHandler("/addInvitation", handleAddInvitation).Needs(PersonGetter).Needs(AdminGetter)
 	s.AddSessionHandler("/deleteInvitation", handleDeleteInvitation).Needs(PersonGetter).Needs(AdminGetter)
@@ -44,6 +49,16 @@ func Register(client *datastore.Client) {
 
 	s.AddSessionHandler("/events", handleEvents).Needs(PersonGetter).Needs(AdminGetter)
 	s.AddSessionHandler("/createUpdateEvent", handleCreateUpdateEvent).Needs(PersonGetter).Needs(AdminGetter)
+	s.AddSessionHandler("/selectEvent", handleSelectEvent).Needs(AdminGetter)
+
+	s.AddSessionHandler("/venues", handleVenues).Needs(PersonGetter).Needs(AdminGetter)
+	s.AddSessionHandler("/saveVenue", handleSaveVenue).Needs(PersonGetter).Needs(AdminGetter)
+	s.AddSessionHandler("/deleteVenue", handleDeleteVenue).Needs(PersonGetter).Needs(AdminGetter)
+	s.AddSessionHandler("/building", handleBuilding).Needs(PersonGetter).Needs(AdminGetter)
+	s.AddSessionHandler("/saveBuilding", handleSaveBuilding).Needs(PersonGetter).Needs(AdminGetter)
+	s.AddSessionHandler("/deleteBuilding", handleDeleteBuilding).Needs(PersonGetter).Needs(AdminGetter)
+	s.AddSessionHandler("/saveRoom", handleSaveRoom).Needs(PersonGetter).Needs(AdminGetter)
+	s.AddSessionHandler("/deleteRoom", handleDeleteRoom).Needs(PersonGetter).Needs(AdminGetter)
 
 	s.AddSessionHandler("/rsvp", handleViewInvitationUser).Needs(InvitationGetter)
 
@@ -51,16 +66,33 @@ func Register(client *datastore.Client) {
 	s.AddSessionHandler("/activitiesReport", handleActivitiesReport).Needs(PersonGetter).Needs(AdminGetter)
 	s.AddSessionHandler("/roomingReport", handleRoomingReport).Needs(PersonGetter).Needs(AdminGetter)
 	s.AddSessionHandler("/handleSaveReservations", handleSaveReservations).Needs(PersonGetter).Needs(AdminGetter)
+	s.AddSessionHandler("/bookingHistory", handleBookingHistory).Needs(PersonGetter).Needs(AdminGetter)
+	s.AddSessionHandler("/invitationHistory", handleInvitationHistory).Needs(PersonGetter).Needs(AdminGetter)
 	s.AddSessionHandler("/foodReport", handleFoodReport).Needs(PersonGetter).Needs(AdminGetter)
 	s.AddSessionHandler("/ridesReport", handleRidesReport).Needs(PersonGetter).Needs(AdminGetter)
 
 	s.AddSessionHandler("/rooming", handleRoomingTool).Needs(PersonGetter).Needs(AdminGetter)
+	s.AddSessionHandler("/floorplan", handleFloorplan).Needs(PersonGetter).Needs(AdminGetter)
 	s.AddSessionHandler("/saveRooming", handleSaveRooming).Needs(PersonGetter).Needs(AdminGetter)
 
 	s.AddSessionHandler("/viewMyInvitation", handleViewMyInvitation).Needs(InvitationGetter)
 
-	s.AddSessionHandler("/sendMail", handleSendMail).Needs(InvitationGetter).Needs(AdminGetter)
-	s.AddSessionHandler("/doSendMail", handleDoSendMail).Needs(InvitationGetter).Needs(AdminGetter)
+	s.AddSessionHandler("/sendMail", handleSendMail).Needs(AdminGetter).Needs(InvitationGetter)
+	s.AddSessionHandler("/doSendMail", handleDoSendMail).Needs(AdminGetter).Needs(InvitationGetter)
+	s.AddSessionHandler("/previewSegment", handlePreviewSegment).Needs(AdminGetter)
+	s.AddSessionHandler("/editMessage", handleEditMessage).Needs(AdminGetter).Needs(InvitationGetter)
+	s.AddSessionHandler("/saveMessage", handleSaveMessage).Needs(AdminGetter).Needs(InvitationGetter)
+	s.AddSessionHandler("/previewMessage", handlePreviewMessage).Needs(AdminGetter).Needs(InvitationGetter)
+	s.AddSessionHandler("/mailings", handleMailings).Needs(PersonGetter).Needs(AdminGetter)
+	s.AddSessionHandler("/mailing", handleMailing).Needs(PersonGetter).Needs(AdminGetter)
+	s.AddSessionHandler("/processMailQueue", handleProcessMailQueue)
+	s.AddSessionHandler("/scheduledMail", handleScheduledMail).Needs(AdminGetter)
+	s.AddSessionHandler("/saveScheduledMail", handleSaveScheduledMail).Needs(AdminGetter)
+	s.AddSessionHandler("/deleteScheduledMail", handleDeleteScheduledMail).Needs(AdminGetter)
+	s.AddSessionHandler("/processScheduledMail", handleProcessScheduledMail)
+	s.AddSessionHandler("/mailEvents", handleMailEvents)
+	s.AddSessionHandler("/mailPreferences", handleMailPreferences)
+	s.AddSessionHandler("/calendar.ics", handleCalendarFeed)
 
 	s.AddSessionHandler("/testRoomingMail", handleTestSendRoomingEmail).Needs(AdminGetter)
 	s.AddSessionHandler("/sendRoomingMail", handleAskSendRoomingEmail).Needs(AdminGetter)
@@ -92,8 +124,16 @@ func handleIndex(ctx context.Context, wr WrappedRequest) {
 }
 
 func handleAdmin(ctx context.Context, wr WrappedRequest) {
+	allEvents, err := event.GetAllEvents(ctx)
+	if err != nil {
+		log.Printf("GetAllEvents: %v", err)
+	}
+	data := wr.MakeTemplateData(map[string]interface{}{
+		"Events":          allEvents,
+		"CurrentEventKey": wr.EventKey.Encode(),
+	})
 	var tpl = template.Must(template.ParseFiles("templates/main.html", "templates/admin.html"))
-	if err := tpl.ExecuteTemplate(wr.ResponseWriter, "admin.html", wr.TemplateData); err != nil {
+	if err := tpl.ExecuteTemplate(wr.ResponseWriter, "admin.html", data); err != nil {
 		log.Println(err)
 	}
 }
@@ -0,0 +1,149 @@
+package conju
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/appengine/v2/user"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/conju/mailer"
+	"github.com/cshabsin/conju/model/event"
+	"github.com/cshabsin/conju/model/person"
+)
+
+// TestMain runs the tests from the top of the repository, where the
+// handlers find templates/.
+func TestMain(m *testing.M) {
+	if err := os.Chdir(".."); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// recordingTransport keeps the messages sent through it.
+type recordingTransport struct {
+	sent []*mailer.Message
+}
+
+func (r *recordingTransport) Send(ctx context.Context, msg *mailer.Message) (string, error) {
+	r.sent = append(r.sent, msg)
+	return fmt.Sprintf("<%d@test>", len(r.sent)), nil
+}
+
+// to returns the messages sent to the address.
+func (r *recordingTransport) to(address string) []*mailer.Message {
+	var msgs []*mailer.Message
+	for _, msg := range r.sent {
+		for _, a := range msg.To {
+			if a.Email == address {
+				msgs = append(msgs, msg)
+				break
+			}
+		}
+	}
+	return msgs
+}
+
+// testSite is an event in a datastore of its own, with an invitation to
+// it for each of the mail fixtures (see putLintEvent).
+type testSite struct {
+	ctx         context.Context
+	client      *dsclient.MemoryClient
+	ev          *event.Event
+	invitations []*datastore.Key // in the order of mailFixtures
+	transport   *recordingTransport
+}
+
+func newTestSite(t *testing.T) *testSite {
+	t.Helper()
+	client := dsclient.NewMemoryClient()
+	ctx := dsclient.WrapContext(context.Background(), client)
+	ev, invitations, err := putLintEvent(ctx, "TEST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSite{
+		ctx:         ctx,
+		client:      client,
+		ev:          ev,
+		invitations: invitations,
+		transport:   &recordingTransport{},
+	}
+}
+
+// fixture returns the key and invitation of the named mail fixture.
+func (s *testSite) fixture(t *testing.T, name string) (*datastore.Key, *Invitation) {
+	t.Helper()
+	for i, f := range mailFixtures {
+		if f.name != name {
+			continue
+		}
+		var inv Invitation
+		if err := s.client.Get(s.ctx, s.invitations[i], &inv); err != nil {
+			t.Fatal(err)
+		}
+		return s.invitations[i], &inv
+	}
+	t.Fatalf("no fixture %q", name)
+	return nil, nil
+}
+
+// request returns a request to the site and the recorder its response
+// goes to. The request is from the first invitee of the named fixture,
+// signed in as an admin if admin is set.
+func (s *testSite) request(t *testing.T, fixture string, admin bool, method, target string, form url.Values) (WrappedRequest, *httptest.ResponseRecorder) {
+	t.Helper()
+	r := httptest.NewRequest(method, target, nil)
+	if form != nil {
+		r = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	rec := httptest.NewRecorder()
+	sess, err := store.Get(r, "conju")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invitationKey, inv := s.fixture(t, fixture)
+	var p person.Person
+	if err := s.client.Get(s.ctx, inv.Invitees[0], &p); err != nil {
+		t.Fatal(err)
+	}
+	p.DatastoreKey = inv.Invitees[0]
+	var u *user.User
+	if admin {
+		u = &user.User{Email: p.Email, Admin: true}
+	}
+	li := &LoginInfo{
+		InvitationKey: invitationKey,
+		Invitation:    inv,
+		PersonKey:     inv.Invitees[0],
+		Person:        &p,
+	}
+	wr := WrappedRequest{
+		ResponseWriter:  NewWrappedResponseWriter(rec),
+		Request:         r,
+		Session:         sess,
+		User:            u,
+		DatastoreClient: s.client,
+		MailTransport:   s.transport,
+		EventKey:        s.ev.Key,
+		Event:           s.ev,
+		LoginInfo:       li,
+		TemplateData: map[string]interface{}{
+			"User":         u,
+			"IsAdminUser":  admin,
+			"CurrentEvent": s.ev,
+			"LoginInfo":    li,
+		},
+	}
+	return wr, rec
+}
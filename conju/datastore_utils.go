@@ -6,10 +6,9 @@ import (
 	"log"
 	"net/http"
 
-	"cloud.google.com/go/datastore"
-
 	"github.com/cshabsin/conju/conju/dsclient"
 	"github.com/cshabsin/conju/conju/login"
+	"github.com/cshabsin/conju/model/event"
 	"github.com/cshabsin/conju/model/person"
 )
 
@@ -22,7 +21,7 @@ func ClearAllData(ctx context.Context, wr WrappedRequest, entityNames []string)
 
 	for _, entityName := range entityNames {
 		wr.ResponseWriter.Write([]byte(fmt.Sprintf("Clearing: %s\n", entityName)))
-		q := datastore.NewQuery(entityName).KeysOnly()
+		q := dsclient.NewQuery(entityName).KeysOnly()
 
 		keys, err := dsclient.FromContext(ctx).GetAll(ctx, q, nil)
 		if err != nil {
@@ -47,7 +46,7 @@ func ClearAllData(ctx context.Context, wr WrappedRequest, entityNames []string)
 }
 
 func RepairData(ctx context.Context, wr WrappedRequest) {
-	q := datastore.NewQuery("Person")
+	q := dsclient.NewQuery("Person")
 	var people []person.Person
 	personKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &people)
 	if err != nil {
@@ -66,5 +65,14 @@ func RepairData(ctx context.Context, wr WrappedRequest) {
 			}
 		}
 	}
+	changed, err := event.AddLegacyHostnames(ctx)
+	for _, shortName := range changed {
+		fmt.Fprintf(wr.ResponseWriter, "Added %s's hostname.\n", shortName)
+	}
+	if err != nil {
+		log.Printf("RepairData AddLegacyHostnames: %v", err)
+		http.Error(wr.ResponseWriter, fmt.Sprintf("adding hostnames: %v", err), http.StatusInternalServerError)
+		return
+	}
 	fmt.Fprintf(wr.ResponseWriter, "Done.")
 }
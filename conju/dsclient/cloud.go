@@ -0,0 +1,118 @@
+package dsclient
+
+import (
+	"context"
+
+	"cloud.google.com/go/datastore"
+)
+
+type cloudClient struct {
+	client *datastore.Client
+}
+
+// NewCloudClient returns a Client backed by Cloud Datastore.
+func NewCloudClient(client *datastore.Client) Client {
+	return &cloudClient{client: client}
+}
+
+func (c *cloudClient) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	return c.client.Get(ctx, key, dst)
+}
+
+func (c *cloudClient) GetMulti(ctx context.Context, keys []*datastore.Key, dst interface{}) error {
+	return c.client.GetMulti(ctx, keys, dst)
+}
+
+func (c *cloudClient) GetAll(ctx context.Context, q *Query, dst interface{}) ([]*datastore.Key, error) {
+	return c.client.GetAll(ctx, q.datastoreQuery(), dst)
+}
+
+func (c *cloudClient) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	return c.client.Put(ctx, key, src)
+}
+
+func (c *cloudClient) PutMulti(ctx context.Context, keys []*datastore.Key, src interface{}) ([]*datastore.Key, error) {
+	return c.client.PutMulti(ctx, keys, src)
+}
+
+func (c *cloudClient) Delete(ctx context.Context, key *datastore.Key) error {
+	return c.client.Delete(ctx, key)
+}
+
+func (c *cloudClient) DeleteMulti(ctx context.Context, keys []*datastore.Key) error {
+	return c.client.DeleteMulti(ctx, keys)
+}
+
+func (c *cloudClient) RunInTransaction(ctx context.Context, f func(tx Client) error) error {
+	_, err := c.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		return f(&cloudTransaction{client: c.client, tx: tx})
+	})
+	return err
+}
+
+// cloudTransaction is a Client for use inside RunInTransaction. Incomplete
+// keys are allocated before the put, so that Put can return complete keys
+// as it does outside a transaction.
+type cloudTransaction struct {
+	client *datastore.Client
+	tx     *datastore.Transaction
+}
+
+func (t *cloudTransaction) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	return t.tx.Get(key, dst)
+}
+
+func (t *cloudTransaction) GetMulti(ctx context.Context, keys []*datastore.Key, dst interface{}) error {
+	return t.tx.GetMulti(keys, dst)
+}
+
+func (t *cloudTransaction) GetAll(ctx context.Context, q *Query, dst interface{}) ([]*datastore.Key, error) {
+	return t.client.GetAll(ctx, q.datastoreQuery().Transaction(t.tx), dst)
+}
+
+func (t *cloudTransaction) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	keys, err := t.PutMulti(ctx, []*datastore.Key{key}, []interface{}{src})
+	if err != nil {
+		if multiErr, ok := err.(datastore.MultiError); ok {
+			return nil, multiErr[0]
+		}
+		return nil, err
+	}
+	return keys[0], nil
+}
+
+func (t *cloudTransaction) PutMulti(ctx context.Context, keys []*datastore.Key, src interface{}) ([]*datastore.Key, error) {
+	var incomplete []*datastore.Key
+	for _, key := range keys {
+		if key != nil && key.Incomplete() {
+			incomplete = append(incomplete, key)
+		}
+	}
+	allocated, err := t.client.AllocateIDs(ctx, incomplete)
+	if err != nil {
+		return nil, err
+	}
+	complete := make([]*datastore.Key, len(keys))
+	for i, key := range keys {
+		complete[i] = key
+		if key != nil && key.Incomplete() {
+			complete[i], allocated = allocated[0], allocated[1:]
+		}
+	}
+	if _, err := t.tx.PutMulti(complete, src); err != nil {
+		return nil, err
+	}
+	return complete, nil
+}
+
+func (t *cloudTransaction) Delete(ctx context.Context, key *datastore.Key) error {
+	return t.tx.Delete(key)
+}
+
+func (t *cloudTransaction) DeleteMulti(ctx context.Context, keys []*datastore.Key) error {
+	return t.tx.DeleteMulti(keys)
+}
+
+func (t *cloudTransaction) RunInTransaction(ctx context.Context, f func(tx Client) error) error {
+	return errNestedTransaction
+}
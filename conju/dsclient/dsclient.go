@@ -1,17 +1,49 @@
+// Package dsclient holds the storage client used by conju handlers and
+// models. Callers retrieve it from the request context with FromContext and
+// talk to it through the Client interface, which is implemented both by
+// Cloud Datastore (NewCloudClient) and by an in-memory store
+// (NewMemoryClient) suitable for tests and local development.
 package dsclient
 
 import (
 	"context"
+	"errors"
 
 	"cloud.google.com/go/datastore"
 )
 
+// Client is the subset of datastore operations used by conju. Keys are
+// always *datastore.Key, and entities are saved and loaded with the same
+// rules as cloud.google.com/go/datastore (struct fields or
+// datastore.PropertyLoadSaver).
+type Client interface {
+	Get(ctx context.Context, key *datastore.Key, dst interface{}) error
+	GetMulti(ctx context.Context, keys []*datastore.Key, dst interface{}) error
+	GetAll(ctx context.Context, q *Query, dst interface{}) ([]*datastore.Key, error)
+	Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error)
+	PutMulti(ctx context.Context, keys []*datastore.Key, src interface{}) ([]*datastore.Key, error)
+	Delete(ctx context.Context, key *datastore.Key) error
+	DeleteMulti(ctx context.Context, keys []*datastore.Key) error
+
+	// RunInTransaction calls f with a Client whose writes are applied
+	// atomically if f returns nil, and discarded otherwise. f may be called
+	// more than once if the transaction conflicts with another. Keys that
+	// the transaction's Put returns are always complete. Queries inside a
+	// transaction must have an ancestor.
+	RunInTransaction(ctx context.Context, f func(tx Client) error) error
+}
+
+var errNestedTransaction = errors.New("dsclient: nested transactions are not supported")
+
 var dsClientKey = &struct{}{}
 
-func FromContext(ctx context.Context) *datastore.Client {
-	return ctx.Value(dsClientKey).(*datastore.Client)
+// FromContext returns the Client stored by WrapContext, or nil if there is
+// none.
+func FromContext(ctx context.Context) Client {
+	client, _ := ctx.Value(dsClientKey).(Client)
+	return client
 }
 
-func WrapContext(ctx context.Context, client *datastore.Client) context.Context {
+func WrapContext(ctx context.Context, client Client) context.Context {
 	return context.WithValue(ctx, dsClientKey, client)
 }
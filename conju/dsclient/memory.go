@@ -0,0 +1,655 @@
+package dsclient
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// MemoryClient is a Client that keeps all entities in memory. Entities are
+// stored as property lists, so they go through the same Save and Load code
+// paths (including datastore.PropertyLoadSaver) that Cloud Datastore uses.
+// It is safe for concurrent use.
+type MemoryClient struct {
+	mu       sync.Mutex
+	txMu     sync.Mutex // held for the length of a transaction
+	entities map[string]*memoryEntity
+	nextID   int64
+}
+
+type memoryEntity struct {
+	key   *datastore.Key
+	props []datastore.Property
+}
+
+func NewMemoryClient() *MemoryClient {
+	return &MemoryClient{
+		entities: map[string]*memoryEntity{},
+		nextID:   1,
+	}
+}
+
+func (c *MemoryClient) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	if key == nil || key.Incomplete() {
+		return datastore.ErrInvalidKey
+	}
+	ptr, err := entityPointer(reflect.ValueOf(dst))
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	e, ok := c.entities[key.Encode()]
+	c.mu.Unlock()
+	if !ok {
+		return datastore.ErrNoSuchEntity
+	}
+	return loadEntity(ptr, e)
+}
+
+func (c *MemoryClient) GetMulti(ctx context.Context, keys []*datastore.Key, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Slice || v.Len() != len(keys) {
+		return errors.New("datastore: keys and dst slices have different length")
+	}
+	multiErr := make(datastore.MultiError, len(keys))
+	failed := false
+	for i, key := range keys {
+		elem := v.Index(i)
+		if elem.Kind() == reflect.Ptr && elem.IsNil() {
+			elem.Set(reflect.New(elem.Type().Elem()))
+		}
+		if err := c.Get(ctx, key, elemPointer(elem)); err != nil {
+			multiErr[i] = err
+			failed = true
+		}
+	}
+	if failed {
+		return multiErr
+	}
+	return nil
+}
+
+func (c *MemoryClient) GetAll(ctx context.Context, q *Query, dst interface{}) ([]*datastore.Key, error) {
+	results, err := c.run(q)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*datastore.Key, len(results))
+	for i, e := range results {
+		keys[i] = copyKey(e.key)
+	}
+	if q.keysOnly {
+		return keys, nil
+	}
+
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return nil, datastore.ErrInvalidEntityType
+	}
+	slice := v.Elem()
+	elemType := slice.Type().Elem()
+	var firstErr error
+	for _, e := range results {
+		var elem reflect.Value
+		if elemType.Kind() == reflect.Ptr {
+			elem = reflect.New(elemType.Elem())
+		} else {
+			elem = reflect.New(elemType)
+		}
+		if err := loadEntity(elem.Interface(), e); err != nil {
+			var mismatch *datastore.ErrFieldMismatch
+			if !errors.As(err, &mismatch) {
+				return nil, err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if elemType.Kind() != reflect.Ptr {
+			elem = elem.Elem()
+		}
+		slice.Set(reflect.Append(slice, elem))
+	}
+	return keys, firstErr
+}
+
+func (c *MemoryClient) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	keys, err := c.PutMulti(ctx, []*datastore.Key{key}, []interface{}{src})
+	if err != nil {
+		if multiErr, ok := err.(datastore.MultiError); ok {
+			return nil, multiErr[0]
+		}
+		return nil, err
+	}
+	return keys[0], nil
+}
+
+func (c *MemoryClient) PutMulti(ctx context.Context, keys []*datastore.Key, src interface{}) ([]*datastore.Key, error) {
+	entities, err := c.newEntities(keys, src)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]*datastore.Key, len(keys))
+	for i, e := range entities {
+		c.entities[e.key.Encode()] = e
+		out[i] = copyKey(e.key)
+	}
+	return out, nil
+}
+
+// newEntities saves each of src under the matching key, assigning IDs to
+// incomplete keys.
+func (c *MemoryClient) newEntities(keys []*datastore.Key, src interface{}) ([]*memoryEntity, error) {
+	v := reflect.ValueOf(src)
+	if v.Kind() != reflect.Slice || v.Len() != len(keys) {
+		return nil, errors.New("datastore: keys and src slices have different length")
+	}
+	entities := make([]*memoryEntity, len(keys))
+	for i, key := range keys {
+		if key == nil {
+			return nil, datastore.ErrInvalidKey
+		}
+		ptr, err := entityPointer(v.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		props, err := saveEntity(ptr)
+		if err != nil {
+			return nil, err
+		}
+		entities[i] = &memoryEntity{key: copyKey(key), props: copyProperties(props)}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range entities {
+		if e.key.Incomplete() {
+			e.key.ID = c.nextID
+			c.nextID++
+		}
+	}
+	return entities, nil
+}
+
+func (c *MemoryClient) Delete(ctx context.Context, key *datastore.Key) error {
+	return c.DeleteMulti(ctx, []*datastore.Key{key})
+}
+
+func (c *MemoryClient) DeleteMulti(ctx context.Context, keys []*datastore.Key) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if key == nil || key.Incomplete() {
+			return datastore.ErrInvalidKey
+		}
+		delete(c.entities, key.Encode())
+	}
+	return nil
+}
+
+// RunInTransaction runs f, then applies its writes all at once if it
+// succeeds. Transactions on the same client run one at a time. As in Cloud
+// Datastore, reads inside f don't see f's own writes.
+func (c *MemoryClient) RunInTransaction(ctx context.Context, f func(tx Client) error) error {
+	c.txMu.Lock()
+	defer c.txMu.Unlock()
+	tx := &memoryTransaction{client: c, writes: map[string]*memoryEntity{}}
+	if err := f(tx); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, encoded := range tx.order {
+		if e := tx.writes[encoded]; e != nil {
+			c.entities[encoded] = e
+		} else {
+			delete(c.entities, encoded)
+		}
+	}
+	return nil
+}
+
+// memoryTransaction buffers writes until RunInTransaction commits them. A
+// nil entry in writes is a delete.
+type memoryTransaction struct {
+	client *MemoryClient
+	writes map[string]*memoryEntity
+	order  []string
+}
+
+func (tx *memoryTransaction) Get(ctx context.Context, key *datastore.Key, dst interface{}) error {
+	return tx.client.Get(ctx, key, dst)
+}
+
+func (tx *memoryTransaction) GetMulti(ctx context.Context, keys []*datastore.Key, dst interface{}) error {
+	return tx.client.GetMulti(ctx, keys, dst)
+}
+
+func (tx *memoryTransaction) GetAll(ctx context.Context, q *Query, dst interface{}) ([]*datastore.Key, error) {
+	if q.ancestor == nil {
+		return nil, errors.New("dsclient: queries in a transaction must have an ancestor")
+	}
+	return tx.client.GetAll(ctx, q, dst)
+}
+
+func (tx *memoryTransaction) Put(ctx context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	keys, err := tx.PutMulti(ctx, []*datastore.Key{key}, []interface{}{src})
+	if err != nil {
+		return nil, err
+	}
+	return keys[0], nil
+}
+
+func (tx *memoryTransaction) PutMulti(ctx context.Context, keys []*datastore.Key, src interface{}) ([]*datastore.Key, error) {
+	entities, err := tx.client.newEntities(keys, src)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*datastore.Key, len(keys))
+	for i, e := range entities {
+		tx.write(e.key.Encode(), e)
+		out[i] = copyKey(e.key)
+	}
+	return out, nil
+}
+
+func (tx *memoryTransaction) Delete(ctx context.Context, key *datastore.Key) error {
+	return tx.DeleteMulti(ctx, []*datastore.Key{key})
+}
+
+func (tx *memoryTransaction) DeleteMulti(ctx context.Context, keys []*datastore.Key) error {
+	for _, key := range keys {
+		if key == nil || key.Incomplete() {
+			return datastore.ErrInvalidKey
+		}
+	}
+	for _, key := range keys {
+		tx.write(key.Encode(), nil)
+	}
+	return nil
+}
+
+func (tx *memoryTransaction) RunInTransaction(ctx context.Context, f func(tx Client) error) error {
+	return errNestedTransaction
+}
+
+func (tx *memoryTransaction) write(encoded string, e *memoryEntity) {
+	if _, ok := tx.writes[encoded]; !ok {
+		tx.order = append(tx.order, encoded)
+	}
+	tx.writes[encoded] = e
+}
+
+// run evaluates q against the stored entities, returning the matching
+// entities in query order.
+func (c *MemoryClient) run(q *Query) ([]*memoryEntity, error) {
+	for _, f := range q.filters {
+		switch f.op {
+		case "=", "!=", "<", "<=", ">", ">=":
+		default:
+			return nil, errors.New("datastore: invalid operator " + f.op + " in filter")
+		}
+	}
+
+	c.mu.Lock()
+	var results []*memoryEntity
+	for _, e := range c.entities {
+		if e.key.Kind != q.kind {
+			continue
+		}
+		if q.ancestor != nil && !hasAncestor(e.key, q.ancestor) {
+			continue
+		}
+		if !e.matches(q.filters) {
+			continue
+		}
+		if !e.hasFields(q.orders) {
+			continue
+		}
+		results = append(results, e)
+	}
+	c.mu.Unlock()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		for _, o := range q.orders {
+			cmp := compareAny(a.sortValue(o), b.sortValue(o))
+			if o.desc {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return compareKeys(a.key, b.key) < 0
+	})
+	if q.limit > 0 && len(results) > q.limit {
+		results = results[:q.limit]
+	}
+	return results, nil
+}
+
+// values returns every value stored under the named property, flattening
+// slice-valued properties.
+func (e *memoryEntity) values(name string) []interface{} {
+	if name == "__key__" {
+		return []interface{}{e.key}
+	}
+	var vals []interface{}
+	for _, p := range e.props {
+		if p.Name != name {
+			continue
+		}
+		if vs, ok := p.Value.([]interface{}); ok {
+			for _, v := range vs {
+				vals = append(vals, normalize(v))
+			}
+		} else {
+			vals = append(vals, normalize(p.Value))
+		}
+	}
+	return vals
+}
+
+func (e *memoryEntity) matches(filters []filter) bool {
+	for _, f := range filters {
+		want := normalize(f.value)
+		found := false
+		for _, v := range e.values(f.field) {
+			cmp, ok := compareValues(v, want)
+			if f.op == "!=" {
+				found = !ok || cmp != 0
+			} else if ok {
+				switch f.op {
+				case "=":
+					found = cmp == 0
+				case "<":
+					found = cmp < 0
+				case "<=":
+					found = cmp <= 0
+				case ">":
+					found = cmp > 0
+				case ">=":
+					found = cmp >= 0
+				}
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// hasFields reports whether e has a value for every ordered field; like
+// Cloud Datastore, entities missing a sort property are left out.
+func (e *memoryEntity) hasFields(orders []order) bool {
+	for _, o := range orders {
+		if len(e.values(o.field)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// sortValue returns the value e sorts by for o: the smallest value of a
+// multi-valued property when ascending and the largest when descending.
+func (e *memoryEntity) sortValue(o order) interface{} {
+	vals := e.values(o.field)
+	best := vals[0]
+	for _, v := range vals[1:] {
+		cmp := compareAny(v, best)
+		if (o.desc && cmp > 0) || (!o.desc && cmp < 0) {
+			best = v
+		}
+	}
+	return best
+}
+
+func hasAncestor(key, ancestor *datastore.Key) bool {
+	for k := key; k != nil; k = k.Parent {
+		if k.Equal(ancestor) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize converts v to the representation Cloud Datastore would store
+// it as, so that values from structs and from filters compare equal.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case datastore.Key:
+		return &v
+	case *datastore.Key:
+		if v == nil {
+			return nil
+		}
+	}
+	return v
+}
+
+// compareValues compares two normalized values of the same type. ok is
+// false if the values have different types.
+func compareValues(a, b interface{}) (cmp int, ok bool) {
+	switch a := a.(type) {
+	case nil:
+		return 0, b == nil
+	case int64:
+		if b, ok := b.(int64); ok {
+			return compareOrdered(a, b), true
+		}
+	case float64:
+		if b, ok := b.(float64); ok {
+			return compareOrdered(a, b), true
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			if a == b {
+				return 0, true
+			}
+			if !a {
+				return -1, true
+			}
+			return 1, true
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			return a.Compare(b), true
+		}
+	case *datastore.Key:
+		if b, ok := b.(*datastore.Key); ok {
+			return compareKeys(a, b), true
+		}
+	}
+	return 0, false
+}
+
+// compareAny orders values of any type, falling back to the Cloud
+// Datastore ordering of value types when the types differ.
+func compareAny(a, b interface{}) int {
+	if cmp, ok := compareValues(a, b); ok {
+		return cmp
+	}
+	return compareOrdered(typeRank(a), typeRank(b))
+}
+
+func typeRank(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int64:
+		return 1
+	case time.Time:
+		return 2
+	case bool:
+		return 3
+	case string:
+		return 4
+	case float64:
+		return 5
+	case *datastore.Key:
+		return 6
+	}
+	return 7
+}
+
+func compareOrdered[T int | int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareKeys orders keys by path from the root, with numeric IDs sorting
+// before names.
+func compareKeys(a, b *datastore.Key) int {
+	pa, pb := keyPath(a), keyPath(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		x, y := pa[i], pb[i]
+		if cmp := strings.Compare(x.Kind, y.Kind); cmp != 0 {
+			return cmp
+		}
+		if (x.Name == "") != (y.Name == "") {
+			if x.Name == "" {
+				return -1
+			}
+			return 1
+		}
+		if cmp := compareOrdered(x.ID, y.ID); cmp != 0 {
+			return cmp
+		}
+		if cmp := strings.Compare(x.Name, y.Name); cmp != 0 {
+			return cmp
+		}
+	}
+	return compareOrdered(len(pa), len(pb))
+}
+
+func keyPath(k *datastore.Key) []*datastore.Key {
+	var path []*datastore.Key
+	for ; k != nil; k = k.Parent {
+		path = append([]*datastore.Key{k}, path...)
+	}
+	return path
+}
+
+// entityPointer returns a pointer to the struct held in v, copying it if
+// it is not addressable.
+func entityPointer(v reflect.Value) (interface{}, error) {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil, datastore.ErrInvalidEntityType
+		}
+		return v.Interface(), nil
+	case reflect.Struct:
+		if v.CanAddr() {
+			return v.Addr().Interface(), nil
+		}
+		p := reflect.New(v.Type())
+		p.Elem().Set(v)
+		return p.Interface(), nil
+	}
+	return nil, datastore.ErrInvalidEntityType
+}
+
+// elemPointer returns a pointer to the struct held in a slice element.
+func elemPointer(elem reflect.Value) interface{} {
+	if elem.Kind() == reflect.Interface || elem.Kind() == reflect.Ptr {
+		return elem.Interface()
+	}
+	return elem.Addr().Interface()
+}
+
+func saveEntity(src interface{}) ([]datastore.Property, error) {
+	if pls, ok := src.(datastore.PropertyLoadSaver); ok {
+		return pls.Save()
+	}
+	return datastore.SaveStruct(src)
+}
+
+func loadEntity(dst interface{}, e *memoryEntity) error {
+	if kl, ok := dst.(datastore.KeyLoader); ok {
+		if err := kl.LoadKey(copyKey(e.key)); err != nil {
+			return err
+		}
+	}
+	props := copyProperties(e.props)
+	if pls, ok := dst.(datastore.PropertyLoadSaver); ok {
+		return pls.Load(props)
+	}
+	return datastore.LoadStruct(dst, props)
+}
+
+// copyProperties deep-copies props so that neither the caller nor the
+// store can modify the other's keys or slices.
+func copyProperties(props []datastore.Property) []datastore.Property {
+	out := make([]datastore.Property, len(props))
+	for i, p := range props {
+		out[i] = p
+		out[i].Value = copyValue(p.Value)
+	}
+	return out
+}
+
+func copyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *datastore.Key:
+		return copyKey(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, x := range v {
+			out[i] = copyValue(x)
+		}
+		return out
+	case []byte:
+		return append([]byte(nil), v...)
+	case *datastore.Entity:
+		if v == nil {
+			return v
+		}
+		return &datastore.Entity{Key: copyKey(v.Key), Properties: copyProperties(v.Properties)}
+	}
+	return v
+}
+
+func copyKey(k *datastore.Key) *datastore.Key {
+	if k == nil {
+		return nil
+	}
+	c := *k
+	c.Parent = copyKey(k.Parent)
+	return &c
+}
@@ -0,0 +1,242 @@
+package dsclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+type testRoom struct {
+	Building   *datastore.Key
+	RoomNumber int
+	Beds       []int
+	Added      time.Time
+}
+
+func TestMemoryClientGetPut(t *testing.T) {
+	ctx := context.Background()
+	client := NewMemoryClient()
+
+	key, err := client.Put(ctx, datastore.IncompleteKey("Room", nil), &testRoom{RoomNumber: 7})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if key.Incomplete() {
+		t.Fatalf("Put returned incomplete key %v", key)
+	}
+
+	var room testRoom
+	if err := client.Get(ctx, key, &room); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if room.RoomNumber != 7 {
+		t.Errorf("Get returned room number %d, want 7", room.RoomNumber)
+	}
+
+	if err := client.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := client.Get(ctx, key, &room); err != datastore.ErrNoSuchEntity {
+		t.Errorf("Get after Delete returned %v, want ErrNoSuchEntity", err)
+	}
+}
+
+func TestMemoryClientGetMulti(t *testing.T) {
+	ctx := context.Background()
+	client := NewMemoryClient()
+
+	present := datastore.NameKey("Room", "present", nil)
+	missing := datastore.NameKey("Room", "missing", nil)
+	if _, err := client.Put(ctx, present, &testRoom{RoomNumber: 1}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	rooms := make([]*testRoom, 2)
+	err := client.GetMulti(ctx, []*datastore.Key{present, missing}, rooms)
+	var multiErr datastore.MultiError
+	if !errors.As(err, &multiErr) {
+		t.Fatalf("GetMulti returned %v, want MultiError", err)
+	}
+	if multiErr[0] != nil || multiErr[1] != datastore.ErrNoSuchEntity {
+		t.Errorf("GetMulti errors were %v, want [nil, ErrNoSuchEntity]", multiErr)
+	}
+	if rooms[0].RoomNumber != 1 {
+		t.Errorf("GetMulti returned room number %d, want 1", rooms[0].RoomNumber)
+	}
+}
+
+func TestMemoryClientKeysAreCopied(t *testing.T) {
+	ctx := context.Background()
+	client := NewMemoryClient()
+
+	building := datastore.NameKey("Building", "A", nil)
+	key, err := client.Put(ctx, datastore.NameKey("Room", "1", nil), &testRoom{Building: building})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	building.Name = "B"
+
+	var room testRoom
+	if err := client.Get(ctx, key, &room); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if room.Building.Name != "A" {
+		t.Errorf("stored building key was modified through caller's pointer: got %v", room.Building)
+	}
+}
+
+func TestMemoryClientGetAll(t *testing.T) {
+	ctx := context.Background()
+	client := NewMemoryClient()
+
+	buildingA := datastore.NameKey("Building", "A", nil)
+	buildingB := datastore.NameKey("Building", "B", nil)
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	rooms := []testRoom{
+		{Building: buildingA, RoomNumber: 3, Beds: []int{0, 3}, Added: start},
+		{Building: buildingA, RoomNumber: 1, Beds: []int{1}, Added: start.Add(time.Hour)},
+		{Building: buildingB, RoomNumber: 2, Beds: []int{3, 3}, Added: start.Add(2 * time.Hour)},
+	}
+	keys := []*datastore.Key{
+		datastore.IncompleteKey("Room", buildingA),
+		datastore.IncompleteKey("Room", buildingA),
+		datastore.IncompleteKey("Room", buildingB),
+	}
+	if _, err := client.PutMulti(ctx, keys, rooms); err != nil {
+		t.Fatalf("PutMulti: %v", err)
+	}
+
+	type TestCase struct {
+		Name  string
+		Query *Query
+		Want  []int
+	}
+	testcases := []TestCase{
+		{
+			Name:  "Order ascending",
+			Query: NewQuery("Room").Order("RoomNumber"),
+			Want:  []int{1, 2, 3},
+		},
+		{
+			Name:  "Order descending",
+			Query: NewQuery("Room").Order("-Added"),
+			Want:  []int{2, 1, 3},
+		},
+		{
+			Name:  "Filter by key",
+			Query: NewQuery("Room").FilterField("Building", "=", buildingA).Order("RoomNumber"),
+			Want:  []int{1, 3},
+		},
+		{
+			Name:  "Filter by untyped int",
+			Query: NewQuery("Room").FilterField("RoomNumber", ">=", 2).Order("RoomNumber"),
+			Want:  []int{2, 3},
+		},
+		{
+			Name:  "Filter on multi-valued property",
+			Query: NewQuery("Room").FilterField("Beds", "=", 3).Order("RoomNumber"),
+			Want:  []int{2, 3},
+		},
+		{
+			Name:  "Ancestor",
+			Query: NewQuery("Room").Ancestor(buildingB),
+			Want:  []int{2},
+		},
+		{
+			Name:  "Limit",
+			Query: NewQuery("Room").Order("RoomNumber").Limit(2),
+			Want:  []int{1, 2},
+		},
+		{
+			Name:  "Other kind",
+			Query: NewQuery("Building"),
+			Want:  nil,
+		},
+	}
+	for _, tc := range testcases {
+		var got []testRoom
+		gotKeys, err := client.GetAll(ctx, tc.Query, &got)
+		if err != nil {
+			t.Errorf("%s: GetAll: %v", tc.Name, err)
+			continue
+		}
+		if len(gotKeys) != len(got) || len(got) != len(tc.Want) {
+			t.Errorf("%s: got %d keys and %d rooms, want %d", tc.Name, len(gotKeys), len(got), len(tc.Want))
+			continue
+		}
+		for i, room := range got {
+			if room.RoomNumber != tc.Want[i] {
+				t.Errorf("%s: result %d was room %d, want %d", tc.Name, i, room.RoomNumber, tc.Want[i])
+			}
+		}
+	}
+
+	keysOnly, err := client.GetAll(ctx, NewQuery("Room").KeysOnly(), nil)
+	if err != nil {
+		t.Fatalf("GetAll KeysOnly: %v", err)
+	}
+	if len(keysOnly) != 3 {
+		t.Errorf("GetAll KeysOnly returned %d keys, want 3", len(keysOnly))
+	}
+}
+
+func TestMemoryClientRunInTransaction(t *testing.T) {
+	ctx := context.Background()
+	client := NewMemoryClient()
+	building := datastore.NameKey("Building", "main", nil)
+	existing, err := client.Put(ctx, datastore.IncompleteKey("Room", building), &testRoom{RoomNumber: 1})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	var added *datastore.Key
+	err = client.RunInTransaction(ctx, func(tx Client) error {
+		var err error
+		if added, err = tx.Put(ctx, datastore.IncompleteKey("Room", building), &testRoom{RoomNumber: 2}); err != nil {
+			return err
+		}
+		if added.Incomplete() {
+			t.Errorf("Put in transaction returned incomplete key %v", added)
+		}
+		if err := tx.Get(ctx, added, &testRoom{}); err != datastore.ErrNoSuchEntity {
+			t.Errorf("Get of uncommitted entity returned %v, want ErrNoSuchEntity", err)
+		}
+		return tx.Delete(ctx, existing)
+	})
+	if err != nil {
+		t.Fatalf("RunInTransaction: %v", err)
+	}
+	var room testRoom
+	if err := client.Get(ctx, added, &room); err != nil || room.RoomNumber != 2 {
+		t.Errorf("Get of committed entity returned %+v, %v; want room 2", room, err)
+	}
+	if err := client.Get(ctx, existing, &room); err != datastore.ErrNoSuchEntity {
+		t.Errorf("Get of deleted entity returned %v, want ErrNoSuchEntity", err)
+	}
+
+	failure := errors.New("failure")
+	err = client.RunInTransaction(ctx, func(tx Client) error {
+		if _, err := tx.Put(ctx, existing, &testRoom{RoomNumber: 3}); err != nil {
+			return err
+		}
+		return failure
+	})
+	if err != failure {
+		t.Errorf("RunInTransaction returned %v, want %v", err, failure)
+	}
+	if err := client.Get(ctx, existing, &room); err != datastore.ErrNoSuchEntity {
+		t.Errorf("Get after failed transaction returned %v, want ErrNoSuchEntity", err)
+	}
+
+	err = client.RunInTransaction(ctx, func(tx Client) error {
+		var rooms []testRoom
+		_, err := tx.GetAll(ctx, NewQuery("Room"), &rooms)
+		return err
+	})
+	if err == nil {
+		t.Errorf("query without ancestor in transaction succeeded, want error")
+	}
+}
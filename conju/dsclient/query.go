@@ -0,0 +1,111 @@
+package dsclient
+
+import (
+	"strings"
+
+	"cloud.google.com/go/datastore"
+)
+
+// Query describes a datastore query in a form that every Client
+// implementation can evaluate. Like datastore.Query, its methods return a
+// derived query and leave the receiver unchanged.
+type Query struct {
+	kind     string
+	ancestor *datastore.Key
+	filters  []filter
+	orders   []order
+	keysOnly bool
+	limit    int
+}
+
+type filter struct {
+	field string
+	op    string
+	value interface{}
+}
+
+type order struct {
+	field string
+	desc  bool
+}
+
+func NewQuery(kind string) *Query {
+	return &Query{kind: kind}
+}
+
+func (q *Query) clone() *Query {
+	c := *q
+	c.filters = append([]filter(nil), q.filters...)
+	c.orders = append([]order(nil), q.orders...)
+	return &c
+}
+
+// FilterField returns a derived query with a field-based filter. The
+// operator is one of "=", "!=", "<", "<=", ">" or ">=".
+func (q *Query) FilterField(fieldName, operator string, value interface{}) *Query {
+	c := q.clone()
+	c.filters = append(c.filters, filter{
+		field: strings.TrimSpace(fieldName),
+		op:    strings.TrimSpace(operator),
+		value: value,
+	})
+	return c
+}
+
+func (q *Query) Ancestor(ancestor *datastore.Key) *Query {
+	c := q.clone()
+	c.ancestor = ancestor
+	return c
+}
+
+// Order returns a derived query with a sort order on fieldName. A leading
+// "-" sorts in descending order.
+func (q *Query) Order(fieldName string) *Query {
+	c := q.clone()
+	fieldName = strings.TrimSpace(fieldName)
+	o := order{field: fieldName}
+	if strings.HasPrefix(fieldName, "-") {
+		o = order{field: strings.TrimSpace(fieldName[1:]), desc: true}
+	}
+	c.orders = append(c.orders, o)
+	return c
+}
+
+func (q *Query) KeysOnly() *Query {
+	c := q.clone()
+	c.keysOnly = true
+	return c
+}
+
+// Limit returns a derived query that returns at most limit results. A
+// limit of zero or less means no limit.
+func (q *Query) Limit(limit int) *Query {
+	c := q.clone()
+	c.limit = limit
+	return c
+}
+
+// datastoreQuery converts q to the equivalent Cloud Datastore query.
+func (q *Query) datastoreQuery() *datastore.Query {
+	dq := datastore.NewQuery(q.kind)
+	if q.ancestor != nil {
+		dq = dq.Ancestor(q.ancestor)
+	}
+	for _, f := range q.filters {
+		dq = dq.FilterField(f.field, f.op, f.value)
+	}
+	for _, o := range q.orders {
+		if o.desc {
+			dq = dq.Order("-" + o.field)
+		} else {
+			dq = dq.Order(o.field)
+		}
+	}
+	if q.keysOnly {
+		dq = dq.KeysOnly()
+	}
+	if q.limit > 0 {
+		dq = dq.Limit(q.limit)
+	}
+	return dq
+}
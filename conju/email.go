@@ -3,16 +3,23 @@ package conju
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"html/template"
+	"io"
 	"log"
 	"net/http"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	text_template "text/template"
 
+	"cloud.google.com/go/datastore"
+
+	"github.com/cshabsin/conju/conju/mailer"
+	"github.com/cshabsin/conju/model/message"
 	"github.com/cshabsin/conju/model/person"
-	"github.com/sendgrid/sendgrid-go/helpers/mail"
 )
 
 // MailHeaderInfo contains the header info for outgoing email, passed into sendMail.
@@ -22,71 +29,100 @@ type MailHeaderInfo struct {
 	Bcc     []string
 	Subject string
 
-	BccSelf bool
+	BccSelf     bool
+	Attachments []mailer.Attachment
 }
 
-// Renders the named mail template and returns the filled text, filled
-// html, and filled subject line, or an error.
-func renderMail(wr WrappedRequest, templatePrefix string, data interface{}, needSubject bool) (string, string, string, error) {
-	functionMap := template.FuncMap{
-		"HasHousingPreference":        RealInvHasHousingPreference,
-		"PronounString":               person.GetPronouns,
-		"CollectiveAddressFirstNames": person.CollectiveAddressFirstNames,
-		"SharerName":                  MakeSharerName,
-		"DerefPeople":                 DerefPeople,
+// mailFunctions are available to every email template.
+var mailFunctions = map[string]interface{}{
+	"HasHousingPreference":        RealInvHasHousingPreference,
+	"PronounString":               person.GetPronouns,
+	"CollectiveAddressFirstNames": person.CollectiveAddressFirstNames,
+	"SharerName":                  MakeSharerName,
+	"DerefPeople":                 DerefPeople,
+}
+
+// parseMailTemplates parses the shared and event email templates, as both
+// HTML and text templates. If msg isn't nil, its templates replace the
+// files' templates of the same names.
+func parseMailTemplates(eventShortName string, msg *message.Message) (*template.Template, *text_template.Template, error) {
+	files, err := filepath.Glob("templates/email/*.html")
+	if err != nil {
+		return nil, nil, err
 	}
-	tpl, err := template.New("").Funcs(functionMap).ParseGlob("templates/email/*.html")
+	eventFiles, err := filepath.Glob("templates/" + eventShortName + "/email/*.html")
 	if err != nil {
-		return "", "", "", err
+		return nil, nil, err
 	}
+	files = append(files, eventFiles...)
+	// Hard-code that we want the roomingInfo template available for now.
+	files = append(files, "templates/roomingInfo.html")
 
-	tpl, err = tpl.ParseGlob("templates/" + wr.Event.ShortName + "/email/*.html")
+	tpl, err := template.New("").Funcs(template.FuncMap(mailFunctions)).ParseFiles(files...)
 	if err != nil {
-		return "", "", "", fmt.Errorf("parsing templates %s: %v", "templates/"+wr.Event.ShortName+"/email/*.html", err)
+		return nil, nil, err
 	}
-
-	// Hard-code that we want the roomingInfo template available for now.
-	tpl, err = tpl.ParseFiles("templates/roomingInfo.html")
+	textTpl, err := text_template.New("").Funcs(text_template.FuncMap(mailFunctions)).ParseFiles(files...)
 	if err != nil {
-		return "", "", "", err
+		return nil, nil, err
 	}
-
-	textFunctionMap := text_template.FuncMap{
-		"HasHousingPreference":        RealInvHasHousingPreference,
-		"PronounString":               person.GetPronouns,
-		"CollectiveAddressFirstNames": person.CollectiveAddressFirstNames,
-		"SharerName":                  MakeSharerName,
-		"DerefPeople":                 DerefPeople,
+	if msg != nil {
+		for name, body := range msg.Templates() {
+			if _, err := textTpl.New(name).Parse(body); err != nil {
+				return nil, nil, err
+			}
+			if _, err := tpl.New(name).Parse(body); err != nil {
+				return nil, nil, err
+			}
+		}
 	}
-	textTpl, err := text_template.New("").Funcs(textFunctionMap).ParseGlob("templates/email/*.html")
+	return tpl, textTpl, nil
+}
+
+// Renders the named mail template and returns the filled text, filled
+// html, and filled subject line, or an error. A template saved in the
+// datastore for the event is used in preference to the file. Mail for a
+// person gets a link to their mail preferences, as UnsubscribeLink in the
+// data and at the bottom if the template doesn't use it (see
+// mail_preferences.go).
+func renderMail(ctx context.Context, wr WrappedRequest, templatePrefix string, data interface{}, needSubject bool) (string, string, string, error) {
+	msg, err := message.GetMessage(ctx, wr.EventKey, templatePrefix)
 	if err != nil {
-		return "", "", "", err
+		log.Printf("Getting message %q, using files instead: %v", templatePrefix, err)
+		msg = nil
 	}
-	textTpl, err = textTpl.ParseGlob("templates/" + wr.Event.ShortName + "/email/*.html")
+	tpl, textTpl, err := parseMailTemplates(wr.Event.ShortName, msg)
 	if err != nil {
 		return "", "", "", err
 	}
-
-	// Hard-code that we want the roomingInfo template available for now.
-	textTpl, err = textTpl.ParseFiles("templates/roomingInfo.html")
-	if err != nil {
-		return "", "", "", err
+	link := unsubscribeLink(ctx, wr.Event, templatePrefix, data)
+	if link != "" {
+		data.(map[string]interface{})["UnsubscribeLink"] = link
 	}
+	text, html, subject, err := executeMail(tpl, textTpl, templatePrefix, data, needSubject)
+	text, html = addUnsubscribeFooter(link, text, html)
+	return text, html, subject, err
+}
 
+// executeMail fills the text, html and (if needSubject) subject templates
+// for templatePrefix. A message with no html template is sent as text only.
+func executeMail(tpl *template.Template, textTpl *text_template.Template, templatePrefix string, data interface{}, needSubject bool) (string, string, string, error) {
 	var text bytes.Buffer
 	if err := textTpl.ExecuteTemplate(&text, templatePrefix+"_text", data); err != nil {
 		return "", "", "", err
 	}
 	var htmlBuf bytes.Buffer
-	if err := tpl.ExecuteTemplate(&htmlBuf, templatePrefix+"_html", data); err != nil {
-		return text.String(), "", "", err
+	if t := tpl.Lookup(templatePrefix + "_html"); t != nil && t.Tree != nil && len(t.Tree.Root.Nodes) > 0 {
+		if err := t.Execute(&htmlBuf, data); err != nil {
+			return text.String(), "", "", err
+		}
 	}
 	if needSubject {
 		var subject bytes.Buffer
 		if err := textTpl.ExecuteTemplate(&subject, templatePrefix+"_subject", data); err != nil {
 			return text.String(), htmlBuf.String(), "", err
 		}
-		return text.String(), htmlBuf.String(), subject.String(), nil
+		return text.String(), htmlBuf.String(), strings.TrimSpace(subject.String()), nil
 	}
 	return text.String(), htmlBuf.String(), "", nil
 }
@@ -108,11 +144,11 @@ func handleViewMyInvitation(ctx context.Context, wr WrappedRequest) {
 	handleMailPage(ctx, wr, "initial_invitation", "viewMyInvitation.html")
 }
 
-func handleMailPage(ctx context.Context, wr WrappedRequest, emailTemplate, htmlTemplate string) {
-	// TODO: What data do we send this?
-	realizedInvitation := makeRealizedInvitation(ctx, wr.LoginInfo.InvitationKey,
-		wr.LoginInfo.Invitation)
-	roomingInfo := getRoomingInfoWithInvitation(ctx, wr, wr.LoginInfo.Invitation, wr.LoginInfo.InvitationKey)
+// makeMailData returns the data that email templates are rendered with,
+// for a message to p about the given invitation.
+func makeMailData(ctx context.Context, wr WrappedRequest, invitationKey *datastore.Key, inv *Invitation, p *person.Person) map[string]interface{} {
+	realizedInvitation := makeRealizedInvitation(ctx, invitationKey, inv)
+	roomingInfo := getRoomingInfoWithInvitation(ctx, wr, inv, invitationKey)
 	var unreserved []BuildingRoom
 	if roomingInfo != nil {
 		for _, booking := range roomingInfo.InviteeBookings {
@@ -121,29 +157,36 @@ func handleMailPage(ctx context.Context, wr WrappedRequest, emailTemplate, htmlT
 			}
 		}
 	}
-	emailData := map[string]interface{}{
+	return map[string]interface{}{
 		"Event":       wr.Event,
 		"Invitation":  realizedInvitation,
-		"Person":      wr.LoginInfo.Person,
-		"LoginLink":   makeLoginUrl(wr.LoginInfo.Person, true),
+		"Person":      p,
+		"LoginLink":   makeLoginUrl(wr.Event, p, true),
 		"RoomingInfo": roomingInfo,
 		"Env":         wr.GetEnvForTemplates(),
 		"Unreserved":  unreserved,
 	}
-	text, html, subject, err := renderMail(wr, emailTemplate, emailData, true)
+}
+
+func handleMailPage(ctx context.Context, wr WrappedRequest, emailTemplate, htmlTemplate string) {
+	// TODO: What data do we send this?
+	emailData := makeMailData(ctx, wr, wr.LoginInfo.InvitationKey, wr.LoginInfo.Invitation, wr.LoginInfo.Person)
+	text, html, subject, err := renderMail(ctx, wr, emailTemplate, emailData, true)
 	if err != nil {
 		http.Error(wr.ResponseWriter, fmt.Sprintf("Rendering mail: %v", err),
 			http.StatusInternalServerError)
 		return
 	}
-	data := wr.MakeTemplateData(map[string]interface{}{
-		"TemplateName":    emailTemplate,
-		"Subject":         subject,
-		"Body":            text,
-		"HTMLBody":        template.HTML(html),
-		"AllDistributors": AllDistributors,
-	})
-	tpl, err := template.ParseFiles("templates/main.html", "templates/"+htmlTemplate)
+	segment := defaultSegment(mailRecipientType(ctx, wr.EventKey, emailTemplate))
+	data := wr.MakeTemplateData(segmentFormData(ctx, wr, segment, map[string]interface{}{
+		"TemplateName":  emailTemplate,
+		"Subject":       subject,
+		"Body":          text,
+		"HTMLBody":      template.HTML(html),
+		"DeliveryModes": AllDeliveryModes(),
+		"RealMode":      DeliverReal,
+	}))
+	tpl, err := template.ParseFiles("templates/main.html", "templates/"+htmlTemplate, "templates/segmentFields.html")
 	if err != nil {
 		http.Error(wr.ResponseWriter, fmt.Sprintf("Parsing files: %v", err),
 			http.StatusInternalServerError)
@@ -157,61 +200,164 @@ func handleMailPage(ctx context.Context, wr WrappedRequest, emailTemplate, htmlT
 	}
 }
 
+// handlePreviewSegment returns how many people the send mail form's
+// segment selects, and who they are, as JSON.
+func handlePreviewSegment(ctx context.Context, wr WrappedRequest) {
+	preview := struct {
+		Error       string
+		Description string
+		Count       int
+		People      []string
+	}{}
+	defer func() {
+		wr.ResponseWriter.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(wr.ResponseWriter).Encode(preview); err != nil {
+			log.Printf("Encoding segment preview: %v", err)
+		}
+	}()
+	wr.Request.ParseForm()
+	segment, err := segmentFromForm(wr.Request.Form)
+	if err != nil {
+		preview.Error = err.Error()
+		return
+	}
+	recipients, err := segment.Recipients(ctx, wr, wr.Request.Form.Get("emailTemplate"))
+	if err != nil {
+		preview.Error = err.Error()
+		return
+	}
+	preview.Description = segment.Description(wr.Event)
+	preview.Count = len(recipients)
+	for _, r := range recipients {
+		preview.People = append(preview.People, fmt.Sprintf("%s <%s>", r.Person.FullName(), r.Person.Email))
+	}
+}
+
 func handleDoSendMail(ctx context.Context, wr WrappedRequest) {
 	wr.Request.ParseForm()
-	emailTemplates, ok := wr.Request.PostForm["emailTemplate"]
-	if !ok || len(emailTemplates) == 0 {
+	emailTemplate := wr.Request.PostForm.Get("emailTemplate")
+	if emailTemplate == "" {
 		http.Error(wr.ResponseWriter,
 			fmt.Sprintf("%s issued without emailTemplate?", wr.URL.Path),
 			http.StatusBadRequest)
 		return
 	}
-	emailTemplate := emailTemplates[0]
-	distributors, ok := wr.Request.PostForm["distributor"]
-	if !ok || len(distributors) == 0 {
-		http.Error(wr.ResponseWriter,
-			fmt.Sprintf("%s issued without distributor?", wr.URL.Path),
-			http.StatusBadRequest)
+	segment, err := segmentFromForm(wr.Request.PostForm)
+	if err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Bad recipients: %v", err), http.StatusBadRequest)
 		return
 	}
-	distributorName := distributors[0]
-	distributor, ok := AllDistributors[distributorName]
-	if !ok {
+	mode, err := strconv.Atoi(wr.Request.PostForm.Get("mode"))
+	if err != nil || mode < int(DeliverList) || mode > int(DeliverReal) {
 		http.Error(wr.ResponseWriter,
-			fmt.Sprintf("Bad distributor name: %s", distributorName),
+			fmt.Sprintf("Bad delivery mode: %q", wr.Request.PostForm.Get("mode")),
 			http.StatusBadRequest)
 		return
 	}
-	bccSelf := wr.Request.PostForm.Get("bccSelf") == "1"
-	var senderFunc EmailSender = func(ctx context.Context, emailData map[string]interface{}, headerData MailHeaderInfo) error {
-		p := emailData["Person"].(*person.Person)
-		if _, ok := emailData["LoginLink"]; !ok {
-			emailData["LoginLink"] = makeLoginUrl(p, true)
-		}
-		if _, ok := emailData["Env"]; !ok {
-			emailData["Env"] = wr.GetEnvForTemplates()
+	recipients, err := segment.Recipients(ctx, wr, emailTemplate)
+	if err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Finding recipients: %v", err),
+			http.StatusInternalServerError)
+		return
+	}
+	wr.ResponseWriter.Header().Set("Content-Type", "text/html")
+	description := segment.Description(wr.Event)
+	fmt.Fprintf(wr.ResponseWriter, "%d recipients (%s).<br>", len(recipients), template.HTMLEscapeString(description))
+	if DeliveryMode(mode) == DeliverList {
+		for _, r := range recipients {
+			fmt.Fprintf(wr.ResponseWriter, "Would send email for %s.<br>", r.Person.Email)
 		}
+		return
+	}
 
-		roomingAndCostInfo := emailData["RoomingInfo"].(*RoomingAndCostInfo)
+	// A dry run sends everyone's mail to the admin, so each one is a
+	// separate test mailing.
+	mailingName := ""
+	if DeliveryMode(mode) == DeliverReal {
+		mailingName = emailTemplate
+	}
+	mailingKey, err := getMailing(ctx, wr, mailingName, emailTemplate, description)
+	if err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Creating mailing: %v", err),
+			http.StatusInternalServerError)
+		return
+	}
+	bccSelf := wr.Request.PostForm.Get("bccSelf") == "1"
+	if err := queueSegmentMail(ctx, wr, mailingKey, emailTemplate, recipients, mailingName == "", bccSelf, wr.ResponseWriter); err != nil {
+		fmt.Fprintf(wr.ResponseWriter, "Error %v", err)
+		return
+	}
+	fmt.Fprintf(wr.ResponseWriter, "<p>Messages are queued and will go out over the next few minutes. <a href=\"mailing?mailing=%s\">Mailing status</a></p>", mailingKey.Encode())
+}
+
+// queueSegmentMail queues emailTemplate for each of the recipients in the
+// mailing. In a test mailing the messages all go to the admin. Progress is
+// written to out.
+func queueSegmentMail(ctx context.Context, wr WrappedRequest, mailingKey *datastore.Key, emailTemplate string,
+	recipients []Recipient, test, bccSelf bool, out io.Writer) error {
+	for _, r := range recipients {
+		p := r.Person
+		emailData := r.emailData(wr)
+		emailData["LoginLink"] = makeLoginUrl(wr.Event, p, true)
+		emailData["Env"] = wr.GetEnvForTemplates()
 		var unreserved []BuildingRoom
-		if roomingAndCostInfo != nil {
-			for _, booking := range roomingAndCostInfo.InviteeBookings {
-				if !booking.ReservationMade {
-					unreserved = append(unreserved, BuildingRoom{booking.Room, booking.Building})
-				}
-			}
+		if r.RoomingInfo != nil {
+			unreserved = r.RoomingInfo.Unreserved()
 		}
 		emailData["Unreserved"] = unreserved
-		headerData.BccSelf = bccSelf
-		return sendMail(wr, emailTemplate, emailData, headerData)
-	}
-	if err := distributor.Distribute(ctx, wr, senderFunc); err != nil {
-		// Email distributors output info as they go, so don't issue an HTTP error.
-		fmt.Fprintf(wr.ResponseWriter, "Error from email distributor: %v", err)
+
+		headerData := MailHeaderInfo{To: []string{p.Email}, BccSelf: bccSelf}
+		if test {
+			headerData.To = []string{wr.LoginInfo.Person.Email}
+		}
+		msg, err := renderMessage(ctx, wr, emailTemplate, emailData, headerData)
+		if err != nil {
+			return fmt.Errorf("rendering mail for %s: %v", p.Email, err)
+		}
+		if test {
+			// Don't let the admin's mail client unsubscribe the recipient.
+			msg.Unsubscribe = ""
+		}
+		job := newMailJob(p.FullName(), msg)
+		job.Template = emailTemplate
+		job.Invitation = r.InvitationKey
+		jobName := ""
+		if !test {
+			job.Person = p.DatastoreKey
+			jobName = p.DatastoreKey.Encode()
+		}
+		queued, err := queueMail(ctx, mailingKey, jobName, job)
+		if err != nil {
+			return fmt.Errorf("queueing mail for %s: %v", p.Email, err)
+		}
+		if !queued {
+			fmt.Fprintf(out, "Skipping %s, who has already been sent this mailing or is queued for it.<br>", p.Email)
+		} else if test {
+			fmt.Fprintf(out, "Sending email for %s to %s.<br>", p.Email, wr.LoginInfo.Person.Email)
+		} else {
+			fmt.Fprintf(out, "Sending email for %s.<br>", p.Email)
+		}
 	}
+	return nil
 }
 
-func handleListMail(ctx context.Context, wr WrappedRequest) {
+// MailTemplate is an email template that can be sent for the event.
+// Templates edited on the site are listed with the files they replace.
+type MailTemplate struct {
+	Name    string
+	File    bool
+	Message *message.Message
+}
+
+// RecipientType returns who the template is meant for.
+func (t *MailTemplate) RecipientType() message.RecipientType {
+	return templateRecipientType(t.Message, t.Name)
+}
+
+// mailTemplateNames returns the names of the shared and the event's email
+// template files. Each file defines the templates for one mail, named for
+// the file.
+func mailTemplateNames(eventShortName string) []string {
 	templateNames, err := filepath.Glob("templates/email/*.html")
 	if err != nil {
 		log.Printf("Error globbing email templates: %v", err)
@@ -220,21 +366,51 @@ func handleListMail(ctx context.Context, wr WrappedRequest) {
 		templateNames[i] = strings.TrimPrefix(templateNames[i], "templates/email/")
 		templateNames[i] = strings.TrimSuffix(templateNames[i], ".html")
 	}
-	eventTemplateNames, err := filepath.Glob("templates/" + wr.Event.ShortName + "/email/*.html")
+	eventTemplateNames, err := filepath.Glob("templates/" + eventShortName + "/email/*.html")
 	if err != nil {
 		log.Printf("Error globbing event email templates: %v", err)
 	}
 	for i := range eventTemplateNames {
-		eventTemplateNames[i] = strings.TrimPrefix(eventTemplateNames[i], "templates/"+wr.Event.ShortName+"/email/")
+		eventTemplateNames[i] = strings.TrimPrefix(eventTemplateNames[i], "templates/"+eventShortName+"/email/")
 		eventTemplateNames[i] = strings.TrimSuffix(eventTemplateNames[i], ".html")
 	}
+	return append(templateNames, eventTemplateNames...)
+}
+
+// getMailTemplates returns the current event's email templates, by name.
+func getMailTemplates(ctx context.Context, wr WrappedRequest) []*MailTemplate {
+	templateNames := mailTemplateNames(wr.Event.ShortName)
+
+	rows := make(map[string]*MailTemplate)
+	for _, name := range templateNames {
+		rows[name] = &MailTemplate{Name: name, File: true}
+	}
+	messages, err := message.GetMessages(ctx, wr.EventKey)
+	if err != nil {
+		log.Printf("Error fetching messages: %v", err)
+	}
+	for _, msg := range messages {
+		if rows[msg.ShortName] == nil {
+			rows[msg.ShortName] = &MailTemplate{Name: msg.ShortName}
+		}
+		rows[msg.ShortName].Message = msg
+	}
+	var templates []*MailTemplate
+	for _, row := range rows {
+		templates = append(templates, row)
+	}
+	sort.Slice(templates, func(a, b int) bool { return templates[a].Name < templates[b].Name })
+	return templates
+}
+
+func handleListMail(ctx context.Context, wr WrappedRequest) {
+	templates := getMailTemplates(ctx, wr)
 
-	templateNames = append(templateNames, eventTemplateNames...)
 	functionMap := template.FuncMap{
 		"makeSendMailLink": makeSendMailLink,
 	}
 	tpl := template.Must(template.New("").Funcs(functionMap).ParseFiles("templates/main.html", "templates/listEmail.html"))
-	data := wr.MakeTemplateData(map[string]interface{}{"Templates": templateNames})
+	data := wr.MakeTemplateData(map[string]interface{}{"Templates": templates})
 	if err := tpl.ExecuteTemplate(wr.ResponseWriter, "listEmail.html", data); err != nil {
 		log.Println(err)
 	}
@@ -246,71 +422,84 @@ func makeSendMailLink(templateName string) string {
 
 const senders = "Dana Scott and Chris Shabsin"
 
-func sendMail(wr WrappedRequest, templatePrefix string, data interface{},
-	headerData MailHeaderInfo) error {
-	text, html, subject, err := renderMail(wr, templatePrefix, data,
+// sendMail renders the named template and sends it right away. If
+// recipient isn't nil, the mail is recorded as sent to them.
+func sendMail(ctx context.Context, wr WrappedRequest, templatePrefix string, data interface{},
+	headerData MailHeaderInfo, recipient *person.Person) error {
+	msg, err := renderMessage(ctx, wr, templatePrefix, data, headerData)
+	if err != nil {
+		log.Printf("Error rendering mail: %v", err)
+		return err
+	}
+
+	log.Printf("sending mail to %v: %q", headerData.To, msg.Subject)
+	messageID, err := wr.MailTransport.Send(wr.Request.Context(), msg)
+	if err != nil {
+		log.Printf("Error sending mail to %v: %v", headerData.To, err)
+	}
+	if recipient != nil {
+		recordSentMail(ctx, recipient.DatastoreKey, nil,
+			directSentMail(wr, templatePrefix, strings.Join(headerData.To, ", "), msg, messageID, err))
+	}
+	return nil
+}
+
+// renderMessage renders the named mail template into a message from the
+// hosts, addressed as headerData says.
+func renderMessage(ctx context.Context, wr WrappedRequest, templatePrefix string, data interface{},
+	headerData MailHeaderInfo) (*mailer.Message, error) {
+	text, html, subject, err := renderMail(ctx, wr, templatePrefix, data,
 		/* needSubject = */ headerData.Subject == "")
 	if headerData.Subject != "" {
 		subject = headerData.Subject
 	}
 	if err != nil {
-		log.Printf("Error rendering mail: %v", err)
-		return err
+		return nil, err
 	}
-	bccPers := mail.NewPersonalization()
+	bcc := addresses(headerData.Bcc)
 	if headerData.BccSelf {
-		bccPers.AddBCCs(mail.NewEmail("", wr.GetBccAddress()))
+		bcc = append(bcc, wr.bccAddresses()...)
 	}
-
 	// TODO(cshabsin): get string name from somewhere environmental?
-	message := &mail.SGMailV3{
-		From:    mail.NewEmail(senders, wr.GetSenderAddress()),
-		Subject: subject,
-		Content: []*mail.Content{
-			mail.NewContent("text/plain", text),
-			mail.NewContent("text/html", html),
-		},
-		Personalizations: []*mail.Personalization{
-			ToListPersonalization(wr, headerData.To),
-		},
-	}
-
-	log.Printf("sending mail to %v: %v", headerData.To, message)
-	if resp, err := wr.GetEmailClient().Send(message); err != nil {
-		log.Printf("sendgrid.Send got err: %v, %v", resp, err)
-	} else {
-		log.Printf("sendgrid.Send got resp: %v", resp)
-	}
-	return nil
+	return &mailer.Message{
+		From:        mailer.Address{Name: senders, Email: wr.GetSenderAddress()},
+		To:          addresses(headerData.To),
+		Cc:          addresses(headerData.Cc),
+		Bcc:         bcc,
+		Subject:     subject,
+		Text:        text,
+		HTML:        html,
+		Unsubscribe: unsubscribeLink(ctx, wr.Event, templatePrefix, data),
+		Attachments: headerData.Attachments,
+	}, nil
 }
 
-func ToListPersonalization(wr WrappedRequest, to []string) *mail.Personalization {
-	mailPersonalizations := mail.NewPersonalization()
-	for _, to := range to {
-		mailPersonalizations.AddTos(mail.NewEmail("", to))
+// addresses converts bare email addresses to mailer.Addresses.
+func addresses(emails []string) []mailer.Address {
+	var addrs []mailer.Address
+	for _, email := range emails {
+		addrs = append(addrs, mailer.Address{Email: email})
 	}
-	mailPersonalizations.AddBCCs(mail.NewEmail("", wr.GetBccAddress()))
-	return mailPersonalizations
+	return addrs
 }
 
-func ToPersonalization(name, addr string) *mail.Personalization {
-	mailPersonalizations := mail.NewPersonalization()
-	mailPersonalizations.AddTos(mail.NewEmail(name, addr))
-	return mailPersonalizations
+// bccAddresses returns the archive address that outgoing mail is copied
+// to, if one is configured.
+func (w WrappedRequest) bccAddresses() []mailer.Address {
+	if w.GetBccAddress() == "" {
+		return nil
+	}
+	return []mailer.Address{{Email: w.GetBccAddress()}}
 }
 
 func sendErrorMail(wr WrappedRequest, message string) {
-	mailPersonalizations := mail.NewPersonalization()
-	mailPersonalizations.AddTos(mail.NewEmail("Errors", wr.GetErrorAddress()))
-	msg := &mail.SGMailV3{
-		From:    mail.NewEmail(senders, wr.GetSenderAddress()),
+	msg := &mailer.Message{
+		From:    mailer.Address{Name: senders, Email: wr.GetSenderAddress()},
+		To:      []mailer.Address{{Name: "Errors", Email: wr.GetErrorAddress()}},
 		Subject: "[conju] Runtime error report",
-		Content: []*mail.Content{
-			mail.NewContent("text/plain", message),
-		},
-		Personalizations: []*mail.Personalization{mailPersonalizations},
+		Text:    message,
 	}
-	if _, err := wr.GetEmailClient().Send(msg); err != nil {
+	if _, err := wr.MailTransport.Send(wr.Request.Context(), msg); err != nil {
 		log.Printf("Error sending error mail: %v", err)
 	}
 }
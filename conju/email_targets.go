@@ -3,343 +3,348 @@ package conju
 import (
 	"context"
 	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 
 	"cloud.google.com/go/datastore"
+
 	"github.com/cshabsin/conju/conju/dsclient"
 	"github.com/cshabsin/conju/invitation"
+	"github.com/cshabsin/conju/model/event"
+	"github.com/cshabsin/conju/model/message"
+	"github.com/cshabsin/conju/model/person"
 )
 
-// This file defines a set of EmailDistributors, which the
-// handleSendMail function uses to
-
-type EmailSender func(context.Context, map[string]interface{}, MailHeaderInfo) error
+// This file defines recipient segments, which handleDoSendMail uses to
+// choose who a mailing goes to. A segment combines filters on the event's
+// invitations and invitees; a person has to pass every filter that is set.
+// Invitees with no email address, or whose address has hard bounced (see
+// mail_events.go), are never included, and nor are invitees who don't want
+// the mail (see mail_preferences.go) unless it's transactional.
 
-type EmailDistributor func(context.Context, WrappedRequest, EmailSender) error
-type EmailDistributorEntry struct {
-	NeedsConfirm bool
-	Distribute   EmailDistributor
+// Segment selects invitees of the current event.
+type Segment struct {
+	RsvpStatuses       []invitation.RsvpStatus // RSVPed with one of these
+	Attending          bool                    // RSVPed with an attending status
+	Undecided          bool                    // RSVPed with an undecided status
+	NotDeclined        bool                    // hasn't RSVPed no
+	NotResponded       bool                    // nobody on the invitation has RSVPed
+	Booked             bool                    // has a room booked
+	Unpaid             bool                    // the invitation owes money
+	HasChildren        bool                    // the invitation includes a child
+	HousingPreferences []HousingPreference     // the invitation's housing preference is one of these
+	Invitations        []*datastore.Key        // only these invitations
+	Essential          bool                    // also send to people who only want essential mail
 }
 
-var AllDistributors = map[string]EmailDistributorEntry{
-	"SelfOnly":              {false, SelfOnlyDistributor},
-	"AllInviteesDryRun":     {false, AllInviteesDryRunDistributor},
-	"AllInvitees*REAL*":     {true, AllInviteesDistributor},
-	"AttendeesList":         {false, AttendeesListDistributor},
-	"AttendeesDryRun":       {false, AttendeesDryRunDistributor},
-	"Attendees*REAL*":       {true, AttendeesDistributor},
-	"QualifiedInviteesList": {false, QualifiedInviteesListDistributor},
-	"QualifiedDryRunList":   {false, QualifiedInviteesDryRunDistributor},
-	"Qualified*REAL*":       {true, QualifiedInviteesDistributor},
+// segmentFlags are the segment's yes/no filters, by form field name.
+var segmentFlags = []struct {
+	Name  string
+	Label string
+	field func(*Segment) *bool
+}{
+	{"attending", "Attending", func(s *Segment) *bool { return &s.Attending }},
+	{"undecided", "Undecided", func(s *Segment) *bool { return &s.Undecided }},
+	{"notDeclined", "Hasn't declined", func(s *Segment) *bool { return &s.NotDeclined }},
+	{"notResponded", "Hasn't responded", func(s *Segment) *bool { return &s.NotResponded }},
+	{"booked", "Booked into a room", func(s *Segment) *bool { return &s.Booked }},
+	{"unpaid", "Unpaid balance", func(s *Segment) *bool { return &s.Unpaid }},
+	{"hasChildren", "Invitation has children", func(s *Segment) *bool { return &s.HasChildren }},
 }
 
-func SelfOnlyDistributor(ctx context.Context, wr WrappedRequest, sender EmailSender) error {
-	client := dsclient.FromContext(ctx)
-	if client == nil {
-		return fmt.Errorf("datastore client is nil")
+// segmentFromForm reads a segment from the send mail form.
+func segmentFromForm(form url.Values) (Segment, error) {
+	var s Segment
+	for _, flag := range segmentFlags {
+		*flag.field(&s) = form.Get(flag.Name) == "1"
 	}
-	wr.ResponseWriter.Header().Set("Content-Type", "text/html")
-	realizedInvitation := makeRealizedInvitation(ctx, wr.LoginInfo.InvitationKey,
-		wr.LoginInfo.Invitation)
-	roomingInfo := getRoomingInfoWithInvitation(ctx, wr, wr.LoginInfo.Invitation, wr.LoginInfo.InvitationKey)
-	fmt.Fprintf(wr.ResponseWriter, "Sending only to &lt;%s&gt;.<br>", wr.LoginInfo.Person.Email)
-	emailData := map[string]interface{}{
-		"Event":       wr.Event,
-		"Invitation":  realizedInvitation,
-		"Person":      wr.LoginInfo.Person,
-		"RoomingInfo": roomingInfo,
+	s.Essential = form.Get("essential") == "1"
+	for _, value := range form["rsvp"] {
+		status, err := strconv.Atoi(value)
+		if err != nil {
+			return s, fmt.Errorf("invalid RSVP status %q", value)
+		}
+		s.RsvpStatuses = append(s.RsvpStatuses, invitation.RsvpStatus(status))
 	}
-	err := sender(ctx, emailData, MailHeaderInfo{To: []string{wr.LoginInfo.Person.Email}})
-	return err
-}
-
-func AllInviteesDryRunDistributor(ctx context.Context, wr WrappedRequest, sender EmailSender) error {
-	wr.ResponseWriter.Header().Set("Content-Type", "text/html")
-	fmt.Fprintf(wr.ResponseWriter, "Looking up all invitees...<br>")
-
-	q := datastore.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
-	var invitations []*Invitation
-	invitationKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
-	if err != nil {
-		return err
+	for _, value := range form["housing"] {
+		pref, err := strconv.Atoi(value)
+		if err != nil {
+			return s, fmt.Errorf("invalid housing preference %q", value)
+		}
+		s.HousingPreferences = append(s.HousingPreferences, HousingPreference(pref))
 	}
-	for i := 0; i < len(invitations); i++ {
-		realizedInvitation := makeRealizedInvitation(ctx, invitationKeys[i],
-			invitations[i])
-		roomingInfo := getRoomingInfoWithInvitation(ctx, wr, invitations[i], invitationKeys[i])
-		for _, p := range realizedInvitation.Invitees {
-			if p.Person.Email == "" {
-				continue
-			}
-			emailData := map[string]interface{}{
-				"Event":       wr.Event,
-				"Invitation":  realizedInvitation,
-				"Person":      &p.Person,
-				"RoomingInfo": roomingInfo,
-			}
-			fmt.Fprintf(wr.ResponseWriter, "Sending email for %s to %s.<br>", p.Person.Email, wr.LoginInfo.Person.Email)
-			err := sender(ctx, emailData, MailHeaderInfo{To: []string{wr.LoginInfo.Person.Email}})
-			if err != nil {
-				fmt.Fprintf(wr.ResponseWriter, "Error emailing %s: %v", p.Person.Email, err)
-				return err
-			}
+	for _, value := range form["segmentInvitation"] {
+		key, err := datastore.DecodeKey(value)
+		if err != nil {
+			return s, fmt.Errorf("invalid invitation: %v", err)
 		}
+		s.Invitations = append(s.Invitations, key)
 	}
-	return nil
+	return s, nil
 }
 
-func AllInviteesDistributor(ctx context.Context, wr WrappedRequest, sender EmailSender) error {
-	wr.ResponseWriter.Header().Set("Content-Type", "text/html")
-	fmt.Fprintf(wr.ResponseWriter, "Looking up all invitees...<br>")
+// defaultSegment returns the segment that the send mail forms start with
+// for a template meant for recipientType: the people coming, for mail
+// meant for attendees, and otherwise everyone invited.
+func defaultSegment(recipientType message.RecipientType) Segment {
+	return Segment{Attending: recipientType == message.Attendees}
+}
 
-	q := datastore.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
-	var invitations []*Invitation
-	invitationKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
-	if err != nil {
-		return err
+// segmentFormData adds what the segmentFields template needs to data, to
+// start the form out with segment's flags.
+func segmentFormData(ctx context.Context, wr WrappedRequest, segment Segment, data map[string]interface{}) map[string]interface{} {
+	checked := make(map[string]bool)
+	for _, flag := range segmentFlags {
+		checked[flag.Name] = *flag.field(&segment)
 	}
-	for i := 0; i < len(invitations); i++ {
-		realizedInvitation := makeRealizedInvitation(ctx, invitationKeys[i],
-			invitations[i])
-		roomingInfo := getRoomingInfoWithInvitation(ctx, wr, invitations[i], invitationKeys[i])
-		for _, p := range realizedInvitation.Invitees {
-			if p.Person.Email == "" {
-				continue
-			}
-			emailData := map[string]interface{}{
-				"Event":       wr.Event,
-				"Invitation":  realizedInvitation,
-				"Person":      &p.Person,
-				"RoomingInfo": roomingInfo,
-			}
-			fmt.Fprintf(wr.ResponseWriter, "Sending email for %s.<br>", p.Person.Email)
-			err := sender(ctx, emailData, MailHeaderInfo{To: []string{p.Person.Email}})
-			if err != nil {
-				fmt.Fprintf(wr.ResponseWriter, "Error emailing %s: %v", p.Person.Email, err)
-				return err
-			}
-		}
+	data["CheckedFlags"] = checked
+	var rsvpStatuses []invitation.RsvpStatusInfo
+	for _, status := range wr.Event.RsvpStatusOrder() {
+		rsvpStatuses = append(rsvpStatuses, wr.Event.RsvpStatusInfo(status))
 	}
-	return nil
+	data["RsvpStatuses"] = rsvpStatuses
+	data["SegmentFlags"] = segmentFlags
+	data["Housing"] = GetAllHousingPreferences()
+	data["Invitations"] = invitationOptions(ctx, wr)
+	return data
 }
 
-func AttendeesListDistributor(ctx context.Context, wr WrappedRequest, sender EmailSender) error {
-	wr.ResponseWriter.Header().Set("Content-Type", "text/html")
-	fmt.Fprintf(wr.ResponseWriter, "Looking up all attendees...<br>")
-
-	q := datastore.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
-	var invitations []*Invitation
-	invitationKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
-	if err != nil {
-		return err
+// Description says in words who the segment selects.
+func (s Segment) Description(ev *event.Event) string {
+	var parts []string
+	if len(s.RsvpStatuses) > 0 {
+		var names []string
+		for _, status := range s.RsvpStatuses {
+			names = append(names, ev.RsvpStatusInfo(status).ShortDescription)
+		}
+		parts = append(parts, "RSVPed "+strings.Join(names, " or "))
 	}
-	for i := 0; i < len(invitations); i++ {
-		realizedInvitation := makeRealizedInvitation(ctx, invitationKeys[i],
-			invitations[i])
-		roomingInfo := getRoomingInfoWithInvitation(ctx, wr, invitations[i], invitationKeys[i])
-		if roomingInfo == nil {
-			continue
+	for _, flag := range segmentFlags {
+		if *flag.field(&s) {
+			parts = append(parts, strings.ToLower(flag.Label))
 		}
-		for _, p := range realizedInvitation.Invitees {
-			if p.Person.Email == "" {
-				continue
-			}
-			if _, found := roomingInfo.Attendees[p.Person.DatastoreKey.ID]; !found {
-				continue
+	}
+	if len(s.HousingPreferences) > 0 {
+		var names []string
+		for _, info := range GetAllHousingPreferences() {
+			for _, pref := range s.HousingPreferences {
+				if info.Preference == pref {
+					names = append(names, info.ReportDescription)
+				}
 			}
-			fmt.Fprintf(wr.ResponseWriter, "Would send email for %s to %s.<br>", p.Person.Email, wr.LoginInfo.Person.Email)
 		}
+		parts = append(parts, "rooming with "+strings.Join(names, " or "))
 	}
-	return nil
+	if len(s.Invitations) > 0 {
+		parts = append(parts, fmt.Sprintf("on %d chosen invitations", len(s.Invitations)))
+	}
+	desc := "all invitees"
+	if len(parts) > 0 {
+		desc = "invitees: " + strings.Join(parts, ", ")
+	}
+	if s.Essential {
+		desc += " (essential mail)"
+	}
+	return desc
 }
 
-func AttendeesDryRunDistributor(ctx context.Context, wr WrappedRequest, sender EmailSender) error {
-	wr.ResponseWriter.Header().Set("Content-Type", "text/html")
-	fmt.Fprintf(wr.ResponseWriter, "Looking up all attendees...<br>")
+// Recipient is a person selected by a segment, with what their email is
+// rendered with.
+type Recipient struct {
+	Person        *person.Person
+	InvitationKey *datastore.Key
+	Invitation    RealizedInvitation
+	RoomingInfo   *RoomingAndCostInfo
+}
 
-	q := datastore.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
+// emailData returns the data email templates are rendered with for the
+// recipient. The sender fills in the rest.
+func (r Recipient) emailData(wr WrappedRequest) map[string]interface{} {
+	return map[string]interface{}{
+		"Event":       wr.Event,
+		"Invitation":  r.Invitation,
+		"Person":      r.Person,
+		"RoomingInfo": r.RoomingInfo,
+	}
+}
+
+// Recipients returns the people the segment selects to be sent the email
+// template, by invitation.
+func (s Segment) Recipients(ctx context.Context, wr WrappedRequest, emailTemplate string) ([]Recipient, error) {
 	var invitations []*Invitation
-	invitationKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
+	var invitationKeys []*datastore.Key
+	if len(s.Invitations) > 0 {
+		invitationKeys = s.Invitations
+		invitations = make([]*Invitation, len(invitationKeys))
+		for i := range invitations {
+			invitations[i] = &Invitation{}
+		}
+		if err := dsclient.FromContext(ctx).GetMulti(ctx, invitationKeys, invitations); err != nil {
+			return nil, err
+		}
+	} else {
+		q := dsclient.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
+		var err error
+		invitationKeys, err = dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	statuses, err := getAddressStatuses(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	for i := 0; i < len(invitations); i++ {
-		realizedInvitation := makeRealizedInvitation(ctx, invitationKeys[i],
-			invitations[i])
-		roomingInfo := getRoomingInfoWithInvitation(ctx, wr, invitations[i], invitationKeys[i])
-		if roomingInfo == nil {
+	transactional := isTransactional(ctx, wr.EventKey, emailTemplate)
+
+	var recipients []Recipient
+	for i, inv := range invitations {
+		if !inv.Event.Equal(wr.EventKey) || !s.includesInvitation(inv) {
+			continue
+		}
+		realizedInvitation := makeRealizedInvitation(ctx, invitationKeys[i], inv)
+		roomingInfo := getRoomingInfoWithInvitation(ctx, wr, inv, invitationKeys[i])
+		if s.Unpaid && (roomingInfo == nil || roomingInfo.IsPaid()) {
+			continue
+		}
+		if s.HasChildren && !anyNonAdult(realizedInvitation.Invitees, wr.Event) {
 			continue
 		}
 		for _, p := range realizedInvitation.Invitees {
-			if p.Person.Email == "" {
+			if p.Person.Email == "" || !s.includesPerson(realizedInvitation, roomingInfo, p) {
 				continue
 			}
-			if _, found := roomingInfo.Attendees[p.Person.DatastoreKey.ID]; !found {
+			pers := p.Person
+			if status := statuses.forPerson(&pers); status != nil && status.BadAddress() {
+				log.Printf("Leaving out %s: mail to %s bounced (%s)", pers.FullName(), pers.Email, status.BounceReason)
 				continue
 			}
-			emailData := map[string]interface{}{
-				"Event":       wr.Event,
-				"Invitation":  realizedInvitation,
-				"Person":      &p.Person,
-				"RoomingInfo": roomingInfo,
-			}
-			fmt.Fprintf(wr.ResponseWriter, "Sending email for %s to %s.<br>", p.Person.Email, wr.LoginInfo.Person.Email)
-			err := sender(ctx, emailData, MailHeaderInfo{To: []string{wr.LoginInfo.Person.Email}})
-			if err != nil {
-				fmt.Fprintf(wr.ResponseWriter, "Error emailing %s: %v", p.Person.Email, err)
-				return err
+			if !transactional && !pers.MailPreference.Wants(s.Essential) {
+				continue
 			}
+			recipients = append(recipients, Recipient{
+				Person:        &pers,
+				InvitationKey: invitationKeys[i],
+				Invitation:    realizedInvitation,
+				RoomingInfo:   roomingInfo,
+			})
 		}
 	}
-	return nil
+	return recipients, nil
 }
 
-func AttendeesDistributor(ctx context.Context, wr WrappedRequest, sender EmailSender) error {
-	wr.ResponseWriter.Header().Set("Content-Type", "text/html")
-	fmt.Fprintf(wr.ResponseWriter, "Looking up all attendees...<br>")
+// includesInvitation applies the filters that only need the invitation.
+func (s Segment) includesInvitation(inv *Invitation) bool {
+	if s.NotResponded && len(inv.RsvpMap) > 0 {
+		return false
+	}
+	if len(s.HousingPreferences) > 0 {
+		found := false
+		for _, pref := range s.HousingPreferences {
+			if inv.Housing == pref {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
 
-	q := datastore.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
-	var invitations []*Invitation
-	invitationKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
-	if err != nil {
-		return err
+// includesPerson applies the filters on each invitee.
+func (s Segment) includesPerson(realizedInvitation RealizedInvitation, roomingInfo *RoomingAndCostInfo, p person.PersonWithKey) bool {
+	info, responded := realizedInvitation.RsvpMap[p.Key]
+	if len(s.RsvpStatuses) > 0 {
+		found := false
+		for _, status := range s.RsvpStatuses {
+			if responded && info.Status == status {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if s.Attending && !(responded && info.Attending) {
+		return false
+	}
+	if s.Undecided && !(responded && info.Undecided) {
+		return false
 	}
-	for i := 0; i < len(invitations); i++ {
-		realizedInvitation := makeRealizedInvitation(ctx, invitationKeys[i],
-			invitations[i])
-		roomingInfo := getRoomingInfoWithInvitation(ctx, wr, invitations[i], invitationKeys[i])
+	if s.NotDeclined && responded && info.Status == invitation.No {
+		return false
+	}
+	if s.Booked {
 		if roomingInfo == nil {
-			continue
+			return false
 		}
-		for _, p := range realizedInvitation.Invitees {
-			if p.Person.Email == "" {
-				continue
-			}
-			if _, found := roomingInfo.Attendees[p.Person.DatastoreKey.ID]; !found {
-				continue
-			}
-			emailData := map[string]interface{}{
-				"Event":       wr.Event,
-				"Invitation":  realizedInvitation,
-				"Person":      &p.Person,
-				"RoomingInfo": roomingInfo,
-			}
-			fmt.Fprintf(wr.ResponseWriter, "Sending email for %s.<br>", p.Person.Email)
-			err := sender(ctx, emailData, MailHeaderInfo{To: []string{p.Person.Email}})
-			if err != nil {
-				fmt.Fprintf(wr.ResponseWriter, "Error emailing %s: %v", p.Person.Email, err)
-				return err
-			}
+		if _, found := roomingInfo.Attendees[p.Person.DatastoreKey.ID]; !found {
+			return false
 		}
 	}
-	return nil
+	return true
 }
 
-// QualifiedInviteesListDistributor is an email distributor that lists all invitees
-// who have not RSVP'ed "no" to the event. If RsvpMap is nil, the invitee has not
-// submitted any RSVP at all, and the person is included.
-func QualifiedInviteesListDistributor(ctx context.Context, wr WrappedRequest, sender EmailSender) error {
-	wr.ResponseWriter.Header().Set("Content-Type", "text/html")
-	fmt.Fprintf(wr.ResponseWriter, "Looking up all invitees...<br>")
-
-	q := datastore.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
-	var invitations []*Invitation
-	invitationKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
-	if err != nil {
-		return err
-	}
-	for i := 0; i < len(invitations); i++ {
-		realizedInvitation := makeRealizedInvitation(ctx, invitationKeys[i],
-			invitations[i])
-		for _, p := range realizedInvitation.Invitees {
-			if p.Person.Email == "" {
-				continue
-			}
-			if len(realizedInvitation.RsvpMap) != 0 && realizedInvitation.RsvpMap[p.Key].Status == invitation.No {
-				fmt.Fprintf(wr.ResponseWriter, "Skipping recipient %s: %v<br>", p.Person.Email, realizedInvitation.RsvpMap[p.Key].Status)
-				continue
-			}
-			fmt.Fprintf(wr.ResponseWriter, "Would send email for %s to %s.<br>", p.Person.Email, wr.LoginInfo.Person.Email)
+func anyNonAdult(invitees []person.PersonWithKey, ev *event.Event) bool {
+	for _, p := range invitees {
+		if p.Person.IsNonAdultAtTime(ev.StartDate) {
+			return true
 		}
 	}
-	return nil
+	return false
 }
 
-// QualifiedInviteesDryRunDistributor is an email distributor that sends the currently
-// logged in user one email for each person who has not RSVP'ed "no" to the event.
-func QualifiedInviteesDryRunDistributor(ctx context.Context, wr WrappedRequest, sender EmailSender) error {
-	wr.ResponseWriter.Header().Set("Content-Type", "text/html")
-	fmt.Fprintf(wr.ResponseWriter, "Looking up all invitees...<br>")
+// DeliveryMode says what sending to a segment does.
+type DeliveryMode int
 
-	q := datastore.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
-	var invitations []*Invitation
-	invitationKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
-	if err != nil {
-		return err
-	}
-	for i := 0; i < len(invitations); i++ {
-		realizedInvitation := makeRealizedInvitation(ctx, invitationKeys[i],
-			invitations[i])
-		roomingInfo := getRoomingInfoWithInvitation(ctx, wr, invitations[i], invitationKeys[i])
-		for _, p := range realizedInvitation.Invitees {
-			if p.Person.Email == "" {
-				continue
-			}
-			if len(realizedInvitation.RsvpMap) != 0 && realizedInvitation.RsvpMap[p.Key].Status == invitation.No {
-				continue
-			}
-			emailData := map[string]interface{}{
-				"Event":       wr.Event,
-				"Invitation":  realizedInvitation,
-				"Person":      &p.Person,
-				"RoomingInfo": roomingInfo,
-			}
-			fmt.Fprintf(wr.ResponseWriter, "Would send email for %s to %s.<br>", p.Person.Email, wr.LoginInfo.Person.Email)
-			err := sender(ctx, emailData, MailHeaderInfo{To: []string{wr.LoginInfo.Person.Email}})
-			if err != nil {
-				fmt.Fprintf(wr.ResponseWriter, "Error emailing %s: %v", p.Person.Email, err)
-				return err
-			}
-		}
+const (
+	DeliverList   DeliveryMode = iota // list who would be sent mail
+	DeliverDryRun                     // send everyone's mail to the admin
+	DeliverReal                       // send everyone their mail
+)
+
+func (m DeliveryMode) String() string {
+	switch m {
+	case DeliverList:
+		return "List recipients"
+	case DeliverDryRun:
+		return "Dry run (send each message to me)"
+	case DeliverReal:
+		return "Send for real"
 	}
-	return nil
+	return fmt.Sprintf("DeliveryMode(%d)", int(m))
 }
 
-// QualifiedInviteesDistributor is an email distributor that sends an email
-// to each person who has not RSVP'ed "no" to the event.
-func QualifiedInviteesDistributor(ctx context.Context, wr WrappedRequest, sender EmailSender) error {
-	wr.ResponseWriter.Header().Set("Content-Type", "text/html")
-	fmt.Fprintf(wr.ResponseWriter, "Looking up all invitees...<br>")
+func AllDeliveryModes() []DeliveryMode {
+	return []DeliveryMode{DeliverList, DeliverDryRun, DeliverReal}
+}
 
-	q := datastore.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
+// InvitationOption is an invitation for a form's select list.
+type InvitationOption struct {
+	Key  string
+	Name string
+}
+
+// invitationOptions returns the event's invitations, by name.
+func invitationOptions(ctx context.Context, wr WrappedRequest) []InvitationOption {
 	var invitations []*Invitation
+	q := dsclient.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
 	invitationKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
 	if err != nil {
-		return err
+		log.Printf("Fetching invitations: %v", err)
 	}
-	for i := 0; i < len(invitations); i++ {
-		realizedInvitation := makeRealizedInvitation(ctx, invitationKeys[i],
-			invitations[i])
-		roomingInfo := getRoomingInfoWithInvitation(ctx, wr, invitations[i], invitationKeys[i])
-		for _, p := range realizedInvitation.Invitees {
-			if p.Person.Email == "" {
-				continue
-			}
-			if len(realizedInvitation.RsvpMap) != 0 && realizedInvitation.RsvpMap[p.Key].Status == invitation.No {
-				continue
-			}
-			emailData := map[string]interface{}{
-				"Event":       wr.Event,
-				"Invitation":  realizedInvitation,
-				"Person":      &p.Person,
-				"RoomingInfo": roomingInfo,
-			}
-			fmt.Fprintf(wr.ResponseWriter, "Would send email for %s to %s.<br>", p.Person.Email, wr.LoginInfo.Person.Email)
-			err := sender(ctx, emailData, MailHeaderInfo{To: []string{p.Person.Email}})
-			if err != nil {
-				fmt.Fprintf(wr.ResponseWriter, "Error emailing %s: %v", p.Person.Email, err)
-				return err
-			}
-		}
+	var options []InvitationOption
+	for i, inv := range invitations {
+		realized := makeRealizedInvitation(ctx, invitationKeys[i], inv)
+		options = append(options, InvitationOption{
+			Key:  invitationKeys[i].Encode(),
+			Name: person.CollectiveAddress(realized.InviteePeople, person.Informal),
+		})
 	}
-	return nil
+	sort.Slice(options, func(a, b int) bool { return options[a].Name < options[b].Name })
+	return options
 }
@@ -0,0 +1,42 @@
+package conju
+
+import (
+	"testing"
+)
+
+func TestRenderMessageBcc(t *testing.T) {
+	t.Setenv("BCC_ADDRESS", "archive@example.com")
+	s := newTestSite(t)
+	wr, _ := s.request(t, "single_adult", true, "GET", "/sendMail", nil)
+	for _, tc := range []struct {
+		header MailHeaderInfo
+		want   []string
+	}{
+		{MailHeaderInfo{To: []string{"avery@example.com"}}, nil},
+		{MailHeaderInfo{To: []string{"avery@example.com"}, BccSelf: true}, []string{"archive@example.com"}},
+		{MailHeaderInfo{To: []string{"avery@example.com"}, Bcc: []string{"drew@example.com"}},
+			[]string{"drew@example.com"}},
+		{MailHeaderInfo{To: []string{"avery@example.com"}, Bcc: []string{"drew@example.com"}, BccSelf: true},
+			[]string{"drew@example.com", "archive@example.com"}},
+	} {
+		data := map[string]interface{}{"Event": *s.ev, "LoginLink": "https://example.com/login"}
+		msg, err := renderMessage(s.ctx, wr, "resendInvitation", data, tc.header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, a := range msg.Bcc {
+			got = append(got, a.Email)
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%+v: Bcc = %v, want %v", tc.header, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%+v: Bcc = %v, want %v", tc.header, got, tc.want)
+				break
+			}
+		}
+	}
+}
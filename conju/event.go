@@ -4,13 +4,16 @@ package conju
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"cloud.google.com/go/datastore"
 
@@ -19,6 +22,7 @@ import (
 	"github.com/cshabsin/conju/invitation"
 	"github.com/cshabsin/conju/model/event"
 	"github.com/cshabsin/conju/model/housing"
+	"github.com/cshabsin/conju/model/pricing"
 	"github.com/cshabsin/conju/model/venue"
 )
 
@@ -26,43 +30,31 @@ type CurrentEvent struct {
 	Key *datastore.Key
 }
 
-// Sets up Event in the WrappedRequest.
+// selectedEventSession is the session value holding the encoded key of
+// the event an admin has chosen to work on.
+const selectedEventSession = "SelectedEvent"
+
+// Sets up Event in the WrappedRequest: the event for the request's host,
+// or else the current event. On admin pages, AdminGetter then switches to
+// the event the admin has selected.
 func EventGetter(ctx context.Context, wr *WrappedRequest) error {
 	if wr.hasRunEventGetter {
 		return nil // Only retrieve once.
 	}
 	wr.hasRunEventGetter = true
-	var key *datastore.Key
-	found, err := event.GetEventForHost(ctx, wr.Host, &wr.Event, &key)
+
+	ev, err := event.GetEventForHost(ctx, wr.Host)
 	if err != nil {
 		return err
 	}
-	if found {
+	if ev != nil {
+		wr.Event = ev
 		wr.TemplateData["CurrentEvent"] = wr.Event
-		wr.EventKey = key
+		wr.EventKey = ev.Key
 		return nil
 	}
 
-	// Don't use the current event in the session - always use the database.
-	//
-	// key, err = wr.RetrieveKeyFromSession("EventKey")
-	// if err != nil {
-	// 	return err
-	// }
-
-	// if key != nil {
-	// 	ev, err := event.GetEvent(ctx, key)
-	// 	if err == nil {
-	// 		// We have retrieved the event successfully.
-	// 		wr.Event = ev
-	// 		wr.EventKey = key
-	// 		wr.TemplateData["CurrentEvent"] = ev
-	// 		return nil
-	// 	}
-	// 	// eat the error and fall back to the db current event
-	// }
-
-	ev, err := event.GetCurrentEvent(ctx)
+	ev, err = event.GetCurrentEvent(ctx)
 	if err != nil {
 		return err
 	}
@@ -70,12 +62,65 @@ func EventGetter(ctx context.Context, wr *WrappedRequest) error {
 
 	wr.TemplateData["CurrentEvent"] = wr.Event
 	wr.EventKey = ev.Key
-	wr.SetSessionValue("EventKey", ev.Key.Encode())
-	wr.SaveSession()
 
 	return nil
 }
 
+// useSelectedEvent switches the request to the event the admin has
+// selected (see handleSelectEvent), if any. Only admin pages use it, so
+// an admin's own RSVP is always for the event everyone else sees.
+func useSelectedEvent(ctx context.Context, wr *WrappedRequest) {
+	key, err := wr.RetrieveKeyFromSession(selectedEventSession)
+	if err != nil {
+		log.Printf("Decoding selected event: %v", err)
+		return
+	}
+	if key == nil {
+		return
+	}
+	ev, err := event.GetEvent(ctx, key)
+	if err != nil {
+		// The event may have been deleted; go back to the default.
+		log.Printf("Getting selected event %v: %v", key, err)
+		wr.SetSessionValue(selectedEventSession, nil)
+		wr.SaveSession()
+		return
+	}
+	wr.Event = ev
+	wr.EventKey = key
+	wr.TemplateData["CurrentEvent"] = wr.Event
+	wr.TemplateData["EventSelected"] = true
+}
+
+// handleSelectEvent sets the event an admin's pages show, from the
+// encoded key in "event". An empty key goes back to the event for the
+// host, or the current event. It then redirects to "next", or /admin.
+func handleSelectEvent(ctx context.Context, wr WrappedRequest) {
+	encoded := wr.Request.FormValue("event")
+	if encoded == "" {
+		wr.SetSessionValue(selectedEventSession, nil)
+	} else {
+		key, err := datastore.DecodeKey(encoded)
+		if err != nil {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Bad event key: %v", err), http.StatusBadRequest)
+			return
+		}
+		if _, err := event.GetEvent(ctx, key); err != nil {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Getting event: %v", err), http.StatusNotFound)
+			return
+		}
+		wr.SetSessionValue(selectedEventSession, encoded)
+	}
+	if err := wr.SaveSession(); err != nil {
+		log.Printf("Saving session: %v", err)
+	}
+	next := wr.Request.FormValue("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		next = "/admin"
+	}
+	http.Redirect(wr.ResponseWriter, wr.Request, next, http.StatusSeeOther)
+}
+
 func handleEvents(ctx context.Context, wr WrappedRequest) {
 	tic := time.Now()
 
@@ -104,7 +149,7 @@ func handleEvents(ctx context.Context, wr WrappedRequest) {
 	buildingRoomMap := make(map[int64][]*housing.Room)
 	buildingKeyMap := make(map[int64]*housing.Building)
 	if len(allVenues) == 1 {
-		q := datastore.NewQuery("Building").Ancestor(allVenues[0].Key).Order("Name")
+		q := dsclient.NewQuery("Building").Ancestor(allVenues[0].Key).Order("Name")
 		buildingKeys, _ := dsclient.FromContext(ctx).GetAll(ctx, q, &buildings)
 
 		for i, building := range buildings {
@@ -113,7 +158,7 @@ func handleEvents(ctx context.Context, wr WrappedRequest) {
 		}
 
 		// whoops this query doesn't use venue
-		q = datastore.NewQuery("Room").Order("RoomNumber").Order("Partition")
+		q = dsclient.NewQuery("Room").Order("RoomNumber").Order("Partition")
 		roomKeys, _ := dsclient.FromContext(ctx).GetAll(ctx, q, &rooms)
 
 		for j, room := range rooms {
@@ -131,11 +176,6 @@ func handleEvents(ctx context.Context, wr WrappedRequest) {
 	if err != nil {
 		log.Printf("Error parsing form: %v", err)
 	}
-	setCurrentKeyEncoded := wr.Request.Form.Get("setCurrent")
-	if setCurrentKeyEncoded != "" {
-		wr.SetSessionValue("EventKey", setCurrentKeyEncoded)
-		wr.SaveSession()
-	}
 	editEventKeyEncoded := wr.Request.Form.Get("editEvent")
 	var editEventKey *datastore.Key
 	if editEventKeyEncoded != "" {
@@ -145,7 +185,9 @@ func handleEvents(ctx context.Context, wr WrappedRequest) {
 		}
 	}
 	log.Print(2)
-	var editEvent *event.Event
+	// Without an event to edit, the form creates a new one, starting from
+	// the default RSVP catalog and pricing.
+	editEvent := event.NewEvent()
 	eventRoomMap := make(map[string]bool)
 	rsvpStatusMap := make(map[invitation.RsvpStatus]bool)
 	activityMap := make(map[string]bool)
@@ -160,13 +202,17 @@ func handleEvents(ctx context.Context, wr WrappedRequest) {
 			building := buildingKeyMap[room.Building.ID]
 			eventRoomMap[building.Code+"_"+strconv.Itoa(room.RoomNumber)+"_"+room.Partition] = true
 		}
-		for _, status := range editEvent.RsvpStatuses {
-			rsvpStatusMap[status] = true
-		}
 		for _, activityKey := range editEvent.Activities {
 			activityMap[activityKey.Encode()] = true
 		}
 	}
+	for _, status := range editEvent.RsvpStatuses {
+		rsvpStatusMap[status] = true
+	}
+	pricingBytes, err := json.MarshalIndent(editEvent.Pricing, "", "  ")
+	if err != nil {
+		log.Printf("Marshaling pricing: %v", err)
+	}
 
 	wr.ResponseWriter.Header().Set("Content-Type", "text/html; charset=utf-8")
 	log.Print(3)
@@ -176,7 +222,11 @@ func handleEvents(ctx context.Context, wr WrappedRequest) {
 		"BuildingOrder":       buildingOrder,
 		"BuildingKeyMap":      buildingKeyMap,
 		"BuildingRoomMap":     buildingRoomMap,
-		"RsvpStatuses":        invitation.GetAllRsvpStatuses(),
+		"RsvpCatalog":         editEvent.RsvpCatalog,
+		"NextRsvpStatus":      editEvent.NextRsvpStatus(),
+		"AllNights":           []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday},
+		"AllMeals":            invitation.GetAllMeals(),
+		"PricingJSON":         string(pricingBytes),
 		"ActivitiesWithKeys":  activitiesWithKeys,
 		"EditEvent":           editEvent,
 		"EditEventKeyEncoded": editEventKeyEncoded,
@@ -184,17 +234,29 @@ func handleEvents(ctx context.Context, wr WrappedRequest) {
 		"ActivityMap":         activityMap,
 		"RoomMap":             eventRoomMap,
 		"Venues":              allVenues,
+		"DefaultBaseURL":      event.DefaultBaseURL,
 	})
 
 	functionMap := template.FuncMap{
-		"makeLoginUrl":   makeLoginUrl,
 		"dereferenceKey": func(key *datastore.Key) datastore.Key { return *key },
+		"join":           strings.Join,
 		"encodeKey": func(key *datastore.Key) string {
 			if key == nil {
 				return ""
 			}
 			return key.Encode()
 		},
+		"coversNight": func(info invitation.RsvpStatusInfo, night time.Weekday) bool {
+			return info.CoversNight(night)
+		},
+		"includesMeal": func(info invitation.RsvpStatusInfo, meal invitation.Meal) bool {
+			for _, m := range info.Meals {
+				if m == meal {
+					return true
+				}
+			}
+			return false
+		},
 	}
 	tpl := template.Must(template.New("").Funcs(functionMap).ParseFiles("templates/main.html", "templates/events.html"))
 	if err := tpl.ExecuteTemplate(wr.ResponseWriter, "events.html", data); err != nil {
@@ -211,7 +273,7 @@ func handleCreateUpdateEvent(ctx context.Context, wr WrappedRequest) {
 		log.Printf("%s=\"%s\"\n", key, value)
 	}
 
-	ev := &event.Event{}
+	ev := event.NewEvent()
 	if form["editEventKeyEncoded"] != nil && form["editEventKeyEncoded"][0] != "" {
 		eventKey, err := datastore.DecodeKey(form["editEventKeyEncoded"][0])
 		if err != nil {
@@ -240,22 +302,86 @@ func handleCreateUpdateEvent(ctx context.Context, wr WrappedRequest) {
 	if err != nil {
 		log.Printf("decoding end date from form: %v", err)
 	}
+	ev.RsvpDeadline = time.Time{}
+	if deadline := strings.TrimSpace(form.Get("rsvpDeadline")); deadline != "" {
+		ev.RsvpDeadline, err = time.Parse(layout, deadline)
+		if err != nil {
+			log.Printf("decoding RSVP deadline from form: %v", err)
+		}
+	}
 
-	allRsvpStatuses := invitation.GetAllRsvpStatuses()
-	var statusesForEvent []invitation.RsvpStatus
+	ev.Hostnames = nil
+	for _, host := range strings.FieldsFunc(form.Get("hostnames"), func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		host = event.NormalizeHost(host)
+		owner, err := event.HostOwner(ctx, host)
+		if err != nil {
+			log.Printf("Looking up hostname %q: %v", host, err)
+		} else if owner != nil && (ev.Key == nil || !owner.Equal(ev.Key)) {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Hostname %s is already used by another event.", host), http.StatusBadRequest)
+			return
+		}
+		ev.Hostnames = append(ev.Hostnames, host)
+	}
+	ev.BaseURL = strings.TrimSpace(form.Get("baseURL"))
+	if ev.BaseURL != "" {
+		if u, err := url.Parse(ev.AbsoluteURL("/")); err != nil || u.Host == "" {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Invalid site address %q.", ev.BaseURL), http.StatusBadRequest)
+			return
+		}
+	}
+
+	catalog := rsvpCatalogFromForm(form)
+	if ev.Key != nil {
+		inUse, err := rsvpStatusesInUse(ctx, ev.Key)
+		if err != nil {
+			log.Printf("Finding RSVP statuses in use: %v", err)
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Finding RSVP statuses in use: %v", err), http.StatusInternalServerError)
+			return
+		}
+		kept := make(map[invitation.RsvpStatus]bool)
+		for _, info := range catalog {
+			kept[info.Status] = true
+		}
+		for _, info := range ev.RsvpCatalog {
+			if inUse[info.Status] && !kept[info.Status] {
+				http.Error(wr.ResponseWriter, fmt.Sprintf("RSVP status %s is used by invitations, so it can't be removed.", info.ShortDescription), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	ev.RsvpCatalog = catalog
+	offered := make(map[invitation.RsvpStatus]bool)
 	for _, statusIntStr := range form["rsvpStatus"] {
-		statusInt, _ := strconv.ParseInt(statusIntStr, 10, 64)
-		statusInfo := allRsvpStatuses[statusInt]
-		statusesForEvent = append(statusesForEvent, statusInfo.Status)
+		statusInt, err := strconv.Atoi(statusIntStr)
+		if err != nil {
+			log.Printf("Parsing rsvpStatus %q: %v", statusIntStr, err)
+			continue
+		}
+		offered[invitation.RsvpStatus(statusInt)] = true
+	}
+	var statusesForEvent []invitation.RsvpStatus
+	for _, info := range ev.RsvpCatalog {
+		if offered[info.Status] {
+			statusesForEvent = append(statusesForEvent, info.Status)
+		}
 	}
 	ev.RsvpStatuses = statusesForEvent
 
+	// An empty schedule falls back to the legacy pricing when loaded.
+	ev.Pricing = pricing.Schedule{}
+	if pricingJSON := strings.TrimSpace(form.Get("pricing")); pricingJSON != "" {
+		if err := json.Unmarshal([]byte(pricingJSON), &ev.Pricing); err != nil {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Invalid pricing: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
 	var rooms []*datastore.Key
 	for _, room := range form["rooms"] {
 
 		components := strings.Split(room, "_")
 		//log.Printf( "found room in building "+components[0]+" with number "+components[1])
-		q := datastore.NewQuery("Building").FilterField("Code", "=", components[0]).KeysOnly()
+		q := dsclient.NewQuery("Building").FilterField("Code", "=", components[0]).KeysOnly()
 		buildingKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, nil)
 		if err != nil {
 			log.Printf("Getting buildings by code %q: %v", components[0], err)
@@ -266,7 +392,7 @@ func handleCreateUpdateEvent(ctx context.Context, wr WrappedRequest) {
 			log.Printf("Parsing value %q: %v", components[1], err)
 		}
 		//log.Printf( "Room number: %v", roomNumber)
-		q = datastore.NewQuery("Room").FilterField("Building", "=", buildingKeys[0]).
+		q = dsclient.NewQuery("Room").FilterField("Building", "=", buildingKeys[0]).
 			FilterField("RoomNumber", "=", roomNumber).
 			FilterField("Partition", "=", components[2]).KeysOnly()
 		roomKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, nil)
@@ -288,16 +414,18 @@ func handleCreateUpdateEvent(ctx context.Context, wr WrappedRequest) {
 
 	makeCurrent := (form["current"] != nil && len(form["current"]) > 0 && form["current"][0] == "on")
 	if makeCurrent {
-		// make all events not current
+		// Only one event can be current.
 		allEvents, err := event.GetAllEvents(ctx)
 		if err != nil {
 			log.Printf("GetAllEvents: %v", err)
 		}
 
-		for _, event := range allEvents {
-			event.Current = false
-			_, err := dsclient.FromContext(ctx).Put(ctx, event.Key, event)
-			if err != nil {
+		for _, other := range allEvents {
+			if !other.Current || (ev.Key != nil && other.Key.Equal(ev.Key)) {
+				continue
+			}
+			other.Current = false
+			if err := event.PutEvent(ctx, other); err != nil {
 				log.Printf("Updating event: %v", err)
 			}
 		}
@@ -312,3 +440,64 @@ func handleCreateUpdateEvent(ctx context.Context, wr WrappedRequest) {
 
 	http.Redirect(wr.ResponseWriter, wr.Request, "events", http.StatusSeeOther)
 }
+
+// rsvpStatusesInUse returns the RSVP statuses that invitations to the
+// event have.
+func rsvpStatusesInUse(ctx context.Context, eventKey *datastore.Key) (map[invitation.RsvpStatus]bool, error) {
+	var invitations []*Invitation
+	q := dsclient.NewQuery("Invitation").FilterField("Event", "=", eventKey)
+	if _, err := dsclient.FromContext(ctx).GetAll(ctx, q, &invitations); err != nil {
+		return nil, err
+	}
+	inUse := make(map[invitation.RsvpStatus]bool)
+	for _, inv := range invitations {
+		for _, status := range inv.RsvpMap {
+			inUse[status] = true
+		}
+	}
+	return inUse, nil
+}
+
+// rsvpCatalogFromForm reads the RSVP catalog editor from /events. Each row
+// is identified by its status value in rsvpCatalogStatus; rows with an
+// empty short description are dropped.
+func rsvpCatalogFromForm(form url.Values) []invitation.RsvpStatusInfo {
+	var catalog []invitation.RsvpStatusInfo
+	for _, statusStr := range form["rsvpCatalogStatus"] {
+		statusInt, err := strconv.Atoi(statusStr)
+		if err != nil {
+			log.Printf("Parsing rsvpCatalogStatus %q: %v", statusStr, err)
+			continue
+		}
+		shortDescription := strings.TrimSpace(form.Get("rsvpShort_" + statusStr))
+		if shortDescription == "" {
+			continue
+		}
+		info := invitation.RsvpStatusInfo{
+			Status:           invitation.RsvpStatus(statusInt),
+			ShortDescription: shortDescription,
+			LongDescription:  strings.TrimSpace(form.Get("rsvpLong_" + statusStr)),
+			Attending:        form.Get("rsvpAttending_"+statusStr) == "on",
+			Undecided:        form.Get("rsvpUndecided_"+statusStr) == "on",
+			NoLodging:        form.Get("rsvpNoLodging_"+statusStr) == "on",
+		}
+		for _, nightStr := range form["rsvpNights_"+statusStr] {
+			night, err := strconv.Atoi(nightStr)
+			if err != nil {
+				log.Printf("Parsing rsvpNights %q: %v", nightStr, err)
+				continue
+			}
+			info.Nights = append(info.Nights, time.Weekday(night))
+		}
+		for _, mealStr := range form["rsvpMeals_"+statusStr] {
+			meal, err := strconv.Atoi(mealStr)
+			if err != nil {
+				log.Printf("Parsing rsvpMeals %q: %v", mealStr, err)
+				continue
+			}
+			info.Meals = append(info.Meals, invitation.Meal(meal))
+		}
+		catalog = append(catalog, info)
+	}
+	return catalog
+}
@@ -0,0 +1,67 @@
+package conju
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/cshabsin/conju/invitation"
+	"github.com/cshabsin/conju/model/event"
+)
+
+func TestUpdateEventKeepsStatusesInUse(t *testing.T) {
+	s := newTestSite(t)
+	// eventForm is the /events form for the site's event, with the
+	// catalog row for status cleared.
+	eventForm := func(cleared invitation.RsvpStatus) url.Values {
+		form := url.Values{
+			"editEventKeyEncoded": {s.ev.Key.Encode()},
+			"venue":               {s.ev.VenueKey().Encode()},
+			"name":                {s.ev.Name},
+			"shortName":           {s.ev.ShortName},
+			"startDate":           {s.ev.StartDate.Format("01/02/2006")},
+			"endDate":             {s.ev.EndDate.Format("01/02/2006")},
+			"current":             {"on"},
+		}
+		for _, info := range s.ev.RsvpCatalog {
+			status := strconv.Itoa(int(info.Status))
+			form.Add("rsvpCatalogStatus", status)
+			if info.Status != cleared {
+				form.Set("rsvpShort_"+status, info.ShortDescription)
+			}
+		}
+		return form
+	}
+	hasStatus := func(status invitation.RsvpStatus) bool {
+		ev, err := event.GetEvent(s.ctx, s.ev.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, info := range ev.RsvpCatalog {
+			if info.Status == status {
+				return true
+			}
+		}
+		return false
+	}
+
+	// The single_adult fixture is coming Thursday.
+	wr, rec := s.request(t, "single_adult", true, "POST", "/createUpdateEvent", eventForm(invitation.ThuFriSat))
+	handleCreateUpdateEvent(s.ctx, wr)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("removing a status in use: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if !hasStatus(invitation.ThuFriSat) {
+		t.Errorf("ThuFriSat was removed from the catalog, but an invitation uses it")
+	}
+
+	wr, rec = s.request(t, "single_adult", true, "POST", "/createUpdateEvent", eventForm(invitation.SatSun))
+	handleCreateUpdateEvent(s.ctx, wr)
+	if rec.Code != http.StatusSeeOther {
+		t.Errorf("removing an unused status: status %d, want %d\n%s", rec.Code, http.StatusSeeOther, rec.Body)
+	}
+	if hasStatus(invitation.SatSun) {
+		t.Errorf("SatSun is still in the catalog")
+	}
+}
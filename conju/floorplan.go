@@ -0,0 +1,242 @@
+package conju
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/model/housing"
+	"github.com/cshabsin/conju/model/person"
+)
+
+// A floorplan draws a building's rooms over its floorplan image. Rooms'
+// ImageTop, ImageLeft, ImageWidth and ImageHeight are pixels from the top
+// left of the rooming tool's box for the building, which shows the image
+// floorplanWidth wide, starting floorplanImageTop pixels down.
+const (
+	floorplanWidth    = 350
+	floorplanImageTop = 78
+)
+
+// floorplanRoom is a room drawn on a floorplan.
+type floorplanRoom struct {
+	Room      *housing.Room
+	Label     string // room number and partition
+	Beds      string
+	State     string // "unoffered", "empty", "booked" or "full", a CSS class
+	Reserved  bool
+	Occupants []string // only filled in for admins
+	Mine      bool     // one of the guest's own rooms
+}
+
+// Title is the room's hover text.
+func (r floorplanRoom) Title() string {
+	title := fmt.Sprintf("%s: %s", r.Label, r.Beds)
+	switch {
+	case r.State == "unoffered":
+		title += " (not offered)"
+	case len(r.Occupants) > 0:
+		for i, name := range r.Occupants {
+			if i == 0 {
+				title += "\n"
+			} else {
+				title += ", "
+			}
+			title += name
+		}
+	}
+	if r.Reserved {
+		title += "\n(reserved)"
+	}
+	return title
+}
+
+type floorplan struct {
+	Key      string
+	Building *housing.Building
+	Width    int
+	ImageTop int
+	Rooms    []floorplanRoom // on the image
+	Unplaced []floorplanRoom // without a position on the image
+}
+
+// makeFloorplan returns the building's floorplan. For admins, the rooms
+// have their states from the event's bookings and list who is booked into
+// them; guests only see which rooms are theirs, those whose encoded keys
+// are in mine.
+func makeFloorplan(ctx context.Context, wr WrappedRequest, buildingKey *datastore.Key, building *housing.Building, admin bool, mine map[string]bool) (*floorplan, error) {
+	rooms, keys, err := buildingRooms(ctx, buildingKey)
+	if err != nil {
+		return nil, err
+	}
+	offered := make(map[string]bool)
+	for _, key := range wr.Event.Rooms {
+		offered[key.Encode()] = true
+	}
+	bookings := make(map[string]Booking)
+	var peopleKeys []*datastore.Key
+	if bookingInfo := wr.GetBookingInfo(ctx); bookingInfo != nil {
+		for _, booking := range bookingInfo.BookingKeyMap {
+			if booking.Room == nil || booking.Room.Parent == nil || !booking.Room.Parent.Equal(buildingKey) {
+				continue
+			}
+			bookings[booking.Room.Encode()] = booking
+			peopleKeys = append(peopleKeys, booking.Roommates...)
+		}
+	}
+	names := make(map[int64]string)
+	if admin && len(peopleKeys) > 0 {
+		people := make([]*person.Person, len(peopleKeys))
+		if err := dsclient.FromContext(ctx).GetMulti(ctx, peopleKeys, people); err != nil {
+			log.Printf("fetching occupants: %v", err)
+		}
+		for i, p := range people {
+			if p != nil {
+				names[peopleKeys[i].ID] = p.FullNameWithAge(wr.Event.StartDate)
+			}
+		}
+	}
+
+	plan := &floorplan{Key: buildingKey.Encode(), Building: building, Width: floorplanWidth, ImageTop: floorplanImageTop}
+	for i, room := range rooms {
+		fr := floorplanRoom{
+			Room:  room,
+			Label: fmt.Sprintf("%d%s", room.RoomNumber, room.Partition),
+			Beds:  housing.BedsString(room.Beds),
+			State: "empty",
+			Mine:  mine[keys[i].Encode()],
+		}
+		booking, booked := bookings[keys[i].Encode()]
+		switch {
+		case !admin:
+			fr.State = ""
+		case !offered[keys[i].Encode()] && !booked:
+			fr.State = "unoffered"
+		case len(booking.Roommates) >= room.Capacity():
+			fr.State = "full"
+		case len(booking.Roommates) > 0:
+			fr.State = "booked"
+		}
+		if admin {
+			fr.Reserved = booking.Reserved
+			for _, key := range booking.Roommates {
+				fr.Occupants = append(fr.Occupants, names[key.ID])
+			}
+		}
+		if room.ImageWidth > 0 && room.ImageHeight > 0 {
+			plan.Rooms = append(plan.Rooms, fr)
+		} else if admin || fr.Mine {
+			plan.Unplaced = append(plan.Unplaced, fr)
+		}
+	}
+	return plan, nil
+}
+
+// handleFloorplan shows the floorplan of the building in "building", or
+// of the first of the event's buildings, colored by the event's bookings.
+func handleFloorplan(ctx context.Context, wr WrappedRequest) {
+	// The buildings with rooms the event offers, in name order.
+	buildingKeys := make(map[string]*datastore.Key)
+	for _, room := range wr.Event.Rooms {
+		buildingKeys[room.Parent.Encode()] = room.Parent
+	}
+	buildingsMap := getBuildingMapForVenue(ctx, wr.Event.VenueKey())
+	type BuildingLink struct {
+		Key  string
+		Name string
+	}
+	var links []BuildingLink
+	for encoded, key := range buildingKeys {
+		if building, ok := buildingsMap[key.ID]; ok {
+			links = append(links, BuildingLink{Key: encoded, Name: building.Name})
+		}
+	}
+	sort.Slice(links, func(a, b int) bool { return links[a].Name < links[b].Name })
+
+	encoded := wr.Request.FormValue("building")
+	if encoded == "" && len(links) > 0 {
+		encoded = links[0].Key
+	}
+	var plan *floorplan
+	if encoded != "" {
+		key, err := decodeKind(encoded, "Building")
+		if err != nil {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Invalid building: %v", err), http.StatusBadRequest)
+			return
+		}
+		var building housing.Building
+		if err := dsclient.FromContext(ctx).Get(ctx, key, &building); err != nil {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Getting building: %v", err), http.StatusNotFound)
+			return
+		}
+		plan, err = makeFloorplan(ctx, wr, key, &building, true, nil)
+		if err != nil {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Making floorplan: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	data := wr.MakeTemplateData(map[string]interface{}{
+		"Buildings": links,
+		"Floorplan": plan,
+	})
+	tpl := template.Must(template.New("").ParseFiles("templates/main.html", "templates/floorplans.html", "templates/floorplan.html"))
+	if err := tpl.ExecuteTemplate(wr.ResponseWriter, "floorplans.html", data); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+// guestFloorplans returns the floorplans of the buildings the invitees
+// are booked into, with their rooms marked. Other guests' names aren't
+// shown.
+func guestFloorplans(ctx context.Context, wr WrappedRequest, inv *Invitation) []*floorplan {
+	bookingInfo := wr.GetBookingInfo(ctx)
+	if bookingInfo == nil {
+		return nil
+	}
+	mine := make(map[string]bool)
+	var buildingKeys []*datastore.Key
+	for _, invitee := range inv.Invitees {
+		bookingID, ok := bookingInfo.PersonToBookingMap[invitee.ID]
+		if !ok {
+			continue
+		}
+		room := bookingInfo.BookingKeyMap[bookingID].Room
+		if room == nil || mine[room.Encode()] {
+			continue
+		}
+		mine[room.Encode()] = true
+		found := false
+		for _, key := range buildingKeys {
+			found = found || key.Equal(room.Parent)
+		}
+		if !found {
+			buildingKeys = append(buildingKeys, room.Parent)
+		}
+	}
+
+	var plans []*floorplan
+	for _, key := range buildingKeys {
+		var building housing.Building
+		if err := dsclient.FromContext(ctx).Get(ctx, key, &building); err != nil {
+			log.Printf("fetching building %v: %v", key, err)
+			continue
+		}
+		if building.FloorplanImageUrl == "" {
+			continue
+		}
+		plan, err := makeFloorplan(ctx, wr, key, &building, false, mine)
+		if err != nil {
+			log.Printf("making floorplan of %s: %v", building.Name, err)
+			continue
+		}
+		plans = append(plans, plan)
+	}
+	return plans
+}
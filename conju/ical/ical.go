@@ -0,0 +1,127 @@
+// Package ical writes iCalendar (RFC 5545) files, for the event dates
+// conju mails out and serves as calendar feeds. It only writes what conju
+// needs: events that last whole days or run between two times.
+package ical
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ContentType is the MIME type of a calendar.
+const ContentType = "text/calendar; charset=utf-8"
+
+// Calendar is a set of events.
+type Calendar struct {
+	Name   string // shown by clients that subscribe to the calendar
+	Method string // e.g. "PUBLISH" for a calendar attached to mail; may be empty
+	Events []Event
+}
+
+// Status is an event's STATUS.
+type Status string
+
+const (
+	Confirmed Status = "CONFIRMED"
+	Tentative Status = "TENTATIVE"
+	Cancelled Status = "CANCELLED"
+)
+
+// Event is a VEVENT. If AllDay is set, only the dates of Start and End
+// are used, and End is the first day after the event.
+type Event struct {
+	UID         string // stays the same when the event changes
+	Summary     string
+	Description string
+	Location    string
+	URL         string
+	Start, End  time.Time
+	AllDay      bool
+	Status      Status
+	Stamp       time.Time // when the event was last changed
+}
+
+// Bytes encodes the calendar.
+func (c *Calendar) Bytes() []byte {
+	var buf bytes.Buffer
+	line := func(name, value string) {
+		writeFolded(&buf, name+":"+value)
+	}
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//conju//conju//EN")
+	line("CALSCALE", "GREGORIAN")
+	if c.Method != "" {
+		line("METHOD", c.Method)
+	}
+	if c.Name != "" {
+		line("X-WR-CALNAME", escapeText(c.Name))
+	}
+	for _, e := range c.Events {
+		line("BEGIN", "VEVENT")
+		line("UID", e.UID)
+		line("DTSTAMP", formatTime(e.Stamp))
+		if e.AllDay {
+			line("DTSTART;VALUE=DATE", formatDate(e.Start))
+			line("DTEND;VALUE=DATE", formatDate(e.End))
+		} else {
+			line("DTSTART", formatTime(e.Start))
+			line("DTEND", formatTime(e.End))
+		}
+		line("SUMMARY", escapeText(e.Summary))
+		if e.Description != "" {
+			line("DESCRIPTION", escapeText(e.Description))
+		}
+		if e.Location != "" {
+			line("LOCATION", escapeText(e.Location))
+		}
+		if e.URL != "" {
+			line("URL", e.URL)
+		}
+		if e.Status != "" {
+			line("STATUS", string(e.Status))
+		}
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	return buf.Bytes()
+}
+
+func formatDate(t time.Time) string {
+	return t.Format("20060102")
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// escapeText escapes a TEXT value.
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+// writeFolded writes a content line, folded so that no line is longer than
+// 75 octets, without splitting a UTF-8 sequence.
+func writeFolded(buf *bytes.Buffer, s string) {
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !isRuneStart(s[cut]) {
+			cut--
+		}
+		fmt.Fprintf(buf, "%s\r\n ", s[:cut])
+		s = s[cut:]
+		// The continuation's leading space counts towards its length.
+		limit = 74
+	}
+	buf.WriteString(s)
+	buf.WriteString("\r\n")
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
@@ -0,0 +1,83 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendarBytes(t *testing.T) {
+	cal := &Calendar{
+		Name:   "PSR 2025",
+		Method: "PUBLISH",
+		Events: []Event{
+			{
+				UID:         "stay-1@conju",
+				Summary:     "PSR 2025",
+				Description: "Friday and Saturday nights; leading: Hike, Games",
+				Location:    "Pine Springs Ranch",
+				Start:       time.Date(2025, 6, 6, 0, 0, 0, 0, time.UTC),
+				End:         time.Date(2025, 6, 8, 0, 0, 0, 0, time.UTC),
+				AllDay:      true,
+				Status:      Confirmed,
+				Stamp:       time.Date(2025, 5, 1, 12, 30, 0, 0, time.UTC),
+			},
+			{
+				UID:     "dinner-1@conju",
+				Summary: "Dinner",
+				Start:   time.Date(2025, 6, 6, 18, 0, 0, 0, time.FixedZone("PDT", -7*3600)),
+				End:     time.Date(2025, 6, 6, 20, 0, 0, 0, time.FixedZone("PDT", -7*3600)),
+				Stamp:   time.Date(2025, 5, 1, 12, 30, 0, 0, time.UTC),
+			},
+		},
+	}
+	want := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//conju//conju//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:PSR 2025",
+		"BEGIN:VEVENT",
+		"UID:stay-1@conju",
+		"DTSTAMP:20250501T123000Z",
+		"DTSTART;VALUE=DATE:20250606",
+		"DTEND;VALUE=DATE:20250608",
+		"SUMMARY:PSR 2025",
+		`DESCRIPTION:Friday and Saturday nights\; leading: Hike\, Games`,
+		"LOCATION:Pine Springs Ranch",
+		"STATUS:CONFIRMED",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:dinner-1@conju",
+		"DTSTAMP:20250501T123000Z",
+		"DTSTART:20250607T010000Z",
+		"DTEND:20250607T030000Z",
+		"SUMMARY:Dinner",
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"",
+	}, "\r\n")
+	if got := string(cal.Bytes()); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFolding(t *testing.T) {
+	description := strings.Repeat("é", 100) + "\nSecond line"
+	cal := &Calendar{Events: []Event{{UID: "x", Summary: "x", Description: description}}}
+	var unfolded strings.Builder
+	for i, line := range strings.Split(strings.TrimSuffix(string(cal.Bytes()), "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line %d is %d octets long: %q", i, len(line), line)
+		}
+		if strings.HasPrefix(line, " ") {
+			unfolded.WriteString(line[1:])
+		} else {
+			unfolded.WriteString("\n" + line)
+		}
+	}
+	if want := "\nDESCRIPTION:" + strings.Repeat("é", 100) + `\nSecond line`; !strings.Contains(unfolded.String(), want) {
+		t.Errorf("unfolded calendar doesn't contain %q:\n%s", want, unfolded.String())
+	}
+}
@@ -125,7 +125,7 @@ func SetupEvents(w http.ResponseWriter, ctx context.Context) error {
 
 	venuesMap := make(map[string]datastore.Key)
 	var venues []venue.Venue
-	q := datastore.NewQuery("Venue")
+	q := dsclient.NewQuery("Venue")
 	keys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &venues)
 	if err != nil {
 		log.Printf("GetAll: %v", err)
@@ -136,7 +136,7 @@ func SetupEvents(w http.ResponseWriter, ctx context.Context) error {
 
 	buildingsMap := make(map[string]datastore.Key)
 	var buildings []housing.Building
-	q = datastore.NewQuery("Building")
+	q = dsclient.NewQuery("Building")
 	keys, err = dsclient.FromContext(ctx).GetAll(ctx, q, &buildings)
 	if err != nil {
 		log.Printf("GetAll: %v", err)
@@ -153,7 +153,7 @@ func SetupEvents(w http.ResponseWriter, ctx context.Context) error {
 
 	activityMap := make(map[string]*datastore.Key)
 	var activities []activity.Activity
-	q = datastore.NewQuery("Activity")
+	q = dsclient.NewQuery("Activity")
 	keys, err = dsclient.FromContext(ctx).GetAll(ctx, q, &activities)
 	for i, activityKey := range keys {
 		activityMap[(activities[i]).Keyword] = activityKey
@@ -228,7 +228,7 @@ func getRoomsFromString(roomsString string, ctx context.Context, buildingsMap ma
 		parts := strings.Split(r, "_")
 		buildingKey := (buildingsMap[parts[0]])
 		if len(parts) == 1 {
-			q := datastore.NewQuery("Room").Filter("Building =", &buildingKey).KeysOnly()
+			q := dsclient.NewQuery("Room").FilterField("Building", "=", &buildingKey).KeysOnly()
 			roomKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, nil)
 			if err != nil {
 				log.Printf("fetching rooms for building %s: %v", parts[0], err)
@@ -237,7 +237,7 @@ func getRoomsFromString(roomsString string, ctx context.Context, buildingsMap ma
 		}
 		if len(parts) == 2 {
 			roomNumber, _ := strconv.Atoi(parts[1])
-			q := datastore.NewQuery("Room").Filter("Building =", &buildingKey).Filter("RoomNumber =", roomNumber).KeysOnly()
+			q := dsclient.NewQuery("Room").FilterField("Building", "=", &buildingKey).FilterField("RoomNumber", "=", roomNumber).KeysOnly()
 			roomKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, nil)
 			if err != nil {
 				log.Printf("fetching room %v %v: %v", parts[0], parts[1], err)
@@ -611,7 +611,7 @@ func ReloadHousingSetup(ctx context.Context, wr WrappedRequest) {
 
 	venuesMap := make(map[string]*datastore.Key)
 	var venues []venue.Venue
-	q := datastore.NewQuery("Venue")
+	q := dsclient.NewQuery("Venue")
 	keys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &venues)
 	if err != nil {
 		log.Printf("GetAll: %v", err)
@@ -622,7 +622,7 @@ func ReloadHousingSetup(ctx context.Context, wr WrappedRequest) {
 
 	buildingsMap := make(map[string]datastore.Key)
 	var buildings []housing.Building
-	q = datastore.NewQuery("Building")
+	q = dsclient.NewQuery("Building")
 	keys, err = dsclient.FromContext(ctx).GetAll(ctx, q, &buildings)
 	if err != nil {
 		log.Printf("GetAll: %v", err)
@@ -692,7 +692,7 @@ func SetupVenues(w http.ResponseWriter, ctx context.Context) error {
 			contactPhone := fields[4]
 			website := fields[5]
 
-			venue := venue.Venue{
+			v := &venue.Venue{
 				Name:          name,
 				ShortName:     shortName,
 				ContactPerson: contactPerson,
@@ -701,8 +701,7 @@ func SetupVenues(w http.ResponseWriter, ctx context.Context) error {
 				Website:       website,
 			}
 
-			_, err := dsclient.FromContext(ctx).Put(ctx, datastore.IncompleteKey("Venue", nil), &venue)
-			if err != nil {
+			if err := venue.PutVenue(ctx, v); err != nil {
 				log.Printf("%v", err)
 			}
 			w.Write([]byte(fmt.Sprintf("Loading venue %s\n", fields[0])))
@@ -721,18 +720,12 @@ func SetupBuildings(w http.ResponseWriter, ctx context.Context) error {
 
 	venuesMap := make(map[string]*datastore.Key)
 	var venues []venue.Venue
-	q := datastore.NewQuery("Venue")
+	q := dsclient.NewQuery("Venue")
 	keys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &venues)
 	for i, venueKey := range keys {
 		venuesMap[(venues[i]).ShortName] = venueKey
 	}
 
-	propertiesMap := make(map[string]int)
-	for _, hpb := range GetAllHousingPreferenceBooleans() {
-		propertiesMap[hpb.Name] = hpb.Bit
-	}
-	log.Printf("Properties Map: %v", propertiesMap)
-
 	scanner := bufio.NewScanner(buildingsFile)
 	processedHeader := false
 	for scanner.Scan() {
@@ -743,21 +736,15 @@ func SetupBuildings(w http.ResponseWriter, ctx context.Context) error {
 			name := fields[1]
 			code := fields[2]
 			floorplanUrl := fields[3]
-			propertyList := fields[4]
-			propertyStrings := strings.Split(propertyList, ",")
-			properties := 0
-			for _, b := range propertyStrings {
-				properties += propertiesMap[b]
-				log.Printf("%s: %s --> %d", name, b, propertiesMap[b])
-			}
-			log.Printf("%s total properties: %d", name, properties)
+			attributes := housing.ParseAttributes(fields[4])
+			log.Printf("%s attributes: %v", name, attributes)
 
 			building := housing.Building{
 				Venue:             venue,
 				Name:              name,
 				Code:              code,
 				FloorplanImageUrl: floorplanUrl,
-				Properties:        properties,
+				Attributes:        attributes,
 			}
 
 			_, err := dsclient.FromContext(ctx).Put(ctx, datastore.IncompleteKey("Building", venue), &building)
@@ -780,17 +767,12 @@ func SetupRooms(w http.ResponseWriter, ctx context.Context) error {
 
 	buildingsMap := make(map[string]*datastore.Key)
 	var buildings []housing.Building
-	q := datastore.NewQuery("Building")
+	q := dsclient.NewQuery("Building")
 	keys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &buildings)
 	for i, buildingKey := range keys {
 		buildingsMap[(buildings[i]).Code] = buildingKey
 	}
 
-	propertiesMap := make(map[string]int)
-	for _, hpb := range GetAllHousingPreferenceBooleans() {
-		propertiesMap[hpb.Name] = int(hpb.Bit)
-	}
-
 	scanner := bufio.NewScanner(roomsFile)
 	processedHeader := false
 	for scanner.Scan() {
@@ -800,26 +782,10 @@ func SetupRooms(w http.ResponseWriter, ctx context.Context) error {
 			building := buildingsMap[fields[0]]
 			number, _ := strconv.Atoi(fields[1])
 			partition := fields[2]
-			propertyList := fields[3]
-			propertyStrings := strings.Split(propertyList, ",")
-			properties := 0
-			for _, b := range propertyStrings {
-				properties += propertiesMap[b]
-			}
-			var bedSizes []housing.BedSize
-			for _, c := range fields[4] {
-				switch c {
-				case 'K':
-					bedSizes = append(bedSizes, housing.King)
-				case 'Q':
-					bedSizes = append(bedSizes, housing.Queen)
-				case 'D':
-					bedSizes = append(bedSizes, housing.Double)
-				case 'T':
-					bedSizes = append(bedSizes, housing.Twin)
-				case 'C':
-					bedSizes = append(bedSizes, housing.Cot)
-				}
+			attributes := housing.ParseAttributes(fields[3])
+			bedSizes, err := housing.ParseBeds(fields[4])
+			if err != nil {
+				log.Printf("Room %s%s%s: %v", fields[0], fields[1], fields[2], err)
 			}
 
 			top, _ := strconv.Atoi(fields[5])
@@ -831,7 +797,7 @@ func SetupRooms(w http.ResponseWriter, ctx context.Context) error {
 				Building:    building,
 				RoomNumber:  number,
 				Partition:   partition,
-				Properties:  properties,
+				Attributes:  attributes,
 				Beds:        bedSizes,
 				ImageTop:    top,
 				ImageLeft:   left,
@@ -839,7 +805,7 @@ func SetupRooms(w http.ResponseWriter, ctx context.Context) error {
 				ImageHeight: height,
 			}
 
-			_, err := dsclient.FromContext(ctx).Put(ctx, datastore.IncompleteKey("Room", building), &room)
+			_, err = dsclient.FromContext(ctx).Put(ctx, datastore.IncompleteKey("Room", building), &room)
 			if err != nil {
 				log.Printf("%v", err)
 			}
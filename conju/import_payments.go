@@ -0,0 +1,249 @@
+package conju
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/conju/statement"
+	"github.com/cshabsin/conju/model/person"
+)
+
+// ImportRow is one statement transaction shown for confirmation.
+type ImportRow struct {
+	Transaction     statement.Transaction
+	Method          PaymentType
+	Proposals       []statement.Proposal
+	Best            string // encoded invitation key to preselect
+	AlreadyImported bool
+}
+
+// ImportCandidate is an invitation offered in the import page's menus.
+type ImportCandidate struct {
+	EncodedKey string
+	Name       string
+	Balance    float64
+}
+
+var statementSourceMethods = map[statement.Source]PaymentType{
+	statement.Venmo:        Venmo,
+	statement.PayPal:       PayPal,
+	statement.GoogleWallet: GoogleWallet,
+}
+
+// handleImportPayments shows the statement upload form and, when a
+// statement is posted, the proposed match for each payment in it.
+func handleImportPayments(ctx context.Context, wr WrappedRequest) {
+	var rows []ImportRow
+	var candidates []ImportCandidate
+	if wr.Request.Method == http.MethodPost {
+		if err := wr.Request.ParseMultipartForm(10 << 20); err != nil {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Error parsing upload: %v", err), http.StatusBadRequest)
+			return
+		}
+		file, _, err := wr.Request.FormFile("statement")
+		if err != nil {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Error reading statement: %v", err), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		transactions, err := statement.Parse(file)
+		if err != nil {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Error parsing statement: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		matchCandidates, err := getImportCandidates(ctx, wr)
+		if err != nil {
+			log.Printf("%v", err)
+			http.Error(wr.ResponseWriter, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		imported, err := importedReferences(ctx, wr.EventKey)
+		if err != nil {
+			log.Printf("%v", err)
+		}
+
+		for _, t := range transactions {
+			row := ImportRow{
+				Transaction:     t,
+				Method:          statementSourceMethods[t.Source],
+				Proposals:       statement.Match(t, matchCandidates),
+				AlreadyImported: t.Reference() != "" && imported[t.Reference()],
+			}
+			if best := statement.Best(row.Proposals); best != nil {
+				row.Best = best.Candidate.ID
+			}
+			rows = append(rows, row)
+		}
+		for _, c := range matchCandidates {
+			candidates = append(candidates, ImportCandidate{
+				EncodedKey: c.ID,
+				Name:       strings.Join(c.Names, ", "),
+				Balance:    c.Balance,
+			})
+		}
+		sort.Slice(candidates, func(a, b int) bool { return candidates[a].Name < candidates[b].Name })
+	}
+
+	data := wr.MakeTemplateData(map[string]interface{}{
+		"Rows":       rows,
+		"Candidates": candidates,
+		"Imported":   wr.Request.URL.Query().Get("imported"),
+	})
+	tpl := template.Must(template.New("").ParseFiles("templates/main.html", "templates/importPayments.html"))
+	if err := tpl.ExecuteTemplate(wr.ResponseWriter, "importPayments.html", data); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+// getImportCandidates returns every invitation to the current event along
+// with what it still owes.
+func getImportCandidates(ctx context.Context, wr WrappedRequest) ([]*statement.Candidate, error) {
+	var invitations []*Invitation
+	q := dsclient.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
+	invitationKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
+	if err != nil {
+		return nil, fmt.Errorf("fetching invitations: %w", err)
+	}
+
+	var candidates []*statement.Candidate
+	for i, inv := range invitations {
+		people := make([]*person.Person, len(inv.Invitees))
+		if err := dsclient.FromContext(ctx).GetMulti(ctx, inv.Invitees, people); err != nil {
+			log.Printf("fetching invitees: %v", err)
+		}
+		candidate := &statement.Candidate{ID: invitationKeys[i].Encode()}
+		for _, p := range people {
+			if p == nil {
+				continue
+			}
+			candidate.Names = append(candidate.Names, p.FullName())
+			if formal := p.FullNameWithFormality(person.Formal); formal != p.FullName() {
+				candidate.Names = append(candidate.Names, formal)
+			}
+			if p.Email != "" {
+				candidate.Emails = append(candidate.Emails, p.Email)
+			}
+		}
+		if roomingInfo := getRoomingInfoWithInvitation(ctx, wr, inv, invitationKeys[i]); roomingInfo != nil {
+			candidate.Balance = math.Round((roomingInfo.TotalCost-inv.ReceivedPay)*100) / 100
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, nil
+}
+
+// importedReferences returns the references of the payments to the
+// event's invitations that were imported from a statement.
+func importedReferences(ctx context.Context, eventKey *datastore.Key) (map[string]bool, error) {
+	client := dsclient.FromContext(ctx)
+	q := dsclient.NewQuery("Invitation").FilterField("Event", "=", eventKey).KeysOnly()
+	invitationKeys, err := client.GetAll(ctx, q, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fetching invitations: %w", err)
+	}
+	imported := make(map[string]bool)
+	for _, invitationKey := range invitationKeys {
+		var payments []*Payment
+		if _, err := client.GetAll(ctx, dsclient.NewQuery("Payment").Ancestor(invitationKey), &payments); err != nil {
+			return nil, fmt.Errorf("fetching payments: %w", err)
+		}
+		for _, p := range payments {
+			if p.Reference != "" {
+				imported[p.Reference] = true
+			}
+		}
+	}
+	return imported, nil
+}
+
+// handleDoImportPayments records the payments confirmed on the import page.
+func handleDoImportPayments(ctx context.Context, wr WrappedRequest) {
+	wr.Request.ParseForm()
+	form := wr.Request.PostForm
+
+	count, err := strconv.Atoi(form.Get("count"))
+	if err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Invalid row count: %v", err), http.StatusBadRequest)
+		return
+	}
+	imported, err := importedReferences(ctx, wr.EventKey)
+	if err != nil {
+		log.Printf("%v", err)
+		imported = make(map[string]bool)
+	}
+
+	recorded := 0
+	var problems []string
+	for i := 0; i < count; i++ {
+		field := func(name string) string { return form.Get(fmt.Sprintf("%s_%d", name, i)) }
+		if field("confirm") != "on" {
+			continue
+		}
+		reference := field("reference")
+		if reference != "" && imported[reference] {
+			problems = append(problems, fmt.Sprintf("row %d: %s was already imported", i+1, reference))
+			continue
+		}
+		invitationKey, err := datastore.DecodeKey(field("invitation"))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("row %d: no invitation selected", i+1))
+			continue
+		}
+		amount, err := strconv.ParseFloat(field("amount"), 64)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("row %d: invalid amount %q", i+1, field("amount")))
+			continue
+		}
+		date, err := time.Parse("2006-01-02", field("date"))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("row %d: invalid date %q", i+1, field("date")))
+			continue
+		}
+		method, err := strconv.Atoi(field("method"))
+		if err != nil || method < 0 || method >= len(GetAllPaymentTypes()) {
+			problems = append(problems, fmt.Sprintf("row %d: invalid method %q", i+1, field("method")))
+			continue
+		}
+
+		payment := &Payment{
+			Amount:    amount,
+			Method:    PaymentType(method),
+			Date:      date,
+			Note:      strings.TrimSpace(field("note")),
+			Reference: reference,
+			Recorded:  time.Now(),
+		}
+		if wr.User != nil {
+			payment.RecordedBy = wr.User.Email
+		}
+		if err := recordPayment(ctx, invitationKey, payment); err != nil {
+			problems = append(problems, fmt.Sprintf("row %d: %v", i+1, err))
+			continue
+		}
+		if reference != "" {
+			imported[reference] = true
+		}
+		recorded++
+	}
+
+	if len(problems) > 0 {
+		log.Printf("importing payments: %s", strings.Join(problems, "; "))
+		http.Error(wr.ResponseWriter,
+			fmt.Sprintf("Recorded %d payments. These rows were not recorded:\n%s", recorded, strings.Join(problems, "\n")),
+			http.StatusBadRequest)
+		return
+	}
+	http.Redirect(wr.ResponseWriter, wr.Request, fmt.Sprintf("importPayments?imported=%d", recorded), http.StatusSeeOther)
+}
@@ -4,6 +4,7 @@ package conju
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
@@ -44,6 +45,7 @@ type Invitation struct {
 	OtherInfo                 string
 	LastUpdatedPerson         *datastore.Key
 	LastUpdatedTimestamp      time.Time
+	Version                   int64   // incremented by each save; see updateInvitation
 	ReceivedPay               float64 // US Dollars
 	ReceivedPayMethod         string
 	ReceivedPayDate           time.Time
@@ -54,8 +56,6 @@ type Invitation struct {
 const delimiter = "|_|"
 
 func (inv *Invitation) Load(ps []datastore.Property) error {
-	allRsvpStatuses := invitation.GetAllRsvpStatuses()
-
 	inv.RsvpMap = make(map[*datastore.Key]invitation.RsvpStatus)
 	inv.ActivityMap = make(map[*datastore.Key](map[*datastore.Key]ActivityRanking))
 	inv.ActivityLeaderMap = make(map[*datastore.Key](map[*datastore.Key]bool))
@@ -66,7 +66,7 @@ func (inv *Invitation) Load(ps []datastore.Property) error {
 				return err
 			}
 			rsvpInt := p.Value.(int64)
-			inv.RsvpMap[personKey] = allRsvpStatuses[rsvpInt].Status
+			inv.RsvpMap[personKey] = invitation.RsvpStatus(rsvpInt)
 		}
 
 		if strings.HasPrefix(p.Name, "ActivityMap.") {
@@ -219,6 +219,10 @@ func (inv *Invitation) Save() ([]datastore.Property, error) {
 			Name:  "LastUpdatedTimestamp",
 			Value: inv.LastUpdatedTimestamp,
 		},
+		{
+			Name:  "Version",
+			Value: inv.Version,
+		},
 		{
 			Name:  "ReceivedPay",
 			Value: float64(inv.ReceivedPay),
@@ -278,10 +282,9 @@ func (inv *Invitation) Save() ([]datastore.Property, error) {
 	return props, nil
 }
 
-func (inv *Invitation) AnyAttending() bool {
-	allStatuses := invitation.GetAllRsvpStatuses()
+func (inv *Invitation) AnyAttending(ev *event.Event) bool {
 	for _, v := range inv.RsvpMap {
-		attending := allStatuses[v].Attending
+		attending := ev.RsvpStatusInfo(v).Attending
 		if attending {
 			return attending
 		}
@@ -289,22 +292,20 @@ func (inv *Invitation) AnyAttending() bool {
 	return false
 }
 
-func (inv *Invitation) AttendingInvitees() []*datastore.Key {
-	allStatuses := invitation.GetAllRsvpStatuses()
+func (inv *Invitation) AttendingInvitees(ev *event.Event) []*datastore.Key {
 	var attending []*datastore.Key
 	for k, v := range inv.RsvpMap {
-		if allStatuses[v].Attending {
+		if ev.RsvpStatusInfo(v).Attending {
 			attending = append(attending, k)
 		}
 	}
 	return attending
 }
 
-func (inv *Invitation) AnyUndecided() bool {
-	allStatuses := invitation.GetAllRsvpStatuses()
+func (inv *Invitation) AnyUndecided(ev *event.Event) bool {
 	for _, invitee := range inv.Invitees {
 		if rsvp, present := inv.RsvpMap[invitee]; present {
-			undecided := allStatuses[rsvp].Undecided
+			undecided := ev.RsvpStatusInfo(rsvp).Undecided
 			if undecided {
 				return true
 			}
@@ -337,7 +338,7 @@ func handleInvitations(ctx context.Context, wr WrappedRequest) {
 	currentEventKey := wr.EventKey
 
 	var notInvitedSet = make(map[datastore.Key]person.PersonWithKey)
-	personQuery := datastore.NewQuery("Person")
+	personQuery := dsclient.NewQuery("Person")
 	var people []*person.Person
 	personKeys, _ := dsclient.FromContext(ctx).GetAll(ctx, personQuery, &people)
 
@@ -348,7 +349,7 @@ func handleInvitations(ctx context.Context, wr WrappedRequest) {
 
 	var invitations []*Invitation
 
-	q := datastore.NewQuery("Invitation").FilterField("Event", "=", currentEventKey)
+	q := dsclient.NewQuery("Invitation").FilterField("Event", "=", currentEventKey)
 	invitationKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
 	if err != nil {
 		log.Printf(
@@ -458,7 +459,7 @@ func handleCopyInvitations(ctx context.Context, wr WrappedRequest) {
 		log.Printf("error decoding event key: %v", err)
 	}
 	var invitations []*Invitation
-	q := datastore.NewQuery("Invitation").FilterField("Event", "=", baseEventKey)
+	q := dsclient.NewQuery("Invitation").FilterField("Event", "=", baseEventKey)
 	dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
 
 	log.Printf("Found %d invitations from copied event", len(invitations))
@@ -506,18 +507,24 @@ func handleAddInvitation(ctx context.Context, wr WrappedRequest) {
 		newInvitation.Event = currentEventKey
 		newInvitation.Invitees = newPeople
 
-		_, err := dsclient.FromContext(ctx).Put(ctx, newKey, &newInvitation)
+		key, err := dsclient.FromContext(ctx).Put(ctx, newKey, &newInvitation)
 		if err != nil {
 			log.Printf("%v", err)
+		} else if err := recordChange(ctx, wr, key, &Invitation{}, &newInvitation, wr.Event, invitationAuditIgnore); err != nil {
+			log.Printf("%v", err)
 		}
 	} else {
 		existingInvitationKey, _ := datastore.DecodeKey(invitationKeyEncoded)
-		var existingInvitation Invitation
-		dsclient.FromContext(ctx).Get(ctx, existingInvitationKey, &existingInvitation)
-		existingInvitation.Invitees = append(existingInvitation.Invitees, newPeople...)
-		_, err := dsclient.FromContext(ctx).Put(ctx, existingInvitationKey, &existingInvitation)
+		// An RSVP form opened before this would drop the new invitees, so
+		// the save bumps the invitation's version.
+		oldInvitation, existingInvitation, err := updateInvitation(ctx, existingInvitationKey, func(tx dsclient.Client, inv *Invitation) error {
+			inv.Invitees = append(inv.Invitees[:len(inv.Invitees):len(inv.Invitees)], newPeople...)
+			return nil
+		})
 		if err != nil {
 			log.Printf("%v", err)
+		} else if err := recordChange(ctx, wr, existingInvitationKey, &oldInvitation, &existingInvitation, wr.Event, invitationAuditIgnore); err != nil {
+			log.Printf("%v", err)
 		}
 	}
 
@@ -540,6 +547,35 @@ func handleDeleteInvitation(ctx context.Context, wr WrappedRequest) {
 	http.Redirect(wr.ResponseWriter, wr.Request, "invitations", http.StatusSeeOther)
 }
 
+// errStaleInvitation is returned when the RSVP form was opened before the
+// invitation's latest save.
+var errStaleInvitation = errors.New("invitation has been saved since the form was loaded")
+
+// updateInvitation changes a stored invitation in a transaction and bumps
+// its Version, so that an RSVP form opened before the change shows the
+// conflict page instead of overwriting it. update is given the transaction
+// and the invitation as stored. updateInvitation returns a shallow copy of
+// the invitation from before the change, and the invitation after it.
+func updateInvitation(ctx context.Context, key *datastore.Key, update func(tx dsclient.Client, inv *Invitation) error) (Invitation, Invitation, error) {
+	var old, inv Invitation
+	err := dsclient.FromContext(ctx).RunInTransaction(ctx, func(tx dsclient.Client) error {
+		inv = Invitation{}
+		if err := tx.Get(ctx, key, &inv); err != nil {
+			return fmt.Errorf("fetching invitation: %w", err)
+		}
+		old = inv
+		if err := update(tx, &inv); err != nil {
+			return err
+		}
+		inv.Version++
+		if _, err := tx.Put(ctx, key, &inv); err != nil {
+			return fmt.Errorf("saving invitation: %w", err)
+		}
+		return nil
+	})
+	return old, inv, err
+}
+
 // handleViewInvitationUser handles /viewInvitation URLs.
 func handleViewInvitationAdmin(ctx context.Context, wr WrappedRequest) {
 	wr.Request.ParseForm()
@@ -560,17 +596,13 @@ func handleViewInvitationUser(ctx context.Context, wr WrappedRequest) {
 	handleViewInvitation(ctx, wr, wr.InvitationKey)
 }
 
-var (
-	functionMap = template.FuncMap{
-		"PronounString":               person.GetPronouns,
-		"HasPreference":               HasPreference,
-		"DerefPeople":                 DerefPeople,
-		"CollectiveAddressFirstNames": person.CollectiveAddressFirstNames,
-		"SharerName":                  MakeSharerName,
-	}
-
-	invitationTpl = template.Must(template.New("").Funcs(functionMap).ParseFiles("templates/main.html", "templates/viewInvitation.html", "templates/updatePersonForm.html", "templates/roomingInfo.html"))
-)
+var functionMap = template.FuncMap{
+	"PronounString":               person.GetPronouns,
+	"HasPreference":               HasPreference,
+	"DerefPeople":                 DerefPeople,
+	"CollectiveAddressFirstNames": person.CollectiveAddressFirstNames,
+	"SharerName":                  MakeSharerName,
+}
 
 func handleViewInvitation(ctx context.Context, wr WrappedRequest, invitationKey *datastore.Key) {
 	var inv Invitation
@@ -592,10 +624,21 @@ func handleViewInvitation(ctx context.Context, wr WrappedRequest, invitationKey
 		log.Printf("activity.Realize: %v", err)
 	}
 
+	var sentMail []PersonSentMail
+	if wr.IsAdminUser() {
+		sentMail = getInvitationSentMail(ctx, realizedInvitation)
+	}
+
+	roomingInfo := getRoomingInfo(ctx, wr, invitationKey)
+	var floorplans []*floorplan
+	if roomingInfo != nil {
+		floorplans = guestFloorplans(ctx, wr, &inv)
+	}
+
 	data := wr.MakeTemplateData(map[string]interface{}{
 		"Invitation":                   realizedInvitation,
 		"FormInfoMap":                  formInfoMap,
-		"AllRsvpStatuses":              invitation.GetAllRsvpStatuses(),
+		"AllRsvpStatuses":              realizedInvitation.Event.RsvpStatusMap(),
 		"Activities":                   realActivities,
 		"AllHousingPreferences":        GetAllHousingPreferences(),
 		"AllHousingPreferenceBooleans": GetAllHousingPreferenceBooleans(),
@@ -603,9 +646,12 @@ func handleViewInvitation(ctx context.Context, wr WrappedRequest, invitationKey
 		"AllParkingTypes":              GetAllParkingTypes(),
 		"InvitationHasChildren":        inv.HasChildren(ctx),
 		"IsAdminUser":                  wr.IsAdminUser(),
-		"RoomingInfo":                  getRoomingInfo(ctx, wr, invitationKey),
+		"RoomingInfo":                  roomingInfo,
+		"Floorplans":                   floorplans,
+		"SentMail":                     sentMail,
 	})
 
+	invitationTpl := template.Must(template.New("").Funcs(functionMap).ParseFiles("templates/main.html", "templates/viewInvitation.html", "templates/updatePersonForm.html", "templates/roomingInfo.html", "templates/floorplan.html"))
 	if err := invitationTpl.ExecuteTemplate(wr.ResponseWriter, "viewInvitation.html", data); err != nil {
 		log.Printf("%v", err)
 	}
@@ -629,23 +675,150 @@ func handleSaveInvitation(ctx context.Context, wr WrappedRequest) {
 	}
 
 	var inv Invitation
-	dsclient.FromContext(ctx).Get(ctx, invitationKey, &inv)
+	if err := dsclient.FromContext(ctx).Get(ctx, invitationKey, &inv); err != nil {
+		log.Printf("Get invitation: %v", err)
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Get invitation: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	ev, err := event.GetEvent(ctx, inv.Event)
+	if err != nil {
+		log.Printf("GetEvent: %v", err)
+		http.Error(wr.ResponseWriter, fmt.Sprintf("GetEvent: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// The form carries the version it was rendered from. If the invitation
+	// has been saved since, someone else's changes would be lost, so show
+	// the differences instead of saving. The form's fields are replaced
+	// rather than modified, so the shallow copy updateInvitation returns
+	// keeps the old values.
+	formVersion, _ := strconv.ParseInt(wr.Request.Form.Get("version"), 10, 64)
+	oldInv, inv, err := updateInvitation(ctx, invitationKey, func(tx dsclient.Client, inv *Invitation) error {
+		if inv.Version != formVersion {
+			return errStaleInvitation
+		}
+		updateInvitationFromForm(wr, ev, inv)
+		inv.LastUpdatedPerson = wr.LoginInfo.PersonKey
+		inv.LastUpdatedTimestamp = time.Now()
+		return nil
+	})
+	if errors.Is(err, errStaleInvitation) {
+		handleInvitationConflict(ctx, wr, ev, invitationKey, &oldInv)
+		return
+	}
+	if err != nil {
+		log.Printf("saving invitation: %v", err)
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Saving invitation: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := recordChange(ctx, wr, invitationKey, &oldInv, &inv, ev, invitationAuditIgnore); err != nil {
+		log.Printf("%v", err)
+	}
+
+	var invitees []person.Person
+	for _, personKey := range inv.Invitees {
+		var person person.Person
+		dsclient.FromContext(ctx).Get(ctx, personKey, &person)
+		person.DatastoreKey = personKey
+		invitees = append(invitees, person)
+	}
+
+	savePeople(ctx, wr)
+
+	newPeopleNames := wr.Request.Form["newPersonName"]
+	newPeopleDescs := wr.Request.Form["newPersonDescription"]
 
+	var additionalPeople []NewPersonInfo
+	for i, name := range newPeopleNames {
+		additionalPeople = append(additionalPeople, NewPersonInfo{Name: name, Description: newPeopleDescs[i]})
+	}
+
+	newPeopleSubjectFragment := ""
+	if len(additionalPeople) > 0 {
+		newPeopleSubjectFragment = " ADDITION REQUESTED,"
+	}
+
+	subject := fmt.Sprintf("%s:%s RSVP from %s", ev.ShortName, newPeopleSubjectFragment, person.CollectiveAddress(invitees, person.Informal))
+
+	data := makeRsvpConfirmationData(ctx, ev, invitationKey, &inv, additionalPeople)
+
+	header := MailHeaderInfo{
+		To:      []string{wr.GetSenderAddress()},
+		Subject: subject,
+		BccSelf: false,
+	}
+
+	sendMail(ctx, wr, "rsvpconfirmation", data, header, nil)
+
+	if !wr.IsAdminUser() {
+		sendGuestConfirmations(ctx, wr, ev, invitees, data)
+
+		data := wr.MakeTemplateData(map[string]interface{}{
+			"AnyAttending": inv.AnyAttending(ev),
+			"AnyUndecided": inv.AnyUndecided(ev),
+		})
+		if wr.LoginInfo != nil && wr.LoginInfo.Person != nil {
+			link := calendarFeedLink(wr.Event, wr.LoginInfo.Person)
+			data["CalendarLink"] = link
+			// html/template doesn't trust webcal: links.
+			data["CalendarSubscribeLink"] = template.URL("webcal://" + strings.TrimPrefix(link, "https://"))
+		}
+
+		tpl := template.Must(template.ParseFiles("templates/main.html", "templates/thanks.html"))
+		if err := tpl.ExecuteTemplate(wr.ResponseWriter, "thanks.html", data); err != nil {
+			log.Printf("%v", err)
+		}
+
+		return
+	}
+
+	http.Redirect(wr.ResponseWriter, wr.Request, "invitations", http.StatusSeeOther)
+}
+
+// sendGuestConfirmations mails the invitees a receipt for their RSVP,
+// with the calendar of their stay attached. Invitees who share an address
+// get one copy.
+func sendGuestConfirmations(ctx context.Context, wr WrappedRequest, ev *event.Event, invitees []person.Person, data rsvpConfirmationData) {
+	attachments := invitationCalendarAttachment(ctx, ev, data.RealInvitation)
+	subject := fmt.Sprintf("%s: Your RSVP", ev.ShortName)
+	sent := make(map[string]bool)
+	for i := range invitees {
+		p := &invitees[i]
+		address := strings.ToLower(strings.TrimSpace(p.Email))
+		if address == "" || sent[address] {
+			continue
+		}
+		sent[address] = true
+		header := MailHeaderInfo{
+			To:          []string{p.Email},
+			Subject:     subject,
+			Attachments: attachments,
+		}
+		sendMail(ctx, wr, "rsvpreceipt", data, header, p)
+	}
+}
+
+// updateInvitationFromForm sets the fields of inv that the RSVP form edits.
+func updateInvitationFromForm(wr WrappedRequest, ev *event.Event, inv *Invitation) {
 	people := wr.Request.Form["person"]
 	rsvps := wr.Request.Form["rsvp"]
+	rsvpStatuses := ev.RsvpStatusMap()
 	var newPeople []*datastore.Key
 	var rsvpMap = make(map[*datastore.Key]invitation.RsvpStatus)
 	var activityMap = make(map[*datastore.Key](map[*datastore.Key]ActivityRanking))
 	var activityLeaderMap = make(map[*datastore.Key](map[*datastore.Key]bool))
 	for i, personKey := range people {
 		key, _ := datastore.DecodeKey(personKey)
-		var person person.Person
-		dsclient.FromContext(ctx).Get(ctx, key, &person)
 		newPeople = append(newPeople, key)
 		rsvp, _ := strconv.Atoi(rsvps[i])
 		if rsvp >= 0 {
-			fullStatus := invitation.GetAllRsvpStatuses()[rsvp]
-			rsvpMap[key] = fullStatus.Status
+			status := invitation.RsvpStatus(rsvp)
+			if _, known := rsvpStatuses[status]; known {
+				rsvpMap[key] = status
+			} else {
+				log.Printf("Ignoring unknown RSVP status %d for %v", rsvp, key)
+			}
 		}
 
 		var activityMapForPerson = make(map[*datastore.Key]ActivityRanking)
@@ -730,104 +903,6 @@ func handleSaveInvitation(ctx context.Context, wr WrappedRequest) {
 	} else {
 		inv.FridayIceCreamCount = 0
 	}
-
-	inv.LastUpdatedPerson = wr.LoginInfo.PersonKey
-
-	inv.LastUpdatedTimestamp = time.Now()
-
-	_, err = dsclient.FromContext(ctx).Put(ctx, invitationKey, &inv)
-	if err != nil {
-		log.Printf("%v", err)
-	}
-
-	var invitees []person.Person
-	for _, personKey := range inv.Invitees {
-		var person person.Person
-		dsclient.FromContext(ctx).Get(ctx, personKey, &person)
-		invitees = append(invitees, person)
-	}
-
-	savePeople(wr)
-
-	type NewPersonInfo struct {
-		Name        string
-		Description string
-	}
-	newPeopleNames := wr.Request.Form["newPersonName"]
-	newPeopleDescs := wr.Request.Form["newPersonDescription"]
-
-	var additionalPeople []NewPersonInfo
-	for i, name := range newPeopleNames {
-		additionalPeople = append(additionalPeople, NewPersonInfo{Name: name, Description: newPeopleDescs[i]})
-	}
-
-	newPeopleSubjectFragment := ""
-	if len(additionalPeople) > 0 {
-		newPeopleSubjectFragment = " ADDITION REQUESTED,"
-	}
-
-	var isAttending []bool
-	for _, invitee := range inv.Invitees {
-		if rsvp, present := rsvpMap[invitee]; present {
-			attending := invitation.GetAllRsvpStatuses()[rsvp].Attending
-			isAttending = append(isAttending, attending)
-		} else {
-			isAttending = append(isAttending, false)
-		}
-	}
-
-	ev, err := event.GetEvent(ctx, inv.Event)
-	if err != nil {
-		log.Printf("GetEvent: %v", err)
-	}
-	subject := fmt.Sprintf("%s:%s RSVP from %s", ev.ShortName, newPeopleSubjectFragment, person.CollectiveAddress(invitees, person.Informal))
-
-	realizedInvitation := makeRealizedInvitation(ctx, invitationKey, &inv)
-	// TODO: escape this.
-	//realizedInvitation.HousingNotes = strings.Replace(realizedInvitation.HousingNotes, "\n", "<br>", -1)
-
-	data := struct {
-		RealInvitation               RealizedInvitation
-		AllHousingPreferenceBooleans []HousingPreferenceBooleanInfo
-		AllPronouns                  []person.PronounSet
-		AllFoodRestrictions          []person.FoodRestrictionTag
-		AdditionalPeople             []NewPersonInfo
-		AnyAttending                 bool
-		IsAttending                  []bool
-	}{
-		RealInvitation:               realizedInvitation,
-		AllHousingPreferenceBooleans: GetAllHousingPreferenceBooleans(),
-		AllPronouns:                  []person.PronounSet{person.They, person.She, person.He, person.Zie},
-		AllFoodRestrictions:          person.GetAllFoodRestrictionTags(),
-		AdditionalPeople:             additionalPeople,
-		AnyAttending:                 inv.AnyAttending(),
-		IsAttending:                  isAttending,
-	}
-
-	header := MailHeaderInfo{
-		To:      []string{wr.GetSenderAddress()},
-		Subject: subject,
-		BccSelf: false,
-	}
-
-	sendMail(wr, "rsvpconfirmation", data, header)
-
-	if !wr.IsAdminUser() {
-
-		data := wr.MakeTemplateData(map[string]interface{}{
-			"AnyAttending": inv.AnyAttending(),
-			"AnyUndecided": inv.AnyUndecided(),
-		})
-
-		tpl := template.Must(template.ParseFiles("templates/main.html", "templates/thanks.html"))
-		if err := tpl.ExecuteTemplate(wr.ResponseWriter, "thanks.html", data); err != nil {
-			log.Printf("%v", err)
-		}
-
-		return
-	}
-
-	http.Redirect(wr.ResponseWriter, wr.Request, "invitations", http.StatusSeeOther)
 }
 
 func (inv *Invitation) ClusterByRsvp(ctx context.Context) (map[invitation.RsvpStatus][]person.Person, []person.Person) {
@@ -859,3 +934,48 @@ func (inv *Invitation) ClusterByRsvp(ctx context.Context) (map[invitation.RsvpSt
 
 	return rsvpMap, noRsvp
 }
+
+// NewPersonInfo is someone an RSVP asks to add to the invitation.
+type NewPersonInfo struct {
+	Name        string
+	Description string
+}
+
+// rsvpConfirmationData is what the rsvpconfirmation mail to the hosts and
+// the rsvpreceipt mail to the guests are rendered with.
+type rsvpConfirmationData struct {
+	RealInvitation               RealizedInvitation
+	AllHousingPreferenceBooleans []HousingPreferenceBooleanInfo
+	AllPronouns                  []person.PronounSet
+	AllFoodRestrictions          []person.FoodRestrictionTag
+	AdditionalPeople             []NewPersonInfo
+	AnyAttending                 bool
+	IsAttending                  []bool
+}
+
+func makeRsvpConfirmationData(ctx context.Context, ev *event.Event, invitationKey *datastore.Key, inv *Invitation,
+	additionalPeople []NewPersonInfo) rsvpConfirmationData {
+	var isAttending []bool
+	for _, invitee := range inv.Invitees {
+		if rsvp, present := inv.RsvpMap[invitee]; present {
+			attending := ev.RsvpStatusInfo(rsvp).Attending
+			isAttending = append(isAttending, attending)
+		} else {
+			isAttending = append(isAttending, false)
+		}
+	}
+
+	realizedInvitation := makeRealizedInvitation(ctx, invitationKey, inv)
+	// TODO: escape this.
+	//realizedInvitation.HousingNotes = strings.Replace(realizedInvitation.HousingNotes, "\n", "<br>", -1)
+
+	return rsvpConfirmationData{
+		RealInvitation:               realizedInvitation,
+		AllHousingPreferenceBooleans: GetAllHousingPreferenceBooleans(),
+		AllPronouns:                  []person.PronounSet{person.They, person.She, person.He, person.Zie},
+		AllFoodRestrictions:          person.GetAllFoodRestrictionTags(),
+		AdditionalPeople:             additionalPeople,
+		AnyAttending:                 inv.AnyAttending(ev),
+		IsAttending:                  isAttending,
+	}
+}
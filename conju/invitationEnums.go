@@ -2,6 +2,7 @@ package conju
 
 import (
 	"github.com/cshabsin/conju/invitation"
+	"github.com/cshabsin/conju/model/housing"
 )
 
 // Each event should have a list of acceptable RSVP statuses
@@ -30,21 +31,45 @@ type RsvpStatusInfo struct {
 	Attending        bool
 	Undecided        bool
 	NoLodging        bool
-	BaseCost         [6]float64
-	AddOnCost        [6]float64
 	Meals            []invitation.Meal
 }
 
 type PaymentType int
 
 const (
-	Cash = iota
+	Cash PaymentType = iota
 	Check
 	GoogleWallet
 	Venmo
 	PayPal
+	OtherPayment
 )
 
+type PaymentTypeInfo struct {
+	Type PaymentType
+	Name string
+}
+
+func GetAllPaymentTypes() []PaymentTypeInfo {
+	return []PaymentTypeInfo{
+		{Type: Cash, Name: "Cash"},
+		{Type: Check, Name: "Check"},
+		{Type: GoogleWallet, Name: "Google Wallet"},
+		{Type: Venmo, Name: "Venmo"},
+		{Type: PayPal, Name: "PayPal"},
+		{Type: OtherPayment, Name: "Other"},
+	}
+}
+
+func (t PaymentType) String() string {
+	for _, info := range GetAllPaymentTypes() {
+		if info.Type == t {
+			return info.Name
+		}
+	}
+	return "Unknown"
+}
+
 type HousingPreference int
 
 const (
@@ -256,6 +281,53 @@ func GetPreferenceTypeMask(preferenceType HousingPreferenceBooleanType) int {
 	return mask
 }
 
+// Bit returns the preference's bit. The catalog isn't in constant order,
+// so it can't be indexed by the constant.
+func (b HousingPreferenceBoolean) Bit() int {
+	for _, info := range GetAllHousingPreferenceBooleans() {
+		if info.Boolean == b {
+			return info.Bit
+		}
+	}
+	return 0
+}
+
+// Name returns the preference's name, which venue attributes use to say
+// they satisfy it (see housing.Attribute).
+func (b HousingPreferenceBoolean) Name() string {
+	for _, info := range GetAllHousingPreferenceBooleans() {
+		if info.Boolean == b {
+			return info.Name
+		}
+	}
+	return ""
+}
+
+// housingPreferenceNamed returns the housing preference with the given
+// name, if there is one.
+func housingPreferenceNamed(name string) (HousingPreferenceBooleanInfo, bool) {
+	for _, info := range GetAllHousingPreferenceBooleans() {
+		if info.Name == name {
+			return info, true
+		}
+	}
+	return HousingPreferenceBooleanInfo{}, false
+}
+
+// attributeBits returns the bits of the housing preferences that the
+// named attributes satisfy according to the venue's catalog, to compare a
+// room against people's preferences. Attributes that satisfy no preference
+// are left out; the venue editor marks them as for information only.
+func attributeBits(catalog []housing.Attribute, attributes []string) int {
+	bits := 0
+	for _, name := range attributes {
+		if info, ok := housingPreferenceNamed(housing.PreferenceFor(catalog, name)); ok {
+			bits |= info.Bit
+		}
+	}
+	return bits
+}
+
 func GetAdultPreferenceMask() int {
 	mask := 0
 	for _, info := range GetAllHousingPreferenceBooleans() {
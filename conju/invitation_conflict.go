@@ -0,0 +1,68 @@
+package conju
+
+import (
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/cshabsin/conju/conju/audit"
+	"github.com/cshabsin/conju/model/event"
+)
+
+// handleInvitationConflict is shown instead of saving the RSVP form when
+// the invitation was saved by someone else after the form was loaded. It
+// lists how the submitted form differs from what's saved now, and offers
+// to submit the form again over the saved version.
+func handleInvitationConflict(ctx context.Context, wr WrappedRequest, ev *event.Event, invitationKey *datastore.Key, current *Invitation) {
+	mine := *current
+	updateInvitationFromForm(wr, ev, &mine)
+	format := auditFormatter(ctx, ev)
+	changes := audit.Diff(current, &mine, format, invitationAuditIgnore...)
+
+	lastUpdatedBy := ""
+	if current.LastUpdatedPerson != nil {
+		lastUpdatedBy, _ = format(current.LastUpdatedPerson)
+	}
+
+	// Everything the form submitted, to post again with the current version.
+	type FormValue struct {
+		Name  string
+		Value string
+	}
+	var names []string
+	for name := range wr.Request.PostForm {
+		if name != "version" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var values []FormValue
+	for _, name := range names {
+		for _, value := range wr.Request.PostForm[name] {
+			values = append(values, FormValue{name, value})
+		}
+	}
+
+	reload := "rsvp"
+	if wr.IsAdminUser() {
+		reload = "viewInvitation?invitation=" + invitationKey.Encode()
+	}
+
+	tpl := template.Must(template.New("").ParseFiles("templates/main.html", "templates/invitationConflict.html"))
+	data := wr.MakeTemplateData(map[string]interface{}{
+		"LastUpdatedBy":        lastUpdatedBy,
+		"LastUpdatedTimestamp": current.LastUpdatedTimestamp,
+		"Changes":              changes,
+		"FormValues":           values,
+		"Version":              current.Version,
+		"Reload":               reload,
+	})
+	wr.ResponseWriter.WriteHeader(http.StatusConflict)
+	if err := tpl.ExecuteTemplate(wr.ResponseWriter, "invitationConflict.html", data); err != nil {
+		log.Printf("%v", err)
+	}
+}
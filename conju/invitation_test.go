@@ -0,0 +1,91 @@
+package conju
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cshabsin/conju/invitation"
+)
+
+func TestSaveInvitation(t *testing.T) {
+	t.Setenv("SENDER_ADDRESS", "hosts@example.com")
+	t.Setenv("BCC_ADDRESS", "archive@example.com")
+	s := newTestSite(t)
+	key, inv := s.fixture(t, "couple")
+	form := func(version int64, notes string) url.Values {
+		return url.Values{
+			"invitation":        {key.Encode()},
+			"version":           {strconv.FormatInt(version, 10)},
+			"person":            {inv.Invitees[0].Encode(), inv.Invitees[1].Encode()},
+			"rsvp":              {strconv.Itoa(int(invitation.ThuFriSat)), strconv.Itoa(int(invitation.No))},
+			"housingPreference": {strconv.Itoa(int(SpecificRoommates))},
+			"housingNotes":      {notes},
+		}
+	}
+
+	wr, rec := s.request(t, "couple", false, "POST", "/saveInvitation", form(inv.Version, "Near the lake"))
+	handleSaveInvitation(s.ctx, wr)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("save: status %d\n%s", rec.Code, rec.Body)
+	}
+	_, saved := s.fixture(t, "couple")
+	if saved.HousingNotes != "Near the lake" {
+		t.Errorf("HousingNotes = %q, want %q", saved.HousingNotes, "Near the lake")
+	}
+	// RsvpMap is keyed by pointer, so look the invitees up by value.
+	rsvps := make(map[int64]invitation.RsvpStatus)
+	for personKey, status := range saved.RsvpMap {
+		rsvps[personKey.ID] = status
+	}
+	if got := rsvps[inv.Invitees[0].ID]; got != invitation.ThuFriSat {
+		t.Errorf("first invitee's RSVP = %v, want ThuFriSat", got)
+	}
+	if got := rsvps[inv.Invitees[1].ID]; got != invitation.No {
+		t.Errorf("second invitee's RSVP = %v, want No", got)
+	}
+	if saved.Version != inv.Version+1 {
+		t.Errorf("Version = %d, want %d", saved.Version, inv.Version+1)
+	}
+	if !saved.LastUpdatedPerson.Equal(inv.Invitees[0]) {
+		t.Errorf("LastUpdatedPerson = %v, want %v", saved.LastUpdatedPerson, inv.Invitees[0])
+	}
+
+	// The hosts hear about it, and each guest gets a receipt with the
+	// calendar attached.
+	if got := len(s.transport.to("hosts@example.com")); got != 1 {
+		t.Errorf("%d messages to the hosts, want 1", got)
+	}
+	for _, address := range []string{"jordan@example.com", "sam@example.com"} {
+		msgs := s.transport.to(address)
+		if len(msgs) != 1 || len(msgs[0].Attachments) != 1 {
+			t.Errorf("%s got %d messages, want one with the calendar attached", address, len(msgs))
+			continue
+		}
+		if strings.Contains(msgs[0].HTML, "Last updated by") || !strings.Contains(msgs[0].HTML, "Will attend: Thursday - Sunday") {
+			t.Errorf("%s got the hosts' confirmation instead of a receipt:\n%s", address, msgs[0].HTML)
+		}
+		if len(msgs[0].Bcc) != 0 {
+			t.Errorf("%s's receipt was copied to %v", address, msgs[0].Bcc)
+		}
+	}
+
+	// A form from before that save doesn't overwrite it.
+	wr, rec = s.request(t, "couple", false, "POST", "/saveInvitation", form(inv.Version, "Anywhere"))
+	handleSaveInvitation(s.ctx, wr)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("stale save: status %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if _, again := s.fixture(t, "couple"); again.HousingNotes != "Near the lake" || again.Version != saved.Version {
+		t.Errorf("stale save changed the invitation to %q (version %d)", again.HousingNotes, again.Version)
+	}
+
+	// Guests can only save their own invitation.
+	wr, rec = s.request(t, "single_adult", false, "POST", "/saveInvitation", form(saved.Version, "Mine now"))
+	handleSaveInvitation(s.ctx, wr)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("saving someone else's invitation: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
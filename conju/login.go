@@ -9,6 +9,8 @@ import (
 	"net/http"
 
 	"cloud.google.com/go/datastore"
+	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/model/event"
 	"github.com/cshabsin/conju/model/person"
 	"google.golang.org/appengine/v2/user"
 )
@@ -46,7 +48,7 @@ func handleLoginInner(ctx context.Context, wr WrappedRequest, urlTarget string)
 		return
 	}
 	var people []person.Person
-	q := datastore.NewQuery("Person").FilterField("LoginCode", "=", lc[0])
+	q := dsclient.NewQuery("Person").FilterField("LoginCode", "=", lc[0])
 	peopleKeys, err := wr.DatastoreClient.GetAll(ctx, q, &people)
 	if err != nil {
 		http.Redirect(wr.ResponseWriter, wr.Request,
@@ -99,7 +101,7 @@ func getPersonFromLoggedInUser(ctx context.Context, wr *WrappedRequest) (*datast
 		return nil, nil, errors.New("not logged in")
 	}
 	var people []*person.Person
-	q := datastore.NewQuery("Person").FilterField("Email", "=", wr.User.Email)
+	q := dsclient.NewQuery("Person").FilterField("Email", "=", wr.User.Email)
 	peopleKeys, err := wr.DatastoreClient.GetAll(ctx, q, &people)
 	if err != nil {
 		log.Printf("person lookup by email (%v) error: %v", wr.User.Email, err)
@@ -121,7 +123,7 @@ func getPersonFromInvitationCode(ctx context.Context, wr *WrappedRequest) (*data
 		return nil, nil, errors.New("invitation code not set")
 	}
 	var people []*person.Person
-	q := datastore.NewQuery("Person").FilterField("LoginCode", "=", code)
+	q := dsclient.NewQuery("Person").FilterField("LoginCode", "=", code)
 	peopleKeys, err := wr.DatastoreClient.GetAll(ctx, q, &people)
 	if err != nil {
 		log.Printf("person lookup by login code (%v) error: %v", code, err)
@@ -203,7 +205,7 @@ func InvitationGetter(ctx context.Context, wr *WrappedRequest) error {
 		return RedirectError{loginErrorPage + "?message=Please use the link from your invitation email to log in."}
 	}
 	var invitations []Invitation
-	q := datastore.NewQuery("Invitation").
+	q := dsclient.NewQuery("Invitation").
 		FilterField("Invitees", "=", wr.LoginInfo.PersonKey).
 		FilterField("Event", "=", wr.EventKey)
 	invitationKeys, err := wr.DatastoreClient.GetAll(ctx, q, &invitations)
@@ -264,9 +266,9 @@ func handleResendInvitation(ctx context.Context, wr WrappedRequest) {
 			loginErrorPage+"?message=Bad form input.", http.StatusFound)
 		return
 	}
-	q := datastore.NewQuery("Person").FilterField("Email", "=", emailAddresses[0])
+	q := dsclient.NewQuery("Person").FilterField("Email", "=", emailAddresses[0])
 	var people []person.Person
-	_, err := wr.DatastoreClient.GetAll(ctx, q, &people)
+	peopleKeys, err := wr.DatastoreClient.GetAll(ctx, q, &people)
 	if err != nil {
 		log.Printf("%v", err)
 		http.Redirect(wr.ResponseWriter, wr.Request,
@@ -278,7 +280,8 @@ func handleResendInvitation(ctx context.Context, wr WrappedRequest) {
 	// people they know. This may be a bad UI, but it is good
 	// privacy.
 	if len(people) == 1 {
-		loginUrl := makeLoginUrl(&people[0], true)
+		people[0].DatastoreKey = peopleKeys[0]
+		loginUrl := makeLoginUrl(wr.Event, &people[0], true)
 		data := map[string]interface{}{
 			"Event":     *wr.Event,
 			"LoginLink": loginUrl,
@@ -287,7 +290,7 @@ func handleResendInvitation(ctx context.Context, wr WrappedRequest) {
 			To:      []string{people[0].Email},
 			BccSelf: false,
 		}
-		sendMail(wr, "resendInvitation", data, header)
+		sendMail(ctx, wr, "resendInvitation", data, header, &people[0])
 	}
 	// TODO: Make a resentInvitation.html template explaining that
 	// if they don't get email in a minute or two from us, they
@@ -317,10 +320,12 @@ func handleResentInvitation(ctx context.Context, wr WrappedRequest) {
 	}
 }
 
-func makeLoginUrl(p *person.Person, absolute bool) string {
-	var prefix string
+// makeLoginUrl returns the person's login link, on the event's site if
+// absolute is set.
+func makeLoginUrl(ev *event.Event, p *person.Person, absolute bool) string {
+	path := "/login?loginCode=" + p.LoginCode
 	if absolute {
-		prefix = "http://psr.shabsin.com"
+		return ev.AbsoluteURL(path)
 	}
-	return prefix + "/login?loginCode=" + p.LoginCode
+	return path
 }
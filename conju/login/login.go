@@ -1,6 +1,11 @@
 package login
 
-import "math/rand"
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/rand"
+)
 
 // A LoginCode is a secret string we send to users as part of their
 // Login link. It's stored as a string field in the Person object.
@@ -20,3 +25,17 @@ func RandomLoginCodeString() string {
 	}
 	return string(b)
 }
+
+// SignLink returns a signature for value, such as an encoded Person key,
+// so that a link containing both can be trusted without logging in.
+func SignLink(secret []byte, value string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// CheckLinkSignature returns true if signature is SignLink's signature for
+// value.
+func CheckLinkSignature(secret []byte, value, signature string) bool {
+	return hmac.Equal([]byte(SignLink(secret, value)), []byte(signature))
+}
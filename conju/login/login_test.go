@@ -16,3 +16,20 @@ func TestRandomLoginCode(t *testing.T) {
 		}
 	}
 }
+
+func TestLinkSignature(t *testing.T) {
+	secret := []byte("secret")
+	sig := SignLink(secret, "EgoKBlBlcnNvbhAE")
+	if !CheckLinkSignature(secret, "EgoKBlBlcnNvbhAE", sig) {
+		t.Errorf("Signature %s didn't check out", sig)
+	}
+	if CheckLinkSignature(secret, "EgoKBlBlcnNvbhAF", sig) {
+		t.Errorf("Signature %s checked out for a different value", sig)
+	}
+	if CheckLinkSignature([]byte("other"), "EgoKBlBlcnNvbhAE", sig) {
+		t.Errorf("Signature %s checked out with a different secret", sig)
+	}
+	if CheckLinkSignature(secret, "EgoKBlBlcnNvbhAE", "") {
+		t.Errorf("Empty signature checked out")
+	}
+}
@@ -0,0 +1,194 @@
+package conju
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/conju/mailer"
+	"github.com/cshabsin/conju/model/person"
+)
+
+// The mail transport posts delivery reports to /mailEvents: SendGrid's
+// event webhook, or conju's own format (see mailer.ParseGenericEvents)
+// with source=generic. The post has to carry the MAIL_WEBHOOK_TOKEN
+// environment variable as its token parameter.
+//
+// Each report is recorded on the SentMail it's about, if it can be matched
+// by message id, and on the AddressStatus of the person it went to (or,
+// failing that, of everyone with that address). People whose address has
+// hard bounced are flagged on the people list and left out of segments.
+
+// AddressStatus is a child of Person, keyed by the lower-cased address, so
+// that changing a person's address starts them afresh.
+type AddressStatus struct {
+	Email        string
+	Delivered    time.Time // last delivery
+	Bounced      time.Time // last bounce
+	HardBounce   bool      // the last bounce was permanent
+	BounceReason string    `datastore:",noindex"`
+	SpamReported time.Time
+}
+
+func addressStatusKey(personKey *datastore.Key, email string) *datastore.Key {
+	return datastore.NameKey("AddressStatus", strings.ToLower(email), personKey)
+}
+
+// BadAddress returns true if mail to the address has hard bounced, and
+// nothing has been delivered to it since.
+func (s *AddressStatus) BadAddress() bool {
+	return s.HardBounce && !s.Delivered.After(s.Bounced)
+}
+
+// apply updates the status with a report. Reports can arrive out of order,
+// and more than once, so older reports don't replace newer ones.
+func (s *AddressStatus) apply(e mailer.DeliveryEvent) {
+	switch e.Kind {
+	case mailer.Delivered:
+		if e.Time.After(s.Delivered) {
+			s.Delivered = e.Time
+		}
+	case mailer.Bounced:
+		if e.Time.After(s.Bounced) {
+			s.Bounced = e.Time
+			s.HardBounce = e.Permanent
+			s.BounceReason = e.Reason
+		}
+	case mailer.SpamReport:
+		if e.Time.After(s.SpamReported) {
+			s.SpamReported = e.Time
+		}
+	}
+}
+
+// addressStatuses holds AddressStatus entities by their encoded key.
+type addressStatuses map[string]*AddressStatus
+
+func getAddressStatuses(ctx context.Context) (addressStatuses, error) {
+	var statuses []*AddressStatus
+	keys, err := dsclient.FromContext(ctx).GetAll(ctx, dsclient.NewQuery("AddressStatus"), &statuses)
+	if err != nil {
+		return nil, err
+	}
+	m := make(addressStatuses)
+	for i, status := range statuses {
+		m[keys[i].Encode()] = status
+	}
+	return m, nil
+}
+
+// forPerson returns the status of the person's current address, or nil if
+// nothing has been reported about it.
+func (m addressStatuses) forPerson(p *person.Person) *AddressStatus {
+	if p.DatastoreKey == nil || p.Email == "" {
+		return nil
+	}
+	return m[addressStatusKey(p.DatastoreKey, p.Email).Encode()]
+}
+
+// recordDeliveryEvent records e against the mail and people it's about.
+func recordDeliveryEvent(ctx context.Context, e mailer.DeliveryEvent) error {
+	client := dsclient.FromContext(ctx)
+	var personKeys []*datastore.Key
+	if e.MessageID != "" {
+		var sent []*SentMail
+		q := dsclient.NewQuery("SentMail").FilterField("MessageID", "=", e.MessageID)
+		keys, err := client.GetAll(ctx, q, &sent)
+		if err != nil {
+			return fmt.Errorf("finding mail %s: %w", e.MessageID, err)
+		}
+		for i, s := range sent {
+			// The report may be about the archive copy.
+			if !strings.EqualFold(s.Email, e.Email) {
+				continue
+			}
+			personKeys = append(personKeys, keys[i].Parent)
+			if e.Time.Before(s.DeliveryTime) {
+				continue
+			}
+			s.Delivery = e.Kind
+			s.DeliveryTime = e.Time
+			s.DeliveryReason = e.Reason
+			if _, err := client.Put(ctx, keys[i], s); err != nil {
+				return fmt.Errorf("updating %v: %w", keys[i], err)
+			}
+		}
+	}
+	if len(personKeys) == 0 {
+		q := dsclient.NewQuery("Person").FilterField("Email", "=", e.Email).KeysOnly()
+		keys, err := client.GetAll(ctx, q, nil)
+		if err != nil {
+			return fmt.Errorf("finding people with address %s: %w", e.Email, err)
+		}
+		personKeys = keys
+	}
+	if len(personKeys) == 0 {
+		log.Printf("%s report for %s (message %q) matches nobody", e.Kind, e.Email, e.MessageID)
+		return nil
+	}
+	for _, personKey := range personKeys {
+		key := addressStatusKey(personKey, e.Email)
+		err := client.RunInTransaction(ctx, func(tx dsclient.Client) error {
+			var status AddressStatus
+			if err := tx.Get(ctx, key, &status); err != nil && err != datastore.ErrNoSuchEntity {
+				return err
+			}
+			status.Email = e.Email
+			status.apply(e)
+			_, err := tx.Put(ctx, key, &status)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("updating %v: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func handleMailEvents(ctx context.Context, wr WrappedRequest) {
+	if wr.Method != http.MethodPost {
+		http.Error(wr.ResponseWriter, "POST only.", http.StatusMethodNotAllowed)
+		return
+	}
+	query := wr.Request.URL.Query()
+	token := os.Getenv("MAIL_WEBHOOK_TOKEN")
+	if token == "" || subtle.ConstantTimeCompare([]byte(query.Get("token")), []byte(token)) != 1 {
+		http.Error(wr.ResponseWriter, "Not authorized.", http.StatusForbidden)
+		return
+	}
+	var parse func(io.Reader) ([]mailer.DeliveryEvent, error)
+	switch query.Get("source") {
+	case "", "sendgrid":
+		parse = mailer.ParseSendGridEvents
+	case "generic":
+		parse = mailer.ParseGenericEvents
+	default:
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Unknown source %q.", query.Get("source")), http.StatusBadRequest)
+		return
+	}
+	events, err := parse(wr.Request.Body)
+	if err != nil {
+		log.Printf("Mail events: %v", err)
+		http.Error(wr.ResponseWriter, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, e := range events {
+		// Failing makes the sender post the whole batch again, which is
+		// harmless for the reports that were already recorded.
+		if err := recordDeliveryEvent(ctx, e); err != nil {
+			log.Printf("Recording mail event: %v", err)
+			http.Error(wr.ResponseWriter, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	fmt.Fprintf(wr.ResponseWriter, "Recorded %d events.\n", len(events))
+}
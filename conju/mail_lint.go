@@ -0,0 +1,403 @@
+package conju
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	text_template "text/template"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/invitation"
+	"github.com/cshabsin/conju/model/event"
+	"github.com/cshabsin/conju/model/housing"
+	"github.com/cshabsin/conju/model/message"
+	"github.com/cshabsin/conju/model/person"
+)
+
+// Email templates are only parsed when mail is rendered, so a mistake in
+// one doesn't show up until someone goes to send it. LintMailTemplates
+// renders every event's template files against made-up invitations, in a
+// datastore of its own, so they can be checked from the command line (see
+// tools/maillint). The renderings only depend on the templates, so they
+// can also be compared against earlier ones.
+
+// MailRendering is one email template rendered for one fixture invitation.
+type MailRendering struct {
+	Event    string // short name of the event whose templates were used
+	Template string
+	Fixture  string
+	Subject  string
+	Text     string
+	HTML     string
+}
+
+// MailProblem is something wrong with an email template.
+type MailProblem struct {
+	Event    string
+	Template string // empty if the event's templates didn't parse
+	Fixture  string // empty for problems with the template itself
+	Problem  string
+}
+
+func (p MailProblem) String() string {
+	where := []string{p.Event}
+	if p.Template != "" {
+		where = append(where, p.Template)
+	}
+	if p.Fixture != "" {
+		where = append(where, p.Fixture)
+	}
+	return strings.Join(where, "/") + ": " + p.Problem
+}
+
+// codeSubjectTemplates are the templates whose subject is written by the
+// code that sends them, so they don't need a _subject template.
+var codeSubjectTemplates = map[string]bool{
+	"rsvpconfirmation": true,
+	"rsvpreceipt":      true,
+}
+
+// roomingMailTemplates are the templates getRoomingEmails sends, with
+// what it works out about the invitation's rooms.
+var roomingMailTemplates = map[string]bool{
+	"rooming": true,
+	"updates": true,
+	"final":   true,
+}
+
+// usesRooming reports whether the named template shows the rooms an
+// invitation is booked into. That mail only goes to invitations with a
+// room (see getRoomingEmails and the "booked" segment), so fixtures
+// without one aren't rendered with it.
+func usesRooming(textTpl *text_template.Template, name string) bool {
+	for _, suffix := range []string{"_subject", "_text"} {
+		t := textTpl.Lookup(name + suffix)
+		if t == nil || t.Tree == nil {
+			continue
+		}
+		body := t.Tree.Root.String()
+		if strings.Contains(body, ".RoomingInfo") || strings.Contains(body, ".InviteeBookings") {
+			return true
+		}
+	}
+	return false
+}
+
+// mailFixture is an invitation that mail is rendered for.
+type mailFixture struct {
+	name       string
+	invitees   []person.Person
+	statuses   []invitation.RsvpStatus
+	housing    HousingPreference
+	beds       []housing.BedSize // in the room the invitees are booked into; not booked if empty
+	paid       bool
+	additional []NewPersonInfo
+}
+
+func fixtureBirthdate(year int, month time.Month) time.Time {
+	return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+}
+
+var mailFixtures = []mailFixture{
+	{
+		name: "single_adult",
+		invitees: []person.Person{
+			{FirstName: "Avery", LastName: "Quinn", Pronouns: person.They, Email: "avery@example.com"},
+		},
+		statuses: []invitation.RsvpStatus{invitation.ThuFriSat},
+		housing:  NoRoommates,
+		beds:     []housing.BedSize{housing.Queen},
+		paid:     true,
+	},
+	{
+		name: "couple",
+		invitees: []person.Person{
+			{FirstName: "Jordan", LastName: "Lee", Pronouns: person.She, Email: "jordan@example.com"},
+			{FirstName: "Sam", LastName: "Lee", Pronouns: person.He, Email: "sam@example.com",
+				FoodRestrictions: []person.FoodRestriction{person.Vegetarian}},
+		},
+		statuses: []invitation.RsvpStatus{invitation.FriSat, invitation.FriSat},
+		housing:  SpecificRoommates,
+		beds:     []housing.BedSize{housing.Twin, housing.Twin},
+		paid:     true,
+	},
+	{
+		name: "family",
+		invitees: []person.Person{
+			{FirstName: "Robin", LastName: "Park", Pronouns: person.She, Email: "robin@example.com"},
+			{FirstName: "Casey", LastName: "Park", Pronouns: person.He, Email: "casey@example.com"},
+			{FirstName: "Mika", LastName: "Park", Pronouns: person.She, Birthdate: fixtureBirthdate(2016, time.March)},
+			{FirstName: "Noa", LastName: "Park", Pronouns: person.They, Birthdate: fixtureBirthdate(2024, time.November)},
+		},
+		statuses:   []invitation.RsvpStatus{invitation.FriSat, invitation.FriSat, invitation.FriSat, invitation.FriSat},
+		housing:    SpecificRoommates,
+		beds:       []housing.BedSize{housing.Queen, housing.Twin, housing.Cot},
+		paid:       true,
+		additional: []NewPersonInfo{{Name: "Lou Park", Description: "Robin's mother"}},
+	},
+	{
+		name: "no_housing",
+		invitees: []person.Person{
+			{FirstName: "Drew", LastName: "Ellis", Pronouns: person.He, Email: "drew@example.com"},
+		},
+		statuses: []invitation.RsvpStatus{invitation.MealsOnly},
+		housing:  HousingNotSet,
+	},
+	{
+		name: "unpaid",
+		invitees: []person.Person{
+			{FirstName: "Taylor", LastName: "Brooks", Pronouns: person.She, Email: "taylor@example.com"},
+		},
+		statuses: []invitation.RsvpStatus{invitation.FriSat},
+		housing:  AnyRoommates,
+		beds:     []housing.BedSize{housing.Twin, housing.Twin},
+	},
+}
+
+// lintEnv stands in for the site's settings that templates are given
+// (see GetEnvForTemplates).
+var lintEnv = map[string]string{
+	"GOOGLE_WALLET_ADDRESS": "wallet@example.com",
+	"VENMO_ADDRESS":         "@example-venmo",
+	"PAYPAL_ADDRESS":        "paypal@example.com",
+	"PAYPAL_URL":            "https://paypal.example.com/",
+	"DISCORD_URL":           "https://discord.example.com/",
+}
+
+// lintUpdateTime is when the fixture invitations were last updated.
+var lintUpdateTime = time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// putLintEvent stores an event with the given short name, on the first
+// weekend in June 2025, and the fixture invitations to it. It returns the
+// invitations' keys, in the order of mailFixtures.
+func putLintEvent(ctx context.Context, shortName string) (*event.Event, []*datastore.Key, error) {
+	client := dsclient.FromContext(ctx)
+	// venue.Venue has its key in it, so store just the fields it's loaded from.
+	venueKey, err := client.Put(ctx, datastore.IncompleteKey("Venue", nil), &struct {
+		Name, ShortName string
+	}{"Fixture Ranch", "FR"})
+	if err != nil {
+		return nil, nil, err
+	}
+	buildingKey, err := client.Put(ctx, datastore.IncompleteKey("Building", venueKey), &housing.Building{
+		Venue: venueKey,
+		Name:  "Lodge",
+		Code:  "L",
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	ev := &event.Event{
+		Name:      shortName,
+		ShortName: shortName,
+		StartDate: time.Date(2025, 6, 6, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2025, 6, 8, 0, 0, 0, 0, time.UTC),
+		RsvpStatuses: []invitation.RsvpStatus{
+			invitation.FriSat, invitation.ThuFriSat, invitation.MealsOnly, invitation.Maybe, invitation.No,
+		},
+		Current: true,
+	}
+	ev.SetVenueKey(venueKey)
+	if err := event.PutEvent(ctx, ev); err != nil {
+		return nil, nil, err
+	}
+	ev, err = event.GetEvent(ctx, ev.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := ev.LoadVenue(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	var invitationKeys []*datastore.Key
+	for i, f := range mailFixtures {
+		inv := &Invitation{
+			Event:                ev.Key,
+			RsvpMap:              make(map[*datastore.Key]invitation.RsvpStatus),
+			Housing:              f.housing,
+			LastUpdatedTimestamp: lintUpdateTime,
+		}
+		for j, p := range f.invitees {
+			p.LoginCode = fmt.Sprintf("%s%d", f.name, j)
+			personKey, err := client.Put(ctx, datastore.IncompleteKey("Person", nil), &p)
+			if err != nil {
+				return nil, nil, err
+			}
+			inv.Invitees = append(inv.Invitees, personKey)
+			inv.RsvpMap[personKey] = f.statuses[j]
+		}
+		inv.LastUpdatedPerson = inv.Invitees[0]
+		if len(f.beds) > 0 {
+			roomKey, err := client.Put(ctx, datastore.IncompleteKey("Room", buildingKey), &housing.Room{
+				Building:   buildingKey,
+				RoomNumber: i + 1,
+				Beds:       f.beds,
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+			ev.Rooms = append(ev.Rooms, roomKey)
+			if _, err := client.Put(ctx, datastore.IncompleteKey("Booking", ev.Key), &Booking{
+				Event:     ev.Key,
+				Room:      roomKey,
+				Reserved:  f.paid,
+				Roommates: inv.Invitees,
+			}); err != nil {
+				return nil, nil, err
+			}
+		}
+		invitationKey, err := client.Put(ctx, datastore.IncompleteKey("Invitation", nil), inv)
+		if err != nil {
+			return nil, nil, err
+		}
+		invitationKeys = append(invitationKeys, invitationKey)
+	}
+
+	if err := event.PutEvent(ctx, ev); err != nil {
+		return nil, nil, err
+	}
+
+	// Pay for the paid invitations now that everyone's booked.
+	wr := WrappedRequest{DatastoreClient: client, EventKey: ev.Key, Event: ev}
+	for i, f := range mailFixtures {
+		if !f.paid {
+			continue
+		}
+		var inv Invitation
+		if err := client.Get(ctx, invitationKeys[i], &inv); err != nil {
+			return nil, nil, err
+		}
+		if roomingInfo := getRoomingInfoWithInvitation(ctx, wr, &inv, invitationKeys[i]); roomingInfo != nil {
+			inv.ReceivedPay = roomingInfo.TotalCost
+		}
+		inv.ReceivedPayMethod = "PayPal"
+		inv.ReceivedPayDate = lintUpdateTime
+		if _, err := client.Put(ctx, invitationKeys[i], &inv); err != nil {
+			return nil, nil, err
+		}
+	}
+	return ev, invitationKeys, nil
+}
+
+// lintMailData returns the data the named template is rendered with for
+// the fixture, as the code that sends it would.
+func lintMailData(ctx context.Context, wr WrappedRequest, templatePrefix string, f mailFixture,
+	invitationKey *datastore.Key, inv *Invitation, p *person.Person) interface{} {
+	switch templatePrefix {
+	case "rsvpconfirmation", "rsvpreceipt":
+		return makeRsvpConfirmationData(ctx, wr.Event, invitationKey, inv, f.additional)
+	case "resendInvitation":
+		return map[string]interface{}{
+			"Event":     *wr.Event,
+			"LoginLink": makeLoginUrl(wr.Event, p, true),
+		}
+	}
+	data := makeMailData(ctx, wr, invitationKey, inv, p)
+	data["Env"] = lintEnv
+	if roomingMailTemplates[templatePrefix] {
+		if mail, ok := getRoomingMails(ctx, wr)[invitationKey.ID]; ok {
+			wr.TemplateData = data
+			return mail.templateData(wr, p)
+		}
+	}
+	return data
+}
+
+// LintMailTemplates renders the email template files of every event with
+// templates, for each fixture invitation, and returns the renderings and
+// any problems found with the templates. It must be run from the
+// directory holding templates/.
+func LintMailTemplates(ctx context.Context) ([]MailRendering, []MailProblem, error) {
+	eventDirs, err := filepath.Glob("templates/*/email")
+	if err != nil {
+		return nil, nil, err
+	}
+	var renderings []MailRendering
+	var problems []MailProblem
+	for _, dir := range eventDirs {
+		shortName := filepath.Base(filepath.Dir(dir))
+		tpl, textTpl, err := parseMailTemplates(shortName, nil)
+		if err != nil {
+			problems = append(problems, MailProblem{Event: shortName, Problem: err.Error()})
+			continue
+		}
+
+		// Each event gets a datastore of its own, so that every event's
+		// fixtures have the same keys.
+		client := dsclient.NewMemoryClient()
+		ctx := dsclient.WrapContext(ctx, client)
+		ev, invitationKeys, err := putLintEvent(ctx, shortName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("storing fixtures for %s: %w", shortName, err)
+		}
+		wr := WrappedRequest{DatastoreClient: client, EventKey: ev.Key, Event: ev}
+
+		for _, name := range mailTemplateNames(shortName) {
+			problem := func(fixture, format string, args ...interface{}) {
+				problems = append(problems, MailProblem{shortName, name, fixture, fmt.Sprintf(format, args...)})
+			}
+			if textTpl.Lookup(name+"_text") == nil {
+				problem("", "no %s_text template", name)
+				continue
+			}
+			if tpl.Lookup(name+"_html") == nil {
+				problem("", "no %s_html template", name)
+			}
+			needSubject := !codeSubjectTemplates[name]
+			if needSubject && textTpl.Lookup(name+"_subject") == nil {
+				problem("", "no %s_subject template", name)
+				needSubject = false
+			}
+
+			rooming := usesRooming(textTpl, name)
+			for i, f := range mailFixtures {
+				if rooming && len(f.beds) == 0 {
+					continue
+				}
+				var inv Invitation
+				if err := client.Get(ctx, invitationKeys[i], &inv); err != nil {
+					return nil, nil, err
+				}
+				var p person.Person
+				if err := client.Get(ctx, inv.Invitees[0], &p); err != nil {
+					return nil, nil, err
+				}
+				p.DatastoreKey = inv.Invitees[0]
+
+				data := lintMailData(ctx, wr, name, f, invitationKeys[i], &inv, &p)
+				// The real link is signed with the site's secret; this
+				// one stays the same wherever the renderings are made.
+				var link string
+				if m, ok := data.(map[string]interface{}); ok && templateRecipientType(nil, name) != message.Individuals {
+					link = wr.Event.AbsoluteURL("/mailPreferences?p=" + f.name)
+					m["UnsubscribeLink"] = link
+				}
+				text, html, subject, err := executeMail(tpl, textTpl, name, data, needSubject)
+				if err != nil {
+					problem(f.name, "%v", err)
+					continue
+				}
+				text, html = addUnsubscribeFooter(link, text, html)
+				if strings.Contains(subject, "<no value>") {
+					problem(f.name, "subject has <no value>")
+				}
+				if strings.Contains(text, "<no value>") {
+					problem(f.name, "text has <no value>")
+				}
+				renderings = append(renderings, MailRendering{
+					Event:    shortName,
+					Template: name,
+					Fixture:  f.name,
+					Subject:  subject,
+					Text:     text,
+					HTML:     html,
+				})
+			}
+		}
+	}
+	return renderings, problems, nil
+}
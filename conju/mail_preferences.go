@@ -0,0 +1,231 @@
+package conju
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/conju/login"
+	"github.com/cshabsin/conju/model/event"
+	"github.com/cshabsin/conju/model/message"
+	"github.com/cshabsin/conju/model/person"
+)
+
+// Everyone has a person.MailPreference, which segments honor (see
+// Segment.Recipients). Mail rendered for a person by renderMail carries a
+// signed link to /mailPreferences, where they can change it without
+// logging in, and a List-Unsubscribe header so that mail clients can
+// unsubscribe them in one click. Transactional mail, from templates meant
+// for individuals, is sent regardless, and doesn't get the link.
+
+// fileRecipientTypes say who the template files not meant for invitees
+// are meant for. A template edited on the site says for itself.
+var fileRecipientTypes = map[string]message.RecipientType{
+	"resendInvitation": message.Individuals,
+	"rsvpconfirmation": message.Individuals,
+	"rsvpreceipt":      message.Individuals,
+}
+
+// templateRecipientType returns who the named template is meant for,
+// given the event's message for it, if there is one.
+func templateRecipientType(msg *message.Message, name string) message.RecipientType {
+	if msg != nil {
+		return msg.RecipientType
+	}
+	if recipientType, ok := fileRecipientTypes[name]; ok {
+		return recipientType
+	}
+	return message.Invitees
+}
+
+// mailRecipientType returns who the event's named template is meant for.
+func mailRecipientType(ctx context.Context, eventKey *datastore.Key, name string) message.RecipientType {
+	msg, err := message.GetMessage(ctx, eventKey, name)
+	if err != nil {
+		log.Printf("Getting message %q, using the file's recipients instead: %v", name, err)
+		msg = nil
+	}
+	return templateRecipientType(msg, name)
+}
+
+// isTransactional reports whether mail from the event's named template is
+// sent to one person because of something they did.
+func isTransactional(ctx context.Context, eventKey *datastore.Key, name string) bool {
+	return mailRecipientType(ctx, eventKey, name) == message.Individuals
+}
+
+// linkSecretKey names the datastore entity holding the secret mail
+// preference links are signed with, so that every instance signs them the
+// same way.
+var linkSecretKey = datastore.NameKey("LinkSecret", "mailPreferences", nil)
+
+type linkSecretEntity struct {
+	Secret []byte `datastore:",noindex"`
+}
+
+// linkSecret returns the key that mail preference links are signed with:
+// LINK_SECRET if it's set, or else a random key kept in the datastore,
+// which is made the first time it's needed.
+func linkSecret(ctx context.Context) ([]byte, error) {
+	if secret := os.Getenv("LINK_SECRET"); secret != "" {
+		return []byte(secret), nil
+	}
+	client := dsclient.FromContext(ctx)
+	var stored linkSecretEntity
+	err := client.Get(ctx, linkSecretKey, &stored)
+	if err == nil && len(stored.Secret) > 0 {
+		return stored.Secret, nil
+	}
+	if err != nil && !errors.Is(err, datastore.ErrNoSuchEntity) {
+		return nil, fmt.Errorf("fetching link secret: %w", err)
+	}
+	// Another instance may be making it at the same time.
+	err = client.RunInTransaction(ctx, func(tx dsclient.Client) error {
+		err := tx.Get(ctx, linkSecretKey, &stored)
+		if err == nil && len(stored.Secret) > 0 {
+			return nil
+		}
+		if err != nil && !errors.Is(err, datastore.ErrNoSuchEntity) {
+			return err
+		}
+		stored.Secret = make([]byte, 32)
+		if _, err := rand.Read(stored.Secret); err != nil {
+			return err
+		}
+		_, err = tx.Put(ctx, linkSecretKey, &stored)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("making link secret: %w", err)
+	}
+	return stored.Secret, nil
+}
+
+// mailPreferencesLink returns the person's signed link to their mail
+// preferences, on the event's site, or "" if links can't be signed.
+func mailPreferencesLink(ctx context.Context, ev *event.Event, p *person.Person) string {
+	secret, err := linkSecret(ctx)
+	if err != nil {
+		log.Printf("ERROR: mail preference links are disabled: %v", err)
+		return ""
+	}
+	key := p.DatastoreKey.Encode()
+	return ev.AbsoluteURL("/mailPreferences?" + url.Values{
+		"p":   {key},
+		"sig": {login.SignLink(secret, key)},
+	}.Encode())
+}
+
+// unsubscribeLink returns the mail preferences link for the person the
+// mail template data is for, or "" if the mail is transactional or isn't
+// for anyone in particular.
+func unsubscribeLink(ctx context.Context, ev *event.Event, templatePrefix string, data interface{}) string {
+	if isTransactional(ctx, ev.Key, templatePrefix) {
+		return ""
+	}
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	p, ok := m["Person"].(*person.Person)
+	if !ok || p == nil || p.DatastoreKey == nil {
+		return ""
+	}
+	return mailPreferencesLink(ctx, ev, p)
+}
+
+// addUnsubscribeFooter appends the link to rendered mail whose template
+// didn't include it itself.
+func addUnsubscribeFooter(link, text, htmlBody string) (string, string) {
+	if link == "" {
+		return text, htmlBody
+	}
+	if !strings.Contains(text, link) {
+		text += "\n--\nTo change what mail you get from us, or to unsubscribe: " + link + "\n"
+	}
+	if htmlBody != "" && !strings.Contains(htmlBody, html.EscapeString(link)) {
+		htmlBody += fmt.Sprintf(`<p style="font-size:small"><a href="%s">Change what mail you get from us, or unsubscribe</a></p>`,
+			html.EscapeString(link))
+	}
+	return text, htmlBody
+}
+
+// handleMailPreferences shows and saves a person's mail preference. It's
+// reached by a signed link, so it doesn't need a login. Mail clients post
+// List-Unsubscribe=One-Click to it to unsubscribe.
+func handleMailPreferences(ctx context.Context, wr WrappedRequest) {
+	wr.Request.ParseForm()
+	encodedKey := wr.Request.Form.Get("p")
+	secret, err := linkSecret(ctx)
+	if err != nil {
+		log.Printf("ERROR: mail preference links are disabled: %v", err)
+		http.Error(wr.ResponseWriter, "Mail preferences can't be changed right now.", http.StatusServiceUnavailable)
+		return
+	}
+	if !login.CheckLinkSignature(secret, encodedKey, wr.Request.Form.Get("sig")) {
+		http.Error(wr.ResponseWriter, "This link isn't valid.", http.StatusForbidden)
+		return
+	}
+	key, err := datastore.DecodeKey(encodedKey)
+	if err != nil {
+		http.Error(wr.ResponseWriter, "This link isn't valid.", http.StatusForbidden)
+		return
+	}
+	var p person.Person
+	if err := dsclient.FromContext(ctx).Get(ctx, key, &p); err != nil {
+		log.Printf("Getting person %v for mail preferences: %v", key, err)
+		http.Error(wr.ResponseWriter, "This link isn't valid.", http.StatusNotFound)
+		return
+	}
+	p.DatastoreKey = key
+
+	saved := false
+	if wr.Method == http.MethodPost {
+		old := p
+		if wr.Request.PostForm.Get("List-Unsubscribe") == "One-Click" {
+			p.MailPreference = person.MailNone
+		} else {
+			pref, err := strconv.Atoi(wr.Request.PostForm.Get("preference"))
+			if err != nil || pref < int(person.MailAll) || pref > int(person.MailNone) {
+				http.Error(wr.ResponseWriter, "Invalid preference.", http.StatusBadRequest)
+				return
+			}
+			p.MailPreference = person.MailPreference(pref)
+		}
+		if _, err := dsclient.FromContext(ctx).Put(ctx, key, &p); err != nil {
+			log.Printf("Saving mail preference for %v: %v", key, err)
+			http.Error(wr.ResponseWriter, "Couldn't save your preference.", http.StatusInternalServerError)
+			return
+		}
+		if err := recordChange(ctx, wr, key, &old, &p, nil, personAuditIgnore); err != nil {
+			log.Printf("%v", err)
+		}
+		log.Printf("%s set their mail preference to %v", p.FullName(), p.MailPreference)
+		saved = true
+	}
+
+	data := wr.MakeTemplateData(map[string]interface{}{
+		"Person":       &p,
+		"Preferences":  person.AllMailPreferences(),
+		"Key":          encodedKey,
+		"Sig":          wr.Request.Form.Get("sig"),
+		"Saved":        saved,
+		"Unsubscribed": p.MailPreference == person.MailNone,
+	})
+	tpl := template.Must(template.ParseFiles("templates/main.html", "templates/mailPreferences.html"))
+	if err := tpl.ExecuteTemplate(wr.ResponseWriter, "mailPreferences.html", data); err != nil {
+		log.Printf("%v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package conju
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/model/message"
+)
+
+func TestLinkSecret(t *testing.T) {
+	t.Setenv("LINK_SECRET", "")
+	ctx := dsclient.WrapContext(context.Background(), dsclient.NewMemoryClient())
+	first, err := linkSecret(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) < 32 {
+		t.Errorf("linkSecret is %d bytes, want at least 32", len(first))
+	}
+	again, err := linkSecret(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, again) {
+		t.Errorf("linkSecret changed between calls")
+	}
+
+	other, err := linkSecret(dsclient.WrapContext(context.Background(), dsclient.NewMemoryClient()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first, other) {
+		t.Errorf("two datastores have the same link secret")
+	}
+
+	t.Setenv("LINK_SECRET", "configured")
+	if secret, err := linkSecret(ctx); err != nil || string(secret) != "configured" {
+		t.Errorf("linkSecret with LINK_SECRET set = %q, %v; want %q", secret, err, "configured")
+	}
+}
+
+func TestMailRecipientType(t *testing.T) {
+	s := newTestSite(t)
+	for _, msg := range []*message.Message{
+		{ShortName: "rsvpconfirmation", RecipientType: message.Attendees},
+		{ShortName: "reminder", RecipientType: message.Individuals},
+	} {
+		msg.Event = s.ev.Key
+		msg.Text = "Hello."
+		if err := message.PutMessage(s.ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		name          string
+		want          message.RecipientType
+		transactional bool
+		attending     bool
+	}{
+		{name: "rsvpreceipt", want: message.Individuals, transactional: true},
+		{name: "initial_invitation", want: message.Invitees},
+		// Saved messages say for themselves.
+		{name: "rsvpconfirmation", want: message.Attendees, attending: true},
+		{name: "reminder", want: message.Individuals, transactional: true},
+	} {
+		got := mailRecipientType(s.ctx, s.ev.Key, tc.name)
+		if got != tc.want {
+			t.Errorf("%s: recipient type %v, want %v", tc.name, got, tc.want)
+		}
+		if transactional := isTransactional(s.ctx, s.ev.Key, tc.name); transactional != tc.transactional {
+			t.Errorf("%s: transactional %v, want %v", tc.name, transactional, tc.transactional)
+		}
+		if segment := defaultSegment(got); segment.Attending != tc.attending {
+			t.Errorf("%s: default segment %+v, want attending %v", tc.name, segment, tc.attending)
+		}
+	}
+}
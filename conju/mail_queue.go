@@ -0,0 +1,430 @@
+package conju
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/conju/mailer"
+)
+
+// Mail sent to a list (see email_targets.go) is queued as one MailJob per
+// recipient, and sent by processMailQueue, which cron runs every minute.
+// Failed sends are retried with backoff.
+
+type MailStatus int
+
+const (
+	MailPending MailStatus = iota // waiting to be sent, or to be retried
+	MailSent
+	MailFailed // gave up after maxMailAttempts
+)
+
+func (s MailStatus) String() string {
+	switch s {
+	case MailPending:
+		return "Pending"
+	case MailSent:
+		return "Sent"
+	case MailFailed:
+		return "Failed"
+	}
+	return fmt.Sprintf("MailStatus(%d)", int(s))
+}
+
+const (
+	maxMailAttempts = 8
+	// mailLease is how long a job is left alone once a worker has claimed
+	// it, so that an overlapping run doesn't send it too.
+	mailLease = 10 * time.Minute
+)
+
+// mailBackoff is how long to wait before retrying a job that has failed
+// attempts times.
+func mailBackoff(attempts int) time.Duration {
+	const max = 6 * time.Hour
+	if attempts > 10 {
+		return max
+	}
+	if backoff := time.Minute << (attempts - 1); backoff < max {
+		return backoff
+	}
+	return max
+}
+
+// Mailing groups the jobs sent from one email template. A real mailing is
+// keyed by its template under the event, so sending it again adds to the
+// same Mailing; each test run, and each run of a scheduled mailing, gets a
+// Mailing of its own.
+type Mailing struct {
+	Template   string
+	Recipients string // who it was sent to, as described by the first send
+	Test       bool
+	Created    time.Time
+	CreatedBy  *datastore.Key // Person
+}
+
+// MailJob is one queued message, rendered when it was queued. It is a
+// child of its Mailing. Jobs in a real mailing are keyed by recipient, so
+// that nobody gets the same mailing twice.
+type MailJob struct {
+	Recipient   string // who the message is for, for the status page
+	Template    string
+	Person      *datastore.Key // recipient, if the message goes to them (see SentMail)
+	Invitation  *datastore.Key
+	FromName    string
+	FromEmail   string
+	To          []string
+	Cc          []string
+	Bcc         []string
+	Subject     string `datastore:",noindex"`
+	Text        string `datastore:",noindex"`
+	HTML        string `datastore:",noindex"`
+	Unsubscribe string `datastore:",noindex"`
+	Status      MailStatus
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string `datastore:",noindex"`
+	Queued      time.Time
+	Sent        time.Time
+	MessageID   string
+}
+
+// newMailJob returns a pending job to send msg.
+func newMailJob(recipient string, msg *mailer.Message) *MailJob {
+	now := time.Now()
+	return &MailJob{
+		Recipient:   recipient,
+		FromName:    msg.From.Name,
+		FromEmail:   msg.From.Email,
+		To:          emails(msg.To),
+		Cc:          emails(msg.Cc),
+		Bcc:         emails(msg.Bcc),
+		Subject:     msg.Subject,
+		Text:        msg.Text,
+		HTML:        msg.HTML,
+		Unsubscribe: msg.Unsubscribe,
+		Status:      MailPending,
+		NextAttempt: now,
+		Queued:      now,
+	}
+}
+
+func (job *MailJob) message() *mailer.Message {
+	return &mailer.Message{
+		From:        mailer.Address{Name: job.FromName, Email: job.FromEmail},
+		To:          addresses(job.To),
+		Cc:          addresses(job.Cc),
+		Bcc:         addresses(job.Bcc),
+		Subject:     job.Subject,
+		Text:        job.Text,
+		HTML:        job.HTML,
+		Unsubscribe: job.Unsubscribe,
+	}
+}
+
+func emails(addrs []mailer.Address) []string {
+	var out []string
+	for _, a := range addrs {
+		out = append(out, a.Email)
+	}
+	return out
+}
+
+// getMailing returns the key of the Mailing for sending emailTemplate to
+// the described recipients, creating it if need be. Real mailings are
+// named, usually by their template; an empty name makes a new test
+// mailing.
+func getMailing(ctx context.Context, wr WrappedRequest, name, emailTemplate, recipients string) (*datastore.Key, error) {
+	test := name == ""
+	client := dsclient.FromContext(ctx)
+	mailing := Mailing{
+		Template:   emailTemplate,
+		Recipients: recipients,
+		Test:       test,
+		Created:    time.Now(),
+	}
+	if wr.LoginInfo != nil {
+		mailing.CreatedBy = wr.LoginInfo.PersonKey
+	}
+	if test {
+		return client.Put(ctx, datastore.IncompleteKey("Mailing", wr.EventKey), &mailing)
+	}
+	key := datastore.NameKey("Mailing", name, wr.EventKey)
+	var existing Mailing
+	err := client.Get(ctx, key, &existing)
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, datastore.ErrNoSuchEntity) {
+		return nil, err
+	}
+	return client.Put(ctx, key, &mailing)
+}
+
+// queueMail adds job to the mailing, named jobName. If jobName is empty
+// the job is always added; otherwise it is skipped, returning false, if a
+// job of that name has already been sent or is waiting to be.
+func queueMail(ctx context.Context, mailingKey *datastore.Key, jobName string, job *MailJob) (bool, error) {
+	client := dsclient.FromContext(ctx)
+	if jobName == "" {
+		_, err := client.Put(ctx, datastore.IncompleteKey("MailJob", mailingKey), job)
+		return err == nil, err
+	}
+	key := datastore.NameKey("MailJob", jobName, mailingKey)
+	queued := false
+	err := client.RunInTransaction(ctx, func(tx dsclient.Client) error {
+		var existing MailJob
+		err := tx.Get(ctx, key, &existing)
+		if err == nil && existing.Status != MailFailed {
+			queued = false
+			return nil
+		}
+		if err != nil && !errors.Is(err, datastore.ErrNoSuchEntity) {
+			return err
+		}
+		queued = true
+		_, err = tx.Put(ctx, key, job)
+		return err
+	})
+	return queued, err
+}
+
+// claimMailJob marks the job as being worked on, so that other workers
+// leave it alone for mailLease, and counts the attempt. It returns false
+// if the job is no longer due.
+func claimMailJob(ctx context.Context, key *datastore.Key, job *MailJob, now time.Time) (bool, error) {
+	claimed := false
+	err := dsclient.FromContext(ctx).RunInTransaction(ctx, func(tx dsclient.Client) error {
+		*job = MailJob{}
+		if err := tx.Get(ctx, key, job); err != nil {
+			return err
+		}
+		claimed = job.Status == MailPending && !job.NextAttempt.After(now)
+		if !claimed {
+			return nil
+		}
+		job.Attempts++
+		job.NextAttempt = now.Add(mailLease)
+		_, err := tx.Put(ctx, key, job)
+		return err
+	})
+	return claimed, err
+}
+
+// processMailQueue sends the jobs that are due, until there are none left
+// or the deadline passes, and returns how many were sent and how many
+// failed.
+func processMailQueue(ctx context.Context, transport mailer.Transport, deadline time.Time) (sent, failed int, err error) {
+	client := dsclient.FromContext(ctx)
+	// Sending stops at the deadline, but the job still needs updating.
+	sendCtx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	for time.Now().Before(deadline) {
+		now := time.Now()
+		q := dsclient.NewQuery("MailJob").FilterField("Status", "=", int64(MailPending)).
+			FilterField("NextAttempt", "<=", now).Order("NextAttempt").KeysOnly().Limit(20)
+		keys, err := client.GetAll(ctx, q, nil)
+		if err != nil {
+			return sent, failed, fmt.Errorf("fetching mail jobs: %w", err)
+		}
+		if len(keys) == 0 {
+			break
+		}
+		for _, key := range keys {
+			var job MailJob
+			claimed, err := claimMailJob(ctx, key, &job, now)
+			if err != nil {
+				return sent, failed, fmt.Errorf("claiming mail job %v: %w", key, err)
+			}
+			if !claimed {
+				continue
+			}
+			messageID, sendErr := transport.Send(sendCtx, job.message())
+			if sendErr != nil {
+				if sendCtx.Err() != nil {
+					// Out of time rather than failed; the lease will
+					// expire and the job will be picked up again.
+					return sent, failed, nil
+				}
+				failed++
+				job.LastError = sendErr.Error()
+				if job.Attempts >= maxMailAttempts {
+					job.Status = MailFailed
+				} else {
+					job.NextAttempt = time.Now().Add(mailBackoff(job.Attempts))
+				}
+				log.Printf("Sending mail to %v (attempt %d): %v", job.To, job.Attempts, sendErr)
+			} else {
+				sent++
+				job.Status = MailSent
+				job.Sent = time.Now()
+				job.MessageID = messageID
+				job.LastError = ""
+			}
+			if _, err := client.Put(ctx, key, &job); err != nil {
+				return sent, failed, fmt.Errorf("updating mail job %v: %w", key, err)
+			}
+			if job.Status != MailPending {
+				recordJob(ctx, key, &job)
+			}
+		}
+	}
+	return sent, failed, nil
+}
+
+// recordJob records a job that has been sent, or given up on, as a
+// SentMail.
+func recordJob(ctx context.Context, key *datastore.Key, job *MailJob) {
+	sent := &SentMail{
+		Template:   job.Template,
+		Event:      key.Parent.Parent,
+		Invitation: job.Invitation,
+		Mailing:    key.Parent,
+		Email:      strings.Join(job.To, ", "),
+		Subject:    job.Subject,
+		Sent:       job.Sent,
+		MessageID:  job.MessageID,
+		Status:     job.Status,
+		Error:      job.LastError,
+	}
+	if sent.Sent.IsZero() {
+		sent.Sent = time.Now()
+	}
+	recordSentMail(ctx, job.Person, key, sent)
+}
+
+// handleProcessMailQueue is run by cron (see cron.yaml), and may also be
+// run by an admin.
+func handleProcessMailQueue(ctx context.Context, wr WrappedRequest) {
+	// App Engine strips this header from requests that don't come from
+	// cron.
+	if wr.Request.Header.Get("X-Appengine-Cron") != "true" && !wr.IsAdminUser() {
+		http.Error(wr.ResponseWriter, "Not authorized.", http.StatusForbidden)
+		return
+	}
+	sent, failed, err := processMailQueue(ctx, wr.MailTransport, time.Now().Add(50*time.Second))
+	if err != nil {
+		log.Printf("processMailQueue: %v", err)
+		http.Error(wr.ResponseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(wr.ResponseWriter, "Sent %d, failed %d.\n", sent, failed)
+}
+
+type mailingCounts struct {
+	Pending int
+	Sent    int
+	Failed  int
+}
+
+func (c *mailingCounts) add(status MailStatus) {
+	switch status {
+	case MailPending:
+		c.Pending++
+	case MailSent:
+		c.Sent++
+	case MailFailed:
+		c.Failed++
+	}
+}
+
+// handleMailings lists the current event's mailings and how far along
+// each one is.
+func handleMailings(ctx context.Context, wr WrappedRequest) {
+	client := dsclient.FromContext(ctx)
+	var mailings []*Mailing
+	mailingKeys, err := client.GetAll(ctx, dsclient.NewQuery("Mailing").Ancestor(wr.EventKey), &mailings)
+	if err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Fetching mailings: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var jobs []*MailJob
+	jobKeys, err := client.GetAll(ctx, dsclient.NewQuery("MailJob").Ancestor(wr.EventKey), &jobs)
+	if err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Fetching mail jobs: %v", err), http.StatusInternalServerError)
+		return
+	}
+	counts := make(map[string]*mailingCounts)
+	for i, job := range jobs {
+		mailingKey := jobKeys[i].Parent.Encode()
+		if counts[mailingKey] == nil {
+			counts[mailingKey] = &mailingCounts{}
+		}
+		counts[mailingKey].add(job.Status)
+	}
+
+	type MailingRow struct {
+		Key     string
+		Mailing *Mailing
+		Counts  mailingCounts
+	}
+	var rows []MailingRow
+	for i, mailing := range mailings {
+		row := MailingRow{Key: mailingKeys[i].Encode(), Mailing: mailing}
+		if c := counts[row.Key]; c != nil {
+			row.Counts = *c
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(a, b int) bool { return rows[a].Mailing.Created.After(rows[b].Mailing.Created) })
+
+	tpl := template.Must(template.New("").ParseFiles("templates/main.html", "templates/mailings.html"))
+	data := wr.MakeTemplateData(map[string]interface{}{
+		"Mailings": rows,
+	})
+	if err := tpl.ExecuteTemplate(wr.ResponseWriter, "mailings.html", data); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+// handleMailing shows each job in a mailing.
+func handleMailing(ctx context.Context, wr WrappedRequest) {
+	client := dsclient.FromContext(ctx)
+	mailingKey, err := datastore.DecodeKey(wr.Request.FormValue("mailing"))
+	if err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Invalid mailing: %v", err), http.StatusBadRequest)
+		return
+	}
+	var mailing Mailing
+	if err := client.Get(ctx, mailingKey, &mailing); err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Getting mailing: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var jobs []*MailJob
+	if _, err := client.GetAll(ctx, dsclient.NewQuery("MailJob").Ancestor(mailingKey), &jobs); err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Fetching mail jobs: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var counts mailingCounts
+	for _, job := range jobs {
+		counts.add(job.Status)
+	}
+	// Problems first, then what's still to go.
+	order := map[MailStatus]int{MailFailed: 0, MailPending: 1, MailSent: 2}
+	sort.SliceStable(jobs, func(a, b int) bool {
+		if jobs[a].Status != jobs[b].Status {
+			return order[jobs[a].Status] < order[jobs[b].Status]
+		}
+		return jobs[a].Recipient < jobs[b].Recipient
+	})
+
+	tpl := template.Must(template.New("").ParseFiles("templates/main.html", "templates/mailing.html"))
+	data := wr.MakeTemplateData(map[string]interface{}{
+		"Mailing": mailing,
+		"Counts":  counts,
+		"Jobs":    jobs,
+	})
+	if err := tpl.ExecuteTemplate(wr.ResponseWriter, "mailing.html", data); err != nil {
+		log.Printf("%v", err)
+	}
+}
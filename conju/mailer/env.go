@@ -0,0 +1,61 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Default sending rates, in messages a minute, by transport. SendGrid's
+// limits depend on the plan; a local SMTP server may be a relay with
+// limits of its own.
+var defaultRates = map[string]int{
+	"sendgrid": 600,
+	"smtp":     60,
+	"file":     0,
+}
+
+// FromEnv returns the Transport named by the MAIL_TRANSPORT environment
+// variable:
+//
+//   - "sendgrid" (the default) uses SENDGRID_API_KEY.
+//   - "smtp" uses the server at SMTP_ADDR, logging in with SMTP_USERNAME
+//     and SMTP_PASSWORD if they're set.
+//   - "file" appends to the mbox file MAIL_FILE (default "mail.mbox").
+//
+// The transport is rate limited to MAIL_RATE_PER_MINUTE messages a
+// minute, or a default for its kind; 0 means no limit.
+func FromEnv() (Transport, error) {
+	kind := os.Getenv("MAIL_TRANSPORT")
+	if kind == "" {
+		kind = "sendgrid"
+	}
+	var t Transport
+	switch kind {
+	case "sendgrid":
+		t = NewSendGridTransport(os.Getenv("SENDGRID_API_KEY"))
+	case "smtp":
+		addr := os.Getenv("SMTP_ADDR")
+		if addr == "" {
+			return nil, fmt.Errorf("MAIL_TRANSPORT is smtp but SMTP_ADDR is not set")
+		}
+		t = NewSMTPTransport(addr, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+	case "file":
+		path := os.Getenv("MAIL_FILE")
+		if path == "" {
+			path = "mail.mbox"
+		}
+		t = NewFileTransport(path)
+	default:
+		return nil, fmt.Errorf("unknown MAIL_TRANSPORT %q", kind)
+	}
+
+	rate := defaultRates[kind]
+	if s := os.Getenv("MAIL_RATE_PER_MINUTE"); s != "" {
+		var err error
+		if rate, err = strconv.Atoi(s); err != nil {
+			return nil, fmt.Errorf("bad MAIL_RATE_PER_MINUTE %q: %v", s, err)
+		}
+	}
+	return RateLimit(t, rate), nil
+}
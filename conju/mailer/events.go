@@ -0,0 +1,142 @@
+package mailer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// DeliveryKind is what a delivery report says happened to a message.
+type DeliveryKind int
+
+const (
+	Delivered  DeliveryKind = iota + 1 // accepted by the recipient's server
+	Deferred                           // delayed; the transport will retry
+	Bounced                            // rejected by the recipient's server
+	Dropped                            // not sent by the transport at all
+	SpamReport                         // the recipient marked it as spam
+)
+
+func (k DeliveryKind) String() string {
+	switch k {
+	case Delivered:
+		return "Delivered"
+	case Deferred:
+		return "Deferred"
+	case Bounced:
+		return "Bounced"
+	case Dropped:
+		return "Dropped"
+	case SpamReport:
+		return "Reported as spam"
+	}
+	return fmt.Sprintf("DeliveryKind(%d)", int(k))
+}
+
+// DeliveryEvent is one report about a message sent to one address.
+type DeliveryEvent struct {
+	Kind      DeliveryKind
+	Email     string
+	MessageID string // as returned by Transport.Send
+	Time      time.Time
+	Reason    string
+	Permanent bool // for Bounced: the address doesn't work
+}
+
+// ParseSendGridEvents reads the body of a SendGrid event webhook post.
+// Events that aren't about delivery, like opens and clicks, are skipped.
+func ParseSendGridEvents(r io.Reader) ([]DeliveryEvent, error) {
+	var posted []struct {
+		Email     string `json:"email"`
+		Timestamp int64  `json:"timestamp"`
+		Event     string `json:"event"`
+		MessageID string `json:"sg_message_id"`
+		Reason    string `json:"reason"`
+		Response  string `json:"response"`
+		Type      string `json:"type"`
+	}
+	if err := json.NewDecoder(r).Decode(&posted); err != nil {
+		return nil, fmt.Errorf("decoding sendgrid events: %w", err)
+	}
+	var events []DeliveryEvent
+	for _, p := range posted {
+		e := DeliveryEvent{
+			Email: p.Email,
+			// sg_message_id is the X-Message-Id that Send returned,
+			// followed by a suffix for the recipient.
+			MessageID: strings.SplitN(p.MessageID, ".", 2)[0],
+			Time:      time.Unix(p.Timestamp, 0).UTC(),
+			Reason:    p.Reason,
+		}
+		switch p.Event {
+		case "delivered":
+			e.Kind = Delivered
+			e.Reason = p.Response
+		case "deferred":
+			e.Kind = Deferred
+			e.Reason = p.Response
+		case "bounce":
+			e.Kind = Bounced
+			// A "blocked" bounce is the server refusing for now,
+			// rather than the address not existing.
+			e.Permanent = p.Type != "blocked"
+		case "dropped":
+			e.Kind = Dropped
+		case "spamreport":
+			e.Kind = SpamReport
+		default:
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// genericKinds are the event names ParseGenericEvents accepts.
+var genericKinds = map[string]DeliveryKind{
+	"delivered": Delivered,
+	"deferred":  Deferred,
+	"bounce":    Bounced,
+	"dropped":   Dropped,
+	"complaint": SpamReport,
+}
+
+// ParseGenericEvents reads delivery reports posted in conju's own format,
+// for transports other than SendGrid: a JSON list of objects with "event"
+// (one of "delivered", "deferred", "bounce", "dropped" or "complaint"),
+// "email", "message_id", "time" (RFC 3339), and optionally "reason" and,
+// for bounces, "permanent".
+func ParseGenericEvents(r io.Reader) ([]DeliveryEvent, error) {
+	var posted []struct {
+		Event     string    `json:"event"`
+		Email     string    `json:"email"`
+		MessageID string    `json:"message_id"`
+		Time      time.Time `json:"time"`
+		Reason    string    `json:"reason"`
+		Permanent bool      `json:"permanent"`
+	}
+	if err := json.NewDecoder(r).Decode(&posted); err != nil {
+		return nil, fmt.Errorf("decoding events: %w", err)
+	}
+	var events []DeliveryEvent
+	for i, p := range posted {
+		kind, ok := genericKinds[p.Event]
+		if !ok {
+			return nil, fmt.Errorf("event %d: unknown event %q", i, p.Event)
+		}
+		if p.Email == "" {
+			return nil, fmt.Errorf("event %d: no email", i)
+		}
+		events = append(events, DeliveryEvent{
+			Kind:      kind,
+			Email:     p.Email,
+			MessageID: p.MessageID,
+			Time:      p.Time,
+			Reason:    p.Reason,
+			Permanent: kind == Bounced && p.Permanent,
+		})
+	}
+	return events, nil
+}
@@ -0,0 +1,69 @@
+package mailer
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseEvents(t *testing.T) {
+	type TestCase struct {
+		Name  string
+		File  string
+		Parse func(io.Reader) ([]DeliveryEvent, error)
+		Want  []DeliveryEvent
+	}
+	testcases := []TestCase{
+		{
+			Name:  "SendGrid",
+			File:  "sendgrid_events.json",
+			Parse: ParseSendGridEvents,
+			Want: []DeliveryEvent{
+				{Kind: Delivered, Email: "alice@example.com", MessageID: "14c5d75ce93", Time: time.Unix(1748779205, 0).UTC(), Reason: "250 OK"},
+				{Kind: Bounced, Email: "bob@example.com", MessageID: "14c5d75ce93", Time: time.Unix(1748779206, 0).UTC(), Reason: "550 5.1.1 The email account that you tried to reach does not exist.", Permanent: true},
+				{Kind: Bounced, Email: "carol@example.com", MessageID: "14c5d75ce93", Time: time.Unix(1748779207, 0).UTC(), Reason: "421 4.7.0 Try again later, closing connection."},
+				{Kind: SpamReport, Email: "dave@example.com", MessageID: "14c5d75ce93", Time: time.Unix(1748779208, 0).UTC()},
+			},
+		},
+		{
+			Name:  "Generic",
+			File:  "generic_events.json",
+			Parse: ParseGenericEvents,
+			Want: []DeliveryEvent{
+				{Kind: Delivered, Email: "alice@example.com", MessageID: "<5f2c0a1e9b3d4e6f@example.com>", Time: time.Date(2025, 6, 1, 12, 0, 5, 0, time.UTC)},
+				{Kind: Bounced, Email: "bob@example.com", MessageID: "<0c6e7d2a1f884b35@example.com>", Time: time.Date(2025, 6, 1, 12, 0, 6, 0, time.UTC), Reason: "550 5.1.1 User unknown", Permanent: true},
+				{Kind: SpamReport, Email: "dave@example.com", MessageID: "<9a1b2c3d4e5f6071@example.com>", Time: time.Date(2025, 6, 1, 12, 0, 8, 0, time.UTC)},
+			},
+		},
+	}
+	for _, tc := range testcases {
+		f, err := os.Open(filepath.Join("testdata", tc.File))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := tc.Parse(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.Name, err)
+		}
+		if !reflect.DeepEqual(got, tc.Want) {
+			t.Errorf("%s: got\n%+v\nwant\n%+v", tc.Name, got, tc.Want)
+		}
+	}
+}
+
+func TestParseGenericEventsErrors(t *testing.T) {
+	for _, body := range []string{
+		`{"event": "delivered"}`,
+		`[{"event": "opened", "email": "alice@example.com"}]`,
+		`[{"event": "bounce"}]`,
+	} {
+		if events, err := ParseGenericEvents(strings.NewReader(body)); err == nil {
+			t.Errorf("ParseGenericEvents(%s) = %v, want an error", body, events)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type fileTransport struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileTransport returns a Transport that appends each message to the
+// mbox file at path instead of delivering it, so that mail can be read
+// with any mail client.
+func NewFileTransport(path string) Transport {
+	return &fileTransport{path: path}
+}
+
+func (t *fileTransport) Send(ctx context.Context, msg *Message) (string, error) {
+	msg = withID(msg)
+	data, err := msg.Bytes()
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From %s %s\n", msg.From.Email, time.Now().UTC().Format(time.ANSIC))
+	fmt.Fprintf(&buf, "X-Recipients: %s\n", strings.Join(msg.Recipients(), ", "))
+	for _, line := range bytes.Split(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), []byte("\n")) {
+		// mboxrd quoting, so that body lines aren't taken for separators.
+		if bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+			buf.WriteByte('>')
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, err := os.OpenFile(t.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return "", err
+	}
+	return msg.ID, f.Close()
+}
@@ -0,0 +1,217 @@
+// Package mailer sends rendered email through a Transport. conju handlers
+// build a Message and hand it to the Transport chosen at startup, which
+// is SendGrid in production (NewSendGridTransport), an SMTP server
+// (NewSMTPTransport), or an mbox file on local disk (NewFileTransport) for
+// development and tests.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Transport delivers messages. Send returns the id the transport gave the
+// message, which its delivery reports refer to.
+type Transport interface {
+	Send(ctx context.Context, msg *Message) (string, error)
+}
+
+// Address is a mailbox with an optional display name.
+type Address struct {
+	Name  string
+	Email string
+}
+
+func (a Address) String() string {
+	return (&mail.Address{Name: a.Name, Address: a.Email}).String()
+}
+
+// Message is an email with a plain text body and, optionally, an HTML
+// alternative.
+type Message struct {
+	From    Address
+	To      []Address
+	Cc      []Address
+	Bcc     []Address
+	Subject string
+	Text    string
+	HTML    string
+	Date    time.Time // defaults to the time the message is encoded
+	ID      string    // Message-ID header, angle brackets included
+
+	// Unsubscribe is a URL that unsubscribes the recipient when posted to
+	// (RFC 8058), for the List-Unsubscribe header. Empty for mail that
+	// can't be unsubscribed from.
+	Unsubscribe string
+
+	Attachments []Attachment
+}
+
+// Attachment is a file attached to a message.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Recipients returns the addresses the message is delivered to, including
+// Bcc.
+func (m *Message) Recipients() []string {
+	var addrs []string
+	for _, list := range [][]Address{m.To, m.Cc, m.Bcc} {
+		for _, a := range list {
+			addrs = append(addrs, a.Email)
+		}
+	}
+	return addrs
+}
+
+// Bytes encodes the message in RFC 5322 form, without a Bcc header.
+func (m *Message) Bytes() ([]byte, error) {
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	header("From", m.From.String())
+	if len(m.To) > 0 {
+		header("To", joinAddresses(m.To))
+	}
+	if len(m.Cc) > 0 {
+		header("Cc", joinAddresses(m.Cc))
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	if m.ID != "" {
+		header("Message-ID", m.ID)
+	}
+	if m.Unsubscribe != "" {
+		header("List-Unsubscribe", "<"+m.Unsubscribe+">")
+		header("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+	header("MIME-Version", "1.0")
+
+	if len(m.Attachments) == 0 {
+		if err := m.writeBody(&buf, header); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	mixed := multipart.NewWriter(&buf)
+	header("Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mixed.Boundary()}))
+	buf.WriteString("\r\n")
+	bodyHeader := make(textproto.MIMEHeader)
+	var body bytes.Buffer
+	if err := m.writeBody(&body, bodyHeader.Set); err != nil {
+		return nil, err
+	}
+	pw, err := mixed.CreatePart(bodyHeader)
+	if err != nil {
+		return nil, err
+	}
+	// writeBody ends the headers it writes with a blank line, which
+	// CreatePart has already written.
+	pw.Write(bytes.TrimPrefix(body.Bytes(), []byte("\r\n")))
+	for _, a := range m.Attachments {
+		pw, err := mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.ContentType},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64(pw, a.Data)
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeBody writes the text, and HTML if there is any, to w, with its
+// Content-Type headers passed to header, followed by a blank line.
+func (m *Message) writeBody(w *bytes.Buffer, header func(name, value string)) error {
+	if m.HTML == "" {
+		header("Content-Type", `text/plain; charset="utf-8"`)
+		header("Content-Transfer-Encoding", "quoted-printable")
+		w.WriteString("\r\n")
+		return writeQuotedPrintable(w, m.Text)
+	}
+
+	alternative := multipart.NewWriter(w)
+	header("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": alternative.Boundary()}))
+	w.WriteString("\r\n")
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain", m.Text},
+		{"text/html", m.HTML},
+	} {
+		pw, err := alternative.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + `; charset="utf-8"`},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return err
+		}
+		if err := writeQuotedPrintable(pw, part.content); err != nil {
+			return err
+		}
+	}
+	return alternative.Close()
+}
+
+// writeBase64 writes data base64 encoded, in lines of 76 characters.
+func writeBase64(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		fmt.Fprintf(w, "%s\r\n", encoded[:76])
+		encoded = encoded[76:]
+	}
+	fmt.Fprintf(w, "%s\r\n", encoded)
+}
+
+// withID returns m, or a copy of it with a new Message-ID if it has none.
+func withID(m *Message) *Message {
+	if m.ID != "" {
+		return m
+	}
+	domain := "localhost"
+	if i := strings.LastIndex(m.From.Email, "@"); i >= 0 {
+		domain = m.From.Email[i+1:]
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	copied := *m
+	copied.ID = fmt.Sprintf("<%x@%s>", b, domain)
+	return &copied
+}
+
+func joinAddresses(addrs []Address) string {
+	var parts []string
+	for _, a := range addrs {
+		parts = append(parts, a.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+func writeQuotedPrintable(w interface{ Write([]byte) (int, error) }, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(s)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
@@ -0,0 +1,270 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testMessage() *Message {
+	return &Message{
+		From:    Address{"Dana Scott and Chris Shabsin", "hosts@example.com"},
+		To:      []Address{{"Alice Smith", "alice@example.com"}},
+		Bcc:     []Address{{"", "archive@example.com"}},
+		Subject: "PSR 2025: RSVP from Alice",
+		Text:    "See you there.\nFrom the hosts",
+		Date:    time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestMessageBytes(t *testing.T) {
+	type TestCase struct {
+		Name            string
+		HTML            string
+		WantContentType string
+	}
+	testcases := []TestCase{
+		{Name: "Text only", WantContentType: "text/plain"},
+		{Name: "With HTML", HTML: "<p>See you there.</p>", WantContentType: "multipart/alternative"},
+	}
+	for _, tc := range testcases {
+		msg := testMessage()
+		msg.HTML = tc.HTML
+		data, err := msg.Bytes()
+		if err != nil {
+			t.Fatalf("%s: Bytes: %v", tc.Name, err)
+		}
+		parsed, err := mail.ReadMessage(strings.NewReader(string(data)))
+		if err != nil {
+			t.Fatalf("%s: ReadMessage: %v", tc.Name, err)
+		}
+		if got := parsed.Header.Get("Subject"); got != msg.Subject {
+			t.Errorf("%s: Subject was %q, want %q", tc.Name, got, msg.Subject)
+		}
+		if to, err := parsed.Header.AddressList("To"); err != nil || len(to) != 1 || to[0].Address != "alice@example.com" {
+			t.Errorf("%s: To was %v (%v)", tc.Name, to, err)
+		}
+		if got := parsed.Header.Get("Bcc"); got != "" {
+			t.Errorf("%s: Bcc header was %q, want none", tc.Name, got)
+		}
+		if got := parsed.Header.Get("Content-Type"); !strings.HasPrefix(got, tc.WantContentType) {
+			t.Errorf("%s: Content-Type was %q, want %s", tc.Name, got, tc.WantContentType)
+		}
+	}
+}
+
+func TestUnsubscribeHeader(t *testing.T) {
+	msg := testMessage()
+	for _, unsubscribe := range []string{"", "https://example.com/mailPreferences?p=x&sig=y"} {
+		msg.Unsubscribe = unsubscribe
+		data, err := msg.Bytes()
+		if err != nil {
+			t.Fatalf("Bytes: %v", err)
+		}
+		parsed, err := mail.ReadMessage(strings.NewReader(string(data)))
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		want, wantPost := "", ""
+		if unsubscribe != "" {
+			want, wantPost = "<"+unsubscribe+">", "List-Unsubscribe=One-Click"
+		}
+		if got := parsed.Header.Get("List-Unsubscribe"); got != want {
+			t.Errorf("List-Unsubscribe was %q, want %q", got, want)
+		}
+		if got := parsed.Header.Get("List-Unsubscribe-Post"); got != wantPost {
+			t.Errorf("List-Unsubscribe-Post was %q, want %q", got, wantPost)
+		}
+	}
+}
+
+func TestAttachments(t *testing.T) {
+	for _, html := range []string{"", "<p>See you there.</p>"} {
+		msg := testMessage()
+		msg.HTML = html
+		ics := []byte("BEGIN:VCALENDAR\r\n" + strings.Repeat("X", 200) + "\r\nEND:VCALENDAR\r\n")
+		msg.Attachments = []Attachment{{Filename: "PSR2025.ics", ContentType: "text/calendar", Data: ics}}
+		data, err := msg.Bytes()
+		if err != nil {
+			t.Fatalf("Bytes: %v", err)
+		}
+		parsed, err := mail.ReadMessage(strings.NewReader(string(data)))
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/mixed" {
+			t.Fatalf("Content-Type was %q (%v), want multipart/mixed", parsed.Header.Get("Content-Type"), err)
+		}
+		r := multipart.NewReader(parsed.Body, params["boundary"])
+		body, err := r.NextPart()
+		if err != nil {
+			t.Fatalf("reading body part: %v", err)
+		}
+		wantBody := "text/plain"
+		if html != "" {
+			wantBody = "multipart/alternative"
+		}
+		if got := body.Header.Get("Content-Type"); !strings.HasPrefix(got, wantBody) {
+			t.Errorf("body Content-Type was %q, want %s", got, wantBody)
+		}
+		attachment, err := r.NextPart()
+		if err != nil {
+			t.Fatalf("reading attachment: %v", err)
+		}
+		if got := attachment.FileName(); got != "PSR2025.ics" {
+			t.Errorf("attachment filename was %q", got)
+		}
+		got, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, attachment))
+		if err != nil || string(got) != string(ics) {
+			t.Errorf("attachment was %q (%v), want %q", got, err, ics)
+		}
+		if _, err := r.NextPart(); err != io.EOF {
+			t.Errorf("got another part (%v), want EOF", err)
+		}
+	}
+}
+
+func TestFileTransport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.mbox")
+	transport := NewFileTransport(path)
+	var ids []string
+	for i := 0; i < 2; i++ {
+		id, err := transport.Send(context.Background(), testMessage())
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if ids[0] == "" || ids[0] == ids[1] {
+		t.Errorf("message ids were %q, want two different ones", ids)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var separators, quoted int
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "From ") {
+			separators++
+		}
+		if line == ">From the hosts" {
+			quoted++
+		}
+	}
+	if separators != 2 || quoted != 2 {
+		t.Errorf("got %d messages and %d quoted lines, want 2 and 2:\n%s", separators, quoted, data)
+	}
+	if !strings.Contains(string(data), "X-Recipients: alice@example.com, archive@example.com\n") {
+		t.Errorf("recipients missing:\n%s", data)
+	}
+	if !strings.Contains(string(data), "Message-ID: "+ids[1]+"\n") {
+		t.Errorf("Message-ID %s missing:\n%s", ids[1], data)
+	}
+}
+
+// TestSMTPTransport runs the transport against a minimal SMTP server that
+// records the envelope.
+func TestSMTPTransport(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	envelope := make(chan []string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ready")
+		var got []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSpace(line)
+			switch {
+			case strings.HasPrefix(line, "EHLO"), strings.HasPrefix(line, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(line, "MAIL FROM:"), strings.HasPrefix(line, "RCPT TO:"):
+				got = append(got, line)
+				reply("250 OK")
+			case line == "DATA":
+				reply("354 go ahead")
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil || l == ".\r\n" {
+						break
+					}
+				}
+				reply("250 OK")
+			case line == "QUIT":
+				reply("221 bye")
+				envelope <- got
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	id, err := NewSMTPTransport(l.Addr().String(), "", "").Send(context.Background(), testMessage())
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if !strings.HasSuffix(id, "@example.com>") {
+		t.Errorf("message id was %q, want one at example.com", id)
+	}
+	want := []string{"MAIL FROM:<hosts@example.com>", "RCPT TO:<alice@example.com>", "RCPT TO:<archive@example.com>"}
+	got := <-envelope
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("envelope was %q, want %q", got, want)
+	}
+}
+
+type countingTransport struct{ sent []time.Time }
+
+func (c *countingTransport) Send(ctx context.Context, msg *Message) (string, error) {
+	c.sent = append(c.sent, time.Now())
+	return "", nil
+}
+
+func TestRateLimit(t *testing.T) {
+	counter := &countingTransport{}
+	transport := RateLimit(counter, 6000) // one every 10ms
+	for i := 0; i < 3; i++ {
+		if _, err := transport.Send(context.Background(), testMessage()); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	if elapsed := counter.sent[2].Sub(counter.sent[0]); elapsed < 20*time.Millisecond {
+		t.Errorf("3 messages took %v, want at least 20ms", elapsed)
+	}
+
+	// The second message a minute would have to wait, so gives up when
+	// the context is cancelled.
+	limited := RateLimit(counter, 1)
+	if _, err := limited.Send(context.Background(), testMessage()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := limited.Send(ctx, testMessage()); err != context.Canceled {
+		t.Errorf("Send after cancel was %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type rateLimited struct {
+	Transport
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time // earliest time the next message may be sent
+}
+
+// RateLimit returns a Transport that sends through t at most perMinute
+// messages a minute, spacing them evenly. Send waits for its turn, or
+// returns the context's error if it's cancelled first. If perMinute isn't
+// positive, t is returned unchanged.
+func RateLimit(t Transport, perMinute int) Transport {
+	if perMinute <= 0 {
+		return t
+	}
+	return &rateLimited{Transport: t, interval: time.Minute / time.Duration(perMinute)}
+}
+
+func (r *rateLimited) Send(ctx context.Context, msg *Message) (string, error) {
+	r.mu.Lock()
+	now := time.Now()
+	at := r.next
+	if at.Before(now) {
+		at = now
+	}
+	r.next = at.Add(r.interval)
+	r.mu.Unlock()
+
+	if wait := time.Until(at); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	return r.Transport.Send(ctx, msg)
+}
@@ -0,0 +1,66 @@
+package mailer
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/sendgrid/sendgrid-go"
+	"github.com/sendgrid/sendgrid-go/helpers/mail"
+)
+
+type sendGridTransport struct {
+	client *sendgrid.Client
+}
+
+// NewSendGridTransport returns a Transport that sends through the SendGrid
+// API.
+func NewSendGridTransport(apiKey string) Transport {
+	return sendGridTransport{sendgrid.NewSendClient(apiKey)}
+}
+
+func (t sendGridTransport) Send(ctx context.Context, msg *Message) (string, error) {
+	p := mail.NewPersonalization()
+	for _, a := range msg.To {
+		p.AddTos(mail.NewEmail(a.Name, a.Email))
+	}
+	for _, a := range msg.Cc {
+		p.AddCCs(mail.NewEmail(a.Name, a.Email))
+	}
+	for _, a := range msg.Bcc {
+		p.AddBCCs(mail.NewEmail(a.Name, a.Email))
+	}
+	content := []*mail.Content{mail.NewContent("text/plain", msg.Text)}
+	if msg.HTML != "" {
+		content = append(content, mail.NewContent("text/html", msg.HTML))
+	}
+	sgMessage := &mail.SGMailV3{
+		From:             mail.NewEmail(msg.From.Name, msg.From.Email),
+		Subject:          msg.Subject,
+		Content:          content,
+		Personalizations: []*mail.Personalization{p},
+	}
+	for _, a := range msg.Attachments {
+		sgMessage.AddAttachment(mail.NewAttachment().
+			SetContent(base64.StdEncoding.EncodeToString(a.Data)).
+			SetType(a.ContentType).
+			SetFilename(a.Filename).
+			SetDisposition("attachment"))
+	}
+	if msg.Unsubscribe != "" {
+		sgMessage.SetHeader("List-Unsubscribe", "<"+msg.Unsubscribe+">")
+		sgMessage.SetHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+	resp, err := t.client.SendWithContext(ctx, sgMessage)
+	if err != nil {
+		return "", fmt.Errorf("sendgrid: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("sendgrid: status %d: %s", resp.StatusCode, resp.Body)
+	}
+	// Event webhooks identify the message by this id.
+	if ids := resp.Headers["X-Message-Id"]; len(ids) > 0 {
+		return ids[0], nil
+	}
+	return "", nil
+}
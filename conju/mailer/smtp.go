@@ -0,0 +1,35 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+)
+
+type smtpTransport struct {
+	addr string
+	auth smtp.Auth
+}
+
+// NewSMTPTransport returns a Transport that sends through the SMTP server
+// at addr (host:port). If username is empty, no authentication is used.
+func NewSMTPTransport(addr, username, password string) Transport {
+	t := smtpTransport{addr: addr}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		t.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return t
+}
+
+func (t smtpTransport) Send(ctx context.Context, msg *Message) (string, error) {
+	msg = withID(msg)
+	data, err := msg.Bytes()
+	if err != nil {
+		return "", err
+	}
+	if err := smtp.SendMail(t.addr, t.auth, msg.From.Email, msg.Recipients(), data); err != nil {
+		return "", err
+	}
+	return msg.ID, nil
+}
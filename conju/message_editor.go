@@ -0,0 +1,209 @@
+package conju
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/model/message"
+	"github.com/cshabsin/conju/model/person"
+)
+
+// Email templates can be edited on the site, in which case they're saved
+// as a message.Message and used instead of the file of the same name (see
+// renderMail).
+
+var messageNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// messageFromFiles returns the named email template as it is in the
+// template files, or nil if there are no files for it.
+func messageFromFiles(wr WrappedRequest, name string) *message.Message {
+	_, textTpl, err := parseMailTemplates(wr.Event.ShortName, nil)
+	if err != nil {
+		log.Printf("Parsing email templates: %v", err)
+		return nil
+	}
+	body := func(suffix string) string {
+		t := textTpl.Lookup(name + suffix)
+		if t == nil || t.Tree == nil {
+			return ""
+		}
+		return t.Tree.Root.String()
+	}
+	if textTpl.Lookup(name+"_text") == nil {
+		return nil
+	}
+	return &message.Message{
+		Event:         wr.EventKey,
+		ShortName:     name,
+		RecipientType: templateRecipientType(nil, name),
+		Subject:       strings.TrimSpace(body("_subject")),
+		Text:          body("_text"),
+		HTML:          body("_html"),
+	}
+}
+
+// messageFromForm reads the editor form.
+func messageFromForm(wr WrappedRequest) *message.Message {
+	recipientType, _ := strconv.Atoi(wr.Request.FormValue("recipientType"))
+	return &message.Message{
+		Event:         wr.EventKey,
+		ShortName:     strings.TrimSpace(wr.Request.FormValue("name")),
+		RecipientType: message.RecipientType(recipientType),
+		Subject:       wr.Request.FormValue("subject"),
+		Text:          wr.Request.FormValue("text"),
+		HTML:          wr.Request.FormValue("html"),
+	}
+}
+
+// validateMessage checks that msg's name is usable and that its templates
+// parse.
+func validateMessage(wr WrappedRequest, msg *message.Message) error {
+	if !messageNamePattern.MatchString(msg.ShortName) {
+		return fmt.Errorf("the name may only contain letters, digits and underscores")
+	}
+	if strings.TrimSpace(msg.Text) == "" {
+		return fmt.Errorf("the text version can't be empty")
+	}
+	_, _, err := parseMailTemplates(wr.Event.ShortName, msg)
+	return err
+}
+
+// handleEditMessage shows the editor for the email template named by the
+// "name" parameter. A template that has only ever been a file starts out
+// as a copy of the file.
+func handleEditMessage(ctx context.Context, wr WrappedRequest) {
+	name := wr.Request.FormValue("name")
+	msg, err := message.GetMessage(ctx, wr.EventKey, name)
+	if err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Getting message: %v", err), http.StatusInternalServerError)
+		return
+	}
+	fromFile := false
+	if msg == nil {
+		msg = messageFromFiles(wr, name)
+		fromFile = msg != nil
+	}
+	if msg == nil {
+		msg = &message.Message{Event: wr.EventKey, ShortName: name, RecipientType: message.Invitees}
+	}
+	renderMessageEditor(ctx, wr, msg, fromFile, "")
+}
+
+func renderMessageEditor(ctx context.Context, wr WrappedRequest, msg *message.Message, fromFile bool, errorMessage string) {
+	tpl := template.Must(template.New("").ParseFiles("templates/main.html", "templates/editMessage.html"))
+	data := wr.MakeTemplateData(map[string]interface{}{
+		"Message":           msg,
+		"FromFile":          fromFile,
+		"Saved":             wr.Request.FormValue("saved") != "",
+		"Error":             errorMessage,
+		"AllRecipientTypes": message.AllRecipientTypes(),
+		"Invitations":       invitationOptions(ctx, wr),
+		"MyInvitation":      wr.LoginInfo.InvitationKey.Encode(),
+	})
+	if errorMessage != "" {
+		wr.ResponseWriter.WriteHeader(http.StatusBadRequest)
+	}
+	if err := tpl.ExecuteTemplate(wr.ResponseWriter, "editMessage.html", data); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+// handleSaveMessage saves the editor form, unless the templates don't
+// parse, in which case the editor is shown again with the error.
+func handleSaveMessage(ctx context.Context, wr WrappedRequest) {
+	if wr.Method != "POST" {
+		http.Error(wr.ResponseWriter, "Invalid GET on save message handler.", http.StatusBadRequest)
+		return
+	}
+	msg := messageFromForm(wr)
+	if err := validateMessage(wr, msg); err != nil {
+		renderMessageEditor(ctx, wr, msg, false, err.Error())
+		return
+	}
+	msg.LastUpdated = time.Now()
+	if wr.User != nil {
+		msg.LastUpdatedBy = wr.User.Email
+	}
+	if err := message.PutMessage(ctx, msg); err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Saving message: %v", err), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(wr.ResponseWriter, wr.Request, "editMessage?saved=1&name="+msg.ShortName, http.StatusSeeOther)
+}
+
+// handlePreviewMessage renders the editor form for the first invitee of
+// the chosen invitation, and returns the result (or the error) as JSON.
+func handlePreviewMessage(ctx context.Context, wr WrappedRequest) {
+	preview := struct {
+		Error   string
+		Subject string
+		Text    string
+		HTML    string
+	}{}
+	defer func() {
+		wr.ResponseWriter.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(wr.ResponseWriter).Encode(preview); err != nil {
+			log.Printf("Encoding preview: %v", err)
+		}
+	}()
+
+	msg := messageFromForm(wr)
+	if err := validateMessage(wr, msg); err != nil {
+		preview.Error = err.Error()
+		return
+	}
+	tpl, textTpl, err := parseMailTemplates(wr.Event.ShortName, msg)
+	if err != nil {
+		preview.Error = err.Error()
+		return
+	}
+
+	invitationKey, inv, p, err := previewRecipient(ctx, wr)
+	if err != nil {
+		preview.Error = err.Error()
+		return
+	}
+	data := makeMailData(ctx, wr, invitationKey, inv, p)
+	preview.Text, preview.HTML, preview.Subject, err = executeMail(tpl, textTpl, msg.ShortName, data, true)
+	if err != nil {
+		preview.Error = err.Error()
+	}
+}
+
+// previewRecipient returns the invitation chosen for a preview, and the
+// invitee on it to address the preview to.
+func previewRecipient(ctx context.Context, wr WrappedRequest) (*datastore.Key, *Invitation, *person.Person, error) {
+	client := dsclient.FromContext(ctx)
+	invitationKey := wr.LoginInfo.InvitationKey
+	if encoded := wr.Request.FormValue("invitation"); encoded != "" {
+		key, err := datastore.DecodeKey(encoded)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid invitation: %v", err)
+		}
+		invitationKey = key
+	}
+	var inv Invitation
+	if err := client.Get(ctx, invitationKey, &inv); err != nil {
+		return nil, nil, nil, fmt.Errorf("getting invitation: %v", err)
+	}
+	if len(inv.Invitees) == 0 {
+		return nil, nil, nil, fmt.Errorf("the invitation has nobody on it")
+	}
+	var p person.Person
+	if err := client.Get(ctx, inv.Invitees[0], &p); err != nil {
+		return nil, nil, nil, fmt.Errorf("getting invitee: %v", err)
+	}
+	p.DatastoreKey = inv.Invitees[0]
+	return invitationKey, &inv, &p, nil
+}
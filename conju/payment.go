@@ -0,0 +1,134 @@
+package conju
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+)
+
+// Payment is one payment received for an invitation, or a refund if Amount
+// is negative. Payments are stored as children of the invitation.
+type Payment struct {
+	Amount     float64 // US Dollars
+	Method     PaymentType
+	Date       time.Time
+	Note       string `datastore:",noindex"`
+	Reference  string // statement transaction this was imported from, if any
+	RecordedBy string // email of the admin who entered it
+	Recorded   time.Time
+}
+
+func (p Payment) IsRefund() bool {
+	return p.Amount < 0
+}
+
+// Magnitude is the amount paid or refunded.
+func (p Payment) Magnitude() float64 {
+	return math.Abs(p.Amount)
+}
+
+func (p Payment) DateStr() string {
+	return p.Date.Format("2006-01-02")
+}
+
+// LedgerEntry is a payment along with the balance still owed after it.
+type LedgerEntry struct {
+	Payment *Payment
+	Balance float64
+}
+
+// getPayments returns an invitation's payments, oldest first. An invitation
+// paid before payments were recorded individually gets a single entry built
+// from its ReceivedPay fields, with a nil key since it hasn't been saved.
+func getPayments(ctx context.Context, client dsclient.Client, invitationKey *datastore.Key, inv *Invitation) ([]*datastore.Key, []*Payment, error) {
+	var payments []*Payment
+	q := dsclient.NewQuery("Payment").Ancestor(invitationKey).Order("Date")
+	keys, err := client.GetAll(ctx, q, &payments)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fetching payments: %w", err)
+	}
+	if len(payments) == 0 && inv.ReceivedPay != 0 {
+		return []*datastore.Key{nil}, []*Payment{legacyPayment(inv)}, nil
+	}
+	return keys, payments, nil
+}
+
+func legacyPayment(inv *Invitation) *Payment {
+	payment := &Payment{
+		Amount: inv.ReceivedPay,
+		Method: OtherPayment,
+		Date:   inv.ReceivedPayDate,
+		Note:   "Recorded before payment history was kept",
+	}
+	for _, info := range GetAllPaymentTypes() {
+		if strings.EqualFold(strings.TrimSpace(inv.ReceivedPayMethod), info.Name) {
+			payment.Method = info.Type
+			return payment
+		}
+	}
+	if inv.ReceivedPayMethod != "" {
+		payment.Note += fmt.Sprintf(" (method: %s)", inv.ReceivedPayMethod)
+	}
+	return payment
+}
+
+// makeLedger pairs each payment with the balance remaining after it.
+func makeLedger(totalCost float64, payments []*Payment) []LedgerEntry {
+	var ledger []LedgerEntry
+	balance := totalCost
+	for _, payment := range payments {
+		balance -= payment.Amount
+		ledger = append(ledger, LedgerEntry{
+			Payment: payment,
+			Balance: math.Round(balance*100) / 100,
+		})
+	}
+	return ledger
+}
+
+// recordPayment saves a new payment for the invitation and updates the
+// invitation's ReceivedPay fields, which summarize its payments for the
+// pages that don't show the full ledger. The invitation is updated in a
+// transaction with the payments, so an RSVP saved at the same time isn't
+// lost.
+func recordPayment(ctx context.Context, invitationKey *datastore.Key, payment *Payment) error {
+	_, _, err := updateInvitation(ctx, invitationKey, func(tx dsclient.Client, inv *Invitation) error {
+		keys, payments, err := getPayments(ctx, tx, invitationKey, inv)
+		if err != nil {
+			return err
+		}
+		for i, key := range keys {
+			if key != nil {
+				continue
+			}
+			// Keep the legacy payment now that it's no longer the only record.
+			if _, err := tx.Put(ctx, datastore.IncompleteKey("Payment", invitationKey), payments[i]); err != nil {
+				return fmt.Errorf("saving earlier payment: %w", err)
+			}
+		}
+		if _, err := tx.Put(ctx, datastore.IncompleteKey("Payment", invitationKey), payment); err != nil {
+			return fmt.Errorf("saving payment: %w", err)
+		}
+		payments = append(payments, payment)
+
+		total := float64(0)
+		latest := payments[0]
+		for _, p := range payments {
+			total += p.Amount
+			if !p.Date.Before(latest.Date) {
+				latest = p
+			}
+		}
+		inv.ReceivedPay = math.Round(total*100) / 100
+		inv.ReceivedPayDate = latest.Date
+		inv.ReceivedPayMethod = latest.Method.String()
+		return nil
+	})
+	return err
+}
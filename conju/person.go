@@ -12,7 +12,6 @@ import (
 	"time"
 
 	"cloud.google.com/go/datastore"
-	"google.golang.org/appengine/v2"
 
 	"github.com/cshabsin/conju/conju/dsclient"
 	"github.com/cshabsin/conju/conju/login"
@@ -21,7 +20,7 @@ import (
 
 func handleListPeople(ctx context.Context, wr WrappedRequest) {
 	tic := time.Now()
-	q := datastore.NewQuery("Person").Order("LastName").Order("FirstName")
+	q := dsclient.NewQuery("Person").Order("LastName").Order("FirstName")
 
 	var allPeople []*person.Person
 	keys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &allPeople)
@@ -37,6 +36,11 @@ func handleListPeople(ctx context.Context, wr WrappedRequest) {
 		allPeople[i].DatastoreKey = keys[i]
 	}
 
+	statuses, err := getAddressStatuses(ctx)
+	if err != nil {
+		log.Printf("Fetching address statuses: %v", err)
+	}
+
 	wr.ResponseWriter.Header().Set("Content-Type", "text/html; charset=utf-8")
 
 	data := wr.MakeTemplateData(map[string]interface{}{
@@ -44,7 +48,10 @@ func handleListPeople(ctx context.Context, wr WrappedRequest) {
 	})
 
 	functionMap := template.FuncMap{
-		"makeLoginUrl": makeLoginUrl,
+		"makeLoginUrl": func(p *person.Person, absolute bool) string {
+			return makeLoginUrl(wr.Event, p, absolute)
+		},
+		"addressStatus": statuses.forPerson,
 	}
 	tpl := template.Must(template.New("").Funcs(functionMap).ParseFiles("templates/main.html", "templates/listPeople.html"))
 	if err := tpl.ExecuteTemplate(wr.ResponseWriter, "listPeople.html", data); err != nil {
@@ -52,9 +59,7 @@ func handleListPeople(ctx context.Context, wr WrappedRequest) {
 	}
 }
 
-func fetchPerson(wr WrappedRequest, encodedKey string) (*person.Person, error) {
-	ctx := appengine.NewContext(wr.Request)
-
+func fetchPerson(ctx context.Context, encodedKey string) (*person.Person, error) {
 	key, e := datastore.DecodeKey(encodedKey)
 	if e != nil {
 		log.Printf("%v", e)
@@ -84,7 +89,7 @@ func handleUpdatePersonForm(ctx context.Context, wr WrappedRequest) {
 
 	if queryMap["key"] != nil && queryMap["key"][0] != "" {
 		keyForUpdatePerson := queryMap["key"][0]
-		pers, err = fetchPerson(wr, keyForUpdatePerson)
+		pers, err = fetchPerson(ctx, keyForUpdatePerson)
 		if err != nil {
 			log.Printf("%v", err)
 			http.Redirect(wr.ResponseWriter, wr.Request, "listPeople", http.StatusSeeOther)
@@ -109,14 +114,12 @@ func handleUpdatePersonForm(ctx context.Context, wr WrappedRequest) {
 }
 
 func handleSaveUpdatePerson(ctx context.Context, wr WrappedRequest) {
-	savePeople(wr)
+	savePeople(ctx, wr)
 	// Where to go from here will depend on who's logged in and what they're doing
 	http.Redirect(wr.ResponseWriter, wr.Request, "listPeople", http.StatusSeeOther)
 }
 
-func savePeople(wr WrappedRequest) error {
-	ctx := appengine.NewContext(wr.Request)
-
+func savePeople(ctx context.Context, wr WrappedRequest) error {
 	wr.Request.ParseForm()
 	form := wr.Request.Form
 
@@ -127,11 +130,14 @@ func savePeople(wr WrappedRequest) error {
 		var err error
 
 		var key *datastore.Key
+		var old person.Person
 		if encodedKey != "" {
-			p, err = fetchPerson(wr, encodedKey)
+			p, err = fetchPerson(ctx, encodedKey)
 			if err != nil {
 				log.Printf("%v", err)
+				continue
 			}
+			old = *p
 			key, err = datastore.DecodeKey(encodedKey)
 			if err != nil {
 				log.Printf("%v", err)
@@ -189,10 +195,16 @@ func savePeople(wr WrappedRequest) error {
 		if len(form["PrivateComments"]) > i {
 			p.PrivateComments = form["PrivateComments"][i]
 		}
+		if len(form["MailPreference"]) > i {
+			pref, _ := strconv.Atoi(form["MailPreference"][i])
+			p.MailPreference = person.MailPreference(pref)
+		}
 
-		_, err = dsclient.FromContext(ctx).Put(ctx, key, p)
+		key, err = dsclient.FromContext(ctx).Put(ctx, key, p)
 		if err != nil {
 			log.Printf("------ %v", err)
+		} else if err := recordChange(ctx, wr, key, &old, p, nil, personAuditIgnore); err != nil {
+			log.Printf("%v", err)
 		}
 
 	}
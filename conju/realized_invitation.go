@@ -94,12 +94,12 @@ func makeRealizedInvitation(ctx context.Context, invitationKey *datastore.Key, i
 		log.Printf("GetEvent: %v", err)
 	}
 
-	allRsvpStatuses := invitation.GetAllRsvpStatuses()
 	realizedRsvpMap := make(map[string]invitation.RsvpStatusInfo)
 	thursday := false
 	for k, v := range inv.RsvpMap {
-		realizedRsvpMap[k.Encode()] = allRsvpStatuses[v]
-		if v == invitation.ThuFriSat {
+		info := event.RsvpStatusInfo(v)
+		realizedRsvpMap[k.Encode()] = info
+		if info.CoversNight(time.Thursday) {
 			thursday = true
 		}
 	}
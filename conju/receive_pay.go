@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"html/template"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/datastore"
@@ -23,6 +25,7 @@ func handleReceivePay(ctx context.Context, wr WrappedRequest) {
 		http.Error(wr.ResponseWriter,
 			fmt.Sprintf("Error decoding invitation key: %v", err),
 			http.StatusBadRequest)
+		return
 	}
 
 	var invitation Invitation
@@ -31,11 +34,25 @@ func handleReceivePay(ctx context.Context, wr WrappedRequest) {
 		log.Printf("error getting invitation: %v", err)
 	}
 
+	_, payments, err := getPayments(ctx, dsclient.FromContext(ctx), invitationKey, &invitation)
+	if err != nil {
+		log.Printf("%v", err)
+	}
+
 	realizedInvitation := makeRealizedInvitation(ctx, invitationKey, &invitation)
 	roomingInfo := getRoomingInfoWithInvitation(ctx, wr, &invitation, invitationKey)
+	totalCost := float64(0)
+	if roomingInfo != nil {
+		totalCost = roomingInfo.TotalCost
+	}
 	data := wr.MakeTemplateData(map[string]interface{}{
-		"Invitation":  realizedInvitation,
-		"RoomingInfo": roomingInfo,
+		"Invitation":   realizedInvitation,
+		"RoomingInfo":  roomingInfo,
+		"TotalCost":    totalCost,
+		"Ledger":       makeLedger(totalCost, payments),
+		"Balance":      math.Round((totalCost-invitation.ReceivedPay)*100) / 100,
+		"PaymentTypes": GetAllPaymentTypes(),
+		"Today":        time.Now().Format("2006-01-02"),
 	})
 
 	functionMap := template.FuncMap{
@@ -57,16 +74,25 @@ func handleDoReceivePay(ctx context.Context, wr WrappedRequest) {
 
 	payStr := wr.Request.Form.Get("pay")
 	pay, err := strconv.ParseFloat(payStr, 64)
-	if err != nil {
-		http.Error(wr.ResponseWriter, fmt.Sprintf("Error retrieving pay from form: %v", err), http.StatusBadRequest)
+	if err != nil || pay <= 0 {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Invalid amount %q: must be a positive number", payStr), http.StatusBadRequest)
 		return
 	}
+	if wr.Request.Form.Get("refund") == "on" {
+		pay = -pay
+	}
 	payDateStr := wr.Request.Form.Get("pay_date")
 	payDate, err := time.Parse("2006-01-02", payDateStr)
 	if err != nil {
 		http.Error(wr.ResponseWriter, fmt.Sprintf("Invalid date string from form: %v", err), http.StatusBadRequest)
 		return
 	}
+	methodStr := wr.Request.Form.Get("pay_method")
+	method, err := strconv.Atoi(methodStr)
+	if err != nil || method < 0 || method >= len(GetAllPaymentTypes()) {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Invalid payment method %q", methodStr), http.StatusBadRequest)
+		return
+	}
 
 	invitationKeyEncoded := wr.Request.Form.Get("invitation")
 	invitationKey, err := datastore.DecodeKey(invitationKeyEncoded)
@@ -74,19 +100,23 @@ func handleDoReceivePay(ctx context.Context, wr WrappedRequest) {
 		http.Error(wr.ResponseWriter,
 			fmt.Sprintf("Error decoding invitation key: %v", err),
 			http.StatusBadRequest)
+		return
 	}
 
-	var invitation Invitation
-	err = dsclient.FromContext(ctx).Get(ctx, invitationKey, &invitation)
-	if err != nil {
-		log.Printf("error getting invitation: %v", err)
+	payment := &Payment{
+		Amount:   pay,
+		Method:   PaymentType(method),
+		Date:     payDate,
+		Note:     strings.TrimSpace(wr.Request.Form.Get("note")),
+		Recorded: time.Now(),
 	}
-	invitation.ReceivedPay = float64(pay)
-	invitation.ReceivedPayDate = payDate
-	invitation.ReceivedPayMethod = wr.Request.Form.Get("pay_method")
-	_, err = dsclient.FromContext(ctx).Put(ctx, invitationKey, &invitation)
-	if err != nil {
-		log.Printf("error saving invitation: %v", err)
+	if wr.User != nil {
+		payment.RecordedBy = wr.User.Email
+	}
+	if err := recordPayment(ctx, invitationKey, payment); err != nil {
+		log.Printf("error recording payment: %v", err)
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Error recording payment: %v", err), http.StatusInternalServerError)
+		return
 	}
-	http.Redirect(wr.ResponseWriter, wr.Request, "invitations", http.StatusSeeOther)
+	http.Redirect(wr.ResponseWriter, wr.Request, "receivePay?invitation="+invitationKey.Encode(), http.StatusSeeOther)
 }
@@ -8,6 +8,8 @@ import (
 	"math"
 	"net/http"
 	"sort"
+	"strings"
+	"time"
 
 	"cloud.google.com/go/datastore"
 
@@ -29,7 +31,7 @@ func handleRsvpReport(ctx context.Context, wr WrappedRequest) {
 	currentEventKey := wr.EventKey
 
 	var invitations []*Invitation
-	q := datastore.NewQuery("Invitation").FilterField("Event", "=", currentEventKey)
+	q := dsclient.NewQuery("Invitation").FilterField("Event", "=", currentEventKey)
 	invitationKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
 	if err != nil {
 		log.Printf("fetching invitations: %v", err)
@@ -56,7 +58,7 @@ func handleRsvpReport(ctx context.Context, wr WrappedRequest) {
 	personToExtraInfoMap := make(map[int64]*ExtraInvitationInfo)
 
 	var bookings []Booking
-	q = datastore.NewQuery("Booking").Ancestor(wr.EventKey)
+	q = dsclient.NewQuery("Booking").Ancestor(wr.EventKey)
 	_, _ = dsclient.FromContext(ctx).GetAll(ctx, q, &bookings)
 
 	personToCost := make(map[int64]float64)
@@ -69,7 +71,12 @@ func handleRsvpReport(ctx context.Context, wr WrappedRequest) {
 		thursdayDinnerCount += inv.ThursdayDinnerCount
 		fridayDinnerCount += inv.FridayDinnerCount
 		fridayIceCreamCount += inv.FridayIceCreamCount
-		thursdayCount := len(rsvpMap[invitation.ThuFriSat])
+		thursdayCount := 0
+		for status, people := range rsvpMap {
+			if wr.Event.RsvpStatusInfo(status).CoversNight(time.Thursday) {
+				thursdayCount += len(people)
+			}
+		}
 		if inv.FridayLunch {
 			yesFridayLunch += thursdayCount
 		} else {
@@ -108,49 +115,34 @@ func handleRsvpReport(ctx context.Context, wr WrappedRequest) {
 	}
 
 	sort.Slice(allNoRsvp, func(a, b int) bool { return person.SortByLastFirstName(allNoRsvp[a][0], allNoRsvp[b][0]) })
-	statusOrder := []invitation.RsvpStatus{invitation.FriSatSun, invitation.FriSat, invitation.SatSun, invitation.Maybe, invitation.No}
-
-	for _, booking := range bookings {
+	statusOrder := wr.Event.RsvpStatusOrder()
 
-		FridaySaturday := 0
-		PlusThursday := 0
-		addThurs := make([]bool, len(booking.Roommates))
+	roomKeys := make([]*datastore.Key, len(bookings))
+	for i, booking := range bookings {
+		roomKeys[i] = booking.Room
+	}
+	rooms := make([]*housing.Room, len(roomKeys))
+	if err := dsclient.FromContext(ctx).GetMulti(ctx, roomKeys, rooms); err != nil {
+		log.Printf("fetching rooms: %v", err)
+	}
+	buildingsMap := getBuildingMapForVenue(ctx, wr.Event.VenueKey())
 
-		for i, person := range booking.Roommates {
-			rsvpStatus := personToRsvpStatus[person.ID]
-			if personIdToPerson[person.ID].IsBabyAtTime(wr.Event.StartDate) {
-				continue
-			}
-			if rsvpStatus == invitation.FriSat {
-				FridaySaturday++
-			}
-			if rsvpStatus == invitation.ThuFriSat {
-				FridaySaturday++
-				PlusThursday++
-				addThurs[i] = true
-			}
+	for i, booking := range bookings {
+		var roommates []*person.Person
+		var statuses []invitation.RsvpStatus
+		for _, roommate := range booking.Roommates {
+			p := personIdToPerson[roommate.ID]
+			roommates = append(roommates, &p)
+			statuses = append(statuses, personToRsvpStatus[roommate.ID])
 		}
-
-		for i, person := range booking.Roommates {
-			if personIdToPerson[person.ID].IsBabyAtTime(wr.Event.StartDate) {
-				personToCost[person.ID] = 0
-				continue
-			}
-			costForPerson := float64(0)
-			if FridaySaturday <= 5 {
-				costForPerson = invitation.GetAllRsvpStatuses()[invitation.FriSat].BaseCost[FridaySaturday]
-			}
-
-			if addThurs[i] && PlusThursday <= 5 {
-				costForPerson += invitation.GetAllRsvpStatuses()[invitation.ThuFriSat].AddOnCost[PlusThursday]
-			}
-			costForPerson = math.Floor(costForPerson*100) / 100
-			personToCost[person.ID] = costForPerson
+		quote := priceBooking(wr.Event, buildingsMap[booking.Room.Parent.ID], rooms[i], roommates, statuses)
+		for j, roommate := range booking.Roommates {
+			personToCost[roommate.ID] = quote.Costs[j]
 		}
 	}
 
 	for status, personLists := range allRsvpMap {
-		if !invitation.GetAllRsvpStatuses()[status].Attending {
+		if !wr.Event.RsvpStatusInfo(status).Attending {
 			continue
 		}
 		for _, personList := range personLists {
@@ -168,7 +160,7 @@ func handleRsvpReport(ctx context.Context, wr WrappedRequest) {
 		"RsvpMap":              allRsvpMap,
 		"NoRsvp":               allNoRsvp,
 		"StatusOrder":          statusOrder,
-		"AllRsvpStatuses":      invitation.GetAllRsvpStatuses(),
+		"AllRsvpStatuses":      wr.Event.RsvpStatusMap(),
 		"ThursdayDinnerCount":  thursdayDinnerCount,
 		"FridayLunchYes":       yesFridayLunch,
 		"FridayLunchNo":        noFridayLunch,
@@ -186,14 +178,12 @@ func handleActivitiesReport(ctx context.Context, wr WrappedRequest) {
 	currentEventKey := wr.EventKey
 
 	var invitations []*Invitation
-	q := datastore.NewQuery("Invitation").FilterField("Event", "=", currentEventKey)
+	q := dsclient.NewQuery("Invitation").FilterField("Event", "=", currentEventKey)
 	_, err := dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
 	if err != nil {
 		log.Printf("fetching invitations: %v", err)
 	}
 
-	allRsvpStatuses := invitation.GetAllRsvpStatuses()
-
 	activities, err := activity.Realize(ctx, wr.Event.Activities)
 	if err != nil {
 		log.Printf("fetching activities: %v", err)
@@ -231,7 +221,7 @@ func handleActivitiesReport(ctx context.Context, wr WrappedRequest) {
 
 		personKeySet := make(map[datastore.Key]bool)
 		for k, v := range invitation.RsvpMap {
-			if allRsvpStatuses[v].Attending {
+			if wr.Event.RsvpStatusInfo(v).Attending {
 				personKeySet[*k] = true
 				allPeopleToLookUp = append(allPeopleToLookUp, k)
 			}
@@ -295,7 +285,7 @@ func handleActivitiesReport(ctx context.Context, wr WrappedRequest) {
 
 func handleRoomingReport(ctx context.Context, wr WrappedRequest) {
 	var bookings []Booking
-	q := datastore.NewQuery("Booking").Ancestor(wr.EventKey)
+	q := dsclient.NewQuery("Booking").Ancestor(wr.EventKey)
 	bookingKeys, _ := dsclient.FromContext(ctx).GetAll(ctx, q, &bookings)
 
 	roomsMap := make(map[int64]housing.Room)
@@ -334,7 +324,7 @@ func handleRoomingReport(ctx context.Context, wr WrappedRequest) {
 	}
 
 	var invitations []*Invitation
-	q = datastore.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
+	q = dsclient.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
 	invitationKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
 	if err != nil {
 		log.Printf("fetching invitations: %v", err)
@@ -355,7 +345,7 @@ func handleRoomingReport(ctx context.Context, wr WrappedRequest) {
 		}
 	}
 
-	shareBedBit := GetAllHousingPreferenceBooleans()[ShareBed].Bit
+	shareBedBit := ShareBed.Bit()
 
 	type RealBooking struct {
 		KeyString           string
@@ -363,43 +353,37 @@ func handleRoomingReport(ctx context.Context, wr WrappedRequest) {
 		Building            *housing.Building
 		Roommates           []person.Person
 		ShowConvertToDouble bool
-		FriSat              int
-		PlusThurs           int
-		AddThurs            []bool
+		Statuses            []string // short RSVP description for each roommate
+		MixedStatuses       bool
 		Cost                float64
 		CostString          string
 		Reserved            bool
 	}
 
 	buildingsMap := getBuildingMapForVenue(ctx, wr.Event.VenueKey())
+	catalog := venueAttributes(ctx, wr.Event)
 	// doesn't deal with consolidating partitioned rooms
 	var realBookingsByBuilding = make([][]RealBooking, len(buildingOrderMap))
 	var totalCostForEveryone float64
 	for i, booking := range bookings {
 		people := make([]person.Person, len(booking.Roommates))
 
-		FridaySaturday := 0
-		PlusThursday := 0
-		addThurs := make([]bool, len(booking.Roommates))
+		roommates := make([]*person.Person, len(booking.Roommates))
+		statuses := make([]invitation.RsvpStatus, len(booking.Roommates))
+		statusNames := make([]string, len(booking.Roommates))
+		mixedStatuses := false
 		doubleBedNeeded := false // for now don't deal with more than one double needed
 
 		for i, person := range booking.Roommates {
 			people[i] = personMap[person.ID]
+			roommates[i] = &people[i]
 			inv := invitationMap[personToInvitationMap[person.ID]]
 			doubleBedNeeded = doubleBedNeeded || (inv.HousingPreferenceBooleans&shareBedBit == shareBedBit)
-			rsvpStatus := personToRsvpStatus[person.ID]
-			if people[i].IsBabyAtTime(wr.Event.StartDate) {
-				continue
+			statuses[i] = personToRsvpStatus[person.ID]
+			statusNames[i] = wr.Event.RsvpStatusInfo(statuses[i]).ShortDescription
+			if statuses[i] != statuses[0] {
+				mixedStatuses = true
 			}
-			if rsvpStatus == invitation.FriSat {
-				FridaySaturday++
-			}
-			if rsvpStatus == invitation.ThuFriSat {
-				FridaySaturday++
-				PlusThursday++
-				addThurs[i] = true
-			}
-
 		}
 
 		room := roomsMap[booking.Room.ID]
@@ -408,7 +392,7 @@ func handleRoomingReport(ctx context.Context, wr WrappedRequest) {
 		// for now? ignore case where they want a double bed and aren't getting it
 		showConvertToDouble := doubleBedNeeded
 
-		if doubleBedNeeded && (((building.Properties | room.Properties) & shareBedBit) == shareBedBit) {
+		if doubleBedNeeded && roomSatisfies(catalog, building, &room, ShareBed) {
 			for _, bed := range room.Beds {
 				if bed == housing.Double || bed == housing.Queen || bed == housing.King {
 					showConvertToDouble = false
@@ -417,44 +401,20 @@ func handleRoomingReport(ctx context.Context, wr WrappedRequest) {
 			}
 		}
 
-		basePPCost := float64(0)
-		basePPCostString := "???"
-		if FridaySaturday <= 5 {
-			basePPCost = invitation.GetAllRsvpStatuses()[invitation.FriSat].BaseCost[FridaySaturday]
-			basePPCostString = fmt.Sprintf("$%.2f", basePPCost)
-		}
-		addOnPPCost := float64(0)
-		addOnPPCostString := "???"
-		if PlusThursday <= 5 {
-			addOnPPCost = invitation.GetAllRsvpStatuses()[invitation.ThuFriSat].AddOnCost[PlusThursday]
-			addOnPPCostString = fmt.Sprintf("$%.2f", addOnPPCost)
-		}
-
-		addOnTotalString := ""
-		if PlusThursday > 0 {
-			addOnTotalString = fmt.Sprintf(" + %d * %s", PlusThursday, addOnPPCostString)
-		}
-
-		totalCost := float64(FridaySaturday)*basePPCost + float64(PlusThursday)*addOnPPCost
-		totalCostForEveryone += totalCost
-		totalCostString := fmt.Sprintf("$%.2f", totalCost)
-		if FridaySaturday > 5 || PlusThursday > 5 {
-			totalCostString = "???"
-		}
-
-		costEquationString := fmt.Sprintf("%d * %s %s = %s", FridaySaturday, basePPCostString, addOnTotalString, totalCostString)
+		quote := priceBooking(wr.Event, building, &room, roommates, statuses)
+		totalCostForEveryone += quote.Total
+		costString := fmt.Sprintf("%s = $%.2f", strings.Join(quote.Lines, " + "), quote.Total)
 
 		realBooking := RealBooking{
 			KeyString:           bookingKeys[i].Encode(),
 			Room:                roomsMap[booking.Room.ID],
 			Building:            building,
 			Roommates:           people,
-			FriSat:              FridaySaturday,
-			PlusThurs:           PlusThursday,
-			AddThurs:            addThurs,
+			Statuses:            statusNames,
+			MixedStatuses:       mixedStatuses,
 			ShowConvertToDouble: showConvertToDouble,
-			Cost:                totalCost,
-			CostString:          costEquationString,
+			Cost:                quote.Total,
+			CostString:          costString,
 			Reserved:            booking.Reserved,
 		}
 		buildingIndex := buildingOrderMap[buildingId]
@@ -473,10 +433,16 @@ func handleRoomingReport(ctx context.Context, wr WrappedRequest) {
 			})
 	}
 
-	tpl := template.Must(template.New("").ParseFiles("templates/main.html", "templates/roomingReport.html"))
+	violations, err := checkRooming(ctx, wr, bookings)
+	if err != nil {
+		log.Printf("checking rooming: %v", err)
+	}
+
+	tpl := template.Must(template.New("").ParseFiles("templates/main.html", "templates/roomingReport.html", "templates/roomingViolations.html"))
 	data := wr.MakeTemplateData(map[string]interface{}{
 		"BookingsByBuilding":   realBookingsByBuilding,
 		"TotalCostForEveryone": totalCostForEveryone,
+		"Violations":           violations,
 	})
 	if err := tpl.ExecuteTemplate(wr.ResponseWriter, "roomingReport.html", data); err != nil {
 		log.Printf("%v", err)
@@ -493,33 +459,63 @@ func handleSaveReservations(ctx context.Context, wr WrappedRequest) {
 		if v[0] == "" {
 			continue
 		}
-		if k[0:8] == "booking_" {
+		if strings.HasPrefix(k, "booking_") {
 			bookingKey, _ := datastore.DecodeKey(string(k[8:]))
 			bookingToBooked[bookingKey.ID] = true
 		}
 	}
 
-	var bookings []*Booking
-	q := datastore.NewQuery("Booking").Ancestor(wr.EventKey)
-	bookingKeys, _ := dsclient.FromContext(ctx).GetAll(ctx, q, &bookings)
+	changedBy := ""
+	if wr.User != nil {
+		changedBy = wr.User.Email
+	}
+	err := dsclient.FromContext(ctx).RunInTransaction(ctx, func(tx dsclient.Client) error {
+		var bookings []*Booking
+		q := dsclient.NewQuery("Booking").Ancestor(wr.EventKey)
+		bookingKeys, err := tx.GetAll(ctx, q, &bookings)
+		if err != nil {
+			return fmt.Errorf("fetching bookings: %w", err)
+		}
 
-	for i, booking := range bookings {
-		if _, present := bookingToBooked[bookingKeys[i].ID]; present {
-			booking.Reserved = true
-		} else {
-			booking.Reserved = false
+		var changedKeys []*datastore.Key
+		var changed []*Booking
+		var changes []*BookingChange
+		for i, booking := range bookings {
+			reserved := bookingToBooked[bookingKeys[i].ID]
+			if booking.Reserved == reserved {
+				continue
+			}
+			changes = append(changes, &BookingChange{
+				Room:           booking.Room,
+				Before:         booking.Roommates,
+				After:          booking.Roommates,
+				ReservedBefore: booking.Reserved,
+				ReservedAfter:  reserved,
+			})
+			booking.Reserved = reserved
+			changedKeys = append(changedKeys, bookingKeys[i])
+			changed = append(changed, booking)
 		}
+		if len(changed) == 0 {
+			return nil
+		}
+		if _, err := tx.PutMulti(ctx, changedKeys, changed); err != nil {
+			return fmt.Errorf("saving bookings: %w", err)
+		}
+		return putBookingChanges(ctx, tx, wr.EventKey, changes, time.Now(), changedBy)
+	})
+	if err != nil {
+		log.Printf("saving reservations: %v", err)
+		http.Error(wr.ResponseWriter, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	dsclient.FromContext(ctx).PutMulti(ctx, bookingKeys, bookings)
-
 	http.Redirect(wr.ResponseWriter, wr.Request, "admin", http.StatusSeeOther)
 }
 
 func handleFoodReport(ctx context.Context, wr WrappedRequest) {
 	currentEventKey := wr.EventKey
 
-	allRsvpStatuses := invitation.GetAllRsvpStatuses()
 	totalRestrictions := len(person.GetAllFoodRestrictionTags())
 
 	counts := make([]int, totalRestrictions)
@@ -527,7 +523,7 @@ func handleFoodReport(ctx context.Context, wr WrappedRequest) {
 	var people []person.Person
 
 	var invitations []*Invitation
-	q := datastore.NewQuery("Invitation").FilterField("Event", "=", currentEventKey)
+	q := dsclient.NewQuery("Invitation").FilterField("Event", "=", currentEventKey)
 	_, err := dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
 	if err != nil {
 		log.Printf("fetching invitations: %v", err)
@@ -537,10 +533,11 @@ func handleFoodReport(ctx context.Context, wr WrappedRequest) {
 
 		for p, s := range inv.RsvpMap {
 
-			status := allRsvpStatuses[s]
+			status := wr.Event.RsvpStatusInfo(s)
 			if status.Attending {
 				var per person.Person
 				dsclient.FromContext(ctx).Get(ctx, p, &per)
+				per.DatastoreKey = p
 				people = append(people, per)
 				restrictionsForPerson := make([]bool, totalRestrictions)
 				for _, restriction := range per.FoodRestrictions {
@@ -571,8 +568,6 @@ func handleFoodReport(ctx context.Context, wr WrappedRequest) {
 func handleRidesReport(ctx context.Context, wr WrappedRequest) {
 	currentEventKey := wr.EventKey
 
-	allRsvpStatuses := invitation.GetAllRsvpStatuses()
-
 	type CarRequest struct {
 		People               []*person.Person
 		Preference           DrivingPreference
@@ -592,7 +587,7 @@ func handleRidesReport(ctx context.Context, wr WrappedRequest) {
 	var FridayIndependent []CarRequest
 
 	var invitations []*Invitation
-	q := datastore.NewQuery("Invitation").FilterField("Event", "=", currentEventKey)
+	q := dsclient.NewQuery("Invitation").FilterField("Event", "=", currentEventKey)
 	_, err := dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
 	if err != nil {
 		log.Printf("fetching invitations: %v", err)
@@ -603,12 +598,12 @@ func handleRidesReport(ctx context.Context, wr WrappedRequest) {
 		var personKeys []*datastore.Key
 		thursday := false
 		for p, s := range inv.RsvpMap {
-			status := allRsvpStatuses[s]
+			status := wr.Event.RsvpStatusInfo(s)
 			if status.Attending {
 				personKeys = append(personKeys, p)
 			}
 			// TODO: split rides by person
-			if status.Status == invitation.ThuFriSat {
+			if status.CoversNight(time.Thursday) {
 				thursday = true
 			}
 		}
@@ -0,0 +1,36 @@
+package conju
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReports(t *testing.T) {
+	s := newTestSite(t)
+	for _, tc := range []struct {
+		path    string
+		handler func(context.Context, WrappedRequest)
+		want    []string
+	}{
+		{"/rsvpReport", handleRsvpReport, []string{"Avery Quinn", "Drew Ellis", "Robin Park"}},
+		{"/activitiesReport", handleActivitiesReport, []string{"Activity Preferences"}},
+		{"/roomingReport", handleRoomingReport, []string{"Lodge", "Avery Quinn", "Taylor Brooks"}},
+		{"/foodReport", handleFoodReport, []string{"Sam Lee", "Taylor Brooks"}},
+		{"/ridesReport", handleRidesReport, []string{"<h2>Friday</h2>"}},
+	} {
+		wr, rec := s.request(t, "single_adult", true, "GET", tc.path, nil)
+		tc.handler(s.ctx, wr)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d\n%s", tc.path, rec.Code, rec.Body)
+			continue
+		}
+		body := rec.Body.String()
+		for _, want := range tc.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s doesn't mention %q", tc.path, want)
+			}
+		}
+	}
+}
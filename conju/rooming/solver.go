@@ -0,0 +1,291 @@
+// Package rooming proposes room assignments. It works on plain
+// descriptions of rooms and parties so that it can be tested without the
+// datastore; the conju package translates bookings and invitations.
+package rooming
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// Sharing says whether a party may share a room with another party.
+type Sharing int
+
+const (
+	ShareWithAnyone Sharing = iota
+	// ShareWithKnown parties only want roommates they know. The solver
+	// can't tell who that is, so it avoids sharing with them when it can.
+	ShareWithKnown
+	ShareNever
+)
+
+type Room struct {
+	ID         string
+	Capacity   int // people who need a bed
+	Properties int // housing preference bits of the room and its building
+}
+
+// Party is a group that must stay together, normally the people on one
+// invitation who need lodging.
+type Party struct {
+	ID         string
+	Size       int // people who need a bed
+	Properties int // combined housing preference bits; see CombineProperties
+	Sharing    Sharing
+}
+
+// Problem is a set of rooms to fill. Preference bits in DesiredMask are
+// ones a party wants its room to have; bits in AcceptableMask are room
+// features a party has to have said it's willing to put up with. Other
+// bits are ignored.
+type Problem struct {
+	Rooms          []Room
+	Parties        []Party
+	DesiredMask    int
+	AcceptableMask int
+}
+
+// Assignment is a proposed solution.
+type Assignment struct {
+	Rooms    map[string][]string // room ID to the IDs of the parties in it
+	Unplaced []string            // parties that didn't fit anywhere
+	Score    int
+}
+
+// Scoring weights. Capacity and ShareNever are hard constraints; everything
+// else trades off through the score.
+const (
+	desiredMetScore      = 2
+	desiredMissedScore   = -3
+	acceptableBrokeScore = -20
+	shareKnownScore      = -4
+	shareAnyoneScore     = -1
+	unplacedScore        = -100
+
+	maxPasses = 500
+)
+
+// CombineProperties merges the preference bits of people staying together:
+// anything one of them desires is desired, and a feature is only
+// acceptable if all of them accept it.
+func CombineProperties(a, b, desiredMask, acceptableMask int) int {
+	return ((a | b) & desiredMask) | (a & b & acceptableMask)
+}
+
+// RoomScore scores a room holding the given parties, and reports whether
+// the arrangement is allowed at all.
+func (p Problem) RoomScore(room Room, parties []Party) (int, bool) {
+	size := 0
+	for _, party := range parties {
+		size += party.Size
+	}
+	if size > room.Capacity {
+		return 0, false
+	}
+	score := 0
+	for _, party := range parties {
+		if party.Sharing == ShareNever && len(parties) > 1 {
+			return 0, false
+		}
+		desired := party.Properties & p.DesiredMask
+		score += desiredMetScore * bits.OnesCount(uint(desired&room.Properties))
+		score += desiredMissedScore * bits.OnesCount(uint(desired&^room.Properties))
+		unaccepted := room.Properties & p.AcceptableMask &^ party.Properties
+		score += acceptableBrokeScore * bits.OnesCount(uint(unaccepted))
+		switch party.Sharing {
+		case ShareWithKnown:
+			score += shareKnownScore * (len(parties) - 1)
+		case ShareWithAnyone:
+			score += shareAnyoneScore * (len(parties) - 1)
+		}
+	}
+	return score, true
+}
+
+// solver holds the working state: which room each party is in (-1 for
+// none) and who is in each room.
+type solver struct {
+	Problem
+	roomOf    []int
+	occupants [][]int
+}
+
+func (s *solver) roomScore(r int, occupants []int) (int, bool) {
+	parties := make([]Party, len(occupants))
+	for i, o := range occupants {
+		parties[i] = s.Parties[o]
+	}
+	return s.RoomScore(s.Rooms[r], parties)
+}
+
+func (s *solver) partyUnplacedScore(i int) int {
+	size := s.Parties[i].Size
+	if size < 1 {
+		size = 1
+	}
+	return unplacedScore * size
+}
+
+func without(occupants []int, party int) []int {
+	var out []int
+	for _, o := range occupants {
+		if o != party {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+func with(occupants []int, party int) []int {
+	return append(append([]int(nil), occupants...), party)
+}
+
+// moveDelta is the change in score from moving party i to room r (-1 for
+// unplaced), and whether the move is allowed.
+func (s *solver) moveDelta(i, r int) (int, bool) {
+	from := s.roomOf[i]
+	if from == r {
+		return 0, false
+	}
+	delta := 0
+	if from < 0 {
+		delta -= s.partyUnplacedScore(i)
+	} else {
+		before, _ := s.roomScore(from, s.occupants[from])
+		after, _ := s.roomScore(from, without(s.occupants[from], i))
+		delta += after - before
+	}
+	if r < 0 {
+		delta += s.partyUnplacedScore(i)
+	} else {
+		before, _ := s.roomScore(r, s.occupants[r])
+		after, ok := s.roomScore(r, with(s.occupants[r], i))
+		if !ok {
+			return 0, false
+		}
+		delta += after - before
+	}
+	return delta, true
+}
+
+func (s *solver) move(i, r int) {
+	if from := s.roomOf[i]; from >= 0 {
+		s.occupants[from] = without(s.occupants[from], i)
+	}
+	if r >= 0 {
+		s.occupants[r] = with(s.occupants[r], i)
+	}
+	s.roomOf[i] = r
+}
+
+// swapDelta is the change in score from exchanging the rooms of parties
+// i and j, which must both be placed in different rooms.
+func (s *solver) swapDelta(i, j int) (int, bool) {
+	ri, rj := s.roomOf[i], s.roomOf[j]
+	beforeI, _ := s.roomScore(ri, s.occupants[ri])
+	beforeJ, _ := s.roomScore(rj, s.occupants[rj])
+	afterI, ok := s.roomScore(ri, with(without(s.occupants[ri], i), j))
+	if !ok {
+		return 0, false
+	}
+	afterJ, ok := s.roomScore(rj, with(without(s.occupants[rj], j), i))
+	if !ok {
+		return 0, false
+	}
+	return afterI + afterJ - beforeI - beforeJ, true
+}
+
+func (s *solver) total() int {
+	score := 0
+	for i, r := range s.roomOf {
+		if r < 0 {
+			score += s.partyUnplacedScore(i)
+		}
+	}
+	for r, occupants := range s.occupants {
+		roomScore, _ := s.roomScore(r, occupants)
+		score += roomScore
+	}
+	return score
+}
+
+// improve makes the single best move or swap, if any improves the score.
+func (s *solver) improve() bool {
+	bestDelta, bestI, bestJ, bestRoom := 0, -1, -1, -1
+	for i := range s.Parties {
+		for r := -1; r < len(s.Rooms); r++ {
+			if delta, ok := s.moveDelta(i, r); ok && delta > bestDelta {
+				bestDelta, bestI, bestJ, bestRoom = delta, i, -1, r
+			}
+		}
+	}
+	for i := range s.Parties {
+		for j := i + 1; j < len(s.Parties); j++ {
+			if s.roomOf[i] < 0 || s.roomOf[j] < 0 || s.roomOf[i] == s.roomOf[j] {
+				continue
+			}
+			if delta, ok := s.swapDelta(i, j); ok && delta > bestDelta {
+				bestDelta, bestI, bestJ = delta, i, j
+			}
+		}
+	}
+	if bestI < 0 {
+		return false
+	}
+	if bestJ < 0 {
+		s.move(bestI, bestRoom)
+		return true
+	}
+	ri, rj := s.roomOf[bestI], s.roomOf[bestJ]
+	s.move(bestI, -1)
+	s.move(bestJ, ri)
+	s.move(bestI, rj)
+	return true
+}
+
+// Solve assigns parties to rooms. It places the largest parties first,
+// each in the room where it scores best, then moves and swaps parties
+// while that improves the total score. The result is deterministic for a
+// given problem.
+func Solve(p Problem) Assignment {
+	s := &solver{
+		Problem:   p,
+		roomOf:    make([]int, len(p.Parties)),
+		occupants: make([][]int, len(p.Rooms)),
+	}
+	order := make([]int, len(p.Parties))
+	for i := range order {
+		order[i] = i
+		s.roomOf[i] = -1
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return p.Parties[order[a]].Size > p.Parties[order[b]].Size
+	})
+
+	for _, i := range order {
+		bestDelta, bestRoom := 0, -1
+		for r := range p.Rooms {
+			if delta, ok := s.moveDelta(i, r); ok && (bestRoom < 0 || delta > bestDelta) {
+				bestDelta, bestRoom = delta, r
+			}
+		}
+		if bestRoom >= 0 {
+			s.move(i, bestRoom)
+		}
+	}
+	for pass := 0; pass < maxPasses && s.improve(); pass++ {
+	}
+
+	assignment := Assignment{Rooms: make(map[string][]string), Score: s.total()}
+	for r, occupants := range s.occupants {
+		for _, o := range occupants {
+			assignment.Rooms[p.Rooms[r].ID] = append(assignment.Rooms[p.Rooms[r].ID], p.Parties[o].ID)
+		}
+	}
+	for _, i := range order {
+		if s.roomOf[i] < 0 {
+			assignment.Unplaced = append(assignment.Unplaced, p.Parties[i].ID)
+		}
+	}
+	return assignment
+}
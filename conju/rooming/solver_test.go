@@ -0,0 +1,136 @@
+package rooming
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Preference bits used by the tests, in the style of the real catalog.
+const (
+	shareBed  = 2  // desired
+	closeBy   = 32 // acceptable
+	expensive = 128
+)
+
+func newProblem(rooms []Room, parties []Party) Problem {
+	return Problem{
+		Rooms:          rooms,
+		Parties:        parties,
+		DesiredMask:    shareBed,
+		AcceptableMask: closeBy | expensive,
+	}
+}
+
+func TestCombineProperties(t *testing.T) {
+	desired, acceptable := shareBed, closeBy|expensive
+	got := CombineProperties(shareBed|closeBy, closeBy|expensive, desired, acceptable)
+	if want := shareBed | closeBy; got != want {
+		t.Errorf("CombineProperties = %d, want %d", got, want)
+	}
+}
+
+func TestSolve(t *testing.T) {
+	type TestCase struct {
+		Name         string
+		Problem      Problem
+		Want         map[string][]string
+		WantUnplaced []string
+	}
+	testcases := []TestCase{
+		{
+			Name: "Capacity",
+			Problem: newProblem(
+				[]Room{{ID: "small", Capacity: 2}, {ID: "big", Capacity: 4}},
+				[]Party{{ID: "pair", Size: 2}, {ID: "family", Size: 4}},
+			),
+			Want: map[string][]string{"small": {"pair"}, "big": {"family"}},
+		},
+		{
+			Name: "Too big for any room",
+			Problem: newProblem(
+				[]Room{{ID: "room", Capacity: 2}},
+				[]Party{{ID: "crowd", Size: 3}, {ID: "solo", Size: 1}},
+			),
+			Want:         map[string][]string{"room": {"solo"}},
+			WantUnplaced: []string{"crowd"},
+		},
+		{
+			Name: "Never share",
+			Problem: newProblem(
+				[]Room{{ID: "room", Capacity: 4}},
+				[]Party{{ID: "hermit", Size: 1, Sharing: ShareNever}, {ID: "other", Size: 1}},
+			),
+			Want:         map[string][]string{"room": {"hermit"}},
+			WantUnplaced: []string{"other"},
+		},
+		{
+			Name: "Shares when out of rooms",
+			Problem: newProblem(
+				[]Room{{ID: "room", Capacity: 2}},
+				[]Party{{ID: "a", Size: 1}, {ID: "b", Size: 1}},
+			),
+			Want: map[string][]string{"room": {"a", "b"}},
+		},
+		{
+			Name: "Avoids sharing when rooms are free",
+			Problem: newProblem(
+				[]Room{{ID: "one", Capacity: 2}, {ID: "two", Capacity: 2}},
+				[]Party{{ID: "a", Size: 1}, {ID: "b", Size: 1, Sharing: ShareWithKnown}},
+			),
+			Want: map[string][]string{"one": {"a"}, "two": {"b"}},
+		},
+		{
+			Name: "Desired feature",
+			Problem: newProblem(
+				[]Room{{ID: "twins", Capacity: 2}, {ID: "queen", Capacity: 2, Properties: shareBed}},
+				[]Party{{ID: "couple", Size: 2, Properties: shareBed}},
+			),
+			Want: map[string][]string{"queen": {"couple"}},
+		},
+		{
+			Name: "Unaccepted feature",
+			Problem: newProblem(
+				[]Room{{ID: "fancy", Capacity: 2, Properties: expensive}, {ID: "plain", Capacity: 2}},
+				[]Party{{ID: "thrifty", Size: 2}, {ID: "splurge", Size: 2, Properties: expensive}},
+			),
+			Want: map[string][]string{"plain": {"thrifty"}, "fancy": {"splurge"}},
+		},
+		{
+			Name: "Swap improves on greedy placement",
+			Problem: newProblem(
+				[]Room{{ID: "queen", Capacity: 2, Properties: shareBed}, {ID: "twins", Capacity: 2}},
+				[]Party{{ID: "friends", Size: 2}, {ID: "couple", Size: 2, Properties: shareBed}},
+			),
+			Want: map[string][]string{"queen": {"couple"}, "twins": {"friends"}},
+		},
+	}
+	for _, tc := range testcases {
+		got := Solve(tc.Problem)
+		for _, ids := range got.Rooms {
+			sort.Strings(ids)
+		}
+		if !reflect.DeepEqual(got.Rooms, tc.Want) {
+			t.Errorf("%s: rooms were %v, want %v", tc.Name, got.Rooms, tc.Want)
+		}
+		if !reflect.DeepEqual(got.Unplaced, tc.WantUnplaced) {
+			t.Errorf("%s: unplaced were %v, want %v", tc.Name, got.Unplaced, tc.WantUnplaced)
+		}
+	}
+}
+
+func TestSolveIsDeterministic(t *testing.T) {
+	var rooms []Room
+	var parties []Party
+	for i := 0; i < 10; i++ {
+		rooms = append(rooms, Room{ID: string(rune('A' + i)), Capacity: 2 + i%3, Properties: (i % 2) * shareBed})
+		parties = append(parties, Party{ID: string(rune('a' + i)), Size: 1 + i%3, Properties: ((i + 1) % 2) * shareBed})
+	}
+	problem := newProblem(rooms, parties)
+	first := Solve(problem)
+	for i := 0; i < 5; i++ {
+		if got := Solve(problem); !reflect.DeepEqual(got, first) {
+			t.Fatalf("Solve gave %v, then %v", first, got)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package rooming
+
+import "sort"
+
+// ViolationKind is a way a set of bookings can be wrong.
+type ViolationKind int
+
+const (
+	OverCapacity ViolationKind = iota
+	UnwantedRoommates
+	UnacceptedFeature
+	NoSharedBed
+	NotAttending
+	DoubleBooked
+	Unbooked
+)
+
+func (k ViolationKind) String() string {
+	switch k {
+	case OverCapacity:
+		return "Over capacity"
+	case UnwantedRoommates:
+		return "Sharing despite asking for no roommates"
+	case UnacceptedFeature:
+		return "Room has features not accepted"
+	case NoSharedBed:
+		return "Wants to share a bed, but room has no double, queen, or king"
+	case NotAttending:
+		return "Booked but not attending"
+	case DoubleBooked:
+		return "Booked in more than one room"
+	case Unbooked:
+		return "Attending but not booked"
+	}
+	return "Unknown problem"
+}
+
+// Guest is one person to check.
+type Guest struct {
+	ID         string
+	Name       string
+	Party      string // guests in the same party share an invitation
+	NeedsBed   bool
+	Attending  bool // attending and needing lodging
+	Properties int  // housing preference bits, as for Party
+	Sharing    Sharing
+}
+
+// BookedRoom is a room with the guests booked into it.
+type BookedRoom struct {
+	Name      string // shown in violations
+	Room      Room
+	SharedBed bool // has a bed that sleeps two
+	Guests    []Guest
+}
+
+// Violation is a problem found by Validate.
+type Violation struct {
+	Kind   ViolationKind
+	Room   string   // empty for Unbooked
+	Guests []string // names of the guests involved
+	Bits   int      // for UnacceptedFeature, the features not accepted
+}
+
+// Validate checks bookings against room capacity and the guests'
+// preferences. attendees lists everyone who needs a room, booked or not.
+// Violations come back grouped by kind, in booking order within a kind.
+func (p Problem) Validate(bookings []BookedRoom, attendees []Guest, shareBedBit int) []Violation {
+	var violations []Violation
+	booked := make(map[string]int)
+	for _, booking := range bookings {
+		size := 0
+		var parties []string
+		partyGuests := make(map[string][]Guest)
+		for _, guest := range booking.Guests {
+			if guest.NeedsBed {
+				size++
+			}
+			if _, ok := partyGuests[guest.Party]; !ok {
+				parties = append(parties, guest.Party)
+			}
+			partyGuests[guest.Party] = append(partyGuests[guest.Party], guest)
+			booked[guest.ID]++
+			if booked[guest.ID] == 2 {
+				violations = append(violations, Violation{Kind: DoubleBooked, Room: booking.Name, Guests: []string{guest.Name}})
+			}
+			if !guest.Attending {
+				violations = append(violations, Violation{Kind: NotAttending, Room: booking.Name, Guests: []string{guest.Name}})
+			}
+		}
+		if size > booking.Room.Capacity {
+			violations = append(violations, Violation{Kind: OverCapacity, Room: booking.Name, Guests: guestNames(booking.Guests)})
+		}
+
+		for _, party := range parties {
+			guests := partyGuests[party]
+			properties := guests[0].Properties
+			for _, guest := range guests[1:] {
+				properties = CombineProperties(properties, guest.Properties, p.DesiredMask, p.AcceptableMask)
+			}
+			if len(parties) > 1 {
+				for _, guest := range guests {
+					if guest.Sharing == ShareNever {
+						violations = append(violations, Violation{Kind: UnwantedRoommates, Room: booking.Name, Guests: guestNames(guests)})
+						break
+					}
+				}
+			}
+			if unaccepted := booking.Room.Properties & p.AcceptableMask &^ properties; unaccepted != 0 {
+				violations = append(violations, Violation{Kind: UnacceptedFeature, Room: booking.Name, Guests: guestNames(guests), Bits: unaccepted})
+			}
+			if properties&shareBedBit != 0 && !booking.SharedBed {
+				violations = append(violations, Violation{Kind: NoSharedBed, Room: booking.Name, Guests: guestNames(guests)})
+			}
+		}
+	}
+
+	for _, guest := range attendees {
+		if booked[guest.ID] == 0 {
+			violations = append(violations, Violation{Kind: Unbooked, Guests: []string{guest.Name}})
+		}
+	}
+	sort.SliceStable(violations, func(a, b int) bool { return violations[a].Kind < violations[b].Kind })
+	return violations
+}
+
+func guestNames(guests []Guest) []string {
+	names := make([]string, len(guests))
+	for i, guest := range guests {
+		names[i] = guest.Name
+	}
+	return names
+}
@@ -0,0 +1,85 @@
+package rooming
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	alice := Guest{ID: "alice", Name: "Alice", Party: "smiths", NeedsBed: true, Attending: true}
+	bob := Guest{ID: "bob", Name: "Bob", Party: "smiths", NeedsBed: true, Attending: true}
+	baby := Guest{ID: "baby", Name: "Baby", Party: "smiths", Attending: true}
+	carol := Guest{ID: "carol", Name: "Carol", Party: "jones", NeedsBed: true, Attending: true}
+	double := Room{ID: "double", Capacity: 2}
+
+	type TestCase struct {
+		Name      string
+		Bookings  []BookedRoom
+		Attendees []Guest
+		Want      []Violation
+	}
+	testcases := []TestCase{
+		{
+			Name:      "No problems",
+			Bookings:  []BookedRoom{{Name: "A1", Room: double, Guests: []Guest{alice, bob, baby}}},
+			Attendees: []Guest{alice, bob, baby},
+		},
+		{
+			Name:      "Over capacity",
+			Bookings:  []BookedRoom{{Name: "A1", Room: double, Guests: []Guest{alice, bob, carol}}},
+			Attendees: []Guest{alice, bob, carol},
+			Want:      []Violation{{Kind: OverCapacity, Room: "A1", Guests: []string{"Alice", "Bob", "Carol"}}},
+		},
+		{
+			Name: "No roommates",
+			Bookings: []BookedRoom{{Name: "A1", Room: double, Guests: []Guest{
+				alice,
+				{ID: "carol", Name: "Carol", Party: "jones", NeedsBed: true, Attending: true, Sharing: ShareNever},
+			}}},
+			Want: []Violation{{Kind: UnwantedRoommates, Room: "A1", Guests: []string{"Carol"}}},
+		},
+		{
+			Name: "No roommates alone",
+			Bookings: []BookedRoom{{Name: "A1", Room: double, Guests: []Guest{
+				{ID: "carol", Name: "Carol", Party: "jones", NeedsBed: true, Attending: true, Sharing: ShareNever},
+			}}},
+		},
+		{
+			Name: "Feature accepted by only one of a party",
+			Bookings: []BookedRoom{{
+				Name:   "B1",
+				Room:   Room{ID: "far", Capacity: 2, Properties: closeBy | expensive},
+				Guests: []Guest{{ID: "alice", Name: "Alice", Party: "smiths", Attending: true, Properties: closeBy | expensive}, {ID: "bob", Name: "Bob", Party: "smiths", Attending: true, Properties: closeBy}},
+			}},
+			Want: []Violation{{Kind: UnacceptedFeature, Room: "B1", Guests: []string{"Alice", "Bob"}, Bits: expensive}},
+		},
+		{
+			Name: "Share bed",
+			Bookings: []BookedRoom{
+				{Name: "A1", Room: double, Guests: []Guest{{ID: "alice", Name: "Alice", Party: "smiths", Attending: true, Properties: shareBed}}},
+				{Name: "A2", Room: double, SharedBed: true, Guests: []Guest{{ID: "carol", Name: "Carol", Party: "jones", Attending: true, Properties: shareBed}}},
+			},
+			Want: []Violation{{Kind: NoSharedBed, Room: "A1", Guests: []string{"Alice"}}},
+		},
+		{
+			Name: "Attendance",
+			Bookings: []BookedRoom{
+				{Name: "A1", Room: double, Guests: []Guest{alice, {ID: "dan", Name: "Dan", Party: "dans", NeedsBed: true}}},
+				{Name: "A2", Room: double, Guests: []Guest{alice}},
+			},
+			Attendees: []Guest{alice, bob},
+			Want: []Violation{
+				{Kind: NotAttending, Room: "A1", Guests: []string{"Dan"}},
+				{Kind: DoubleBooked, Room: "A2", Guests: []string{"Alice"}},
+				{Kind: Unbooked, Guests: []string{"Bob"}},
+			},
+		},
+	}
+	for _, tc := range testcases {
+		p := newProblem(nil, nil)
+		got := p.Validate(tc.Bookings, tc.Attendees, shareBed)
+		if !reflect.DeepEqual(got, tc.Want) {
+			t.Errorf("%s: Validate was %+v, want %+v", tc.Name, got, tc.Want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package conju
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/conju/rooming"
+	"github.com/cshabsin/conju/model/housing"
+	"github.com/cshabsin/conju/model/person"
+)
+
+// RoomingViolation is a problem with the event's bookings, ready to show.
+type RoomingViolation struct {
+	Problem string
+	Room    string
+	Guests  string
+}
+
+// checkRooming validates bookings for the current event against room
+// capacity, housing preferences, and RSVPs.
+func checkRooming(ctx context.Context, wr WrappedRequest, bookings []Booking) ([]RoomingViolation, error) {
+	client := dsclient.FromContext(ctx)
+	buildingsMap := getBuildingMapForVenue(ctx, wr.Event.VenueKey())
+	catalog := venueAttributes(ctx, wr.Event)
+	rooms := make([]*housing.Room, len(wr.Event.Rooms))
+	if err := client.GetMulti(ctx, wr.Event.Rooms, rooms); err != nil {
+		return nil, fmt.Errorf("fetching rooms: %w", err)
+	}
+	roomsMap := make(map[int64]*housing.Room)
+	for i, room := range rooms {
+		roomsMap[wr.Event.Rooms[i].ID] = room
+	}
+
+	guests, byInvitation, err := getRoomingGuests(ctx, wr)
+	if err != nil {
+		return nil, err
+	}
+	var attendees []rooming.Guest
+	for _, invitationGuests := range byInvitation {
+		for _, guest := range invitationGuests {
+			if guest.Attending {
+				attendees = append(attendees, guest)
+			}
+		}
+	}
+
+	var booked []rooming.BookedRoom
+	for _, booking := range bookings {
+		room := roomsMap[booking.Room.ID]
+		if room == nil {
+			// The room may have been removed from the event since.
+			room = &housing.Room{}
+			if err := client.Get(ctx, booking.Room, room); err != nil {
+				return nil, fmt.Errorf("fetching booked room: %w", err)
+			}
+		}
+		building := buildingsMap[booking.Room.Parent.ID]
+		b := rooming.BookedRoom{
+			Name:      roomName(room, building),
+			Room:      roomingRoom(catalog, booking.Room, room, building),
+			SharedBed: room.HasSharedBed(),
+		}
+		for _, roommate := range booking.Roommates {
+			guest, ok := guests[roommate.ID]
+			if !ok {
+				// Booked, but no longer on an invitation to the event.
+				var p person.Person
+				if err := client.Get(ctx, roommate, &p); err != nil {
+					return nil, fmt.Errorf("fetching roommate: %w", err)
+				}
+				guest = rooming.Guest{ID: roommate.Encode(), Name: p.FullName(), Party: roommate.Encode(), NeedsBed: true}
+			}
+			b.Guests = append(b.Guests, guest)
+		}
+		booked = append(booked, b)
+	}
+
+	var violations []RoomingViolation
+	shareBedBit := ShareBed.Bit()
+	for _, v := range newRoomingProblem().Validate(booked, attendees, shareBedBit) {
+		problem := v.Kind.String()
+		if v.Kind == rooming.UnacceptedFeature {
+			var features []string
+			for _, info := range GetAllHousingPreferenceBooleans() {
+				if v.Bits&info.Bit != 0 {
+					features = append(features, info.ReportDescription)
+				}
+			}
+			problem += ": " + strings.Join(features, ", ")
+		}
+		violations = append(violations, RoomingViolation{
+			Problem: problem,
+			Room:    v.Room,
+			Guests:  strings.Join(v.Guests, ", "),
+		})
+	}
+	return violations, nil
+}
@@ -8,21 +8,22 @@ import (
 	"log"
 	"net/http"
 	text_template "text/template"
+	"time"
 
 	"cloud.google.com/go/datastore"
 
 	"github.com/cshabsin/conju/conju/dsclient"
-	"github.com/cshabsin/conju/invitation"
+	"github.com/cshabsin/conju/conju/mailer"
 	"github.com/cshabsin/conju/model/housing"
 	"github.com/cshabsin/conju/model/person"
-	"github.com/sendgrid/sendgrid-go/helpers/mail"
 )
 
 type RenderedMail struct {
-	Person  person.Person
-	Text    string
-	HTML    string
-	Subject string
+	Person     person.Person
+	Invitation *datastore.Key
+	Text       string
+	HTML       string
+	Subject    string
 }
 
 func handleTestSendUpdatesEmail(ctx context.Context, wr WrappedRequest) {
@@ -42,6 +43,7 @@ func handleTestSendRoomingRelatedEmail(ctx context.Context, wr WrappedRequest, e
 	if err != nil {
 		http.Error(wr.ResponseWriter, fmt.Sprintf("Rendering mail: %v", err),
 			http.StatusInternalServerError)
+		return
 	}
 	wr.ResponseWriter.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	for _, rm := range rendered_mail {
@@ -54,6 +56,7 @@ func handleAskSendRoomingEmail(ctx context.Context, wr WrappedRequest) {
 	if err != nil {
 		http.Error(wr.ResponseWriter, fmt.Sprintf("Rendering mail: %v", err),
 			http.StatusInternalServerError)
+		return
 	}
 	wr.ResponseWriter.Header().Set("Content-Type", "text/html; charset=utf-8")
 	fmt.Fprintf(wr.ResponseWriter, `
@@ -72,6 +75,7 @@ func handleAskSendUpdatesEmail(ctx context.Context, wr WrappedRequest) {
 	if err != nil {
 		http.Error(wr.ResponseWriter, fmt.Sprintf("Rendering mail: %v", err),
 			http.StatusInternalServerError)
+		return
 	}
 	wr.ResponseWriter.Header().Set("Content-Type", "text/html; charset=utf-8")
 	fmt.Fprintf(wr.ResponseWriter, `
@@ -111,37 +115,88 @@ func handleSendRoomingEmail(ctx context.Context, wr WrappedRequest, emailName st
 	if err != nil {
 		http.Error(wr.ResponseWriter, fmt.Sprintf("Rendering mail: %v", err),
 			http.StatusInternalServerError)
+		return
+	}
+
+	// A test run sends everyone's mail to the archive address, so each one
+	// is a separate test mailing.
+	mailingName := ""
+	if !isTest {
+		mailingName = emailName
+	}
+	mailingKey, err := getMailing(ctx, wr, mailingName, emailName, "Everyone booked into a room")
+	if err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Creating mailing: %v", err),
+			http.StatusInternalServerError)
+		return
 	}
 	wr.ResponseWriter.Header().Set("Content-Type", "text/html; charset=utf-8")
 	for _, to_render := range rendered_mail {
 		p := to_render.Person
-		message := &mail.SGMailV3{
-			From:    mail.NewEmail(senders, wr.GetSenderAddress()),
+		message := &mailer.Message{
+			From:    mailer.Address{Name: senders, Email: wr.GetSenderAddress()},
 			Subject: to_render.Subject,
-			Content: []*mail.Content{
-				mail.NewContent("text/plain", to_render.Text),
-				mail.NewContent("text/html", to_render.HTML),
-			},
+			Text:    to_render.Text,
+			HTML:    to_render.HTML,
 		}
 		if isTest {
-			message.AddPersonalizations(ToPersonalization(fmt.Sprintf("%s test", p.FullName()), wr.GetBccAddress()))
+			message.To = []mailer.Address{{Name: fmt.Sprintf("%s test", p.FullName()), Email: wr.GetBccAddress()}}
 		} else {
-			p := ToPersonalization(p.FullName(), p.Email)
-			p.AddBCCs(mail.NewEmail("", wr.GetBccAddress()))
-			message.AddPersonalizations(p)
+			message.To = []mailer.Address{{Name: p.FullName(), Email: p.Email}}
+			message.Bcc = wr.bccAddresses()
+			message.Unsubscribe = mailPreferencesLink(ctx, wr.Event, &p)
+			message.Text, message.HTML = addUnsubscribeFooter(message.Unsubscribe, message.Text, message.HTML)
+		}
+		job := newMailJob(p.FullName(), message)
+		job.Template = emailName
+		job.Invitation = to_render.Invitation
+		jobName := ""
+		if !isTest {
+			job.Person = p.DatastoreKey
+			jobName = p.DatastoreKey.Encode()
 		}
-		fmt.Fprintf(wr.ResponseWriter, "Sending to %s (isTest = %v)<p>", p.FullName(), isTest)
-		_, err = wr.GetEmailClient().Send(message)
+		queued, err := queueMail(ctx, mailingKey, jobName, job)
 		if err != nil {
-			log.Printf("Error sending mail: %v", err)
+			fmt.Fprintf(wr.ResponseWriter, "Error queueing mail for %s: %v", p.Email, err)
+			return
+		}
+		if !queued {
+			fmt.Fprintf(wr.ResponseWriter, "Skipping %s, who has already been sent this mailing or is queued for it.<br>", p.Email)
+		} else {
+			fmt.Fprintf(wr.ResponseWriter, "Sending to %s (isTest = %v)<br>", p.FullName(), isTest)
 		}
 	}
+	fmt.Fprintf(wr.ResponseWriter, "<p>Messages are queued and will go out over the next few minutes. <a href=\"mailing?mailing=%s\">Mailing status</a></p>", mailingKey.Encode())
 }
 
-func getRoomingEmails(ctx context.Context, wr WrappedRequest, emailName string) (map[int64]RenderedMail, error) {
+// roomingMail is what the rooming, updates and final mails tell an
+// invitation about the rooms its invitees are booked into.
+type roomingMail struct {
+	Invitation      RealizedInvitation
+	InviteeBookings InviteeBookingsMap
+	Thursday        bool // someone in the invitation is staying Thursday night
+	Unreserved      []BuildingRoom
+}
+
+// templateData returns the data the mails are rendered with, for a
+// message to p.
+func (m roomingMail) templateData(wr WrappedRequest, p *person.Person) map[string]interface{} {
+	return wr.MakeTemplateData(map[string]interface{}{
+		"Invitation":      m.Invitation,
+		"InviteeBookings": m.InviteeBookings,
+		"LoginLink":       makeLoginUrl(wr.Event, p, true),
+		"PeopleComing":    m.Invitation.GetPeopleComing(),
+		"Thursday":        m.Thursday,
+		"Unreserved":      m.Unreserved,
+	})
+}
+
+// getRoomingMails returns the roomingMail for each invitation to the
+// current event with someone booked into a room, keyed by invitation ID.
+func getRoomingMails(ctx context.Context, wr WrappedRequest) map[int64]roomingMail {
 	// Cribbed heavily from handleRoomingReport
 	var bookings []Booking
-	q := datastore.NewQuery("Booking").Ancestor(wr.EventKey)
+	q := dsclient.NewQuery("Booking").Ancestor(wr.EventKey)
 	_, err := dsclient.FromContext(ctx).GetAll(ctx, q, &bookings)
 	if err != nil {
 		log.Printf("fetching bookings: %v", err)
@@ -176,7 +231,7 @@ func getRoomingEmails(ctx context.Context, wr WrappedRequest, emailName string)
 	}
 
 	var invitations []*Invitation
-	q = datastore.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
+	q = dsclient.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
 	invitationKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
 	if err != nil {
 		log.Printf("fetching invitations: %v", err)
@@ -190,25 +245,11 @@ func getRoomingEmails(ctx context.Context, wr WrappedRequest, emailName string)
 			personToInvitationMap[person.ID] = invitationKeys[i].ID
 		}
 	}
-	shareBedBit := GetAllHousingPreferenceBooleans()[ShareBed].Bit
-
-	type BuildingRoom struct {
-		Room     *housing.Room
-		Building *housing.Building
-	}
-	type InviteeRoomBookings struct {
-		Building            *housing.Building
-		Room                *housing.Room
-		Roommates           []*person.Person // People from this invitation.
-		RoomSharers         []*person.Person // People from outside the invitation.
-		ShowConvertToDouble bool
-		ReservationMade     bool
-	}
-	type InviteeBookings map[BuildingRoom]InviteeRoomBookings
+	shareBedBit := ShareBed.Bit()
 
 	wr.Event.LoadVenue(ctx)
 	buildingsMap := getBuildingMapForVenue(ctx, wr.Event.Venue.Key)
-	allInviteeBookings := make(map[int64]InviteeBookings)
+	allInviteeBookings := make(map[int64]InviteeBookingsMap)
 	for _, booking := range bookings {
 		room := roomsMap[booking.Room.ID]
 		buildingId := booking.Room.Parent.ID
@@ -225,7 +266,7 @@ func getRoomingEmails(ctx context.Context, wr WrappedRequest, emailName string)
 		// Figure out if we need them to tell PSR to convert twin beds to double.
 		showConvertToDouble := doubleBedNeeded
 
-		if doubleBedNeeded && (((building.Properties | room.Properties) & shareBedBit) == shareBedBit) {
+		if doubleBedNeeded && roomSatisfies(wr.Event.Venue.Attributes, building, room, ShareBed) {
 			for _, bed := range room.Beds {
 				if bed == housing.Double || bed == housing.Queen || bed == housing.King {
 					showConvertToDouble = false
@@ -239,7 +280,7 @@ func getRoomingEmails(ctx context.Context, wr WrappedRequest, emailName string)
 
 			inviteeBookings, found := allInviteeBookings[invitation]
 			if !found {
-				inviteeBookings = make(InviteeBookings)
+				inviteeBookings = make(InviteeBookingsMap)
 				allInviteeBookings[invitation] = inviteeBookings
 			}
 			_, found = inviteeBookings[buildingRoom]
@@ -266,6 +307,36 @@ func getRoomingEmails(ctx context.Context, wr WrappedRequest, emailName string)
 		}
 	}
 
+	roomingMails := make(map[int64]roomingMail)
+	for inv, bookings := range allInviteeBookings {
+		// invitation is ID from key.
+		ri := makeRealizedInvitation(ctx, datastore.IDKey("Invitation", inv, nil), invitationMap[inv])
+		var unreserved []BuildingRoom
+		for _, booking := range bookings {
+			if !booking.ReservationMade {
+				unreserved = append(unreserved, BuildingRoom{booking.Room, booking.Building})
+			}
+		}
+
+		thursday := false
+		for i := range ri.InviteePeople {
+			if ri.RsvpMap[ri.Invitees[i].Key].CoversNight(time.Thursday) {
+				thursday = true
+				break
+			}
+		}
+
+		roomingMails[inv] = roomingMail{
+			Invitation:      ri,
+			InviteeBookings: bookings,
+			Thursday:        thursday,
+			Unreserved:      unreserved,
+		}
+	}
+	return roomingMails
+}
+
+func getRoomingEmails(ctx context.Context, wr WrappedRequest, emailName string) (map[int64]RenderedMail, error) {
 	functionMap := template.FuncMap{
 		"HasHousingPreference":        RealInvHasHousingPreference,
 		"PronounString":               person.GetPronouns,
@@ -274,7 +345,10 @@ func getRoomingEmails(ctx context.Context, wr WrappedRequest, emailName string)
 		"DerefPeople":                 DerefPeople,
 	}
 
-	tpl := template.Must(template.New("").Funcs(functionMap).ParseFiles("templates/PSR2022/email/" + emailName + ".html"))
+	tpl, err := template.New("").Funcs(functionMap).ParseFiles("templates/PSR2022/email/" + emailName + ".html")
+	if err != nil {
+		return nil, err
+	}
 
 	textFunctionMap := text_template.FuncMap{
 		"HasHousingPreference":        RealInvHasHousingPreference,
@@ -283,43 +357,30 @@ func getRoomingEmails(ctx context.Context, wr WrappedRequest, emailName string)
 		"SharerName":                  MakeSharerName,
 		"DerefPeople":                 DerefPeople,
 	}
-	text_tpl := text_template.Must(text_template.New("").Funcs(textFunctionMap).ParseGlob("templates/PSR2022/email/" + emailName + ".html"))
+	text_tpl, err := text_template.New("").Funcs(textFunctionMap).ParseFiles("templates/PSR2022/email/" + emailName + ".html")
+	if err != nil {
+		return nil, err
+	}
 
-	rendered_mail := make(map[int64]RenderedMail, 0)
-	for inv, bookings := range allInviteeBookings {
-		// invitation is ID from key.
-		ri := makeRealizedInvitation(ctx, datastore.IDKey("Invitation", inv, nil), invitationMap[inv])
-		var unreserved []BuildingRoom
-		for _, booking := range bookings {
-			if !booking.ReservationMade {
-				unreserved = append(unreserved, BuildingRoom{booking.Room, booking.Building})
-			}
-		}
+	statuses, err := getAddressStatuses(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-		thursday := false
-		for i := range ri.InviteePeople {
-			status := ri.RsvpMap[ri.Invitees[i].Key].Status
-			if status == invitation.ThuFriSat {
-				thursday = true
-				break
+	rendered_mail := make(map[int64]RenderedMail, 0)
+	for inv, mail := range getRoomingMails(ctx, wr) {
+		for i, p := range mail.Invitation.InviteePeople {
+			if p.Email == "" || !p.MailPreference.Wants(true) {
+				continue
 			}
-		}
-
-		for i, p := range ri.InviteePeople {
-			if p.Email == "" {
+			if status := statuses.forPerson(&p); status != nil && status.BadAddress() {
+				log.Printf("Leaving out %s: mail to %s bounced (%s)", p.FullName(), p.Email, status.BounceReason)
 				continue
 			}
-			if !ri.RsvpMap[ri.Invitees[i].Key].Attending {
+			if !mail.Invitation.RsvpMap[mail.Invitation.Invitees[i].Key].Attending {
 				continue
 			}
-			data := wr.MakeTemplateData(map[string]interface{}{
-				"Invitation":      ri,
-				"InviteeBookings": bookings,
-				"LoginLink":       makeLoginUrl(&p, true),
-				"PeopleComing":    ri.GetPeopleComing(),
-				"Thursday":        thursday,
-				"Unreserved":      unreserved,
-			})
+			data := mail.templateData(wr, &p)
 			var text bytes.Buffer
 			if err := text_tpl.ExecuteTemplate(&text, emailName+"_text", data); err != nil {
 				log.Printf("%v", err)
@@ -334,7 +395,13 @@ func getRoomingEmails(ctx context.Context, wr WrappedRequest, emailName string)
 			if err := text_tpl.ExecuteTemplate(&subject, emailName+"_subject", data); err != nil {
 				log.Printf("%v", err)
 			}
-			rendered_mail[p.DatastoreKey.ID] = RenderedMail{p, text.String(), htmlBuf.String(), subject.String()}
+			rendered_mail[p.DatastoreKey.ID] = RenderedMail{
+				Person:     p,
+				Invitation: datastore.IDKey("Invitation", inv, nil),
+				Text:       text.String(),
+				HTML:       htmlBuf.String(),
+				Subject:    subject.String(),
+			}
 		}
 	}
 	return rendered_mail, nil
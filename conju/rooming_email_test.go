@@ -0,0 +1,69 @@
+package conju
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+)
+
+func TestSendRoomingEmail(t *testing.T) {
+	s := newTestSite(t)
+
+	// Mail to Taylor Brooks, of the unpaid fixture, has bounced.
+	_, inv := s.fixture(t, "unpaid")
+	status := &AddressStatus{Email: "taylor@example.com", Bounced: time.Now(), HardBounce: true}
+	if _, err := s.client.Put(s.ctx, addressStatusKey(inv.Invitees[0], status.Email), status); err != nil {
+		t.Fatal(err)
+	}
+
+	// Everyone else who is booked into a room and has an address gets
+	// the mail, once however many times it's sent.
+	want := map[string]bool{
+		"avery@example.com":  true,
+		"jordan@example.com": true,
+		"sam@example.com":    true,
+		"robin@example.com":  true,
+		"casey@example.com":  true,
+	}
+	// Only the final mail's template is still in templates/PSR2022; the
+	// rooming and updates mails go to the same people.
+	for run := 0; run < 2; run++ {
+		wr, rec := s.request(t, "single_adult", true, "POST", "/doSendRealRoomingEmail", nil)
+		handleSendRoomingEmail(s.ctx, wr, "final", false)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("run %d: status %d\n%s", run, rec.Code, rec.Body)
+		}
+	}
+	if len(s.transport.sent) != 0 {
+		t.Errorf("%d messages were sent right away, want them queued", len(s.transport.sent))
+	}
+
+	var jobs []*MailJob
+	if _, err := s.client.GetAll(s.ctx, dsclient.NewQuery("MailJob").Ancestor(s.ev.Key), &jobs); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]bool)
+	for _, job := range jobs {
+		for _, to := range job.To {
+			if got[to] {
+				t.Errorf("%s was queued more than once", to)
+			}
+			got[to] = true
+		}
+		if job.Template != "final" || job.Person == nil || job.Invitation == nil {
+			t.Errorf("job for %v: template %q, person %v, invitation %v", job.To, job.Template, job.Person, job.Invitation)
+		}
+	}
+	for to := range want {
+		if !got[to] {
+			t.Errorf("no mail queued for %s", to)
+		}
+	}
+	for to := range got {
+		if !want[to] {
+			t.Errorf("mail queued for %s", to)
+		}
+	}
+}
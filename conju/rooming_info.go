@@ -3,13 +3,15 @@ package conju
 import (
 	"context"
 	"log"
-	"math"
+	"time"
 
 	"cloud.google.com/go/datastore"
 	"github.com/cshabsin/conju/conju/dsclient"
 	"github.com/cshabsin/conju/invitation"
+	"github.com/cshabsin/conju/model/event"
 	"github.com/cshabsin/conju/model/housing"
 	"github.com/cshabsin/conju/model/person"
+	"github.com/cshabsin/conju/model/pricing"
 )
 
 // Booking holds the booking info and is kept in the datastore.
@@ -17,6 +19,7 @@ type Booking struct {
 	Event    *datastore.Key
 	Room     *datastore.Key
 	Reserved bool
+	Locked   bool // kept as is when proposing new assignments
 
 	Roommates []*datastore.Key
 }
@@ -154,7 +157,7 @@ func getRoomingInfoWithInvitation(ctx context.Context, wr WrappedRequest, inv *I
 	}
 
 	var invitations []*Invitation
-	q := datastore.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
+	q := dsclient.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
 	invitationKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
 	if err != nil {
 		log.Printf("fetching invitations: %v", err)
@@ -170,7 +173,7 @@ func getRoomingInfoWithInvitation(ctx context.Context, wr WrappedRequest, inv *I
 			personToRsvp[person.ID] = rsvp
 		}
 	}
-	shareBedBit := GetAllHousingPreferenceBooleans()[ShareBed].Bit
+	shareBedBit := ShareBed.Bit()
 
 	wr.Event.LoadVenue(ctx)
 	buildingsMap := getBuildingMapForVenue(ctx, wr.Event.Venue.Key)
@@ -196,7 +199,7 @@ func getRoomingInfoWithInvitation(ctx context.Context, wr WrappedRequest, inv *I
 
 		// Figure out if we need them to tell PSR to convert twin beds to double.
 		showConvertToDouble := doubleBedNeeded
-		if doubleBedNeeded && (((building.Properties | room.Properties) & shareBedBit) == shareBedBit) {
+		if doubleBedNeeded && roomSatisfies(wr.Event.Venue.Attributes, building, room, ShareBed) {
 			for _, bed := range room.Beds {
 				if bed == housing.Double || bed == housing.Queen || bed == housing.King {
 					showConvertToDouble = false
@@ -205,28 +208,9 @@ func getRoomingInfoWithInvitation(ctx context.Context, wr WrappedRequest, inv *I
 			}
 		}
 
-		FridaySaturday := 0
-		PlusThursday := 0
-		addThurs := make([]bool, len(booking.Roommates))
-
-		for i, per := range booking.Roommates {
+		for _, per := range booking.Roommates {
 
 			roommateInvitation := personToInvitationMap[per.ID]
-			rsvpStatus := personToRsvp[per.ID]
-			p := personMap[per.ID]
-
-			if !p.IsBabyAtTime(wr.Event.StartDate) {
-				if rsvpStatus == invitation.FriSat {
-					FridaySaturday++
-				}
-				if rsvpStatus == invitation.ThuFriSat {
-					FridaySaturday++
-					PlusThursday++
-					addThurs[i] = true
-					isThuFriSat = true
-				}
-			}
-
 			inviteeBookings, ok := allInviteeBookings[roommateInvitation]
 			if !ok {
 				inviteeBookings = make(InviteeBookingsMap)
@@ -255,25 +239,20 @@ func getRoomingInfoWithInvitation(ctx context.Context, wr WrappedRequest, inv *I
 			}
 		}
 
-		for i, person := range booking.Roommates {
-
-			p := personMap[person.ID]
-			if p.IsBabyAtTime(wr.Event.StartDate) {
-				personToCost[p] = 0
-				continue
-			}
-			costForPerson := float64(0)
-			if FridaySaturday <= 5 {
-				costForPerson = invitation.GetAllRsvpStatuses()[invitation.FriSat].BaseCost[FridaySaturday]
-			}
-
-			if addThurs[i] && PlusThursday <= 5 {
-				costForPerson += invitation.GetAllRsvpStatuses()[invitation.ThuFriSat].AddOnCost[PlusThursday]
+		var roommates []*person.Person
+		var statuses []invitation.RsvpStatus
+		for _, per := range booking.Roommates {
+			p := personMap[per.ID]
+			roommates = append(roommates, p)
+			statuses = append(statuses, personToRsvp[per.ID])
+			if wr.Event.RsvpStatusInfo(personToRsvp[per.ID]).CoversNight(time.Thursday) {
+				isThuFriSat = true
 			}
-			costForPerson = math.Floor(costForPerson*100) / 100
-			personToCost[p] = costForPerson
 		}
-
+		quote := priceBooking(wr.Event, building, room, roommates, statuses)
+		for i, p := range roommates {
+			personToCost[p] = quote.Costs[i]
+		}
 	}
 
 	inviteePersonToCost := make(map[*person.Person]float64)
@@ -299,3 +278,30 @@ func getRoomingInfoWithInvitation(ctx context.Context, wr WrappedRequest, inv *I
 		IsThuFriSat:     isThuFriSat,
 	}
 }
+
+// priceBooking prices one room using the event's pricing schedule.
+// statuses holds each roommate's RSVP, in the same order as roommates.
+func priceBooking(ev *event.Event, building *housing.Building, room *housing.Room,
+	roommates []*person.Person, statuses []invitation.RsvpStatus) pricing.Quote {
+	guests := make([]pricing.Guest, len(roommates))
+	for i, p := range roommates {
+		guests[i].Nights = ev.RsvpStatusInfo(statuses[i]).Nights
+		if p == nil {
+			continue
+		}
+		if p.IsBabyAtTime(ev.StartDate) {
+			guests[i].Age = pricing.Baby
+		} else if p.IsChildAtTime(ev.StartDate) {
+			guests[i].Age = pricing.Child
+		}
+	}
+	buildingCode := ""
+	if building != nil {
+		buildingCode = building.Code
+	}
+	roomNumber := 0
+	if room != nil {
+		roomNumber = room.RoomNumber
+	}
+	return ev.Pricing.Price(buildingCode, roomNumber, guests)
+}
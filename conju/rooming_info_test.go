@@ -0,0 +1,61 @@
+package conju
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGetRoomingInfoWithInvitation(t *testing.T) {
+	s := newTestSite(t)
+	wr, _ := s.request(t, "single_adult", true, "GET", "/rsvp", nil)
+
+	for _, tc := range []struct {
+		fixture   string
+		attendees int
+		sharers   int
+		paid      bool
+	}{
+		{fixture: "single_adult", attendees: 1, paid: true},
+		{fixture: "couple", attendees: 2, paid: true},
+		{fixture: "family", attendees: 4, paid: true},
+		{fixture: "unpaid", attendees: 1},
+	} {
+		key, inv := s.fixture(t, tc.fixture)
+		info := getRoomingInfoWithInvitation(s.ctx, wr, inv, key)
+		if info == nil {
+			t.Errorf("%s: no rooming info", tc.fixture)
+			continue
+		}
+		if len(info.InviteeBookings) != 1 {
+			t.Errorf("%s: booked into %d rooms, want 1", tc.fixture, len(info.InviteeBookings))
+		}
+		for room, booking := range info.InviteeBookings {
+			if room.Room == nil || room.Building == nil || room.Building.Name != "Lodge" {
+				t.Errorf("%s: booked into %+v, want a room in the Lodge", tc.fixture, room)
+			}
+			if len(booking.Roommates) != tc.attendees || len(booking.RoomSharers) != tc.sharers {
+				t.Errorf("%s: %d roommates and %d sharers, want %d and %d",
+					tc.fixture, len(booking.Roommates), len(booking.RoomSharers), tc.attendees, tc.sharers)
+			}
+		}
+		if len(info.OrderedInvitees) != tc.attendees {
+			t.Errorf("%s: %d invitees, want %d", tc.fixture, len(info.OrderedInvitees), tc.attendees)
+		}
+
+		total := 0.0
+		for _, cost := range info.PersonToCost {
+			total += cost
+		}
+		if info.TotalCost <= 0 || math.Abs(total-info.TotalCost) > 0.005 {
+			t.Errorf("%s: total cost %v, people's costs add up to %v", tc.fixture, info.TotalCost, total)
+		}
+		if info.IsPaid() != tc.paid {
+			t.Errorf("%s: IsPaid = %v, want %v", tc.fixture, info.IsPaid(), tc.paid)
+		}
+	}
+
+	key, inv := s.fixture(t, "no_housing")
+	if info := getRoomingInfoWithInvitation(s.ctx, wr, inv, key); info != nil {
+		t.Errorf("no_housing: got rooming info %+v, want none", info)
+	}
+}
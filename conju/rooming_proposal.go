@@ -0,0 +1,198 @@
+package conju
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/conju/rooming"
+	"github.com/cshabsin/conju/invitation"
+	"github.com/cshabsin/conju/model/housing"
+	"github.com/cshabsin/conju/model/person"
+)
+
+// RoomingProposal is a solver result for the rooming tool to display. Its
+// bookings have not been saved.
+type RoomingProposal struct {
+	Bookings []Booking
+	Unplaced []string // names of parties that didn't fit
+	Score    int
+}
+
+var housingPreferenceSharing = map[HousingPreference]rooming.Sharing{
+	NoRoommates:       rooming.ShareNever,
+	SpecificRoommates: rooming.ShareWithKnown,
+	KnownRoommates:    rooming.ShareWithKnown,
+}
+
+// newRoomingProblem returns a problem with the housing preference masks
+// filled in.
+func newRoomingProblem() rooming.Problem {
+	return rooming.Problem{
+		DesiredMask:    GetPreferenceTypeMask(Desired),
+		AcceptableMask: GetPreferenceTypeMask(Acceptable),
+	}
+}
+
+// roomingRoom describes a room of the event for the rooming package,
+// matching its attributes to preferences with the venue's catalog.
+func roomingRoom(catalog []housing.Attribute, roomKey *datastore.Key, room *housing.Room, building *housing.Building) rooming.Room {
+	attributes := room.AttributeNames()
+	if building != nil {
+		attributes = append(append([]string(nil), attributes...), building.AttributeNames()...)
+	}
+	properties := attributeBits(catalog, attributes)
+	if room.HasSharedBed() {
+		properties |= ShareBed.Bit()
+	}
+	return rooming.Room{
+		ID:         roomKey.Encode(),
+		Capacity:   room.Capacity(),
+		Properties: properties,
+	}
+}
+
+// getRoomingGuests describes each invitee of the event's invitations for
+// the rooming package, keyed by person ID. The guests of each invitation
+// are also returned in invitation order.
+func getRoomingGuests(ctx context.Context, wr WrappedRequest) (map[int64]rooming.Guest, [][]rooming.Guest, error) {
+	client := dsclient.FromContext(ctx)
+	var invitations []*Invitation
+	q := dsclient.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
+	invitationKeys, err := client.GetAll(ctx, q, &invitations)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fetching invitations: %w", err)
+	}
+
+	adultPreferenceMask := GetAdultPreferenceMask()
+	guests := make(map[int64]rooming.Guest)
+	var byInvitation [][]rooming.Guest
+	for i, inv := range invitations {
+		people := make([]*person.Person, len(inv.Invitees))
+		if err := client.GetMulti(ctx, inv.Invitees, people); err != nil {
+			return nil, nil, fmt.Errorf("fetching invitees: %w", err)
+		}
+		personKeyToRsvp := make(map[datastore.Key]invitation.RsvpStatus)
+		for p, r := range inv.RsvpMap {
+			personKeyToRsvp[*p] = r
+		}
+		var invitationGuests []rooming.Guest
+		for j, invitee := range inv.Invitees {
+			p := people[j]
+			guest := rooming.Guest{
+				ID:         invitee.Encode(),
+				Name:       p.FullName(),
+				Party:      invitationKeys[i].Encode(),
+				NeedsBed:   !p.IsBabyAtTime(wr.Event.StartDate),
+				Properties: inv.HousingPreferenceBooleans,
+				Sharing:    housingPreferenceSharing[inv.Housing],
+			}
+			if status, ok := personKeyToRsvp[*invitee]; ok {
+				info := wr.Event.RsvpStatusInfo(status)
+				guest.Attending = info.Attending && !info.NoLodging
+			}
+			if p.IsAdultAtTime(wr.Event.StartDate) {
+				guest.Properties |= adultPreferenceMask
+			}
+			guests[invitee.ID] = guest
+			invitationGuests = append(invitationGuests, guest)
+		}
+		byInvitation = append(byInvitation, invitationGuests)
+	}
+	return guests, byInvitation, nil
+}
+
+// proposeRooming assigns everyone who needs lodging to the event's rooms.
+// Locked bookings are kept as they are: their rooms aren't offered to
+// anybody else, and their occupants aren't moved.
+func proposeRooming(ctx context.Context, wr WrappedRequest, bookings []Booking) (*RoomingProposal, error) {
+	lockedRooms := make(map[int64]bool)
+	lockedPeople := make(map[string]bool)
+	var kept []Booking
+	for _, booking := range bookings {
+		if !booking.Locked {
+			continue
+		}
+		kept = append(kept, booking)
+		lockedRooms[booking.Room.ID] = true
+		for _, roommate := range booking.Roommates {
+			lockedPeople[roommate.Encode()] = true
+		}
+	}
+
+	buildingsMap := getBuildingMapForVenue(ctx, wr.Event.VenueKey())
+	catalog := venueAttributes(ctx, wr.Event)
+	rooms := make([]*housing.Room, len(wr.Event.Rooms))
+	if err := dsclient.FromContext(ctx).GetMulti(ctx, wr.Event.Rooms, rooms); err != nil {
+		return nil, fmt.Errorf("fetching rooms: %w", err)
+	}
+
+	problem := newRoomingProblem()
+	roomKeys := make(map[string]*datastore.Key)
+	for i, room := range rooms {
+		roomKey := wr.Event.Rooms[i]
+		if lockedRooms[roomKey.ID] {
+			continue
+		}
+		problem.Rooms = append(problem.Rooms, roomingRoom(catalog, roomKey, room, buildingsMap[roomKey.Parent.ID]))
+		roomKeys[roomKey.Encode()] = roomKey
+	}
+
+	_, byInvitation, err := getRoomingGuests(ctx, wr)
+	if err != nil {
+		return nil, err
+	}
+	partyMembers := make(map[string][]*datastore.Key)
+	partyNames := make(map[string]string)
+	for _, guests := range byInvitation {
+		var party rooming.Party
+		var members []*datastore.Key
+		var names []string
+		for _, guest := range guests {
+			if !guest.Attending || lockedPeople[guest.ID] {
+				continue
+			}
+			if len(members) == 0 {
+				party = rooming.Party{ID: guest.Party, Properties: guest.Properties, Sharing: guest.Sharing}
+			} else {
+				party.Properties = rooming.CombineProperties(party.Properties, guest.Properties, problem.DesiredMask, problem.AcceptableMask)
+			}
+			if guest.NeedsBed {
+				party.Size++
+			}
+			key, err := datastore.DecodeKey(guest.ID)
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, key)
+			names = append(names, guest.Name)
+		}
+		if len(members) == 0 {
+			continue
+		}
+		problem.Parties = append(problem.Parties, party)
+		partyMembers[party.ID] = members
+		partyNames[party.ID] = strings.Join(names, ", ")
+	}
+
+	assignment := rooming.Solve(problem)
+	proposal := &RoomingProposal{Bookings: kept, Score: assignment.Score}
+	for _, room := range problem.Rooms {
+		partyIDs := assignment.Rooms[room.ID]
+		if len(partyIDs) == 0 {
+			continue
+		}
+		booking := Booking{Event: wr.EventKey, Room: roomKeys[room.ID]}
+		for _, partyID := range partyIDs {
+			booking.Roommates = append(booking.Roommates, partyMembers[partyID]...)
+		}
+		proposal.Bookings = append(proposal.Bookings, booking)
+	}
+	for _, partyID := range assignment.Unplaced {
+		proposal.Unplaced = append(proposal.Unplaced, partyNames[partyID])
+	}
+	return proposal, nil
+}
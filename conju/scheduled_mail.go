@@ -0,0 +1,397 @@
+package conju
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+	_ "time/tzdata" // for siteLocation, wherever the app runs
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/model/event"
+)
+
+// A ScheduledMailing sends an email template to a recipient segment later,
+// either at a set time or a number of days before one of the event's
+// dates, optionally repeating. processScheduledMail, which cron runs,
+// queues each run as a mailing of its own, so a repeated reminder goes to
+// whoever still matches the segment at the time.
+
+// ScheduleKind says what a ScheduledMailing's time is relative to.
+type ScheduleKind int
+
+const (
+	SendAtTime         ScheduleKind = iota // at SendAt
+	BeforeRsvpDeadline                     // DaysBefore the event's RSVP deadline
+	BeforeStart                            // DaysBefore the event starts
+)
+
+func (k ScheduleKind) String() string {
+	switch k {
+	case SendAtTime:
+		return "At a set time"
+	case BeforeRsvpDeadline:
+		return "Days before the RSVP deadline"
+	case BeforeStart:
+		return "Days before the event starts"
+	}
+	return fmt.Sprintf("ScheduleKind(%d)", int(k))
+}
+
+func AllScheduleKinds() []ScheduleKind {
+	return []ScheduleKind{SendAtTime, BeforeRsvpDeadline, BeforeStart}
+}
+
+// siteLocation is the time zone that send times are entered and shown in.
+var siteLocation = func() *time.Location {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}()
+
+// reminderTime is the time of day that reminders relative to the event's
+// dates go out, which are stored as midnight UTC: 10am Eastern.
+const reminderTime = 14 * time.Hour
+
+// ScheduledMailing is a child of its event.
+type ScheduledMailing struct {
+	Template   string
+	Segment    Segment
+	Kind       ScheduleKind
+	SendAt     time.Time // for SendAtTime
+	DaysBefore int       // for the other kinds
+	RepeatDays int       // 0 to send only once
+	LastRun    time.Time
+	Runs       int
+	LastError  string `datastore:",noindex"`
+	Created    time.Time
+	CreatedBy  string // email of the admin who scheduled it
+}
+
+// window returns when the first run is due and the time after which it
+// doesn't run any more. It returns false if the event doesn't have the
+// date the schedule is relative to.
+func (s *ScheduledMailing) window(ev *event.Event) (time.Time, time.Time, bool) {
+	var anchor time.Time
+	switch s.Kind {
+	case SendAtTime:
+		return s.SendAt, ev.StartDate, true
+	case BeforeRsvpDeadline:
+		anchor = ev.RsvpDeadline
+	case BeforeStart:
+		anchor = ev.StartDate
+	}
+	if anchor.IsZero() {
+		return time.Time{}, time.Time{}, false
+	}
+	anchor = anchor.Add(reminderTime)
+	return anchor.AddDate(0, 0, -s.DaysBefore), anchor, true
+}
+
+// NextRun returns when the schedule next runs as of now, or false if it
+// has finished (or can't run). Nothing runs after the window's end, not
+// even the first run, so that a reminder scheduled after the RSVP deadline
+// or the event's start isn't sent late. A mailing at a set time is sent
+// even if that's after the event starts, but doesn't repeat past it.
+func (s *ScheduledMailing) NextRun(ev *event.Event, now time.Time) (time.Time, bool) {
+	next, end, ok := s.window(ev)
+	if !ok {
+		return time.Time{}, false
+	}
+	if s.Runs == 0 {
+		if s.Kind != SendAtTime && (next.After(end) || now.After(end)) {
+			return time.Time{}, false
+		}
+		return next, true
+	}
+	if s.RepeatDays <= 0 {
+		return time.Time{}, false
+	}
+	for !next.After(s.LastRun) {
+		next = next.AddDate(0, 0, s.RepeatDays)
+	}
+	if next.After(end) || now.After(end) {
+		return time.Time{}, false
+	}
+	return next, true
+}
+
+// Description says in words when the schedule runs.
+func (s *ScheduledMailing) Description(ev *event.Event) string {
+	var when string
+	switch s.Kind {
+	case SendAtTime:
+		when = "at " + s.SendAt.In(siteLocation).Format("2006-01-02 15:04 MST")
+	case BeforeRsvpDeadline:
+		when = fmt.Sprintf("%d days before the RSVP deadline", s.DaysBefore)
+		if ev.RsvpDeadline.IsZero() {
+			when += " (the event has no RSVP deadline)"
+		}
+	case BeforeStart:
+		when = fmt.Sprintf("%d days before the event starts", s.DaysBefore)
+	}
+	if s.RepeatDays > 0 {
+		when += fmt.Sprintf(", then every %d days", s.RepeatDays)
+	}
+	return when
+}
+
+// processScheduledMail queues the runs that are due as of now, and returns
+// how many it queued.
+func processScheduledMail(ctx context.Context, wr WrappedRequest, now time.Time) (int, error) {
+	client := dsclient.FromContext(ctx)
+	var schedules []*ScheduledMailing
+	keys, err := client.GetAll(ctx, dsclient.NewQuery("ScheduledMailing"), &schedules)
+	if err != nil {
+		return 0, fmt.Errorf("fetching scheduled mailings: %w", err)
+	}
+	events := make(map[string]*event.Event)
+	runs := 0
+	for i, schedule := range schedules {
+		eventKey := keys[i].Parent
+		ev := events[eventKey.Encode()]
+		if ev == nil {
+			ev, err = event.GetEvent(ctx, eventKey)
+			if err != nil {
+				log.Printf("Getting event for scheduled mailing %v: %v", keys[i], err)
+				continue
+			}
+			events[eventKey.Encode()] = ev
+		}
+		if next, ok := schedule.NextRun(ev, now); !ok || next.After(now) {
+			continue
+		}
+		run, err := claimScheduledRun(ctx, keys[i], ev, now)
+		if err != nil {
+			return runs, fmt.Errorf("claiming scheduled mailing %v: %w", keys[i], err)
+		}
+		if run == nil {
+			continue
+		}
+		runs++
+		eventWr := wr
+		eventWr.Event = ev
+		eventWr.EventKey = eventKey
+		if err := runScheduledMailing(ctx, eventWr, keys[i], run); err != nil {
+			log.Printf("Running scheduled mailing %v: %v", keys[i], err)
+			run.LastError = err.Error()
+		} else {
+			run.LastError = ""
+		}
+		if _, err := client.Put(ctx, keys[i], run); err != nil {
+			return runs, fmt.Errorf("updating scheduled mailing %v: %w", keys[i], err)
+		}
+	}
+	return runs, nil
+}
+
+// claimScheduledRun records a run of the schedule, so that an overlapping
+// worker doesn't run it too, and returns the updated schedule. It returns
+// nil if the schedule is no longer due.
+func claimScheduledRun(ctx context.Context, key *datastore.Key, ev *event.Event, now time.Time) (*ScheduledMailing, error) {
+	var claimed *ScheduledMailing
+	err := dsclient.FromContext(ctx).RunInTransaction(ctx, func(tx dsclient.Client) error {
+		var schedule ScheduledMailing
+		if err := tx.Get(ctx, key, &schedule); err != nil {
+			return err
+		}
+		if next, ok := schedule.NextRun(ev, now); !ok || next.After(now) {
+			return nil
+		}
+		schedule.LastRun = now
+		schedule.Runs++
+		if _, err := tx.Put(ctx, key, &schedule); err != nil {
+			return err
+		}
+		claimed = &schedule
+		return nil
+	})
+	return claimed, err
+}
+
+// runScheduledMailing queues one run of the schedule, as a mailing of its
+// own.
+func runScheduledMailing(ctx context.Context, wr WrappedRequest, key *datastore.Key, schedule *ScheduledMailing) error {
+	recipients, err := schedule.Segment.Recipients(ctx, wr, schedule.Template)
+	if err != nil {
+		return fmt.Errorf("finding recipients: %w", err)
+	}
+	name := fmt.Sprintf("%s-scheduled-%d-%d", schedule.Template, key.ID, schedule.Runs)
+	mailingKey, err := getMailing(ctx, wr, name, schedule.Template, schedule.Segment.Description(wr.Event))
+	if err != nil {
+		return fmt.Errorf("creating mailing: %w", err)
+	}
+	log.Printf("Scheduled mailing %v: queueing %q for %d recipients", key, schedule.Template, len(recipients))
+	return queueSegmentMail(ctx, wr, mailingKey, schedule.Template, recipients, false, false, io.Discard)
+}
+
+// handleProcessScheduledMail is run by cron (see cron.yaml), and may also
+// be run by an admin.
+func handleProcessScheduledMail(ctx context.Context, wr WrappedRequest) {
+	if wr.Request.Header.Get("X-Appengine-Cron") != "true" && !wr.IsAdminUser() {
+		http.Error(wr.ResponseWriter, "Not authorized.", http.StatusForbidden)
+		return
+	}
+	runs, err := processScheduledMail(ctx, wr, time.Now())
+	if err != nil {
+		log.Printf("processScheduledMail: %v", err)
+		http.Error(wr.ResponseWriter, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(wr.ResponseWriter, "Queued %d scheduled mailings.\n", runs)
+}
+
+// handleScheduledMail lists the current event's scheduled mailings, with a
+// form to add one.
+func handleScheduledMail(ctx context.Context, wr WrappedRequest) {
+	renderScheduledMail(ctx, wr, "")
+}
+
+func renderScheduledMail(ctx context.Context, wr WrappedRequest, errorMessage string) {
+	var schedules []*ScheduledMailing
+	q := dsclient.NewQuery("ScheduledMailing").Ancestor(wr.EventKey)
+	keys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &schedules)
+	if err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Fetching scheduled mailings: %v", err), http.StatusInternalServerError)
+		return
+	}
+	type ScheduleRow struct {
+		Key        string
+		Schedule   *ScheduledMailing
+		Recipients string
+		When       string
+		NextRun    time.Time
+		LastRun    time.Time
+		Finished   bool
+	}
+	var rows []ScheduleRow
+	now := time.Now()
+	for i, schedule := range schedules {
+		next, ok := schedule.NextRun(wr.Event, now)
+		rows = append(rows, ScheduleRow{
+			Key:        keys[i].Encode(),
+			Schedule:   schedule,
+			Recipients: schedule.Segment.Description(wr.Event),
+			When:       schedule.Description(wr.Event),
+			NextRun:    next.In(siteLocation),
+			LastRun:    schedule.LastRun.In(siteLocation),
+			Finished:   !ok,
+		})
+	}
+
+	sort.Slice(rows, func(a, b int) bool { return rows[a].Schedule.Created.Before(rows[b].Schedule.Created) })
+
+	tpl := template.Must(template.New("").ParseFiles("templates/main.html", "templates/scheduledMail.html", "templates/segmentFields.html"))
+	// Choosing a template reloads the page, to start the segment out with
+	// the template's recipients.
+	templates := getMailTemplates(ctx, wr)
+	templateName := wr.Request.FormValue("emailTemplate")
+	if templateName == "" && len(templates) > 0 {
+		templateName = templates[0].Name
+	}
+	var segment Segment
+	for _, t := range templates {
+		if t.Name == templateName {
+			segment = defaultSegment(t.RecipientType())
+		}
+	}
+	data := wr.MakeTemplateData(segmentFormData(ctx, wr, segment, map[string]interface{}{
+		"Schedules":     rows,
+		"Templates":     templates,
+		"TemplateName":  templateName,
+		"ScheduleKinds": AllScheduleKinds(),
+		"TimeZone":      siteLocation.String(),
+		"Error":         errorMessage,
+	}))
+	if errorMessage != "" {
+		wr.ResponseWriter.WriteHeader(http.StatusBadRequest)
+	}
+	if err := tpl.ExecuteTemplate(wr.ResponseWriter, "scheduledMail.html", data); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+// scheduleFromForm reads a ScheduledMailing from the form on the
+// scheduled mail page.
+func scheduleFromForm(wr WrappedRequest) (*ScheduledMailing, error) {
+	form := wr.Request.PostForm
+	schedule := &ScheduledMailing{Template: form.Get("emailTemplate"), Created: time.Now()}
+	if schedule.Template == "" {
+		return nil, fmt.Errorf("choose an email template")
+	}
+	if wr.User != nil {
+		schedule.CreatedBy = wr.User.Email
+	}
+	var err error
+	if schedule.Segment, err = segmentFromForm(form); err != nil {
+		return nil, err
+	}
+	kind, err := strconv.Atoi(form.Get("kind"))
+	if err != nil || kind < int(SendAtTime) || kind > int(BeforeStart) {
+		return nil, fmt.Errorf("invalid schedule kind %q", form.Get("kind"))
+	}
+	schedule.Kind = ScheduleKind(kind)
+	if schedule.Kind == SendAtTime {
+		if schedule.SendAt, err = time.ParseInLocation("2006-01-02T15:04", form.Get("sendAt"), siteLocation); err != nil {
+			return nil, fmt.Errorf("invalid send time %q", form.Get("sendAt"))
+		}
+	} else {
+		if schedule.DaysBefore, err = strconv.Atoi(form.Get("daysBefore")); err != nil || schedule.DaysBefore < 0 {
+			return nil, fmt.Errorf("invalid number of days before %q", form.Get("daysBefore"))
+		}
+		if schedule.Kind == BeforeRsvpDeadline && wr.Event.RsvpDeadline.IsZero() {
+			return nil, fmt.Errorf("the event has no RSVP deadline; set one on the events page first")
+		}
+	}
+	if repeat := form.Get("repeatDays"); repeat != "" {
+		if schedule.RepeatDays, err = strconv.Atoi(repeat); err != nil || schedule.RepeatDays < 0 {
+			return nil, fmt.Errorf("invalid repeat interval %q", repeat)
+		}
+	}
+	return schedule, nil
+}
+
+func handleSaveScheduledMail(ctx context.Context, wr WrappedRequest) {
+	if wr.Method != "POST" {
+		http.Error(wr.ResponseWriter, "Invalid GET on save scheduled mail handler.", http.StatusBadRequest)
+		return
+	}
+	wr.Request.ParseForm()
+	schedule, err := scheduleFromForm(wr)
+	if err != nil {
+		renderScheduledMail(ctx, wr, err.Error())
+		return
+	}
+	key := datastore.IncompleteKey("ScheduledMailing", wr.EventKey)
+	if _, err := dsclient.FromContext(ctx).Put(ctx, key, schedule); err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Saving scheduled mailing: %v", err), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(wr.ResponseWriter, wr.Request, "scheduledMail", http.StatusSeeOther)
+}
+
+func handleDeleteScheduledMail(ctx context.Context, wr WrappedRequest) {
+	if wr.Method != "POST" {
+		http.Error(wr.ResponseWriter, "Invalid GET on delete scheduled mail handler.", http.StatusBadRequest)
+		return
+	}
+	key, err := datastore.DecodeKey(wr.Request.FormValue("schedule"))
+	if err != nil || key.Kind != "ScheduledMailing" {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Invalid scheduled mailing: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := dsclient.FromContext(ctx).Delete(ctx, key); err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Deleting scheduled mailing: %v", err), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(wr.ResponseWriter, wr.Request, "scheduledMail", http.StatusSeeOther)
+}
@@ -0,0 +1,109 @@
+package conju
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cshabsin/conju/model/event"
+)
+
+func TestNextRun(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 6, d, 0, 0, 0, 0, time.UTC) }
+	at := func(d int) time.Time { return day(d).Add(reminderTime) }
+	ev := &event.Event{RsvpDeadline: day(10), StartDate: day(20)}
+
+	for _, tc := range []struct {
+		name     string
+		schedule ScheduledMailing
+		now      time.Time
+		want     time.Time // zero if it shouldn't run
+	}{
+		{
+			name:     "before deadline",
+			schedule: ScheduledMailing{Kind: BeforeRsvpDeadline, DaysBefore: 3},
+			now:      day(1),
+			want:     at(7),
+		},
+		{
+			name:     "created inside the window",
+			schedule: ScheduledMailing{Kind: BeforeRsvpDeadline, DaysBefore: 3},
+			now:      day(9),
+			want:     at(7),
+		},
+		{
+			name:     "created after the deadline",
+			schedule: ScheduledMailing{Kind: BeforeRsvpDeadline, DaysBefore: 3},
+			now:      day(11),
+		},
+		{
+			name:     "created after the start",
+			schedule: ScheduledMailing{Kind: BeforeStart, DaysBefore: 1},
+			now:      day(21),
+		},
+		{
+			name:     "repeat",
+			schedule: ScheduledMailing{Kind: BeforeRsvpDeadline, DaysBefore: 6, RepeatDays: 2, Runs: 1, LastRun: at(4)},
+			now:      at(4),
+			want:     at(6),
+		},
+		{
+			name:     "repeat past the deadline",
+			schedule: ScheduledMailing{Kind: BeforeRsvpDeadline, DaysBefore: 6, RepeatDays: 7, Runs: 1, LastRun: at(4)},
+			now:      at(4),
+		},
+		{
+			name:     "no repeat",
+			schedule: ScheduledMailing{Kind: BeforeStart, DaysBefore: 6, Runs: 1, LastRun: at(14)},
+			now:      at(14),
+		},
+		{
+			name:     "set time after the start",
+			schedule: ScheduledMailing{Kind: SendAtTime, SendAt: at(25)},
+			now:      day(22),
+			want:     at(25),
+		},
+		{
+			name:     "set time repeats stop at the start",
+			schedule: ScheduledMailing{Kind: SendAtTime, SendAt: at(15), RepeatDays: 7, Runs: 1, LastRun: at(15)},
+			now:      at(15),
+		},
+	} {
+		got, ok := tc.schedule.NextRun(ev, tc.now)
+		if ok != !tc.want.IsZero() || !got.Equal(tc.want) {
+			t.Errorf("%s: NextRun = %v, %v; want %v", tc.name, got, ok, tc.want)
+		}
+	}
+
+	// Reminders relative to a date the event doesn't have never run.
+	noDeadline := &event.Event{StartDate: day(20)}
+	schedule := ScheduledMailing{Kind: BeforeRsvpDeadline, DaysBefore: 3}
+	if got, ok := schedule.NextRun(noDeadline, day(1)); ok {
+		t.Errorf("NextRun without an RSVP deadline = %v, want none", got)
+	}
+}
+
+func TestScheduleFromFormSendAt(t *testing.T) {
+	s := newTestSite(t)
+	for sendAt, want := range map[string]time.Time{
+		// Send times are Eastern, summer and winter.
+		"2025-06-10T09:30": time.Date(2025, 6, 10, 13, 30, 0, 0, time.UTC),
+		"2025-12-10T09:30": time.Date(2025, 12, 10, 14, 30, 0, 0, time.UTC),
+	} {
+		form := url.Values{
+			"emailTemplate": {"please_rsvp"},
+			"kind":          {strconv.Itoa(int(SendAtTime))},
+			"sendAt":        {sendAt},
+		}
+		wr, _ := s.request(t, "single_adult", true, "POST", "/saveScheduledMail", form)
+		wr.Request.ParseForm()
+		schedule, err := scheduleFromForm(wr)
+		if err != nil {
+			t.Fatalf("%s: %v", sendAt, err)
+		}
+		if !schedule.SendAt.Equal(want) {
+			t.Errorf("%s: SendAt = %v, want %v", sendAt, schedule.SendAt, want)
+		}
+	}
+}
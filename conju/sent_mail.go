@@ -0,0 +1,106 @@
+package conju
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/conju/mailer"
+	"github.com/cshabsin/conju/model/person"
+)
+
+// Mail sent to a person, whether directly or through the mail queue, is
+// recorded as a SentMail under their Person, so that we can tell what
+// they've been sent without searching the archive group. Test sends, which
+// go to the admin, aren't recorded.
+
+// SentMail records a message sent (or given up on) to one person.
+type SentMail struct {
+	Template   string
+	Event      *datastore.Key
+	Invitation *datastore.Key // nil if the mail wasn't about an invitation
+	Mailing    *datastore.Key // nil if the mail didn't go through the queue
+	Email      string         // the address it was sent to
+	Subject    string         `datastore:",noindex"`
+	Sent       time.Time
+	MessageID  string // the transport's id for the message
+	Status     MailStatus
+	Error      string `datastore:",noindex"`
+
+	// The latest delivery report for the message (see mail_events.go).
+	Delivery       mailer.DeliveryKind
+	DeliveryTime   time.Time
+	DeliveryReason string `datastore:",noindex"`
+}
+
+// directSentMail describes msg, sent straight to email from the current
+// event's template, with the result of sending it.
+func directSentMail(wr WrappedRequest, template, email string, msg *mailer.Message, messageID string, sendErr error) *SentMail {
+	sent := &SentMail{
+		Template:  template,
+		Event:     wr.EventKey,
+		Email:     email,
+		Subject:   msg.Subject,
+		Sent:      time.Now(),
+		MessageID: messageID,
+		Status:    MailSent,
+	}
+	if sendErr != nil {
+		sent.Status = MailFailed
+		sent.Error = sendErr.Error()
+	}
+	return sent
+}
+
+// recordSentMail saves sent under personKey. A queued message is recorded
+// under the name of its job, so that it's only recorded once; other
+// messages pass a nil jobKey. Errors are logged, since the mail has been
+// sent either way.
+func recordSentMail(ctx context.Context, personKey, jobKey *datastore.Key, sent *SentMail) {
+	if personKey == nil {
+		return
+	}
+	key := datastore.IncompleteKey("SentMail", personKey)
+	if jobKey != nil {
+		key = datastore.NameKey("SentMail", jobKey.Encode(), personKey)
+	}
+	if _, err := dsclient.FromContext(ctx).Put(ctx, key, sent); err != nil {
+		log.Printf("Recording mail to %v: %v", personKey, err)
+	}
+}
+
+// getSentMail returns everything the person has been sent, newest first.
+func getSentMail(ctx context.Context, personKey *datastore.Key) ([]*SentMail, error) {
+	var sent []*SentMail
+	q := dsclient.NewQuery("SentMail").Ancestor(personKey)
+	if _, err := dsclient.FromContext(ctx).GetAll(ctx, q, &sent); err != nil {
+		return nil, err
+	}
+	sort.Slice(sent, func(a, b int) bool { return sent[a].Sent.After(sent[b].Sent) })
+	return sent, nil
+}
+
+// PersonSentMail is a person's mail history, for the invitation page.
+type PersonSentMail struct {
+	Person *person.Person
+	Mail   []*SentMail
+}
+
+// getInvitationSentMail returns the mail history of each of the
+// invitation's invitees.
+func getInvitationSentMail(ctx context.Context, realizedInvitation RealizedInvitation) []PersonSentMail {
+	var history []PersonSentMail
+	for _, invitee := range realizedInvitation.Invitees {
+		sent, err := getSentMail(ctx, invitee.Person.DatastoreKey)
+		if err != nil {
+			log.Printf("Fetching mail sent to %v: %v", invitee.Person.DatastoreKey, err)
+		}
+		p := invitee.Person
+		history = append(history, PersonSentMail{Person: &p, Mail: sent})
+	}
+	return history
+}
@@ -10,11 +10,11 @@ import (
 
 	"cloud.google.com/go/datastore"
 	"github.com/gorilla/sessions"
-	"github.com/sendgrid/sendgrid-go"
 	"google.golang.org/appengine/v2"
 	"google.golang.org/appengine/v2/user"
 
 	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/conju/mailer"
 	"github.com/cshabsin/conju/model/event"
 )
 
@@ -23,8 +23,8 @@ import (
 var store = sessions.NewCookieStore([]byte("devmode_key_crsdms"))
 
 type WrappedRequest struct {
-	EmailClient     *sendgrid.Client
-	DatastoreClient *datastore.Client
+	MailTransport   mailer.Transport
+	DatastoreClient dsclient.Client
 
 	ResponseWriter WrappedResponseWriter
 	*http.Request
@@ -66,7 +66,8 @@ func (dpe DoneProcessingError) Error() string {
 }
 
 type Sessionizer struct {
-	Client *datastore.Client
+	Client        dsclient.Client
+	MailTransport mailer.Transport
 }
 
 func (s Sessionizer) AddSessionHandler(url string, f func(context.Context, WrappedRequest)) *Getters {
@@ -94,6 +95,7 @@ func (s Sessionizer) AddSessionHandler(url string, f func(context.Context, Wrapp
 				"User": u,
 			},
 			DatastoreClient: s.Client,
+			MailTransport:   s.MailTransport,
 		}
 		if u != nil {
 			logoutUrl, err := user.LogoutURL(ctx, wr.URL.RequestURI())
@@ -183,13 +185,6 @@ func (w WrappedRequest) MakeTemplateData(extraVals map[string]interface{}) map[s
 	return vals
 }
 
-func (w *WrappedRequest) GetEmailClient() *sendgrid.Client {
-	if w.EmailClient == nil {
-		w.EmailClient = sendgrid.NewSendClient(os.Getenv("SENDGRID_API_KEY"))
-	}
-	return w.EmailClient
-}
-
 // Also receives the rsvp change status.
 func (w WrappedRequest) GetSenderAddress() string {
 	return os.Getenv("SENDER_ADDRESS")
@@ -278,7 +273,7 @@ func (wr *WrappedRequest) GetBookingInfo(ctx context.Context) *BookingInfo {
 	}
 	// Load all bookings for the event.
 	var bookings []Booking
-	q := datastore.NewQuery("Booking").Ancestor(wr.EventKey)
+	q := dsclient.NewQuery("Booking").Ancestor(wr.EventKey)
 	allBookingKeys, err := client.GetAll(ctx, q, &bookings)
 	if err != nil {
 		log.Printf("Error reading all booking keys: %v", err)
@@ -0,0 +1,115 @@
+package statement
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// Candidate is an invitation a payment might belong to.
+type Candidate struct {
+	ID      string   // identifies the invitation to the caller
+	Names   []string // full names of the invitees, in any order
+	Emails  []string
+	Balance float64 // amount still owed
+}
+
+// Proposal is a candidate with how well it matches a transaction.
+type Proposal struct {
+	Candidate *Candidate
+	Score     int
+	Reasons   []string
+}
+
+// Scores for each kind of evidence. A proposal needs at least MinScore to
+// be preselected.
+const (
+	emailScore    = 4
+	nameScore     = 3
+	lastNameScore = 1
+	amountScore   = 2
+
+	MinScore = 3
+)
+
+// Match ranks the candidates for a transaction, best first. Candidates
+// with no evidence at all are left out.
+func Match(t Transaction, candidates []*Candidate) []Proposal {
+	var proposals []Proposal
+	payerName := normalizeName(t.Name)
+	payerWords := strings.Fields(payerName)
+	for _, c := range candidates {
+		p := Proposal{Candidate: c}
+		if t.Email != "" {
+			for _, email := range c.Emails {
+				if strings.EqualFold(email, t.Email) {
+					p.Score += emailScore
+					p.Reasons = append(p.Reasons, "email")
+					break
+				}
+			}
+		}
+		if len(payerWords) > 0 {
+			bestName := 0
+			for _, name := range c.Names {
+				words := strings.Fields(normalizeName(name))
+				if len(words) == 0 {
+					continue
+				}
+				if strings.Join(words, " ") == payerName ||
+					(words[0] == payerWords[0] && words[len(words)-1] == payerWords[len(payerWords)-1]) {
+					bestName = nameScore
+					break
+				}
+				if words[len(words)-1] == payerWords[len(payerWords)-1] {
+					bestName = lastNameScore
+				}
+			}
+			p.Score += bestName
+			switch bestName {
+			case nameScore:
+				p.Reasons = append(p.Reasons, "name")
+			case lastNameScore:
+				p.Reasons = append(p.Reasons, "last name")
+			}
+		}
+		if c.Balance > 0 && math.Abs(c.Balance-t.Amount) < 0.01 {
+			p.Score += amountScore
+			p.Reasons = append(p.Reasons, "amount")
+		}
+		if p.Score > 0 {
+			proposals = append(proposals, p)
+		}
+	}
+	sort.SliceStable(proposals, func(a, b int) bool {
+		return proposals[a].Score > proposals[b].Score
+	})
+	return proposals
+}
+
+// Best returns the proposal to preselect, or nil if no candidate is a
+// convincing match or the top two are tied.
+func Best(proposals []Proposal) *Proposal {
+	if len(proposals) == 0 || proposals[0].Score < MinScore {
+		return nil
+	}
+	if len(proposals) > 1 && proposals[1].Score == proposals[0].Score {
+		return nil
+	}
+	return &proposals[0]
+}
+
+// normalizeName lowercases a name and strips punctuation, so that
+// "O'Brien, Jr." and "obrien jr" compare equal.
+func normalizeName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r > 127:
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '\t':
+			b.WriteRune(' ')
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
@@ -0,0 +1,221 @@
+// Package statement reads the CSV transaction exports from the services
+// guests pay through (Venmo, PayPal, Google Wallet) and proposes which
+// invitation each payment belongs to.
+package statement
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Source int
+
+const (
+	Unknown Source = iota
+	Venmo
+	PayPal
+	GoogleWallet
+)
+
+func (s Source) String() string {
+	switch s {
+	case Venmo:
+		return "Venmo"
+	case PayPal:
+		return "PayPal"
+	case GoogleWallet:
+		return "Google Wallet"
+	}
+	return "Unknown"
+}
+
+// Transaction is one incoming payment from a statement.
+type Transaction struct {
+	Source Source
+	ID     string // the service's transaction ID, if the export has one
+	Date   time.Time
+	Name   string // payer's name
+	Email  string // payer's email, if the export has one
+	Amount float64
+	Note   string
+}
+
+// Reference identifies the transaction across imports, so a statement
+// that overlaps an earlier one doesn't record the same payment twice.
+func (t Transaction) Reference() string {
+	if t.ID == "" {
+		return ""
+	}
+	return t.Source.String() + ":" + t.ID
+}
+
+// columns maps the fields we read to the header names each service uses.
+var columns = map[string][]string{
+	"id":     {"ID", "Transaction ID"},
+	"date":   {"Datetime", "Date", "Transaction Date", "Time"},
+	"name":   {"From", "Name", "Sender", "Counterparty"},
+	"email":  {"From Email Address", "Email", "Sender Email"},
+	"amount": {"Amount (total)", "Gross", "Amount"},
+	"note":   {"Note", "Subject", "Memo", "Description", "Item Title"},
+	"status": {"Status"},
+	"type":   {"Type"},
+}
+
+var dateLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"01/02/2006",
+	"1/2/2006",
+	"Jan 2, 2006",
+}
+
+// Parse reads a statement export, detecting which service produced it from
+// its header row. Only completed incoming payments are returned; transfers
+// out, fees and pending transactions are skipped.
+func Parse(r io.Reader) ([]Transaction, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV: %w", err)
+	}
+
+	// Venmo statements start with a few lines of account summary, so look
+	// for the first row that names the columns we need.
+	headerRow := -1
+	var index map[string]int
+	for i, row := range rows {
+		index = columnIndex(row)
+		if _, ok := index["amount"]; !ok {
+			continue
+		}
+		if _, ok := index["date"]; !ok {
+			continue
+		}
+		headerRow = i
+		break
+	}
+	if headerRow < 0 {
+		return nil, errors.New("no header row with date and amount columns")
+	}
+	source := detectSource(rows[headerRow])
+	if source == Unknown {
+		return nil, fmt.Errorf("unrecognized statement columns %q; expected a Venmo, PayPal or Google Wallet export", rows[headerRow])
+	}
+
+	var transactions []Transaction
+	for _, row := range rows[headerRow+1:] {
+		get := func(field string) string {
+			i, ok := index[field]
+			if !ok || i >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[i])
+		}
+		if get("amount") == "" || get("date") == "" {
+			// Blank and summary lines.
+			continue
+		}
+		if status := strings.ToLower(get("status")); status != "" && !strings.HasPrefix(status, "complete") {
+			continue
+		}
+		if strings.Contains(strings.ToLower(get("type")), "fee") {
+			continue
+		}
+		amount, err := parseAmount(get("amount"))
+		if err != nil {
+			return nil, err
+		}
+		if amount <= 0 {
+			continue
+		}
+		date, err := parseDate(get("date"))
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, Transaction{
+			Source: source,
+			ID:     get("id"),
+			Date:   date,
+			Name:   get("name"),
+			Email:  strings.ToLower(get("email")),
+			Amount: amount,
+			Note:   get("note"),
+		})
+	}
+	return transactions, nil
+}
+
+func columnIndex(header []string) map[string]int {
+	index := make(map[string]int)
+	for field, names := range columns {
+		// Earlier names in the list are preferred.
+	names:
+		for _, name := range names {
+			for i, column := range header {
+				if strings.EqualFold(strings.TrimSpace(column), name) {
+					index[field] = i
+					break names
+				}
+			}
+		}
+	}
+	return index
+}
+
+// detectSource identifies the service from columns only its exports have.
+func detectSource(header []string) Source {
+	has := func(name string) bool {
+		for _, column := range header {
+			if strings.EqualFold(strings.TrimSpace(column), name) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case has("Amount (total)") || has("Datetime"):
+		return Venmo
+	case has("Gross") || has("From Email Address"):
+		return PayPal
+	case has("Memo") || has("Counterparty") || has("Sender"):
+		return GoogleWallet
+	}
+	return Unknown
+}
+
+// parseAmount handles the formats the services use: "+ $50.00",
+// "- $1,200.00", "50.00" and "(50.00)".
+func parseAmount(s string) (float64, error) {
+	negative := strings.HasPrefix(s, "-") || strings.HasPrefix(s, "(")
+	cleaned := strings.Map(func(r rune) rune {
+		if (r >= '0' && r <= '9') || r == '.' {
+			return r
+		}
+		return -1
+	}, s)
+	amount, err := strconv.ParseFloat(cleaned, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing amount %q: %w", s, err)
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %q", s)
+}
@@ -0,0 +1,159 @@
+package statement
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const venmoCSV = `Account Statement - (@Chris-Shabsin) ,,,,,,,,,,
+Account Activity,,,,,,,,,,
+,ID,Datetime,Type,Status,Note,From,To,Amount (total),Amount (fee),Funding Source
+,,,,,,,,,,
+,3901,2024-06-01T14:02:11,Payment,Complete,PSR rooms,Dana Scott,Chris Shabsin,+ $353.16,,
+,3902,2024-06-02T09:15:00,Payment,Complete,pizza,Chris Shabsin,Lydia Shabsin,- $20.00,,Venmo balance
+,3903,2024-06-03T10:00:00,Payment,Pending,PSR,Rick Shabsin,Chris Shabsin,+ $100.00,,
+,,,,,,,,,,
+,,,,,,,,$333.16,,
+`
+
+const paypalCSV = `"Date","Time","TimeZone","Name","Type","Status","Currency","Gross","Fee","Net","From Email Address","To Email Address","Transaction ID"
+"06/04/2024","10:11:12","PDT","Richard Shabsin","General Payment","Completed","USD","1,272.50","0.00","1,272.50","Rick@Example.com","chris@example.com","9AB12345CD"
+"06/04/2024","10:11:13","PDT","PayPal","Fee","Completed","USD","-3.00","0.00","-3.00","","","9AB12346CD"
+`
+
+const walletCSV = `Date,Name,Amount,Memo
+"Jun 5, 2024",Lydia Shabsin,50.00,deposit
+`
+
+func TestParse(t *testing.T) {
+	type TestCase struct {
+		Name string
+		CSV  string
+		Want []Transaction
+	}
+	testcases := []TestCase{
+		{
+			Name: "Venmo",
+			CSV:  venmoCSV,
+			Want: []Transaction{{
+				Source: Venmo,
+				ID:     "3901",
+				Date:   time.Date(2024, 6, 1, 14, 2, 11, 0, time.UTC),
+				Name:   "Dana Scott",
+				Amount: 353.16,
+				Note:   "PSR rooms",
+			}},
+		},
+		{
+			Name: "PayPal",
+			CSV:  paypalCSV,
+			Want: []Transaction{{
+				Source: PayPal,
+				ID:     "9AB12345CD",
+				Date:   time.Date(2024, 6, 4, 0, 0, 0, 0, time.UTC),
+				Name:   "Richard Shabsin",
+				Email:  "rick@example.com",
+				Amount: 1272.50,
+			}},
+		},
+		{
+			Name: "Google Wallet",
+			CSV:  walletCSV,
+			Want: []Transaction{{
+				Source: GoogleWallet,
+				Date:   time.Date(2024, 6, 5, 0, 0, 0, 0, time.UTC),
+				Name:   "Lydia Shabsin",
+				Amount: 50,
+				Note:   "deposit",
+			}},
+		},
+	}
+	for _, tc := range testcases {
+		got, err := Parse(strings.NewReader(tc.CSV))
+		if err != nil {
+			t.Errorf("%s: Parse: %v", tc.Name, err)
+			continue
+		}
+		if len(got) != len(tc.Want) {
+			t.Errorf("%s: got %d transactions (%+v), want %d", tc.Name, len(got), got, len(tc.Want))
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.Want[i] {
+				t.Errorf("%s: transaction %d was %+v, want %+v", tc.Name, i, got[i], tc.Want[i])
+			}
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	if _, err := Parse(strings.NewReader("Name,Note\nDana,hi\n")); err == nil {
+		t.Errorf("Parse without date and amount columns succeeded, want error")
+	}
+	if _, err := Parse(strings.NewReader("Date,Name,Amount,Memo\nyesterday,Dana,5,hi\n")); err == nil {
+		t.Errorf("Parse with bad date succeeded, want error")
+	}
+	if _, err := Parse(strings.NewReader("Date,Payee,Amount\n2024-06-05,Dana,5\n")); err == nil {
+		t.Errorf("Parse of an unrecognized export succeeded, want error")
+	}
+}
+
+func TestReference(t *testing.T) {
+	if ref := (Transaction{Source: PayPal, ID: "X1"}).Reference(); ref != "PayPal:X1" {
+		t.Errorf("Reference was %q, want %q", ref, "PayPal:X1")
+	}
+	if ref := (Transaction{Source: GoogleWallet}).Reference(); ref != "" {
+		t.Errorf("Reference without ID was %q, want empty", ref)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	scotts := &Candidate{ID: "scotts", Names: []string{"Dana Scott", "Chris Shabsin"}, Emails: []string{"dana@example.com"}, Balance: 353.16}
+	ricks := &Candidate{ID: "ricks", Names: []string{"Richard (Rick) Shabsin"}, Emails: []string{"rick@example.com"}, Balance: 272.50}
+	lydias := &Candidate{ID: "lydias", Names: []string{"Lydia Shabsin"}, Balance: 50}
+	candidates := []*Candidate{scotts, ricks, lydias}
+
+	type TestCase struct {
+		Name        string
+		Transaction Transaction
+		Want        string // ID of the best candidate, or "" for none
+	}
+	testcases := []TestCase{
+		{
+			Name:        "Name and amount",
+			Transaction: Transaction{Name: "Dana Scott", Amount: 353.16},
+			Want:        "scotts",
+		},
+		{
+			Name:        "Email beats shared last name",
+			Transaction: Transaction{Name: "R Shabsin", Email: "rick@example.com", Amount: 1272.50},
+			Want:        "ricks",
+		},
+		{
+			Name:        "Punctuation and case are ignored",
+			Transaction: Transaction{Name: "LYDIA  shabsin.", Amount: 10},
+			Want:        "lydias",
+		},
+		{
+			Name:        "Last name alone is ambiguous",
+			Transaction: Transaction{Name: "Sam Shabsin", Amount: 10},
+			Want:        "",
+		},
+		{
+			Name:        "Amount alone is not enough",
+			Transaction: Transaction{Name: "Someone Else", Amount: 272.50},
+			Want:        "",
+		},
+	}
+	for _, tc := range testcases {
+		best := Best(Match(tc.Transaction, candidates))
+		got := ""
+		if best != nil {
+			got = best.Candidate.ID
+		}
+		if got != tc.Want {
+			t.Errorf("%s: best match was %q, want %q", tc.Name, got, tc.Want)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"sort"
 	"strconv"
+	"strings"
 
 	"cloud.google.com/go/datastore"
 
@@ -18,8 +19,20 @@ import (
 
 func handleRoomingTool(ctx context.Context, wr WrappedRequest) {
 	var bookings []Booking
-	q := datastore.NewQuery("Booking").Ancestor(wr.EventKey)
-	bookingKeys, _ := dsclient.FromContext(ctx).GetAll(ctx, q, &bookings)
+	q := dsclient.NewQuery("Booking").Ancestor(wr.EventKey)
+	dsclient.FromContext(ctx).GetAll(ctx, q, &bookings)
+
+	var proposal *RoomingProposal
+	if wr.Request.FormValue("propose") != "" {
+		var err error
+		proposal, err = proposeRooming(ctx, wr, bookings)
+		if err != nil {
+			log.Printf("proposing rooming: %v", err)
+			http.Error(wr.ResponseWriter, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		bookings = proposal.Bookings
+	}
 
 	type BookingInfo struct {
 		Booking    Booking
@@ -34,9 +47,9 @@ func handleRoomingTool(ctx context.Context, wr WrappedRequest) {
 
 	wr.Event.LoadVenue(ctx)
 	buildingsMap := getBuildingMapForVenue(ctx, wr.Event.Venue.Key)
-	var buildingsInOrder []housing.Building
+	var buildingsInOrder []*housing.Building
 	var availableRooms []*housing.RealRoom
-	var buildingsToRooms = make(map[housing.Building][]*housing.RealRoom)
+	var buildingsToRooms = make(map[*housing.Building][]*housing.RealRoom)
 
 	for _, room := range wr.Event.Rooms {
 		var rm housing.Room
@@ -54,58 +67,51 @@ func handleRoomingTool(ctx context.Context, wr WrappedRequest) {
 			log.Printf("nil building in buildingsMap for building %v", buildingKey)
 			continue
 		}
-		if len(buildingsInOrder) == 0 || buildingsInOrder[len(buildingsInOrder)-1] != *building {
-			buildingsInOrder = append(buildingsInOrder, *building)
+		if len(buildingsInOrder) == 0 || buildingsInOrder[len(buildingsInOrder)-1] != building {
+			buildingsInOrder = append(buildingsInOrder, building)
 		}
 
-		bedstring := ""
-		for _, bed := range rm.Beds {
-			switch bed {
-			case housing.King:
-				bedstring += "K"
-			case housing.Queen:
-				bedstring += "Q"
-			case housing.Double:
-				bedstring += "D"
-			case housing.Twin:
-				bedstring += "T"
-			case housing.Cot:
-				bedstring += "C"
-
-			}
-		}
 		realRoom := &housing.RealRoom{
 			Room:       rm,
-			Building:   *buildingsMap[buildingKey.ID],
-			BedsString: bedstring,
+			Building:   *building,
+			BedsString: housing.BedsString(rm.Beds),
 		}
+		realRoom.Properties = attributeBits(wr.Event.Venue.Attributes, realRoom.AllAttributes())
 
-		buildingsToRooms[*building] = append(buildingsToRooms[*building], realRoom)
+		buildingsToRooms[building] = append(buildingsToRooms[building], realRoom)
 
 		availableRooms = append(availableRooms, realRoom)
 
-		roomStr := building.Code + "_" + strconv.Itoa(rm.RoomNumber)
-		if rm.Partition != "" {
-			roomStr += "_" + rm.Partition
-		}
-		roomStringMap[room.ID] = roomStr
+		roomStringMap[room.ID] = realRoom.RoomString()
 	}
 
+	lockedRooms := make(map[string]bool)
 	for i, booking := range bookings {
 		bookingInfos[i] = BookingInfo{Booking: booking, RoomString: roomStringMap[booking.Room.ID]}
 		for _, roommate := range booking.Roommates {
-			personToBooking[roommate.ID] = bookingKeys[i].ID
+			// Proposed bookings have no keys yet, so number them instead.
+			personToBooking[roommate.ID] = int64(i + 1)
+		}
+		if booking.Locked {
+			lockedRooms[bookingInfos[i].RoomString] = true
 		}
 	}
 
 	var invitations []*Invitation
-	q = datastore.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
+	q = dsclient.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
 	_, err := dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
 	if err != nil {
 		log.Printf("fetching invitations: %v", err)
 	}
 
-	statusOrder := []invitation.RsvpStatus{invitation.FriSatSun, invitation.FriSat, invitation.SatSun, invitation.Maybe}
+	// Only statuses that may need a room show up in the tool.
+	var statusOrder []invitation.RsvpStatus
+	for _, status := range wr.Event.RsvpStatusOrder() {
+		info := wr.Event.RsvpStatusInfo(status)
+		if (info.Attending && !info.NoLodging) || info.Undecided {
+			statusOrder = append(statusOrder, status)
+		}
+	}
 	adultPreferenceMask := GetAdultPreferenceMask()
 	rsvpToGroupsMap := make(map[invitation.RsvpStatus][][]person.Person)
 	var noRsvps [][]person.Person
@@ -156,12 +162,17 @@ func handleRoomingTool(ctx context.Context, wr WrappedRequest) {
 
 	sort.Slice(noRsvps, func(a, b int) bool { return person.SortByFirstName(noRsvps[a][0], noRsvps[b][0]) })
 
-	tpl := template.Must(template.New("").ParseFiles("templates/main.html", "templates/roomingTool.html"))
+	violations, err := checkRooming(ctx, wr, bookings)
+	if err != nil {
+		log.Printf("checking rooming: %v", err)
+	}
+
+	tpl := template.Must(template.New("").ParseFiles("templates/main.html", "templates/roomingTool.html", "templates/roomingViolations.html"))
 	data := wr.MakeTemplateData(map[string]interface{}{
 		"RsvpToGroupsMap":      rsvpToGroupsMap,
 		"NoRsvps":              noRsvps,
 		"StatusOrder":          statusOrder,
-		"AllRsvpStatuses":      invitation.GetAllRsvpStatuses(),
+		"AllRsvpStatuses":      wr.Event.RsvpStatusMap(),
 		"AvailableRooms":       availableRooms,
 		"BuildingsToRooms":     buildingsToRooms,
 		"BuildingsInOrder":     buildingsInOrder,
@@ -170,6 +181,9 @@ func handleRoomingTool(ctx context.Context, wr WrappedRequest) {
 		"AcceptableMask":       GetPreferenceTypeMask(Acceptable),
 		"BookingInfos":         bookingInfos,
 		"InvitationsToExplode": invitationsToExplode,
+		"LockedRooms":          lockedRooms,
+		"Proposal":             proposal,
+		"Violations":           violations,
 	})
 	if err := tpl.ExecuteTemplate(wr.ResponseWriter, "roomingTool.html", data); err != nil {
 		log.Printf("%v", err)
@@ -180,12 +194,6 @@ func handleRoomingTool(ctx context.Context, wr WrappedRequest) {
 func handleSaveRooming(ctx context.Context, wr WrappedRequest) {
 	wr.Request.ParseForm()
 
-	q := datastore.NewQuery("Booking").Ancestor(wr.EventKey).KeysOnly()
-	bookingKeys, _ := dsclient.FromContext(ctx).GetAll(ctx, q, nil)
-	// TODO: don't unilaterally delete all old bookings -- look for changes.
-	// (Will matter when saving booked state.)
-	dsclient.FromContext(ctx).DeleteMulti(ctx, bookingKeys)
-
 	wr.Event.LoadVenue(ctx)
 	buildingMap := getBuildingMapForVenue(ctx, wr.Event.Venue.Key)
 
@@ -207,15 +215,25 @@ func handleSaveRooming(ctx context.Context, wr WrappedRequest) {
 		if v[0] == "" {
 			continue
 		}
-		if k[0:12] == "roomingSlot_" {
-			personKey, _ := datastore.DecodeKey(string(k[12:]))
+		if strings.HasPrefix(k, "roomingSlot_") {
+			personKey, err := datastore.DecodeKey(string(k[12:]))
+			if err != nil {
+				http.Error(wr.ResponseWriter, "Invalid person key in "+k, http.StatusBadRequest)
+				return
+			}
+			// A room that isn't offered, e.g. from a page loaded before the
+			// event's rooms changed.
+			if _, ok := roomMap[v[0]]; !ok {
+				http.Error(wr.ResponseWriter, "Unknown room "+v[0], http.StatusBadRequest)
+				return
+			}
 			roommates := roomingMap[v[0]]
 			roomingMap[v[0]] = append(roommates, personKey)
 		}
 	}
 
 	var invitations []*Invitation
-	q = datastore.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
+	q := dsclient.NewQuery("Invitation").FilterField("Event", "=", wr.EventKey)
 	invitationKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &invitations)
 	if err != nil {
 		log.Printf("fetching invitations: %v", err)
@@ -241,7 +259,15 @@ func handleSaveRooming(ctx context.Context, wr WrappedRequest) {
 		personMap[peopleToLookUp[i].ID] = *person
 	}
 
+	var wanted []Booking
 	for rmStr, people := range roomingMap {
+		booking := Booking{
+			Event:     wr.EventKey,
+			Room:      roomMap[rmStr],
+			Locked:    wr.Request.PostForm.Get("lock_"+rmStr) == "on",
+			Roommates: people,
+		}
+		wanted = append(wanted, booking)
 		if len(people) == 0 {
 			continue
 		}
@@ -267,9 +293,12 @@ func handleSaveRooming(ctx context.Context, wr WrappedRequest) {
 			// really we want to sort by first person on each invitation, close enough for now.
 			return person.SortByLastFirstName(personMap[people[a].ID], personMap[people[b].ID])
 		})
+	}
 
-		booking := Booking{Event: wr.EventKey, Room: roomMap[rmStr], Roommates: people}
-		dsclient.FromContext(ctx).Put(ctx, datastore.IncompleteKey("Booking", wr.EventKey), &booking)
+	if err := saveBookings(ctx, wr, wanted); err != nil {
+		log.Printf("saving bookings: %v", err)
+		http.Error(wr.ResponseWriter, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
 	http.Redirect(wr.ResponseWriter, wr.Request, "rooming", http.StatusSeeOther)
@@ -278,7 +307,7 @@ func handleSaveRooming(ctx context.Context, wr WrappedRequest) {
 func getBuildingMapForVenue(ctx context.Context, venueKey *datastore.Key) map[int64]*housing.Building {
 	buildingsMap := make(map[int64]*housing.Building)
 	var buildings []*housing.Building
-	q := datastore.NewQuery("Building").Ancestor(venueKey)
+	q := dsclient.NewQuery("Building").Ancestor(venueKey)
 	keys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &buildings)
 
 	if err != nil {
@@ -0,0 +1,866 @@
+package conju
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/model/event"
+	"github.com/cshabsin/conju/model/housing"
+	"github.com/cshabsin/conju/model/venue"
+)
+
+// Venues, their buildings and the buildings' rooms are edited on /venues
+// and /building. A Building is a child of its venue and a Room of its
+// building, so neither can move once it's made. Events, bookings and
+// booking history refer to rooms by key, so a room any of them uses can't
+// be deleted, and its beds can't shrink below a booking's roommates.
+
+// attributeOption is an attribute checkbox on the building and room
+// forms. Unknown attributes are ones the building or room has that aren't
+// in the venue's catalog. Preference is the housing preference that
+// matches the attribute; it's empty for attributes that are only for
+// information.
+type attributeOption struct {
+	Name        string
+	Description string
+	Checked     bool
+	Unknown     bool
+	Preference  string
+}
+
+// attributePreference returns the report description of the housing
+// preference that the named attribute satisfies in the catalog, or "" if
+// it satisfies none.
+func attributePreference(catalog []housing.Attribute, name string) string {
+	if info, ok := housingPreferenceNamed(housing.PreferenceFor(catalog, name)); ok {
+		return info.ReportDescription
+	}
+	return ""
+}
+
+// roomSatisfies reports whether the room, or its building if it isn't
+// nil, has an attribute satisfying the housing preference.
+func roomSatisfies(catalog []housing.Attribute, building *housing.Building, room *housing.Room, b HousingPreferenceBoolean) bool {
+	attributes := room.AttributeNames()
+	if building != nil {
+		attributes = append(append([]string(nil), attributes...), building.AttributeNames()...)
+	}
+	return attributeBits(catalog, attributes)&b.Bit() != 0
+}
+
+// venueAttributes returns the attribute catalog of the event's venue.
+func venueAttributes(ctx context.Context, ev *event.Event) []housing.Attribute {
+	v, err := ev.LoadVenue(ctx)
+	if err != nil {
+		log.Printf("Loading venue: %v", err)
+		return housing.DefaultAttributes()
+	}
+	return v.Attributes
+}
+
+// attributeOptions returns a checkbox for each attribute in the catalog,
+// checked if it's one of names, followed by the names that aren't in the
+// catalog.
+func attributeOptions(catalog []housing.Attribute, names []string) []attributeOption {
+	has := make(map[string]bool)
+	for _, name := range names {
+		has[name] = true
+	}
+	var options []attributeOption
+	for _, attr := range catalog {
+		options = append(options, attributeOption{
+			Name:        attr.Name,
+			Description: attr.Description,
+			Checked:     has[attr.Name],
+			Preference:  attributePreference(catalog, attr.Name),
+		})
+		delete(has, attr.Name)
+	}
+	for _, name := range names {
+		if has[name] {
+			options = append(options, attributeOption{Name: name, Checked: true, Unknown: true, Preference: attributePreference(catalog, name)})
+		}
+	}
+	return options
+}
+
+// attributesFromForm returns the checked "attribute" names.
+func attributesFromForm(form url.Values) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range form["attribute"] {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] || strings.ContainsAny(name, ", \t") {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// catalogFromForm reads the venue's attribute catalog editor, rows of
+// "attributeName" and "attributeDescription". Empty rows are dropped.
+func catalogFromForm(form url.Values) []housing.Attribute {
+	names := form["attributeName"]
+	descriptions := form["attributeDescription"]
+	preferences := form["attributePreference"]
+	var catalog []housing.Attribute
+	for i, name := range names {
+		attr := housing.Attribute{Name: strings.TrimSpace(name)}
+		if i < len(descriptions) {
+			attr.Description = strings.TrimSpace(descriptions[i])
+		}
+		if i < len(preferences) {
+			attr.Preference = preferences[i]
+		}
+		if attr.Name == "" && attr.Description == "" {
+			continue
+		}
+		catalog = append(catalog, attr)
+	}
+	return catalog
+}
+
+// decodeKind decodes an encoded key from the form, which must be of the
+// given kind.
+func decodeKind(encoded, kind string) (*datastore.Key, error) {
+	key, err := datastore.DecodeKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if key.Kind != kind {
+		return nil, fmt.Errorf("%v is not a %s", key, kind)
+	}
+	return key, nil
+}
+
+// roomUses maps the encoded key of each of the rooms to descriptions of
+// what refers to it: events that offer it, and events with a booking or
+// booking history for it. It also returns the most roommates booked into
+// each room.
+func roomUses(ctx context.Context, rooms []*datastore.Key) (map[string][]string, map[string]int, error) {
+	client := dsclient.FromContext(ctx)
+	eventNames := make(map[string]string)
+	eventName := func(key *datastore.Key) string {
+		if name, ok := eventNames[key.Encode()]; ok {
+			return name
+		}
+		name := key.String()
+		if ev, err := event.GetEvent(ctx, key); err == nil {
+			name = ev.ShortName
+		} else {
+			log.Printf("Getting event %v: %v", key, err)
+		}
+		eventNames[key.Encode()] = name
+		return name
+	}
+
+	uses := make(map[string][]string)
+	mostBooked := make(map[string]int)
+	for _, room := range rooms {
+		encoded := room.Encode()
+		eventKeys, err := client.GetAll(ctx, dsclient.NewQuery("Event").FilterField("Rooms", "=", room).KeysOnly(), nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, key := range eventKeys {
+			uses[encoded] = append(uses[encoded], "offered by "+eventName(key))
+		}
+
+		var bookings []*Booking
+		keys, err := client.GetAll(ctx, dsclient.NewQuery("Booking").FilterField("Room", "=", room), &bookings)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, booking := range bookings {
+			uses[encoded] = append(uses[encoded], "booked for "+eventName(keys[i].Parent))
+			if len(booking.Roommates) > mostBooked[encoded] {
+				mostBooked[encoded] = len(booking.Roommates)
+			}
+		}
+
+		keys, err = client.GetAll(ctx, dsclient.NewQuery("BookingChange").FilterField("Room", "=", room).KeysOnly(), nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		inHistory := make(map[string]bool)
+		for _, key := range keys {
+			if inHistory[key.Parent.Encode()] {
+				continue
+			}
+			inHistory[key.Parent.Encode()] = true
+			uses[encoded] = append(uses[encoded], "in "+eventName(key.Parent)+"'s booking history")
+		}
+	}
+	return uses, mostBooked, nil
+}
+
+// handleVenues lists the venues. With "venue", it shows that venue's
+// form and buildings; otherwise a form for a new venue.
+func handleVenues(ctx context.Context, wr WrappedRequest) {
+	v := &venue.Venue{Attributes: housing.DefaultAttributes()}
+	if encoded := wr.Request.FormValue("venue"); encoded != "" {
+		key, err := decodeKind(encoded, "Venue")
+		if err != nil {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Invalid venue: %v", err), http.StatusBadRequest)
+			return
+		}
+		v, err = venue.FromKey(ctx, key)
+		if err != nil {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Getting venue: %v", err), http.StatusNotFound)
+			return
+		}
+	}
+	renderVenues(ctx, wr, v, "")
+}
+
+func renderVenues(ctx context.Context, wr WrappedRequest, editVenue *venue.Venue, errorMessage string) {
+	venues, err := venue.AllVenues(ctx)
+	if err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Fetching venues: %v", err), http.StatusInternalServerError)
+		return
+	}
+	sort.Slice(venues, func(a, b int) bool { return venues[a].Name < venues[b].Name })
+
+	type BuildingRow struct {
+		Key      string
+		Building *housing.Building
+		Rooms    int
+	}
+	var buildingRows []BuildingRow
+	if editVenue.Key != nil {
+		var buildings []*housing.Building
+		q := dsclient.NewQuery("Building").Ancestor(editVenue.Key).Order("Name")
+		keys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &buildings)
+		if err != nil {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Fetching buildings: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for i, building := range buildings {
+			q := dsclient.NewQuery("Room").Ancestor(keys[i]).KeysOnly()
+			roomKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, nil)
+			if err != nil {
+				log.Printf("Fetching rooms of %s: %v", building.Name, err)
+			}
+			buildingRows = append(buildingRows, BuildingRow{
+				Key:      keys[i].Encode(),
+				Building: building,
+				Rooms:    len(roomKeys),
+			})
+		}
+	}
+
+	data := wr.MakeTemplateData(map[string]interface{}{
+		"Venues":           venues,
+		"EditVenue":        editVenue,
+		"Buildings":        buildingRows,
+		"NewAttributeRows": []int{0, 1, 2},
+		"Preferences":      GetAllHousingPreferenceBooleans(),
+		"Error":            errorMessage,
+	})
+	functionMap := template.FuncMap{
+		"encodeKey": func(key *datastore.Key) string {
+			if key == nil {
+				return ""
+			}
+			return key.Encode()
+		},
+	}
+	tpl := template.Must(template.New("").Funcs(functionMap).ParseFiles("templates/main.html", "templates/venues.html"))
+	if err := tpl.ExecuteTemplate(wr.ResponseWriter, "venues.html", data); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+func handleSaveVenue(ctx context.Context, wr WrappedRequest) {
+	if wr.Method != "POST" {
+		http.Error(wr.ResponseWriter, "Invalid GET on save venue handler.", http.StatusBadRequest)
+		return
+	}
+	v := &venue.Venue{}
+	if encoded := wr.Request.FormValue("venue"); encoded != "" {
+		key, err := decodeKind(encoded, "Venue")
+		if err != nil {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Invalid venue: %v", err), http.StatusBadRequest)
+			return
+		}
+		v.Key = key
+	}
+	v.Name = strings.TrimSpace(wr.Request.FormValue("name"))
+	v.ShortName = strings.TrimSpace(wr.Request.FormValue("shortName"))
+	v.ContactPerson = strings.TrimSpace(wr.Request.FormValue("contactPerson"))
+	v.ContactPhone = strings.TrimSpace(wr.Request.FormValue("contactPhone"))
+	v.ContactEmail = strings.TrimSpace(wr.Request.FormValue("contactEmail"))
+	v.Website = strings.TrimSpace(wr.Request.FormValue("website"))
+	wr.Request.ParseForm()
+	v.Attributes = catalogFromForm(wr.Request.Form)
+
+	if err := validateVenue(ctx, v); err != nil {
+		wr.ResponseWriter.WriteHeader(http.StatusBadRequest)
+		renderVenues(ctx, wr, v, err.Error())
+		return
+	}
+	if err := venue.PutVenue(ctx, v); err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Saving venue: %v", err), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(wr.ResponseWriter, wr.Request, "venues?venue="+v.Key.Encode(), http.StatusSeeOther)
+}
+
+// validateVenue checks a venue before it's saved. Short names must be
+// unique, since the housing import files refer to venues by them.
+func validateVenue(ctx context.Context, v *venue.Venue) error {
+	if v.Name == "" || v.ShortName == "" {
+		return fmt.Errorf("A venue needs a name and a short name.")
+	}
+	if v.Website != "" {
+		if u, err := url.Parse(v.Website); err != nil || u.Host == "" {
+			return fmt.Errorf("Invalid website %q.", v.Website)
+		}
+	}
+	venues, err := venue.AllVenues(ctx)
+	if err != nil {
+		return err
+	}
+	for _, other := range venues {
+		if other.ShortName == v.ShortName && (v.Key == nil || !other.Key.Equal(v.Key)) {
+			return fmt.Errorf("Venue %s already has the short name %s.", other.Name, v.ShortName)
+		}
+	}
+	if err := housing.ValidateAttributes(v.Attributes); err != nil {
+		return fmt.Errorf("Invalid attributes: %v.", err)
+	}
+	for _, attr := range v.Attributes {
+		if _, ok := housingPreferenceNamed(attr.Preference); attr.Preference != "" && !ok {
+			return fmt.Errorf("Attribute %s satisfies unknown housing preference %q.", attr.Name, attr.Preference)
+		}
+	}
+	if v.Key == nil {
+		return nil
+	}
+	uses, err := attributeUses(ctx, v.Key)
+	if err != nil {
+		return err
+	}
+	for _, attr := range v.Attributes {
+		delete(uses, attr.Name)
+	}
+	var missing []string
+	for name, users := range uses {
+		missing = append(missing, fmt.Sprintf("%s (used by %s)", name, strings.Join(users, ", ")))
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("Attributes still in use can't be removed: %s.", strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+// attributeUses maps the names of the attributes the venue's buildings
+// and rooms have to the buildings and rooms that have them.
+func attributeUses(ctx context.Context, venueKey *datastore.Key) (map[string][]string, error) {
+	client := dsclient.FromContext(ctx)
+	var buildings []*housing.Building
+	keys, err := client.GetAll(ctx, dsclient.NewQuery("Building").Ancestor(venueKey), &buildings)
+	if err != nil {
+		return nil, err
+	}
+	uses := make(map[string][]string)
+	for i, building := range buildings {
+		for _, name := range building.AttributeNames() {
+			uses[name] = append(uses[name], building.Name)
+		}
+		rooms, _, err := buildingRooms(ctx, keys[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, room := range rooms {
+			for _, name := range room.AttributeNames() {
+				uses[name] = append(uses[name], roomName(room, building))
+			}
+		}
+	}
+	return uses, nil
+}
+
+// handleDeleteVenue deletes a venue that has no buildings and isn't any
+// event's venue.
+func handleDeleteVenue(ctx context.Context, wr WrappedRequest) {
+	if wr.Method != "POST" {
+		http.Error(wr.ResponseWriter, "Invalid GET on delete venue handler.", http.StatusBadRequest)
+		return
+	}
+	key, err := decodeKind(wr.Request.FormValue("venue"), "Venue")
+	if err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Invalid venue: %v", err), http.StatusBadRequest)
+		return
+	}
+	v, err := venue.FromKey(ctx, key)
+	if err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Getting venue: %v", err), http.StatusNotFound)
+		return
+	}
+	client := dsclient.FromContext(ctx)
+	buildingKeys, err := client.GetAll(ctx, dsclient.NewQuery("Building").Ancestor(key).KeysOnly(), nil)
+	if err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Fetching buildings: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if len(buildingKeys) > 0 {
+		wr.ResponseWriter.WriteHeader(http.StatusConflict)
+		renderVenues(ctx, wr, v, "Delete the venue's buildings first.")
+		return
+	}
+	events, err := event.GetAllEvents(ctx)
+	if err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Fetching events: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var users []string
+	for _, ev := range events {
+		if ev.VenueKey() != nil && ev.VenueKey().Equal(key) {
+			users = append(users, ev.ShortName)
+		}
+	}
+	if len(users) > 0 {
+		wr.ResponseWriter.WriteHeader(http.StatusConflict)
+		renderVenues(ctx, wr, v, fmt.Sprintf("The venue can't be deleted; it's the venue of %s.", strings.Join(users, ", ")))
+		return
+	}
+	if err := client.Delete(ctx, key); err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Deleting venue: %v", err), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(wr.ResponseWriter, wr.Request, "venues", http.StatusSeeOther)
+}
+
+// buildingPage is what the building page shows: the building's form, its
+// rooms, and the form for a new room or the one being edited.
+type buildingPage struct {
+	Venue       *venue.Venue
+	BuildingKey *datastore.Key // nil for a new building
+	Building    *housing.Building
+	RoomKey     *datastore.Key // nil for a new room
+	Room        *housing.Room
+	Beds        string // as entered, for the room form
+	Error       string
+}
+
+// handleBuilding shows the building in "building", or a form for a new
+// building of the venue in "venue". With "room", it edits that room of
+// the building.
+func handleBuilding(ctx context.Context, wr WrappedRequest) {
+	page := &buildingPage{Building: &housing.Building{}, Room: &housing.Room{}}
+	client := dsclient.FromContext(ctx)
+	if encoded := wr.Request.FormValue("building"); encoded != "" {
+		key, err := decodeKind(encoded, "Building")
+		if err != nil {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Invalid building: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := client.Get(ctx, key, page.Building); err != nil {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Getting building: %v", err), http.StatusNotFound)
+			return
+		}
+		page.BuildingKey = key
+		page.Building.Venue = key.Parent
+	} else {
+		key, err := decodeKind(wr.Request.FormValue("venue"), "Venue")
+		if err != nil {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Invalid venue: %v", err), http.StatusBadRequest)
+			return
+		}
+		page.Building.Venue = key
+	}
+	if encoded := wr.Request.FormValue("room"); encoded != "" && page.BuildingKey != nil {
+		key, err := decodeKind(encoded, "Room")
+		if err != nil || !key.Parent.Equal(page.BuildingKey) {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Invalid room: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := client.Get(ctx, key, page.Room); err != nil {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Getting room: %v", err), http.StatusNotFound)
+			return
+		}
+		page.RoomKey = key
+		page.Beds = housing.BedsString(page.Room.Beds)
+	}
+	renderBuilding(ctx, wr, page)
+}
+
+func renderBuilding(ctx context.Context, wr WrappedRequest, page *buildingPage) {
+	var err error
+	page.Venue, err = venue.FromKey(ctx, page.Building.Venue)
+	if err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Getting venue: %v", err), http.StatusNotFound)
+		return
+	}
+
+	type RoomRow struct {
+		Key  string
+		Room *housing.Room
+		Beds string
+		Uses []string
+	}
+	var roomRows []RoomRow
+	if page.BuildingKey != nil {
+		rooms, keys, err := buildingRooms(ctx, page.BuildingKey)
+		if err != nil {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Fetching rooms: %v", err), http.StatusInternalServerError)
+			return
+		}
+		uses, _, err := roomUses(ctx, keys)
+		if err != nil {
+			log.Printf("Finding room uses: %v", err)
+		}
+		for i, room := range rooms {
+			roomRows = append(roomRows, RoomRow{
+				Key:  keys[i].Encode(),
+				Room: room,
+				Beds: housing.BedsString(room.Beds),
+				Uses: uses[keys[i].Encode()],
+			})
+		}
+	}
+
+	data := wr.MakeTemplateData(map[string]interface{}{
+		"Page":               page,
+		"Rooms":              roomRows,
+		"BuildingAttributes": attributeOptions(page.Venue.Attributes, page.Building.AttributeNames()),
+		"RoomAttributes":     attributeOptions(page.Venue.Attributes, page.Room.AttributeNames()),
+	})
+	functionMap := template.FuncMap{
+		"join": strings.Join,
+		"encodeKey": func(key *datastore.Key) string {
+			if key == nil {
+				return ""
+			}
+			return key.Encode()
+		},
+	}
+	tpl := template.Must(template.New("").Funcs(functionMap).ParseFiles("templates/main.html", "templates/building.html"))
+	if err := tpl.ExecuteTemplate(wr.ResponseWriter, "building.html", data); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+// buildingRooms returns the building's rooms, in room number and
+// partition order.
+func buildingRooms(ctx context.Context, buildingKey *datastore.Key) ([]*housing.Room, []*datastore.Key, error) {
+	var rooms []*housing.Room
+	keys, err := dsclient.FromContext(ctx).GetAll(ctx, dsclient.NewQuery("Room").Ancestor(buildingKey), &rooms)
+	if err != nil {
+		return nil, nil, err
+	}
+	order := make([]int, len(rooms))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		ra, rb := rooms[order[a]], rooms[order[b]]
+		if ra.RoomNumber != rb.RoomNumber {
+			return ra.RoomNumber < rb.RoomNumber
+		}
+		return ra.Partition < rb.Partition
+	})
+	sortedRooms := make([]*housing.Room, len(rooms))
+	sortedKeys := make([]*datastore.Key, len(keys))
+	for i, j := range order {
+		sortedRooms[i] = rooms[j]
+		sortedKeys[i] = keys[j]
+	}
+	return sortedRooms, sortedKeys, nil
+}
+
+func handleSaveBuilding(ctx context.Context, wr WrappedRequest) {
+	if wr.Method != "POST" {
+		http.Error(wr.ResponseWriter, "Invalid GET on save building handler.", http.StatusBadRequest)
+		return
+	}
+	wr.Request.ParseForm()
+	form := wr.Request.Form
+	page := &buildingPage{Building: &housing.Building{}, Room: &housing.Room{}}
+	if encoded := form.Get("building"); encoded != "" {
+		key, err := decodeKind(encoded, "Building")
+		if err != nil {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Invalid building: %v", err), http.StatusBadRequest)
+			return
+		}
+		page.BuildingKey = key
+		page.Building.Venue = key.Parent
+	} else {
+		key, err := decodeKind(form.Get("venue"), "Venue")
+		if err != nil {
+			http.Error(wr.ResponseWriter, fmt.Sprintf("Invalid venue: %v", err), http.StatusBadRequest)
+			return
+		}
+		page.Building.Venue = key
+	}
+	page.Building.Name = strings.TrimSpace(form.Get("name"))
+	page.Building.Code = strings.TrimSpace(form.Get("code"))
+	page.Building.FloorplanImageUrl = strings.TrimSpace(form.Get("floorplanImageUrl"))
+	page.Building.Attributes = attributesFromForm(form)
+
+	if err := validateBuilding(ctx, page.BuildingKey, page.Building); err != nil {
+		page.Error = err.Error()
+		wr.ResponseWriter.WriteHeader(http.StatusBadRequest)
+		renderBuilding(ctx, wr, page)
+		return
+	}
+	key := page.BuildingKey
+	if key == nil {
+		key = datastore.IncompleteKey("Building", page.Building.Venue)
+	}
+	key, err := dsclient.FromContext(ctx).Put(ctx, key, page.Building)
+	if err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Saving building: %v", err), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(wr.ResponseWriter, wr.Request, "building?building="+key.Encode(), http.StatusSeeOther)
+}
+
+// validateBuilding checks a building before it's saved. Codes must be
+// unique across venues, since the event editor finds rooms by building
+// code, and can't contain "_", which separates the parts of a RoomString.
+func validateBuilding(ctx context.Context, key *datastore.Key, building *housing.Building) error {
+	if building.Name == "" || building.Code == "" {
+		return fmt.Errorf("A building needs a name and a code.")
+	}
+	if strings.ContainsAny(building.Code, "_ ") {
+		return fmt.Errorf("Building codes can't contain spaces or underscores.")
+	}
+	if building.FloorplanImageUrl != "" {
+		if _, err := url.Parse(building.FloorplanImageUrl); err != nil {
+			return fmt.Errorf("Invalid floorplan image URL %q.", building.FloorplanImageUrl)
+		}
+	}
+	var others []*housing.Building
+	q := dsclient.NewQuery("Building").FilterField("Code", "=", building.Code)
+	keys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &others)
+	if err != nil {
+		return err
+	}
+	for i, other := range others {
+		if key == nil || !keys[i].Equal(key) {
+			return fmt.Errorf("Building %s already has the code %s.", other.Name, building.Code)
+		}
+	}
+	return nil
+}
+
+// handleDeleteBuilding deletes a building that has no rooms.
+func handleDeleteBuilding(ctx context.Context, wr WrappedRequest) {
+	if wr.Method != "POST" {
+		http.Error(wr.ResponseWriter, "Invalid GET on delete building handler.", http.StatusBadRequest)
+		return
+	}
+	key, err := decodeKind(wr.Request.FormValue("building"), "Building")
+	if err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Invalid building: %v", err), http.StatusBadRequest)
+		return
+	}
+	client := dsclient.FromContext(ctx)
+	page := &buildingPage{BuildingKey: key, Building: &housing.Building{}, Room: &housing.Room{}}
+	if err := client.Get(ctx, key, page.Building); err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Getting building: %v", err), http.StatusNotFound)
+		return
+	}
+	page.Building.Venue = key.Parent
+	roomKeys, err := client.GetAll(ctx, dsclient.NewQuery("Room").Ancestor(key).KeysOnly(), nil)
+	if err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Fetching rooms: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if len(roomKeys) > 0 {
+		page.Error = "Delete the building's rooms first."
+		wr.ResponseWriter.WriteHeader(http.StatusConflict)
+		renderBuilding(ctx, wr, page)
+		return
+	}
+	if err := client.Delete(ctx, key); err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Deleting building: %v", err), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(wr.ResponseWriter, wr.Request, "venues?venue="+key.Parent.Encode(), http.StatusSeeOther)
+}
+
+// roomPage reads the building in "building", and the room in "room" if
+// there is one, for the room handlers.
+func roomPage(ctx context.Context, wr WrappedRequest) (*buildingPage, error) {
+	client := dsclient.FromContext(ctx)
+	buildingKey, err := decodeKind(wr.Request.FormValue("building"), "Building")
+	if err != nil {
+		return nil, fmt.Errorf("invalid building: %v", err)
+	}
+	page := &buildingPage{BuildingKey: buildingKey, Building: &housing.Building{}, Room: &housing.Room{}}
+	if err := client.Get(ctx, buildingKey, page.Building); err != nil {
+		return nil, fmt.Errorf("getting building: %v", err)
+	}
+	page.Building.Venue = buildingKey.Parent
+	if encoded := wr.Request.FormValue("room"); encoded != "" {
+		roomKey, err := decodeKind(encoded, "Room")
+		if err != nil {
+			return nil, fmt.Errorf("invalid room: %v", err)
+		}
+		if !roomKey.Parent.Equal(buildingKey) {
+			return nil, fmt.Errorf("room %v is not in building %s", roomKey, page.Building.Name)
+		}
+		if err := client.Get(ctx, roomKey, page.Room); err != nil {
+			return nil, fmt.Errorf("getting room: %v", err)
+		}
+		page.RoomKey = roomKey
+	}
+	return page, nil
+}
+
+func handleSaveRoom(ctx context.Context, wr WrappedRequest) {
+	if wr.Method != "POST" {
+		http.Error(wr.ResponseWriter, "Invalid GET on save room handler.", http.StatusBadRequest)
+		return
+	}
+	wr.Request.ParseForm()
+	form := wr.Request.Form
+	page, err := roomPage(ctx, wr)
+	if err != nil {
+		http.Error(wr.ResponseWriter, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page.Room = &housing.Room{
+		Building:    page.BuildingKey,
+		Description: strings.TrimSpace(form.Get("description")),
+		Partition:   strings.TrimSpace(form.Get("partition")),
+		Attributes:  attributesFromForm(form),
+	}
+	page.Beds = form.Get("beds")
+
+	if err := roomFromForm(form, page.Room); err != nil {
+		page.Error = err.Error()
+	} else if err := validateRoom(ctx, page.RoomKey, page.Room); err != nil {
+		page.Error = err.Error()
+	}
+	if page.Error != "" {
+		wr.ResponseWriter.WriteHeader(http.StatusBadRequest)
+		renderBuilding(ctx, wr, page)
+		return
+	}
+	key := page.RoomKey
+	if key == nil {
+		key = datastore.IncompleteKey("Room", page.BuildingKey)
+	}
+	if _, err := dsclient.FromContext(ctx).Put(ctx, key, page.Room); err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Saving room: %v", err), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(wr.ResponseWriter, wr.Request, "building?building="+page.BuildingKey.Encode(), http.StatusSeeOther)
+}
+
+// roomFromForm reads the room's number, beds and floorplan position from
+// the form.
+func roomFromForm(form url.Values, room *housing.Room) error {
+	var err error
+	room.RoomNumber, err = strconv.Atoi(strings.TrimSpace(form.Get("roomNumber")))
+	if err != nil || room.RoomNumber < 0 {
+		return fmt.Errorf("Invalid room number %q.", form.Get("roomNumber"))
+	}
+	room.Beds, err = housing.ParseBeds(form.Get("beds"))
+	if err != nil {
+		return fmt.Errorf("Invalid beds: %v.", err)
+	}
+	for _, field := range []struct {
+		name  string
+		value *int
+	}{
+		{"imageTop", &room.ImageTop},
+		{"imageLeft", &room.ImageLeft},
+		{"imageWidth", &room.ImageWidth},
+		{"imageHeight", &room.ImageHeight},
+	} {
+		value := strings.TrimSpace(form.Get(field.name))
+		if value == "" {
+			*field.value = 0
+			continue
+		}
+		*field.value, err = strconv.Atoi(value)
+		if err != nil || *field.value < 0 {
+			return fmt.Errorf("Invalid floorplan position %q; positions are pixels, 0 or more.", value)
+		}
+	}
+	return nil
+}
+
+// validateRoom checks a room before it's saved: it needs a bed, its
+// number and partition must be unique in its building, and it must still
+// sleep everyone booked into it.
+func validateRoom(ctx context.Context, key *datastore.Key, room *housing.Room) error {
+	if len(room.Beds) == 0 {
+		return fmt.Errorf("A room needs at least one bed.")
+	}
+	if strings.ContainsAny(room.Partition, "_ ") {
+		return fmt.Errorf("Partitions can't contain spaces or underscores.")
+	}
+	rooms, keys, err := buildingRooms(ctx, room.Building)
+	if err != nil {
+		return err
+	}
+	for i, other := range rooms {
+		if other.RoomNumber == room.RoomNumber && other.Partition == room.Partition && (key == nil || !keys[i].Equal(key)) {
+			return fmt.Errorf("The building already has a room %d%s.", room.RoomNumber, room.Partition)
+		}
+	}
+	if key != nil {
+		_, mostBooked, err := roomUses(ctx, []*datastore.Key{key})
+		if err != nil {
+			return err
+		}
+		if booked := mostBooked[key.Encode()]; room.Capacity() < booked {
+			return fmt.Errorf("%d people are booked into the room, but those beds sleep %d.", booked, room.Capacity())
+		}
+	}
+	return nil
+}
+
+// handleDeleteRoom deletes a room that no event, booking or booking
+// history refers to.
+func handleDeleteRoom(ctx context.Context, wr WrappedRequest) {
+	if wr.Method != "POST" {
+		http.Error(wr.ResponseWriter, "Invalid GET on delete room handler.", http.StatusBadRequest)
+		return
+	}
+	page, err := roomPage(ctx, wr)
+	if err != nil {
+		http.Error(wr.ResponseWriter, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if page.RoomKey == nil {
+		http.Error(wr.ResponseWriter, "No room to delete.", http.StatusBadRequest)
+		return
+	}
+	uses, _, err := roomUses(ctx, []*datastore.Key{page.RoomKey})
+	if err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Finding room uses: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if used := uses[page.RoomKey.Encode()]; len(used) > 0 {
+		page.Error = fmt.Sprintf("Room %d%s can't be deleted; it's %s.", page.Room.RoomNumber, page.Room.Partition, strings.Join(used, ", "))
+		page.Beds = housing.BedsString(page.Room.Beds)
+		wr.ResponseWriter.WriteHeader(http.StatusConflict)
+		renderBuilding(ctx, wr, page)
+		return
+	}
+	if err := dsclient.FromContext(ctx).Delete(ctx, page.RoomKey); err != nil {
+		http.Error(wr.ResponseWriter, fmt.Sprintf("Deleting room: %v", err), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(wr.ResponseWriter, wr.Request, "building?building="+page.BuildingKey.Encode(), http.StatusSeeOther)
+}
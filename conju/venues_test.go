@@ -0,0 +1,46 @@
+package conju
+
+import (
+	"testing"
+
+	"github.com/cshabsin/conju/model/housing"
+	"github.com/cshabsin/conju/model/venue"
+)
+
+func TestAttributeBits(t *testing.T) {
+	catalog := []housing.Attribute{
+		{Name: "ShareBed"},
+		{Name: "DoubleBed", Preference: "ShareBed"},
+		{Name: "Cottage", Preference: "WillingExpensive"},
+		{Name: "Lakeview"},
+	}
+	for _, tc := range []struct {
+		attributes []string
+		want       int
+	}{
+		{nil, 0},
+		{[]string{"ShareBed"}, 2},
+		{[]string{"DoubleBed"}, 2},
+		{[]string{"DoubleBed", "Cottage"}, 2 | 128},
+		{[]string{"Lakeview"}, 0},
+		// Not in the catalog, but named for a preference.
+		{[]string{"WillingExpensive"}, 128},
+	} {
+		if got := attributeBits(catalog, tc.attributes); got != tc.want {
+			t.Errorf("attributeBits(%v) = %d, want %d", tc.attributes, got, tc.want)
+		}
+	}
+}
+
+func TestValidateVenuePreference(t *testing.T) {
+	s := newTestSite(t)
+	v := &venue.Venue{Name: "Lake House", ShortName: "lake"}
+	v.Attributes = []housing.Attribute{{Name: "DoubleBed", Preference: "ShareBed"}}
+	if err := validateVenue(s.ctx, v); err != nil {
+		t.Errorf("known preference: %v", err)
+	}
+	v.Attributes = []housing.Attribute{{Name: "DoubleBed", Preference: "KingBed"}}
+	if err := validateVenue(s.ctx, v); err == nil {
+		t.Errorf("unknown preference was accepted")
+	}
+}
@@ -12,3 +12,20 @@ const (
 	SunBrk
 	SunLun
 )
+
+var mealNames = []string{"FriBrk", "FriLun", "FriDin", "SatBrk", "SatLun", "SatDin", "SunBrk", "SunLun"}
+
+func (m Meal) String() string {
+	if m < 0 || int(m) >= len(mealNames) {
+		return "Meal(?)"
+	}
+	return mealNames[m]
+}
+
+func GetAllMeals() []Meal {
+	var meals []Meal
+	for m := range mealNames {
+		meals = append(meals, Meal(m))
+	}
+	return meals
+}
@@ -1,9 +1,14 @@
 package invitation
 
-// Each event should have a list of acceptable RSVP statuses
+import "time"
+
+// Each event should have a list of acceptable RSVP statuses. The values are
+// persisted in invitations, so a status must never be renumbered once used.
 type RsvpStatus int
 
-// TODO: move most of these to the datastore.
+// These are the statuses from the default catalog, which events that have
+// not defined their own catalog still use. Events may add statuses of their
+// own beyond MealsOnly.
 
 const (
 	No RsvpStatus = iota
@@ -26,11 +31,23 @@ type RsvpStatusInfo struct {
 	Attending        bool
 	Undecided        bool
 	NoLodging        bool
-	BaseCost         [6]float64
-	AddOnCost        [6]float64
+	Nights           []time.Weekday // nights of lodging, by the weekday each night starts
 	Meals            []Meal
 }
 
+// CoversNight reports whether the status includes lodging for the night
+// starting on the given weekday.
+func (info RsvpStatusInfo) CoversNight(night time.Weekday) bool {
+	for _, n := range info.Nights {
+		if n == night {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAllRsvpStatuses returns the default RSVP catalog, indexed by status.
+// Per-event catalogs are stored with the event; see event.Event.RsvpCatalog.
 func GetAllRsvpStatuses() []RsvpStatusInfo {
 	return []RsvpStatusInfo{
 		{
@@ -51,37 +68,37 @@ func GetAllRsvpStatuses() []RsvpStatusInfo {
 			ShortDescription: "FriSat",
 			LongDescription:  "Will attend: Friday - Sunday",
 			Attending:        true,
-			// TODO: make costs a property of the event
-			BaseCost: [6]float64{0, 272.50, 176.58, 144.61, 128.62, 119.00},
-			Meals:    []Meal{FriDin, SatBrk, SatLun, SatDin, SunBrk, SunLun},
+			Nights:           []time.Weekday{time.Friday, time.Saturday},
+			Meals:            []Meal{FriDin, SatBrk, SatLun, SatDin, SunBrk, SunLun},
 		},
 		{
 			Status:           ThuFriSat,
 			ShortDescription: "ThuFriSat",
 			LongDescription:  "Will attend: Thursday - Sunday",
 			Attending:        true,
-			// TODO: make costs a property of the event
-			BaseCost:  [6]float64{0, 272.50, 176.58, 144.61, 128.62, 119.00},
-			AddOnCost: [6]float64{0, 124.26, 76.30, 60.31, 52.32, 47.52},
-			Meals:     []Meal{FriBrk, FriLun, FriDin, SatBrk, SatLun, SatDin, SunBrk, SunLun},
+			Nights:           []time.Weekday{time.Thursday, time.Friday, time.Saturday},
+			Meals:            []Meal{FriBrk, FriLun, FriDin, SatBrk, SatLun, SatDin, SunBrk, SunLun},
 		},
 		{
 			Status:           SatSun,
 			ShortDescription: "SatSun",
 			LongDescription:  "Will attend: Saturday - Monday",
 			Attending:        true,
+			Nights:           []time.Weekday{time.Saturday, time.Sunday},
 		},
 		{
 			Status:           FriSatSun,
 			ShortDescription: "FriSatSun",
 			LongDescription:  "Will attend: Friday - Monday",
 			Attending:        true,
+			Nights:           []time.Weekday{time.Friday, time.Saturday, time.Sunday},
 		},
 		{
 			Status:           FriSatPlusEither,
 			ShortDescription: "FriSatPlusEither",
 			LongDescription:  "Will attend: Friday - Sunday, plus either Thursday or Sunday nights",
 			Attending:        true,
+			Nights:           []time.Weekday{time.Friday, time.Saturday},
 		},
 		{
 			Status:           WeddingOnly,
@@ -95,12 +112,14 @@ func GetAllRsvpStatuses() []RsvpStatusInfo {
 			ShortDescription: "Fri",
 			LongDescription:  "Will attend: Friday - Saturday",
 			Attending:        true,
+			Nights:           []time.Weekday{time.Friday},
 		},
 		{
 			Status:           Sat,
 			ShortDescription: "Sat",
 			LongDescription:  "Will attend: Saturday - Sunday",
 			Attending:        true,
+			Nights:           []time.Weekday{time.Saturday},
 		},
 		{
 			Status:           MealsOnly,
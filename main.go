@@ -9,9 +9,23 @@ import (
 	"google.golang.org/appengine/v2"
 
 	"github.com/cshabsin/conju/conju"
+	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/conju/mailer"
 )
 
 func main() {
+	transport, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to set up mail: %v", err)
+	}
+
+	if os.Getenv("DATASTORE_BACKEND") == "memory" {
+		log.Printf("Using in-memory datastore; nothing will be persisted")
+		conju.Register(dsclient.NewMemoryClient(), transport)
+		appengine.Main()
+		return
+	}
+
 	ctx := context.Background()
 	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
 	if projectID == "" {
@@ -25,7 +39,7 @@ func main() {
 	}
 	defer datastoreClient.Close()
 
-	conju.Register(datastoreClient)
+	conju.Register(dsclient.NewCloudClient(datastoreClient), transport)
 	// poll.Register(datastoreClient)
 
 	appengine.Main()
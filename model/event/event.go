@@ -4,13 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"time"
 
 	"cloud.google.com/go/datastore"
 
 	"github.com/cshabsin/conju/conju/dsclient"
 	"github.com/cshabsin/conju/invitation"
+	"github.com/cshabsin/conju/model/pricing"
 	"github.com/cshabsin/conju/model/venue"
 )
 
@@ -22,11 +22,16 @@ type eventDB struct {
 	ShortName             string
 	StartDate             time.Time
 	EndDate               time.Time
+	RsvpDeadline          time.Time
 	RsvpStatuses          []invitation.RsvpStatus
+	RsvpCatalog           []rsvpStatusDB
+	Pricing               pricing.Schedule `datastore:",noindex"`
 	Rooms                 []*datastore.Key
 	Activities            []*datastore.Key
 	InvitationClosingText string
 	Current               bool
+	Hostnames             []string
+	BaseURL               string `datastore:",noindex"`
 }
 
 type Event struct {
@@ -38,11 +43,26 @@ type Event struct {
 	ShortName             string
 	StartDate             time.Time
 	EndDate               time.Time
-	RsvpStatuses          []invitation.RsvpStatus
-	Rooms                 []*datastore.Key // TODO: replace with room
-	Activities            []*datastore.Key // TODO: replace with activity
+	RsvpDeadline          time.Time                   // zero if there isn't one
+	RsvpStatuses          []invitation.RsvpStatus     // statuses offered on the RSVP form, in order
+	RsvpCatalog           []invitation.RsvpStatusInfo // every status invitations for this event may use
+	Pricing               pricing.Schedule            // how rooms are priced
+	Rooms                 []*datastore.Key            // TODO: replace with room
+	Activities            []*datastore.Key            // TODO: replace with activity
 	InvitationClosingText string
 	Current               bool
+	Hostnames             []string // hosts that show this event rather than the current one
+	BaseURL               string   // canonical address of the event's site; see AbsoluteURL
+}
+
+// NewEvent returns an unsaved event with the default RSVP catalog, all of
+// it offered, and the default pricing.
+func NewEvent() *Event {
+	return &Event{
+		RsvpStatuses: defaultRsvpStatuses(),
+		RsvpCatalog:  invitation.GetAllRsvpStatuses(),
+		Pricing:      pricing.Default(),
+	}
 }
 
 func (e *Event) LoadVenue(ctx context.Context) (*venue.Venue, error) {
@@ -81,16 +101,22 @@ func (e *Event) ToDB() *eventDB {
 		ShortName:             e.ShortName,
 		StartDate:             e.StartDate,
 		EndDate:               e.EndDate,
+		RsvpDeadline:          e.RsvpDeadline,
 		RsvpStatuses:          e.RsvpStatuses,
+		RsvpCatalog:           rsvpCatalogToDB(e.RsvpCatalog),
+		Pricing:               e.Pricing,
 		Rooms:                 e.Rooms,
 		Activities:            e.Activities,
 		InvitationClosingText: e.InvitationClosingText,
 		Current:               e.Current,
+		Hostnames:             e.Hostnames,
+		BaseURL:               e.BaseURL,
 	}
 }
 
 func eventFromDB(ctx context.Context, key *datastore.Key, ev *eventDB) (*Event, error) {
 	// TODO: get eventdb if called only with key
+	rsvpCatalog, rsvpStatuses := rsvpCatalogFromDB(ev.RsvpCatalog, ev.RsvpStatuses)
 	return &Event{
 		Key:                   key,
 		EventId:               ev.EventId,
@@ -99,14 +125,28 @@ func eventFromDB(ctx context.Context, key *datastore.Key, ev *eventDB) (*Event,
 		ShortName:             ev.ShortName,
 		StartDate:             ev.StartDate,
 		EndDate:               ev.EndDate,
-		RsvpStatuses:          ev.RsvpStatuses,
+		RsvpDeadline:          ev.RsvpDeadline,
+		RsvpStatuses:          rsvpStatuses,
+		RsvpCatalog:           rsvpCatalog,
+		Pricing:               pricingFromDB(ev.Pricing),
 		Rooms:                 ev.Rooms,      // TODO: replace with keys
 		Activities:            ev.Activities, // TODO: replace with keys
 		InvitationClosingText: ev.InvitationClosingText,
 		Current:               ev.Current,
+		Hostnames:             ev.Hostnames,
+		BaseURL:               ev.BaseURL,
 	}, nil
 }
 
+// pricingFromDB gives events saved before pricing was configurable the
+// legacy schedule, so their guests are charged what they always were.
+func pricingFromDB(schedule pricing.Schedule) pricing.Schedule {
+	if schedule.IsZero() {
+		return pricing.Legacy()
+	}
+	return schedule
+}
+
 func GetEvent(ctx context.Context, key *datastore.Key) (*Event, error) {
 	var ev eventDB
 	err := dsclient.FromContext(ctx).Get(ctx, key, &ev)
@@ -120,12 +160,17 @@ func PutEvent(ctx context.Context, ev *Event) error {
 	if ev.Key == nil {
 		ev.Key = datastore.IncompleteKey("Event", nil)
 	}
-	_, err := dsclient.FromContext(ctx).Put(ctx, ev.Key, ev.ToDB())
-	return err
+	key, err := dsclient.FromContext(ctx).Put(ctx, ev.Key, ev.ToDB())
+	if err != nil {
+		return err
+	}
+	ev.Key = key
+	forgetHosts()
+	return nil
 }
 
 func GetAllEvents(ctx context.Context) ([]*Event, error) {
-	q := datastore.NewQuery("Event").Order("-StartDate")
+	q := dsclient.NewQuery("Event").Order("-StartDate")
 	var allEventDBs []*eventDB
 	eventKeys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &allEventDBs)
 	if err != nil {
@@ -148,7 +193,7 @@ func GetAllEvents(ctx context.Context) ([]*Event, error) {
 func GetCurrentEvent(ctx context.Context) (*Event, error) {
 	var keys []*datastore.Key
 	var events []*eventDB
-	q := datastore.NewQuery("Event").FilterField("Current", "=", true)
+	q := dsclient.NewQuery("Event").FilterField("Current", "=", true)
 	keys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &events)
 	if err != nil {
 		return nil, err
@@ -161,39 +206,3 @@ func GetCurrentEvent(ctx context.Context) (*Event, error) {
 	}
 	return eventFromDB(ctx, keys[0], events[0])
 }
-
-func GetEventForHost(ctx context.Context, host string, e **Event, key **datastore.Key) (bool, error) {
-	// TODO: generalize this for multiple hostnames/events.
-	var shortname string
-	if host == "psr2019.shabsin.com" {
-		shortname = "PSR2019"
-	} else if host == "psr2021.shabsin.com" {
-		shortname = "PSR2021"
-	} else {
-		return false, nil
-	}
-
-	var keys []*datastore.Key
-	var eventDBs []*eventDB
-	q := datastore.NewQuery("Event").FilterField("ShortName", "=", shortname)
-	keys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &eventDBs)
-	if err != nil {
-		log.Printf("Error querying for %s(url) event: %v", shortname, err)
-		return false, nil
-	}
-	if len(keys) == 0 {
-		log.Printf("Found no %s(url) event", shortname)
-		return false, nil
-	}
-	if len(keys) > 1 {
-		log.Printf("Found more than one %s(url) event (%d)", shortname, len(keys))
-		return false, nil
-	}
-	ev, err := eventFromDB(ctx, keys[0], eventDBs[0])
-	if err != nil {
-		return false, err
-	}
-	*e = ev
-	*key = keys[0]
-	return true, nil
-}
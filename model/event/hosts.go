@@ -0,0 +1,200 @@
+package event
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+)
+
+// DefaultBaseURL is the address of the site for events without one of
+// their own.
+const DefaultBaseURL = "https://psr.shabsin.com"
+
+// AbsoluteURL returns the absolute address of path (which starts with
+// "/") on the event's site. Links always use https, whatever the base URL
+// says.
+func (e *Event) AbsoluteURL(path string) string {
+	base := DefaultBaseURL
+	if e != nil && e.BaseURL != "" {
+		base = e.BaseURL
+	}
+	base = strings.TrimSuffix(strings.TrimSpace(base), "/")
+	if strings.HasPrefix(base, "http://") {
+		base = "https://" + strings.TrimPrefix(base, "http://")
+	} else if !strings.Contains(base, "://") {
+		base = "https://" + base
+	}
+	return base + path
+}
+
+// NormalizeHost returns the hostname of a request's Host, lower-cased and
+// without a port, as event hostnames are stored.
+func NormalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// hostCacheTTL is how long the hostname table is used before it's read
+// again, so that events saved by other instances are noticed.
+const hostCacheTTL = 5 * time.Minute
+
+// hostTableEntry is a datastore's table of event hostnames, mapping every
+// hostname to its event's key.
+type hostTableEntry struct {
+	read  time.Time
+	hosts map[string]*datastore.Key
+}
+
+// hostCache holds the hostname table of each datastore client it's been
+// read from. It is emptied whenever an event is saved.
+var hostCache struct {
+	sync.Mutex
+	tables map[dsclient.Client]hostTableEntry
+}
+
+// forgetHosts makes the next lookup read the hostname table again.
+func forgetHosts() {
+	hostCache.Lock()
+	defer hostCache.Unlock()
+	hostCache.tables = nil
+}
+
+// hostTable returns the map from hostname to event key.
+func hostTable(ctx context.Context) (map[string]*datastore.Key, error) {
+	client := dsclient.FromContext(ctx)
+	hostCache.Lock()
+	defer hostCache.Unlock()
+	if table, ok := hostCache.tables[client]; ok && time.Since(table.read) < hostCacheTTL {
+		return table.hosts, nil
+	}
+	var eventDBs []*eventDB
+	keys, err := client.GetAll(ctx, dsclient.NewQuery("Event"), &eventDBs)
+	if err != nil {
+		return nil, err
+	}
+	hosts := make(map[string]*datastore.Key)
+	for i, ev := range eventDBs {
+		for _, host := range ev.Hostnames {
+			if other, ok := hosts[host]; ok {
+				log.Printf("Hostname %s belongs to both %v and %v; using %v", host, other, keys[i], other)
+				continue
+			}
+			hosts[host] = keys[i]
+		}
+	}
+	if hostCache.tables == nil {
+		hostCache.tables = make(map[dsclient.Client]hostTableEntry)
+	}
+	hostCache.tables[client] = hostTableEntry{read: time.Now(), hosts: hosts}
+	return hosts, nil
+}
+
+// GetEventForHost returns the event whose hostnames include the request
+// host, or nil if there isn't one.
+func GetEventForHost(ctx context.Context, host string) (*Event, error) {
+	hosts, err := hostTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	host = NormalizeHost(host)
+	key, ok := hosts[host]
+	if !ok {
+		// The events that used to be wired to a hostname in the code get
+		// it the first time it's asked for.
+		shortName, legacy := legacyShortName(host)
+		if !legacy {
+			return nil, nil
+		}
+		key, _, err = addLegacyHostname(ctx, shortName, host)
+		if err != nil || key == nil {
+			return nil, err
+		}
+	}
+	return GetEvent(ctx, key)
+}
+
+// HostOwner returns the key of the event that has the hostname, or nil if
+// none does. Unlike GetEventForHost, it always reads the datastore.
+func HostOwner(ctx context.Context, host string) (*datastore.Key, error) {
+	q := dsclient.NewQuery("Event").FilterField("Hostnames", "=", NormalizeHost(host)).KeysOnly()
+	keys, err := dsclient.FromContext(ctx).GetAll(ctx, q, nil)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	return keys[0], nil
+}
+
+// legacyHostnames are the hostnames that used to be wired to their events
+// in the code, by event short name.
+var legacyHostnames = map[string]string{
+	"PSR2019": "psr2019.shabsin.com",
+	"PSR2021": "psr2021.shabsin.com",
+}
+
+// legacyShortName returns the short name of the event that used to be
+// wired to host in the code, if there is one.
+func legacyShortName(host string) (string, bool) {
+	for shortName, legacyHost := range legacyHostnames {
+		if legacyHost == host {
+			return shortName, true
+		}
+	}
+	return "", false
+}
+
+// AddLegacyHostnames gives the events that used to be wired to a hostname
+// in the code that hostname, unless some event already has it. It returns
+// the short names of the events it changed. GetEventForHost does the same
+// for each hostname the first time it's asked for, so this only saves
+// waiting for that.
+func AddLegacyHostnames(ctx context.Context) ([]string, error) {
+	var changed []string
+	for shortName, host := range legacyHostnames {
+		_, added, err := addLegacyHostname(ctx, shortName, host)
+		if err != nil {
+			return changed, err
+		}
+		if added {
+			changed = append(changed, shortName)
+		}
+	}
+	return changed, nil
+}
+
+// addLegacyHostname gives the event with the short name the hostname,
+// unless some event already has it. It returns the key of the event with
+// the hostname, or nil if there isn't one, and whether it added it.
+func addLegacyHostname(ctx context.Context, shortName, host string) (*datastore.Key, bool, error) {
+	owner, err := HostOwner(ctx, host)
+	if err != nil || owner != nil {
+		return owner, false, err
+	}
+	q := dsclient.NewQuery("Event").FilterField("ShortName", "=", shortName).KeysOnly()
+	keys, err := dsclient.FromContext(ctx).GetAll(ctx, q, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(keys) != 1 {
+		log.Printf("Found %d %s events; not adding %s", len(keys), shortName, host)
+		return nil, false, nil
+	}
+	ev, err := GetEvent(ctx, keys[0])
+	if err != nil {
+		return nil, false, err
+	}
+	ev.Hostnames = append(ev.Hostnames, host)
+	if err := PutEvent(ctx, ev); err != nil {
+		return nil, false, err
+	}
+	return ev.Key, true, nil
+}
@@ -0,0 +1,156 @@
+package event
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+)
+
+func TestAbsoluteURL(t *testing.T) {
+	for _, tc := range []struct {
+		base, want string
+	}{
+		{"", DefaultBaseURL + "/login"},
+		{"https://psr2025.example.com", "https://psr2025.example.com/login"},
+		{"http://psr2025.example.com/", "https://psr2025.example.com/login"},
+		{"psr2025.example.com", "https://psr2025.example.com/login"},
+	} {
+		ev := &Event{BaseURL: tc.base}
+		if got := ev.AbsoluteURL("/login"); got != tc.want {
+			t.Errorf("AbsoluteURL with base %q = %q, want %q", tc.base, got, tc.want)
+		}
+	}
+}
+
+func TestNormalizeHost(t *testing.T) {
+	for host, want := range map[string]string{
+		"PSR2025.example.com":      "psr2025.example.com",
+		"psr2025.example.com:8080": "psr2025.example.com",
+		"psr2025.example.com.":     "psr2025.example.com",
+		"localhost":                "localhost",
+	} {
+		if got := NormalizeHost(host); got != want {
+			t.Errorf("NormalizeHost(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestGetEventForHost(t *testing.T) {
+	ctx := dsclient.WrapContext(context.Background(), dsclient.NewMemoryClient())
+	ev := &Event{ShortName: "PSR2025", Hostnames: []string{"psr2025.example.com"}}
+	if err := PutEvent(ctx, ev); err != nil {
+		t.Fatal(err)
+	}
+	if err := PutEvent(ctx, &Event{ShortName: "PSR2026", Current: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := GetEventForHost(ctx, "PSR2025.example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.ShortName != "PSR2025" {
+		t.Errorf("GetEventForHost = %v, want PSR2025", got)
+	}
+	if got, err := GetEventForHost(ctx, "psr.example.com"); err != nil || got != nil {
+		t.Errorf("GetEventForHost(unknown host) = %v, %v; want nil", got, err)
+	}
+
+	// Saving an event is seen by the next lookup.
+	ev.Hostnames = []string{"psr2025.example.org"}
+	if err := PutEvent(ctx, ev); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := GetEventForHost(ctx, "psr2025.example.com"); err != nil || got != nil {
+		t.Errorf("GetEventForHost(old host) = %v, %v; want nil", got, err)
+	}
+	if got, err := GetEventForHost(ctx, "psr2025.example.org"); err != nil || got == nil {
+		t.Errorf("GetEventForHost(new host) = %v, %v; want PSR2025", got, err)
+	}
+
+	owner, err := HostOwner(ctx, "psr2025.example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner == nil || !owner.Equal(ev.Key) {
+		t.Errorf("HostOwner = %v, want %v", owner, ev.Key)
+	}
+}
+
+func TestHostTablePerDatastore(t *testing.T) {
+	first := dsclient.WrapContext(context.Background(), dsclient.NewMemoryClient())
+	if err := PutEvent(first, &Event{ShortName: "PSR2025", Hostnames: []string{"psr2025.example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := GetEventForHost(first, "psr2025.example.com"); err != nil || got == nil {
+		t.Fatalf("GetEventForHost = %v, %v; want PSR2025", got, err)
+	}
+
+	// Another datastore has hostnames of its own.
+	second := dsclient.WrapContext(context.Background(), dsclient.NewMemoryClient())
+	if got, err := GetEventForHost(second, "psr2025.example.com"); err != nil || got != nil {
+		t.Errorf("GetEventForHost in another datastore = %v, %v; want nil", got, err)
+	}
+}
+
+func TestAddLegacyHostnames(t *testing.T) {
+	ctx := dsclient.WrapContext(context.Background(), dsclient.NewMemoryClient())
+	psr2019 := &Event{ShortName: "PSR2019"}
+	psr2021 := &Event{ShortName: "PSR2021", Hostnames: []string{"psr2021.shabsin.com"}}
+	for _, ev := range []*Event{psr2019, psr2021, {ShortName: "PSR2022"}} {
+		if err := PutEvent(ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changed, err := AddLegacyHostnames(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || changed[0] != "PSR2019" {
+		t.Errorf("AddLegacyHostnames changed %v, want [PSR2019]", changed)
+	}
+	for host, want := range map[string]*Event{
+		"psr2019.shabsin.com": psr2019,
+		"psr2021.shabsin.com": psr2021,
+	} {
+		got, err := GetEventForHost(ctx, host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == nil || !got.Key.Equal(want.Key) {
+			t.Errorf("GetEventForHost(%q) = %v, want %s", host, got, want.ShortName)
+		}
+	}
+
+	// Running it again changes nothing.
+	if changed, err := AddLegacyHostnames(ctx); err != nil || len(changed) != 0 {
+		t.Errorf("second AddLegacyHostnames changed %v, %v; want nothing", changed, err)
+	}
+}
+
+func TestGetEventForLegacyHost(t *testing.T) {
+	ctx := dsclient.WrapContext(context.Background(), dsclient.NewMemoryClient())
+	psr2019 := &Event{ShortName: "PSR2019"}
+	if err := PutEvent(ctx, psr2019); err != nil {
+		t.Fatal(err)
+	}
+
+	// The hostname is found, and stored, without AddLegacyHostnames.
+	got, err := GetEventForHost(ctx, "PSR2019.shabsin.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || !got.Key.Equal(psr2019.Key) {
+		t.Fatalf("GetEventForHost = %v, want PSR2019", got)
+	}
+	if owner, err := HostOwner(ctx, "psr2019.shabsin.com"); err != nil || owner == nil || !owner.Equal(psr2019.Key) {
+		t.Errorf("HostOwner = %v, %v; want PSR2019's key", owner, err)
+	}
+
+	// A legacy hostname whose event doesn't exist shows no event.
+	if got, err := GetEventForHost(ctx, "psr2021.shabsin.com"); err != nil || got != nil {
+		t.Errorf("GetEventForHost(psr2021) = %v, %v; want nil", got, err)
+	}
+}
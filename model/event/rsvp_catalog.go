@@ -0,0 +1,142 @@
+package event
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cshabsin/conju/invitation"
+)
+
+type rsvpStatusDB struct {
+	Status           invitation.RsvpStatus
+	ShortDescription string
+	LongDescription  string `datastore:",noindex"`
+	Attending        bool
+	Undecided        bool
+	NoLodging        bool
+	Nights           []time.Weekday
+	Meals            []invitation.Meal
+}
+
+func rsvpCatalogToDB(catalog []invitation.RsvpStatusInfo) []rsvpStatusDB {
+	var out []rsvpStatusDB
+	for _, info := range catalog {
+		out = append(out, rsvpStatusDB{
+			Status:           info.Status,
+			ShortDescription: info.ShortDescription,
+			LongDescription:  info.LongDescription,
+			Attending:        info.Attending,
+			Undecided:        info.Undecided,
+			NoLodging:        info.NoLodging,
+			Nights:           info.Nights,
+			Meals:            info.Meals,
+		})
+	}
+	return out
+}
+
+// defaultRsvpStatuses returns every status in the default catalog, in
+// catalog order.
+func defaultRsvpStatuses() []invitation.RsvpStatus {
+	var statuses []invitation.RsvpStatus
+	for _, info := range invitation.GetAllRsvpStatuses() {
+		statuses = append(statuses, info.Status)
+	}
+	return statuses
+}
+
+// rsvpCatalogFromDB converts a stored catalog and the statuses offered from
+// it. Events saved before catalogs were stored per event get the default
+// catalog, and if they offer no statuses either, all of its statuses.
+func rsvpCatalogFromDB(catalog []rsvpStatusDB, statuses []invitation.RsvpStatus) ([]invitation.RsvpStatusInfo, []invitation.RsvpStatus) {
+	if len(catalog) == 0 {
+		if len(statuses) == 0 {
+			statuses = defaultRsvpStatuses()
+		}
+		return invitation.GetAllRsvpStatuses(), statuses
+	}
+	var out []invitation.RsvpStatusInfo
+	for _, info := range catalog {
+		out = append(out, invitation.RsvpStatusInfo{
+			Status:           info.Status,
+			ShortDescription: info.ShortDescription,
+			LongDescription:  info.LongDescription,
+			Attending:        info.Attending,
+			Undecided:        info.Undecided,
+			NoLodging:        info.NoLodging,
+			Nights:           info.Nights,
+			Meals:            info.Meals,
+		})
+	}
+	return out, statuses
+}
+
+// RsvpStatusInfo looks up status in the event's catalog. A status missing
+// from the catalog is reported as neither attending nor undecided, so that
+// stale invitations don't count toward headcounts.
+func (e *Event) RsvpStatusInfo(status invitation.RsvpStatus) invitation.RsvpStatusInfo {
+	for _, info := range e.RsvpCatalog {
+		if info.Status == status {
+			return info
+		}
+	}
+	return invitation.RsvpStatusInfo{
+		Status:           status,
+		ShortDescription: fmt.Sprintf("Unknown%d", status),
+		LongDescription:  fmt.Sprintf("Unknown status %d", status),
+	}
+}
+
+// RsvpStatusMap returns the event's catalog keyed by status, for templates
+// that look statuses up with index.
+func (e *Event) RsvpStatusMap() map[invitation.RsvpStatus]invitation.RsvpStatusInfo {
+	m := make(map[invitation.RsvpStatus]invitation.RsvpStatusInfo)
+	for _, info := range e.RsvpCatalog {
+		m[info.Status] = info
+	}
+	return m
+}
+
+// RsvpStatusOrder returns every status in the catalog in report order:
+// attending statuses first, then undecided ones, then the rest. Within each
+// group, statuses offered on the RSVP form come first, in form order.
+func (e *Event) RsvpStatusOrder() []invitation.RsvpStatus {
+	var ordered []invitation.RsvpStatus
+	seen := make(map[invitation.RsvpStatus]bool)
+	for _, status := range e.RsvpStatuses {
+		if !seen[status] {
+			ordered = append(ordered, status)
+			seen[status] = true
+		}
+	}
+	for _, info := range e.RsvpCatalog {
+		if !seen[info.Status] {
+			ordered = append(ordered, info.Status)
+			seen[info.Status] = true
+		}
+	}
+
+	var attending, undecided, rest []invitation.RsvpStatus
+	for _, status := range ordered {
+		info := e.RsvpStatusInfo(status)
+		if info.Attending {
+			attending = append(attending, status)
+		} else if info.Undecided {
+			undecided = append(undecided, status)
+		} else {
+			rest = append(rest, status)
+		}
+	}
+	return append(append(attending, undecided...), rest...)
+}
+
+// NextRsvpStatus returns an unused status value for a new catalog entry.
+func (e *Event) NextRsvpStatus() invitation.RsvpStatus {
+	next := invitation.MealsOnly + 1
+	for _, info := range e.RsvpCatalog {
+		if info.Status >= next {
+			next = info.Status + 1
+		}
+	}
+	return next
+}
@@ -0,0 +1,50 @@
+package event
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/invitation"
+)
+
+func TestLegacyEventGetsDefaultCatalog(t *testing.T) {
+	ctx := dsclient.WrapContext(context.Background(), dsclient.NewMemoryClient())
+	legacy := &Event{ShortName: "PSR2019"}
+	offered := &Event{ShortName: "PSR2021", RsvpStatuses: []invitation.RsvpStatus{invitation.No, invitation.FriSat}}
+	for _, ev := range []*Event{legacy, offered} {
+		if err := PutEvent(ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := GetEvent(ctx, legacy.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.RsvpCatalog) != len(invitation.GetAllRsvpStatuses()) {
+		t.Errorf("legacy catalog has %d statuses, want the default catalog", len(got.RsvpCatalog))
+	}
+	if len(got.RsvpStatuses) != len(invitation.GetAllRsvpStatuses()) {
+		t.Errorf("legacy event offers %v, want every default status", got.RsvpStatuses)
+	}
+
+	// Statuses an event offers are kept even when its catalog is the default.
+	got, err = GetEvent(ctx, offered.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.RsvpStatuses) != 2 || got.RsvpStatuses[1] != invitation.FriSat {
+		t.Errorf("RsvpStatuses = %v, want [No FriSat]", got.RsvpStatuses)
+	}
+}
+
+func TestNewEventCatalog(t *testing.T) {
+	ev := NewEvent()
+	if len(ev.RsvpCatalog) == 0 || len(ev.RsvpStatuses) != len(ev.RsvpCatalog) {
+		t.Errorf("NewEvent offers %v from %d statuses, want the whole default catalog", ev.RsvpStatuses, len(ev.RsvpCatalog))
+	}
+	if next := ev.NextRsvpStatus(); next <= invitation.MealsOnly {
+		t.Errorf("NextRsvpStatus = %d, which the default catalog uses", next)
+	}
+}
@@ -0,0 +1,168 @@
+package housing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// An Attribute is a named feature that some of a venue's buildings or
+// rooms have, like being within baby-monitor range of the common room.
+// Buildings and rooms list the names of their attributes, so attributes
+// can be added without renumbering anything that's stored. Preference
+// names the housing preference the attribute satisfies, if any.
+type Attribute struct {
+	Name        string
+	Description string `datastore:",noindex"`
+	Preference  string `datastore:",noindex"`
+}
+
+// PreferenceName returns the name of the housing preference the attribute
+// satisfies. Attributes that don't name one satisfy the preference with
+// their own name, if there is one, as all attributes did before they
+// could name one.
+func (a Attribute) PreferenceName() string {
+	if a.Preference != "" {
+		return a.Preference
+	}
+	return a.Name
+}
+
+// PreferenceFor returns the name of the housing preference that the named
+// attribute satisfies in the catalog. Attributes missing from the catalog
+// are taken to satisfy the preference with their own name.
+func PreferenceFor(catalog []Attribute, name string) string {
+	for _, attr := range catalog {
+		if attr.Name == name {
+			return attr.PreferenceName()
+		}
+	}
+	return name
+}
+
+// DefaultAttributes is the catalog of venues that haven't set their own.
+// Its names are those of the housing preferences.
+func DefaultAttributes() []Attribute {
+	return []Attribute{
+		{Name: "MonitorRange", Description: "Within baby-monitor range of the main common room"},
+		{Name: "CloseBuilding", Description: "Very close to the main common room, but out of monitor range"},
+		{Name: "FarBuilding", Description: "About 100 yards from the main common room"},
+		{Name: "CanCrossRoad", Description: "Across the road"},
+		{Name: "PreferFar", Description: "Far from the main common room"},
+		{Name: "FartherBuilding", Description: "Outside the main cluster of buildings"},
+		{Name: "WillingExpensive", Description: "Nicer, more expensive housing"},
+		{Name: "PreferExpensive", Description: "Nicer, more expensive housing, for those who prefer it"},
+		{Name: "ShareBed", Description: "Has a bed that sleeps two"},
+		{Name: "WillingCOVIDCautious", Description: "COVID-cautious housing"},
+		{Name: "PreferCOVIDCautious", Description: "COVID-cautious housing, for those who prefer it"},
+	}
+}
+
+// legacyPropertyBits are the bits buildings' and rooms' Properties were
+// stored with before attributes had names. They must not change.
+var legacyPropertyBits = []struct {
+	bit  int
+	name string
+}{
+	{1, "FartherBuilding"},
+	{2, "ShareBed"},
+	{4, "PreferFar"},
+	{8, "CanCrossRoad"},
+	{16, "FarBuilding"},
+	{32, "CloseBuilding"},
+	{64, "MonitorRange"},
+	{128, "WillingExpensive"},
+	{256, "PreferExpensive"},
+	{512, "WillingCOVIDCautious"},
+	{1024, "PreferCOVIDCautious"},
+}
+
+// legacyAttributes returns the names of the attributes in a stored
+// Properties bitmask.
+func legacyAttributes(properties int) []string {
+	var names []string
+	for _, legacy := range legacyPropertyBits {
+		if properties&legacy.bit != 0 {
+			names = append(names, legacy.name)
+		}
+	}
+	return names
+}
+
+// AttributeNames returns the names of the building's attributes, reading
+// the Properties of buildings saved before attributes had names.
+func (b Building) AttributeNames() []string {
+	if len(b.Attributes) == 0 {
+		return legacyAttributes(b.Properties)
+	}
+	return b.Attributes
+}
+
+// AttributeNames returns the names of the room's own attributes, reading
+// the Properties of rooms saved before attributes had names.
+func (r Room) AttributeNames() []string {
+	if len(r.Attributes) == 0 {
+		return legacyAttributes(r.Properties)
+	}
+	return r.Attributes
+}
+
+// SetAttributes replaces the building's attributes.
+func (b *Building) SetAttributes(names []string) {
+	b.Attributes = names
+	b.Properties = 0
+}
+
+// SetAttributes replaces the room's attributes.
+func (r *Room) SetAttributes(names []string) {
+	r.Attributes = names
+	r.Properties = 0
+}
+
+// HasAttribute reports whether the room, or its building if it isn't nil,
+// has the named attribute.
+func HasAttribute(building *Building, room *Room, name string) bool {
+	for _, n := range room.AttributeNames() {
+		if n == name {
+			return true
+		}
+	}
+	if building != nil {
+		for _, n := range building.AttributeNames() {
+			if n == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AllAttributes returns the names of the attributes of the room and its
+// building.
+func (room RealRoom) AllAttributes() []string {
+	return append(append([]string(nil), room.Building.AttributeNames()...), room.Room.AttributeNames()...)
+}
+
+// ParseAttributes splits a list of attribute names separated by commas
+// or spaces, as in the housing import files.
+func ParseAttributes(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+}
+
+// ValidateAttributes checks a venue's attribute catalog: every attribute
+// needs a name, names can't repeat, and can't contain commas or spaces.
+func ValidateAttributes(catalog []Attribute) error {
+	seen := make(map[string]bool)
+	for _, attr := range catalog {
+		if attr.Name == "" {
+			return fmt.Errorf("attribute %q has no name", attr.Description)
+		}
+		if strings.ContainsAny(attr.Name, ", \t") {
+			return fmt.Errorf("attribute name %q contains a comma or space", attr.Name)
+		}
+		if seen[attr.Name] {
+			return fmt.Errorf("attribute %s is listed twice", attr.Name)
+		}
+		seen[attr.Name] = true
+	}
+	return nil
+}
@@ -1,6 +1,10 @@
 package housing
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+
 	"cloud.google.com/go/datastore"
 )
 
@@ -8,8 +12,22 @@ type Building struct {
 	Venue             *datastore.Key
 	Name              string
 	Code              string
-	Properties        int
+	Attributes        []string // names from the venue's attribute catalog
 	FloorplanImageUrl string
+
+	// Properties is the bitmask buildings were stored with before
+	// attributes had names; use AttributeNames.
+	Properties int
+}
+
+// FloorplanSrc returns the address of the building's floorplan image:
+// FloorplanImageUrl itself if it's a URL or an absolute path, or else the
+// file of that name in /media/floorplan.
+func (b Building) FloorplanSrc() string {
+	if b.FloorplanImageUrl == "" || strings.HasPrefix(b.FloorplanImageUrl, "/") || strings.Contains(b.FloorplanImageUrl, "://") {
+		return b.FloorplanImageUrl
+	}
+	return "/media/floorplan/" + b.FloorplanImageUrl
 }
 
 type BedSize int
@@ -22,26 +40,101 @@ const (
 	Cot
 )
 
+// bedLetters are the letters BedsString and ParseBeds use for each
+// BedSize, as in the rooms import file.
+var bedLetters = map[BedSize]rune{
+	King:   'K',
+	Queen:  'Q',
+	Double: 'D',
+	Twin:   'T',
+	Cot:    'C',
+}
+
+// BedsString returns the beds as a string of letters, e.g. "QT" for a
+// queen and a twin.
+func BedsString(beds []BedSize) string {
+	s := ""
+	for _, bed := range beds {
+		s += string(bedLetters[bed])
+	}
+	return s
+}
+
+// ParseBeds parses a string of bed letters, as BedsString returns. Case,
+// spaces and commas are ignored.
+func ParseBeds(s string) ([]BedSize, error) {
+	bedForLetter := make(map[rune]BedSize)
+	for bed, letter := range bedLetters {
+		bedForLetter[letter] = bed
+	}
+	var beds []BedSize
+	for _, c := range strings.ToUpper(s) {
+		if c == ' ' || c == ',' {
+			continue
+		}
+		bed, ok := bedForLetter[c]
+		if !ok {
+			return nil, fmt.Errorf("unknown bed %q (use K, Q, D, T or C)", c)
+		}
+		beds = append(beds, bed)
+	}
+	return beds, nil
+}
+
 type Room struct {
 	Building    *datastore.Key
 	RoomNumber  int
 	Description string
 	Partition   string
-	Properties  int
+	Attributes  []string // names from the venue's attribute catalog
 	Beds        []BedSize
 
 	ImageTop    int
 	ImageLeft   int
 	ImageWidth  int
 	ImageHeight int
+
+	// Properties is the bitmask rooms were stored with before attributes
+	// had names; use AttributeNames.
+	Properties int
 }
 
 type RealRoom struct {
 	Room       Room
 	Building   Building
 	BedsString string
+	Properties int // housing preference bits of AllAttributes, for the rooming tool
+}
+
+// Capacity is the number of people the room's beds sleep.
+func (room Room) Capacity() int {
+	capacity := 0
+	for _, bed := range room.Beds {
+		if bed == King || bed == Queen || bed == Double {
+			capacity += 2
+		} else {
+			capacity++
+		}
+	}
+	return capacity
+}
+
+// HasSharedBed reports whether the room has a bed that sleeps two.
+func (room Room) HasSharedBed() bool {
+	for _, bed := range room.Beds {
+		if bed == King || bed == Queen || bed == Double {
+			return true
+		}
+	}
+	return false
 }
 
-func (room RealRoom) AllProperties() int {
-	return room.Building.Properties | room.Room.Properties
+// RoomString identifies the room in the rooming tool's form, as
+// BuildingCode_RoomNumber, with _Partition appended for partitioned rooms.
+func (room RealRoom) RoomString() string {
+	s := room.Building.Code + "_" + strconv.Itoa(room.Room.RoomNumber)
+	if room.Room.Partition != "" {
+		s += "_" + room.Room.Partition
+	}
+	return s
 }
@@ -0,0 +1,103 @@
+package housing
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBeds(t *testing.T) {
+	for s, want := range map[string][]BedSize{
+		"":      nil,
+		"QT":    {Queen, Twin},
+		"k, tc": {King, Twin, Cot},
+		"DD":    {Double, Double},
+	} {
+		got, err := ParseBeds(s)
+		if err != nil {
+			t.Errorf("ParseBeds(%q): %v", s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ParseBeds(%q) = %v, want %v", s, got, want)
+		}
+	}
+	if _, err := ParseBeds("QX"); err == nil {
+		t.Errorf("ParseBeds(%q) succeeded, want an error", "QX")
+	}
+}
+
+func TestBedsString(t *testing.T) {
+	beds := []BedSize{King, Queen, Double, Twin, Cot}
+	if got, want := BedsString(beds), "KQDTC"; got != want {
+		t.Errorf("BedsString(%v) = %q, want %q", beds, got, want)
+	}
+}
+
+func TestAttributeNames(t *testing.T) {
+	// Rooms saved before attributes had names only have Properties.
+	legacy := Room{Properties: 2 | 64}
+	if got, want := legacy.AttributeNames(), []string{"ShareBed", "MonitorRange"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("legacy AttributeNames() = %v, want %v", got, want)
+	}
+	legacy.SetAttributes([]string{"Accessible"})
+	if got, want := legacy.AttributeNames(), []string{"Accessible"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("AttributeNames() after SetAttributes = %v, want %v", got, want)
+	}
+
+	building := &Building{Attributes: []string{"CloseBuilding"}}
+	room := &Room{Attributes: []string{"ShareBed"}}
+	for name, want := range map[string]bool{"CloseBuilding": true, "ShareBed": true, "MonitorRange": false} {
+		if got := HasAttribute(building, room, name); got != want {
+			t.Errorf("HasAttribute(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if HasAttribute(nil, room, "CloseBuilding") {
+		t.Errorf("HasAttribute with no building found the building's attribute")
+	}
+}
+
+func TestValidateAttributes(t *testing.T) {
+	if err := ValidateAttributes(DefaultAttributes()); err != nil {
+		t.Errorf("ValidateAttributes(DefaultAttributes()): %v", err)
+	}
+	for _, catalog := range [][]Attribute{
+		{{Description: "No name"}},
+		{{Name: "Close To Dining"}},
+		{{Name: "Accessible"}, {Name: "Accessible"}},
+	} {
+		if err := ValidateAttributes(catalog); err == nil {
+			t.Errorf("ValidateAttributes(%v) succeeded, want an error", catalog)
+		}
+	}
+}
+
+func TestPreferenceFor(t *testing.T) {
+	catalog := []Attribute{
+		{Name: "ShareBed"},
+		{Name: "DoubleBed", Preference: "ShareBed"},
+		{Name: "Lakeview"},
+	}
+	for name, want := range map[string]string{
+		"ShareBed":      "ShareBed",
+		"DoubleBed":     "ShareBed",
+		"Lakeview":      "Lakeview",
+		"CloseBuilding": "CloseBuilding", // not in the catalog
+	} {
+		if got := PreferenceFor(catalog, name); got != want {
+			t.Errorf("PreferenceFor(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestFloorplanSrc(t *testing.T) {
+	for url, want := range map[string]string{
+		"":                              "",
+		"lodge.png":                     "/media/floorplan/lodge.png",
+		"/media/other/lodge.png":        "/media/other/lodge.png",
+		"https://example.com/lodge.png": "https://example.com/lodge.png",
+	} {
+		if got := (Building{FloorplanImageUrl: url}).FloorplanSrc(); got != want {
+			t.Errorf("FloorplanSrc() for %q = %q, want %q", url, got, want)
+		}
+	}
+}
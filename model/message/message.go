@@ -4,18 +4,101 @@
 // Primary keys:
 //
 // - event
-// - email shortname (currently the filename in the repo).
+// - email shortname (the same name as the template file in the repo, which
+// a Message overrides).
 //
 // Columns:
 //
 // - a type enum, to determine which recipients are targeted by the email (individuals, invitees, or attendees)
+// - subject line template
 // - plaintext version of the message template (using golang tmpl language - include a "cheat sheet" on the edit page?)
 // - html version of the message template
 package message
 
-import "cloud.google.com/go/datastore"
+import (
+	"context"
+	"fmt"
+	"time"
 
+	"cloud.google.com/go/datastore"
+
+	"github.com/cshabsin/conju/conju/dsclient"
+)
+
+// RecipientType says who a message is meant to be sent to.
+type RecipientType int
+
+const (
+	Individuals RecipientType = iota // one person at a time, such as a confirmation
+	Invitees                         // everyone invited to the event
+	Attendees                        // everyone coming to the event
+)
+
+var recipientTypeNames = []string{"Individuals", "Invitees", "Attendees"}
+
+func (r RecipientType) String() string {
+	if r < 0 || int(r) >= len(recipientTypeNames) {
+		return fmt.Sprintf("RecipientType(%d)", int(r))
+	}
+	return recipientTypeNames[r]
+}
+
+func AllRecipientTypes() []RecipientType {
+	return []RecipientType{Individuals, Invitees, Attendees}
+}
+
+// Message holds the bodies of the templates named <ShortName>_subject,
+// <ShortName>_text and <ShortName>_html, without {{define}} wrappers.
 type Message struct {
-	Event     *datastore.Key // the event id this message is associated with
-	ShortName string         // the short name of the message, used to identify it
+	Event         *datastore.Key // the event id this message is associated with
+	ShortName     string         // the short name of the message, used to identify it
+	RecipientType RecipientType
+	Subject       string `datastore:",noindex"`
+	Text          string `datastore:",noindex"`
+	HTML          string `datastore:",noindex"`
+	LastUpdated   time.Time
+	LastUpdatedBy string // email of the admin who last saved it
+}
+
+// Key returns the key of the event's message with the given short name.
+func Key(eventKey *datastore.Key, shortName string) *datastore.Key {
+	return datastore.NameKey("Message", shortName, eventKey)
+}
+
+// Templates returns the message's template bodies by template name.
+func (m *Message) Templates() map[string]string {
+	return map[string]string{
+		m.ShortName + "_subject": m.Subject,
+		m.ShortName + "_text":    m.Text,
+		m.ShortName + "_html":    m.HTML,
+	}
+}
+
+// GetMessage returns the event's message with the given short name, or
+// nil if there is none.
+func GetMessage(ctx context.Context, eventKey *datastore.Key, shortName string) (*Message, error) {
+	var m Message
+	err := dsclient.FromContext(ctx).Get(ctx, Key(eventKey, shortName), &m)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// GetMessages returns all of the event's messages.
+func GetMessages(ctx context.Context, eventKey *datastore.Key) ([]*Message, error) {
+	var messages []*Message
+	q := dsclient.NewQuery("Message").Ancestor(eventKey)
+	if _, err := dsclient.FromContext(ctx).GetAll(ctx, q, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+func PutMessage(ctx context.Context, m *Message) error {
+	_, err := dsclient.FromContext(ctx).Put(ctx, Key(m.Event, m.ShortName), m)
+	return err
 }
@@ -28,6 +28,7 @@ type Person struct {
 	NeedBirthdate    bool
 	PrivateComments  string
 	LoginCode        string
+	MailPreference   MailPreference
 	// these fields can be removed after all the data is ported
 	OldGuestId    int
 	OldInviteeId  int
@@ -43,6 +44,7 @@ type PersonUpdateFormInfo struct {
 	ThisPerson               *Person
 	EncodedKey               string
 	AllPronouns              []PronounSet
+	AllMailPreferences       []MailPreference
 	AllFoodRestrictions      []FoodRestrictionTag
 	HighlightNeededBirthdate bool
 	PersonIndex              int
@@ -59,6 +61,7 @@ func MakePersonUpdateFormInfo(key *datastore.Key, person Person, index int, high
 		ThisPerson:               &person,
 		EncodedKey:               encodedKey,
 		AllPronouns:              []PronounSet{They, She, He, Zie},
+		AllMailPreferences:       AllMailPreferences(),
 		AllFoodRestrictions:      GetAllFoodRestrictionTags(),
 		HighlightNeededBirthdate: highlightNeededBirthdate,
 		PersonIndex:              index,
@@ -117,6 +120,44 @@ func GetPronouns(p PronounSet) string {
 	}
 }
 
+// MailPreference says what mail a person wants from us. Mail sent to one
+// person in response to something they did, like a confirmation, is sent
+// regardless.
+type MailPreference int
+
+const (
+	MailAll       MailPreference = iota
+	MailEssential                // only mail that's marked essential
+	MailNone
+)
+
+func (m MailPreference) String() string {
+	switch m {
+	case MailAll:
+		return "All mail"
+	case MailEssential:
+		return "Essential mail only"
+	case MailNone:
+		return "No mail"
+	}
+	return fmt.Sprintf("MailPreference(%d)", int(m))
+}
+
+func AllMailPreferences() []MailPreference {
+	return []MailPreference{MailAll, MailEssential, MailNone}
+}
+
+// Wants returns whether the person wants mail that is (or isn't) essential.
+func (m MailPreference) Wants(essential bool) bool {
+	switch m {
+	case MailAll:
+		return true
+	case MailEssential:
+		return essential
+	}
+	return false
+}
+
 type FoodRestriction int
 
 const (
@@ -159,3 +159,23 @@ func TestCollectiveAddressFirstNames(t *testing.T) {
 		}
 	}
 }
+
+func TestMailPreferenceWants(t *testing.T) {
+	type TestCase struct {
+		Pref                       MailPreference
+		WantEssential, WantGeneral bool
+	}
+	testcases := []TestCase{
+		{MailAll, true, true},
+		{MailEssential, true, false},
+		{MailNone, false, false},
+	}
+	for _, tc := range testcases {
+		if got := tc.Pref.Wants(true); got != tc.WantEssential {
+			t.Errorf("%v: Wants(true) was %v, want %v", tc.Pref, got, tc.WantEssential)
+		}
+		if got := tc.Pref.Wants(false); got != tc.WantGeneral {
+			t.Errorf("%v: Wants(false) was %v, want %v", tc.Pref, got, tc.WantGeneral)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+// Package pricing computes what guests owe for their rooms. Each event
+// carries a Schedule of rates, discounts and fees; Price applies it to the
+// guests sharing one room.
+package pricing
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Weekdays is a list of nights, each identified by the weekday it starts
+// on. It reads and writes JSON as short day names ("Thu", "Fri").
+type Weekdays []time.Weekday
+
+func (w Weekdays) MarshalJSON() ([]byte, error) {
+	names := make([]string, len(w))
+	for i, d := range w {
+		names[i] = d.String()[:3]
+	}
+	return json.Marshal(names)
+}
+
+func (w *Weekdays) UnmarshalJSON(b []byte) error {
+	var names []string
+	if err := json.Unmarshal(b, &names); err != nil {
+		return err
+	}
+	days := make(Weekdays, 0, len(names))
+	for _, name := range names {
+		d, err := parseWeekday(name)
+		if err != nil {
+			return err
+		}
+		days = append(days, d)
+	}
+	*w = days
+	return nil
+}
+
+func parseWeekday(name string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(name, d.String()) || strings.EqualFold(name, d.String()[:3]) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday %q", name)
+}
+
+func (w Weekdays) contains(night time.Weekday) bool {
+	for _, d := range w {
+		if d == night {
+			return true
+		}
+	}
+	return false
+}
+
+func (w Weekdays) String() string {
+	names := make([]string, len(w))
+	for i, d := range w {
+		names[i] = d.String()[:3]
+	}
+	return strings.Join(names, "/")
+}
+
+// Rate prices a block of nights. PerPerson is the price each guest pays for
+// the whole block, indexed by the number of guests sharing the room (index 0
+// is unused); rooms fuller than the list use the last entry. Guests staying
+// only some of the block's nights pay a proportional share.
+//
+// A rate applies to every room unless Building (a building code) is set,
+// and RoomNumber narrows a building rate to a single room. For each night,
+// the most specific rate covering it wins.
+type Rate struct {
+	Nights     Weekdays
+	Building   string `json:",omitempty"`
+	RoomNumber int    `json:",omitempty"`
+	PerPerson  []float64
+}
+
+// Fee is a flat amount charged to every booked guest.
+type Fee struct {
+	Description string
+	Amount      float64
+	AdultsOnly  bool `json:",omitempty"`
+}
+
+type Schedule struct {
+	Rates []Rate
+	Fees  []Fee `json:",omitempty"`
+
+	// Discounts are the fraction of room rates that children and babies
+	// don't pay: 0 charges full price, 1 makes them free.
+	ChildDiscount float64
+	BabyDiscount  float64
+
+	// BabiesTakeSpace counts babies toward room occupancy.
+	BabiesTakeSpace bool
+
+	// Legacy prices rooms the way they were before rates were per night
+	// (see legacyPrice).
+	Legacy bool `json:",omitempty"`
+}
+
+// IsZero reports whether s has no rates or fees configured.
+func (s Schedule) IsZero() bool {
+	return len(s.Rates) == 0 && len(s.Fees) == 0
+}
+
+// Default returns the schedule used before pricing was configurable:
+// Friday and Saturday nights priced together, Thursday as an add-on, and
+// babies free without taking up space.
+func Default() Schedule {
+	return Schedule{
+		Rates: []Rate{
+			{
+				Nights:    Weekdays{time.Friday, time.Saturday},
+				PerPerson: []float64{0, 272.50, 176.58, 144.61, 128.62, 119.00},
+			},
+			{
+				Nights:    Weekdays{time.Thursday},
+				PerPerson: []float64{0, 124.26, 76.30, 60.31, 52.32, 47.52},
+			},
+		},
+		BabyDiscount: 1,
+	}
+}
+
+// Legacy returns the default schedule, priced the way it was before rates
+// were per night. Events saved without a schedule use it, so that what
+// their guests owe doesn't change.
+func Legacy() Schedule {
+	s := Default()
+	s.Legacy = true
+	return s
+}
+
+type AgeGroup int
+
+const (
+	Adult AgeGroup = iota
+	Child
+	Baby
+)
+
+// Guest is one person staying in a room.
+type Guest struct {
+	Nights []time.Weekday
+	Age    AgeGroup
+}
+
+// Quote is the price of one room.
+type Quote struct {
+	Costs []float64 // per guest, in the order passed to Price
+	Total float64
+	Lines []string // human-readable breakdown, one per rate or fee applied
+}
+
+// Price computes what each guest in a room owes. Each guest's cost is
+// rounded down to the cent.
+func (s Schedule) Price(building string, roomNumber int, guests []Guest) Quote {
+	if s.Legacy {
+		return s.legacyPrice(building, roomNumber, guests)
+	}
+	quote := Quote{Costs: make([]float64, len(guests))}
+
+	// Pick the rate for every night anybody stays.
+	rateNights := make(map[int]Weekdays)
+	for night := time.Sunday; night <= time.Saturday; night++ {
+		if !anyoneStays(guests, night) {
+			continue
+		}
+		if r := s.rateFor(building, roomNumber, night); r >= 0 {
+			rateNights[r] = append(rateNights[r], night)
+		}
+	}
+
+	for r, rate := range s.Rates {
+		nights, ok := rateNights[r]
+		if !ok || len(rate.PerPerson) == 0 {
+			continue
+		}
+		occupancy := s.occupancy(guests, nights)
+		if occupancy < 1 {
+			occupancy = 1
+		}
+		perPerson := rate.PerPerson[len(rate.PerPerson)-1]
+		if occupancy < len(rate.PerPerson) {
+			perPerson = rate.PerPerson[occupancy]
+		}
+		for i, g := range guests {
+			stayed := 0
+			for _, night := range nights {
+				if Weekdays(g.Nights).contains(night) {
+					stayed++
+				}
+			}
+			if stayed == 0 {
+				continue
+			}
+			quote.Costs[i] += perPerson * float64(stayed) / float64(len(rate.Nights)) * (1 - s.discount(g.Age))
+		}
+		quote.Lines = append(quote.Lines, fmt.Sprintf("%s, %d in room: $%.2f/person", nights, occupancy, perPerson))
+	}
+
+	s.addFees(&quote, guests)
+	quote.round()
+	return quote
+}
+
+// legacyPrice prices a room the way rooms were priced before rates were
+// per night. Each rate's tier is picked by the number of guests staying all
+// of its nights, and a room fuller than the tiers pays nothing for it.
+// Everyone in the room pays the first rate that applies to it; later rates
+// are only paid by the guests staying all of their nights.
+func (s Schedule) legacyPrice(building string, roomNumber int, guests []Guest) Quote {
+	quote := Quote{Costs: make([]float64, len(guests))}
+	first := true
+	for r, rate := range s.Rates {
+		applies := false
+		for _, night := range rate.Nights {
+			if s.rateFor(building, roomNumber, night) == r {
+				applies = true
+			}
+		}
+		if !applies {
+			continue
+		}
+		base := first
+		first = false
+
+		staying := make([]bool, len(guests))
+		occupancy := 0
+		for i, g := range guests {
+			staying[i] = staysAll(g, rate.Nights)
+			if staying[i] && (g.Age != Baby || s.BabiesTakeSpace) {
+				occupancy++
+			}
+		}
+		if occupancy == 0 || occupancy >= len(rate.PerPerson) {
+			continue
+		}
+		perPerson := rate.PerPerson[occupancy]
+		for i, g := range guests {
+			if base || staying[i] {
+				quote.Costs[i] += perPerson * (1 - s.discount(g.Age))
+			}
+		}
+		quote.Lines = append(quote.Lines, fmt.Sprintf("%s, %d in room: $%.2f/person", rate.Nights, occupancy, perPerson))
+	}
+
+	s.addFees(&quote, guests)
+	quote.round()
+	return quote
+}
+
+// addFees charges the schedule's fees to the guests in quote.
+func (s Schedule) addFees(quote *Quote, guests []Guest) {
+	for _, fee := range s.Fees {
+		charged := false
+		for i, g := range guests {
+			if len(g.Nights) == 0 || (fee.AdultsOnly && g.Age != Adult) {
+				continue
+			}
+			quote.Costs[i] += fee.Amount
+			charged = true
+		}
+		if charged {
+			quote.Lines = append(quote.Lines, fmt.Sprintf("%s: $%.2f/person", fee.Description, fee.Amount))
+		}
+	}
+}
+
+// round rounds each guest's cost down to the cent and totals them.
+func (q *Quote) round() {
+	for i, c := range q.Costs {
+		q.Costs[i] = floorCents(c)
+		q.Total += q.Costs[i]
+	}
+	q.Total = math.Round(q.Total*100) / 100
+}
+
+// rateFor returns the index of the most specific rate covering night in
+// the given room, or -1 if there is none.
+func (s Schedule) rateFor(building string, roomNumber int, night time.Weekday) int {
+	best, bestScore := -1, -1
+	for i, rate := range s.Rates {
+		if !rate.Nights.contains(night) {
+			continue
+		}
+		score := 0
+		if rate.Building != "" {
+			if rate.Building != building {
+				continue
+			}
+			score = 1
+			if rate.RoomNumber != 0 {
+				if rate.RoomNumber != roomNumber {
+					continue
+				}
+				score = 2
+			}
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// occupancy is the largest number of guests taking up space on any of the
+// given nights.
+func (s Schedule) occupancy(guests []Guest, nights Weekdays) int {
+	most := 0
+	for _, night := range nights {
+		count := 0
+		for _, g := range guests {
+			if g.Age == Baby && !s.BabiesTakeSpace {
+				continue
+			}
+			if Weekdays(g.Nights).contains(night) {
+				count++
+			}
+		}
+		if count > most {
+			most = count
+		}
+	}
+	return most
+}
+
+func staysAll(g Guest, nights Weekdays) bool {
+	for _, night := range nights {
+		if !Weekdays(g.Nights).contains(night) {
+			return false
+		}
+	}
+	return true
+}
+
+func anyoneStays(guests []Guest, night time.Weekday) bool {
+	for _, g := range guests {
+		if Weekdays(g.Nights).contains(night) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Schedule) discount(age AgeGroup) float64 {
+	switch age {
+	case Child:
+		return s.ChildDiscount
+	case Baby:
+		return s.BabyDiscount
+	}
+	return 0
+}
+
+// floorCents rounds down to the cent, allowing for floating point error so
+// that, say, 72.305 + 72.305 still comes to 144.61.
+func floorCents(amount float64) float64 {
+	return math.Floor(amount*100+1e-6) / 100
+}
@@ -0,0 +1,209 @@
+package pricing
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var (
+	friSat    = []time.Weekday{time.Friday, time.Saturday}
+	thuFriSat = []time.Weekday{time.Thursday, time.Friday, time.Saturday}
+)
+
+func TestPrice(t *testing.T) {
+	withOverrides := Default()
+	withOverrides.Rates = append(withOverrides.Rates,
+		Rate{Nights: Weekdays{time.Friday, time.Saturday}, Building: "UKP", PerPerson: []float64{0, 300, 200}},
+		Rate{Nights: Weekdays{time.Friday, time.Saturday}, Building: "UKP", RoomNumber: 2, PerPerson: []float64{0, 400}},
+	)
+
+	discounts := Default()
+	discounts.ChildDiscount = 0.5
+	discounts.BabyDiscount = 0.75
+	discounts.BabiesTakeSpace = true
+
+	withFees := Default()
+	withFees.Fees = []Fee{
+		{Description: "Linens", Amount: 10},
+		{Description: "Bar", Amount: 20, AdultsOnly: true},
+	}
+
+	type TestCase struct {
+		Name       string
+		Schedule   Schedule
+		Building   string
+		RoomNumber int
+		Guests     []Guest
+		Want       []float64
+		WantTotal  float64
+	}
+	testcases := []TestCase{
+		{
+			Name:      "Single Fri/Sat",
+			Schedule:  Default(),
+			Guests:    []Guest{{Nights: friSat}},
+			Want:      []float64{272.50},
+			WantTotal: 272.50,
+		},
+		{
+			Name:      "Couple with one staying Thursday",
+			Schedule:  Default(),
+			Guests:    []Guest{{Nights: friSat}, {Nights: thuFriSat}},
+			Want:      []float64{176.58, 176.58 + 124.26},
+			WantTotal: 477.42,
+		},
+		{
+			Name:      "Baby is free and takes no space",
+			Schedule:  Default(),
+			Guests:    []Guest{{Nights: friSat}, {Nights: friSat}, {Nights: friSat, Age: Baby}},
+			Want:      []float64{176.58, 176.58, 0},
+			WantTotal: 353.16,
+		},
+		{
+			Name:      "Occupancy beyond the table uses the last tier",
+			Schedule:  Default(),
+			Guests:    []Guest{{Nights: friSat}, {Nights: friSat}, {Nights: friSat}, {Nights: friSat}, {Nights: friSat}, {Nights: friSat}},
+			Want:      []float64{119, 119, 119, 119, 119, 119},
+			WantTotal: 714,
+		},
+		{
+			Name:      "Partial block is prorated",
+			Schedule:  Default(),
+			Guests:    []Guest{{Nights: []time.Weekday{time.Saturday}}},
+			Want:      []float64{136.25},
+			WantTotal: 136.25,
+		},
+		{
+			Name:      "Not staying costs nothing",
+			Schedule:  withFees,
+			Guests:    []Guest{{Nights: friSat}, {}},
+			Want:      []float64{302.50, 0},
+			WantTotal: 302.50,
+		},
+		{
+			Name:      "Building override",
+			Schedule:  withOverrides,
+			Building:  "UKP",
+			Guests:    []Guest{{Nights: thuFriSat}, {Nights: friSat}},
+			Want:      []float64{200 + 124.26, 200},
+			WantTotal: 524.26,
+		},
+		{
+			Name:       "Room override beats building override",
+			Schedule:   withOverrides,
+			Building:   "UKP",
+			RoomNumber: 2,
+			Guests:     []Guest{{Nights: friSat}},
+			Want:       []float64{400},
+			WantTotal:  400,
+		},
+		{
+			Name:       "Room override only applies in its building",
+			Schedule:   withOverrides,
+			Building:   "LKP",
+			RoomNumber: 2,
+			Guests:     []Guest{{Nights: friSat}},
+			Want:       []float64{272.50},
+			WantTotal:  272.50,
+		},
+		{
+			Name:      "Child and baby discounts",
+			Schedule:  discounts,
+			Guests:    []Guest{{Nights: friSat}, {Nights: friSat, Age: Child}, {Nights: friSat, Age: Baby}},
+			Want:      []float64{144.61, 72.30, 36.15},
+			WantTotal: 253.06,
+		},
+		{
+			Name:      "Adults-only fee",
+			Schedule:  withFees,
+			Guests:    []Guest{{Nights: friSat}, {Nights: friSat, Age: Child}},
+			Want:      []float64{176.58 + 30, 176.58 + 10},
+			WantTotal: 393.16,
+		},
+
+		// The legacy schedule charges what BaseCost and AddOnCost did.
+		{
+			Name:      "Legacy mixed statuses",
+			Schedule:  Legacy(),
+			Guests:    []Guest{{Nights: friSat}, {Nights: thuFriSat}, {Nights: []time.Weekday{time.Saturday, time.Sunday}}},
+			Want:      []float64{176.58, 176.58 + 124.26, 176.58},
+			WantTotal: 654,
+		},
+		{
+			Name:      "Legacy roommate with no nights pays the base rate",
+			Schedule:  Legacy(),
+			Guests:    []Guest{{Nights: friSat}, {}},
+			Want:      []float64{272.50, 272.50},
+			WantTotal: 545,
+		},
+		{
+			Name:      "Legacy Thursday tier counts Thursday guests",
+			Schedule:  Legacy(),
+			Guests:    []Guest{{Nights: thuFriSat}, {Nights: thuFriSat, Age: Child}, {Nights: friSat}, {Nights: friSat, Age: Baby}},
+			Want:      []float64{144.61 + 76.30, 144.61 + 76.30, 144.61, 0},
+			WantTotal: 586.43,
+		},
+		{
+			Name:      "Legacy occupancy beyond the table is free",
+			Schedule:  Legacy(),
+			Guests:    []Guest{{Nights: thuFriSat}, {Nights: friSat}, {Nights: friSat}, {Nights: friSat}, {Nights: friSat}, {Nights: friSat}},
+			Want:      []float64{124.26, 0, 0, 0, 0, 0},
+			WantTotal: 124.26,
+		},
+	}
+	for _, tc := range testcases {
+		quote := tc.Schedule.Price(tc.Building, tc.RoomNumber, tc.Guests)
+		if len(quote.Costs) != len(tc.Want) {
+			t.Errorf("%s: got %d costs, want %d", tc.Name, len(quote.Costs), len(tc.Want))
+			continue
+		}
+		for i, want := range tc.Want {
+			if diff := quote.Costs[i] - want; diff > 0.001 || diff < -0.001 {
+				t.Errorf("%s: guest %d cost %.2f, want %.2f", tc.Name, i, quote.Costs[i], want)
+			}
+		}
+		if diff := quote.Total - tc.WantTotal; diff > 0.001 || diff < -0.001 {
+			t.Errorf("%s: total %.2f, want %.2f", tc.Name, quote.Total, tc.WantTotal)
+		}
+	}
+}
+
+func TestPriceLines(t *testing.T) {
+	quote := Default().Price("", 0, []Guest{{Nights: friSat}, {Nights: thuFriSat}})
+	want := []string{
+		"Fri/Sat, 2 in room: $176.58/person",
+		"Thu, 1 in room: $124.26/person",
+	}
+	if !reflect.DeepEqual(quote.Lines, want) {
+		t.Errorf("Lines were %q, want %q", quote.Lines, want)
+	}
+}
+
+func TestScheduleJSON(t *testing.T) {
+	schedule := Default()
+	schedule.Fees = []Fee{{Description: "Linens", Amount: 10}}
+	b, err := json.Marshal(schedule)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var got Schedule
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("Unmarshal %s: %v", b, err)
+	}
+	if !reflect.DeepEqual(got, schedule) {
+		t.Errorf("round trip through %s gave %+v, want %+v", b, got, schedule)
+	}
+
+	var days Weekdays
+	if err := json.Unmarshal([]byte(`["thursday", "Fri", "SAT"]`), &days); err != nil {
+		t.Fatalf("Unmarshal weekdays: %v", err)
+	}
+	if !reflect.DeepEqual(days, Weekdays{time.Thursday, time.Friday, time.Saturday}) {
+		t.Errorf("weekdays were %v, want Thu/Fri/Sat", days)
+	}
+	if err := json.Unmarshal([]byte(`["Fryday"]`), &days); err == nil {
+		t.Errorf("Unmarshal of unknown weekday succeeded, want error")
+	}
+}
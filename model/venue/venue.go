@@ -6,6 +6,7 @@ import (
 
 	"cloud.google.com/go/datastore"
 	"github.com/cshabsin/conju/conju/dsclient"
+	"github.com/cshabsin/conju/model/housing"
 )
 
 type venueDB struct {
@@ -15,6 +16,7 @@ type venueDB struct {
 	ContactPhone  string
 	ContactEmail  string
 	Website       string
+	Attributes    []housing.Attribute
 }
 
 type Venue struct {
@@ -25,6 +27,10 @@ type Venue struct {
 	ContactPhone  string
 	ContactEmail  string
 	Website       string
+
+	// Attributes is the catalog of attributes the venue's buildings and
+	// rooms can have.
+	Attributes []housing.Attribute
 }
 
 func fromDB(ctx context.Context, key *datastore.Key, v *venueDB) (*Venue, error) {
@@ -44,16 +50,26 @@ func fromDB(ctx context.Context, key *datastore.Key, v *venueDB) (*Venue, error)
 		ContactPhone:  v.ContactPhone,
 		ContactEmail:  v.ContactEmail,
 		Website:       v.Website,
+		Attributes:    attributesFromDB(v.Attributes),
 	}, nil
 }
 
+// attributesFromDB returns a venue's stored attribute catalog. Venues
+// saved before they had catalogs get the default one.
+func attributesFromDB(attributes []housing.Attribute) []housing.Attribute {
+	if len(attributes) == 0 {
+		return housing.DefaultAttributes()
+	}
+	return attributes
+}
+
 func FromKey(ctx context.Context, key *datastore.Key) (*Venue, error) {
 	return fromDB(ctx, key, nil)
 }
 
 func AllVenues(ctx context.Context) ([]*Venue, error) {
 	var venueData []*venueDB
-	q := datastore.NewQuery("Venue")
+	q := dsclient.NewQuery("Venue")
 	keys, err := dsclient.FromContext(ctx).GetAll(ctx, q, &venueData)
 	if err != nil {
 		return nil, err
@@ -68,3 +84,29 @@ func AllVenues(ctx context.Context) ([]*Venue, error) {
 	}
 	return venues, nil
 }
+
+func (v *Venue) toDB() *venueDB {
+	return &venueDB{
+		Name:          v.Name,
+		ShortName:     v.ShortName,
+		ContactPerson: v.ContactPerson,
+		ContactPhone:  v.ContactPhone,
+		ContactEmail:  v.ContactEmail,
+		Website:       v.Website,
+		Attributes:    v.Attributes,
+	}
+}
+
+// PutVenue saves the venue, setting its Key if it's new.
+func PutVenue(ctx context.Context, v *Venue) error {
+	key := v.Key
+	if key == nil {
+		key = datastore.IncompleteKey("Venue", nil)
+	}
+	key, err := dsclient.FromContext(ctx).Put(ctx, key, v.toDB())
+	if err != nil {
+		return err
+	}
+	v.Key = key
+	return nil
+}
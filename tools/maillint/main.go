@@ -0,0 +1,179 @@
+// Command maillint renders every event's email templates against a set of
+// fixture invitations and reports templates that don't parse, fail to
+// execute, or are missing their _subject, _text or _html definitions.
+//
+// With -golden, the renderings and the problems are instead compared
+// against the files in that directory, one per event, template and
+// fixture, plus problems.txt; -update rewrites the files. Run it from the
+// top of the repository:
+//
+//	$ go run ./tools/maillint -golden tools/maillint/testdata -update
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cshabsin/conju/conju"
+)
+
+var (
+	root   = flag.String("root", ".", "directory holding templates/")
+	golden = flag.String("golden", "", "directory of golden renderings to compare against")
+	update = flag.Bool("update", false, "write the renderings to -golden instead of comparing")
+)
+
+// goldenFiles returns the contents of the golden files for the lint
+// results, by path under dir: a file per rendering, and problems.txt
+// listing the problems found, so that known problems in old events'
+// templates don't hide new ones.
+func goldenFiles(dir string, renderings []conju.MailRendering, problems []conju.MailProblem) map[string][]byte {
+	files := make(map[string][]byte)
+	for _, r := range renderings {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "Subject: %s\n", r.Subject)
+		fmt.Fprintf(&buf, "\n==== text ====\n%s", r.Text)
+		fmt.Fprintf(&buf, "\n==== html ====\n%s", r.HTML)
+		files[filepath.Join(dir, r.Event, r.Template, r.Fixture+".txt")] = buf.Bytes()
+	}
+	var buf bytes.Buffer
+	for _, p := range problems {
+		fmt.Fprintln(&buf, p)
+	}
+	files[filepath.Join(dir, "problems.txt")] = buf.Bytes()
+	return files
+}
+
+// writeGolden replaces the golden files in dir.
+func writeGolden(dir string, files map[string][]byte) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	for path, contents := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, contents, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compareGolden returns a description of each file that differs from the
+// golden file in dir, and of each golden file that isn't one of files.
+func compareGolden(dir string, files map[string][]byte) ([]string, error) {
+	var paths []string
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var diffs []string
+	for _, path := range paths {
+		want, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			diffs = append(diffs, fmt.Sprintf("%s: no golden file", path))
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if diff := firstDifference(string(want), string(files[path])); diff != "" {
+			diffs = append(diffs, fmt.Sprintf("%s: %s", path, diff))
+		}
+	}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if _, ok := files[path]; !ok && !d.IsDir() {
+			diffs = append(diffs, fmt.Sprintf("%s: nothing rendered for it", path))
+		}
+		return nil
+	})
+	return diffs, err
+}
+
+// firstDifference describes the first line where got differs from want,
+// or returns "" if they're the same.
+func firstDifference(want, got string) string {
+	if want == got {
+		return ""
+	}
+	wantLines := strings.Split(want, "\n")
+	gotLines := strings.Split(got, "\n")
+	for i := 0; ; i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g || i >= len(wantLines) || i >= len(gotLines) {
+			return fmt.Sprintf("line %d is %q, want %q", i+1, g, w)
+		}
+	}
+}
+
+func realMain(ctx context.Context) error {
+	flag.Parse()
+	if *update && *golden == "" {
+		return fmt.Errorf("-update needs -golden")
+	}
+	goldenDir := *golden
+	if goldenDir != "" && !filepath.IsAbs(goldenDir) {
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		goldenDir = filepath.Join(wd, goldenDir)
+	}
+	if err := os.Chdir(*root); err != nil {
+		return err
+	}
+
+	renderings, problems, err := conju.LintMailTemplates(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	fmt.Printf("%d renderings, %d problems\n", len(renderings), len(problems))
+
+	if goldenDir == "" {
+		if len(problems) > 0 {
+			return fmt.Errorf("%d problems", len(problems))
+		}
+		return nil
+	}
+	files := goldenFiles(goldenDir, renderings, problems)
+	if *update {
+		return writeGolden(goldenDir, files)
+	}
+	diffs, err := compareGolden(goldenDir, files)
+	if err != nil {
+		return err
+	}
+	for _, d := range diffs {
+		fmt.Println(d)
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("%d files differ from %s", len(diffs), *golden)
+	}
+	return nil
+}
+
+func main() {
+	if err := realMain(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+}
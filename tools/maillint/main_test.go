@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cshabsin/conju/conju"
+)
+
+// TestGolden checks the email templates' renderings against testdata.
+// After changing a template on purpose, regenerate the files with
+//
+//	$ go run ./tools/maillint -golden tools/maillint/testdata -update
+func TestGolden(t *testing.T) {
+	dir, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	renderings, problems, err := conju.LintMailTemplates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffs, err := compareGolden(dir, goldenFiles(dir, renderings, problems))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range diffs {
+		t.Error(d)
+	}
+}